@@ -24,6 +24,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime/debug"
+	"strconv"
 	"strings"
 
 	"github.com/c-bata/go-prompt"
@@ -62,7 +63,7 @@ func main() {
 func completer(d prompt.Document) []prompt.Suggest {
 	s := []prompt.Suggest{
 		{Text: "init", Description: "Initialise the client by given key and service API Addr"},
-		{Text: "search", Description: "Search gateways by given location"},
+		{Text: "search", Description: "Search gateways, ordered by given location, reputation and latency"},
 		{Text: "add-peer", Description: "Add active peer"},
 		{Text: "ls-peers", Description: "List active peers"},
 		{Text: "inspect-peer", Description: "Inspect given active peer"},
@@ -72,8 +73,13 @@ func completer(d prompt.Document) []prompt.Suggest {
 		{Text: "find-offer", Description: "Find offers for given cid"},
 		{Text: "find-offer-dht", Description: "Find offers for given cid using DHT discovery"},
 		{Text: "ls-offers", Description: "List obtained offers for given cid"},
+		{Text: "estimate", Description: "Estimate the total cost to retrieve data using an offer by given offer digest"},
+		{Text: "set-pay-interval", Description: "Set the number of chunks paid by each tranche in data retrieval"},
 		{Text: "retrieve", Description: "Retrieve data using an offer by given offer digest"},
 		{Text: "retrieve-fast", Description: "Fast-retrieve data by given cid (automated offer discovery, selection and data retrieval)"},
+		{Text: "retrieve-parallel", Description: "Retrieve data by given cid from all providers offering it concurrently, or resume its failed retrieval"},
+		{Text: "ls-retrievals", Description: "List retrievals in progress"},
+		{Text: "cancel-retrieval", Description: "Cancel the retrieval in progress of given cid and remove its partial file"},
 		{Text: "exit", Description: "Exit the program"},
 	}
 	return prompt.FilterHasPrefix(s, d.GetWordBeforeCursor(), true)
@@ -92,12 +98,16 @@ func (c *ClientCLI) executor(in string) {
 			fmt.Println("Client has already been initialised")
 			return
 		}
-		if len(blocks) != 7 {
-			fmt.Println("Usage: init ${walletPrivKey} ${lotusAPIAddr} ${lotusAuthToken} ${registerPrivKey} ${registerAPIAddr} ${registerAuthToken}")
+		if len(blocks) != 7 && len(blocks) != 8 {
+			fmt.Println("Usage: init ${walletPrivKey} ${lotusAPIAddr} ${lotusAuthToken} ${registerPrivKey} ${registerAPIAddr} ${registerAuthToken} [${dataDir}]")
 			return
 		}
+		settings := client.Settings{}
+		if len(blocks) == 8 {
+			settings.DataDir = blocks[7]
+		}
 		var err error
-		c.client, err = client.NewFilecoinRetrievalClient(blocks[1], blocks[2], blocks[3], blocks[4], blocks[5], blocks[6])
+		c.client, err = client.NewFilecoinRetrievalClientWithSettings(settings, blocks[1], blocks[2], blocks[3], blocks[4], blocks[5], blocks[6])
 		if err != nil {
 			fmt.Printf("Error in initialising the client: %v\n", err.Error())
 			return
@@ -118,7 +128,7 @@ func (c *ClientCLI) executor(in string) {
 			fmt.Printf("Error in searching for gateways in location %v: %v\n", blocks[1], err.Error())
 			return
 		}
-		fmt.Printf("Find gateways in given location %v:\n", blocks[1])
+		fmt.Printf("Find gateways ordered by location %v, reputation and latency:\n", blocks[1])
 		for _, gw := range gws {
 			fmt.Printf("ID: %v\n", gw)
 		}
@@ -262,6 +272,37 @@ func (c *ClientCLI) executor(in string) {
 		for _, offer := range offers {
 			fmt.Printf("Offer %v: provider-%v, price-%v, expiry-%v, qos-%v\n", offer.GetMessageDigest(), offer.GetProviderID(), offer.GetPrice().String(), offer.GetExpiry(), offer.GetQoS())
 		}
+	case "estimate":
+		if !c.initialised {
+			fmt.Println("Client has not been initialised yet")
+			return
+		}
+		if len(blocks) != 2 {
+			fmt.Println("Usage: estimate ${offerDigest}")
+			return
+		}
+		cost, err := c.client.EstimateRetrievalCost(blocks[1])
+		if err != nil {
+			fmt.Printf("Error estimating retrieval cost of offer %v: %v\n", blocks[1], err.Error())
+			return
+		}
+		fmt.Printf("Estimated total cost: %v\n", cost.String())
+	case "set-pay-interval":
+		if !c.initialised {
+			fmt.Println("Client has not been initialised yet")
+			return
+		}
+		if len(blocks) != 2 {
+			fmt.Println("Usage: set-pay-interval ${chunks}")
+			return
+		}
+		interval, err := strconv.ParseUint(blocks[1], 10, 64)
+		if err != nil {
+			fmt.Printf("Error parsing interval from %v: %v\n", blocks[1], err.Error())
+			return
+		}
+		c.client.SetPaymentInterval(interval)
+		fmt.Printf("Payment interval set to %v chunks\n", interval)
 	case "retrieve":
 		if !c.initialised {
 			fmt.Println("Client has not been initialised yet")
@@ -291,12 +332,88 @@ func (c *ClientCLI) executor(in string) {
 			fmt.Printf("Error parsing bigInt from %v\n", blocks[3])
 			return
 		}
-		err := c.client.FastRetrieve(blocks[1], blocks[2], maxPrice)
+		report, err := c.client.FastRetrieve(blocks[1], blocks[2], maxPrice)
+		if report != nil {
+			for _, skipped := range report.Skipped {
+				fmt.Printf("Skipped offer %v from %v: %v\n", skipped.Offer.GetMessageDigest(), skipped.Offer.GetProviderID(), skipped.SkipReason)
+			}
+			for _, attempt := range report.Attempts {
+				result := "success"
+				if attempt.Err != nil {
+					result = attempt.Err.Error()
+				}
+				fmt.Printf("Attempted offer %v from %v with price %v and setup cost %v in %v: %v\n", attempt.Offer.GetMessageDigest(), attempt.Offer.GetProviderID(), attempt.Offer.GetPrice().String(), attempt.SetupCost.String(), attempt.Duration, result)
+			}
+		}
 		if err != nil {
 			fmt.Printf("Error retrieval of offer %v to %v: %v\n", blocks[1], blocks[2], err.Error())
 			return
 		}
 		fmt.Printf("Success, file saved to %v\n", blocks[2])
+	case "retrieve-parallel":
+		if !c.initialised {
+			fmt.Println("Client has not been initialised yet")
+			return
+		}
+		if len(blocks) != 4 {
+			fmt.Println("Usage: retrieve-parallel ${contentID} ${outputDir} ${maxPrice}")
+			return
+		}
+		maxPrice, ok := big.NewInt(0).SetString(blocks[3], 10)
+		if !ok {
+			fmt.Printf("Error parsing bigInt from %v\n", blocks[3])
+			return
+		}
+		report, err := c.client.ParallelRetrieve(blocks[1], blocks[2], maxPrice)
+		if report != nil {
+			if report.ResumedBytes > 0 {
+				fmt.Printf("Resumed with %v of %v bytes already retrieved\n", report.ResumedBytes, report.Size)
+			}
+			fmt.Printf("Content of %v bytes split into %v parts\n", report.Size, report.Parts)
+			for _, share := range report.Shares {
+				result := "ok"
+				if share.Err != nil {
+					result = share.Err.Error()
+				}
+				fmt.Printf("Provider %v delivered %v parts of %v bytes for %v: %v\n", share.ProviderID, share.Parts, share.Bytes, share.Paid.String(), result)
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error retrieval of %v to %v: %v\n", blocks[1], blocks[2], err.Error())
+			return
+		}
+		fmt.Printf("Success, file saved to %v\n", blocks[2])
+	case "ls-retrievals":
+		if !c.initialised {
+			fmt.Println("Client has not been initialised yet")
+			return
+		}
+		retrievals := c.client.ListRetrievals()
+		if len(retrievals) == 0 {
+			fmt.Println("No retrieval in progress")
+			return
+		}
+		for _, r := range retrievals {
+			fmt.Printf("Retrieval of %v to %v: %v of %v bytes retrieved\n", r.CID, r.Path, r.VerifiedSize(), r.Size)
+			for providerID, paid := range r.Paid {
+				fmt.Printf("\tPaid %v to %v\n", paid.String(), providerID)
+			}
+		}
+	case "cancel-retrieval":
+		if !c.initialised {
+			fmt.Println("Client has not been initialised yet")
+			return
+		}
+		if len(blocks) != 2 {
+			fmt.Println("Usage: cancel-retrieval ${contentID}")
+			return
+		}
+		err := c.client.CancelRetrieval(blocks[1])
+		if err != nil {
+			fmt.Printf("Error cancelling retrieval of %v: %v\n", blocks[1], err.Error())
+			return
+		}
+		fmt.Printf("Retrieval of %v cancelled\n", blocks[1])
 	case "exit":
 		fmt.Println("Shutdown client...")
 		if c.client != nil {
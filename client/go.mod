@@ -7,5 +7,6 @@ replace github.com/wcgcyx/fc-retrieval/common => ../common
 require (
 	github.com/c-bata/go-prompt v0.2.6
 	github.com/libp2p/go-libp2p-crypto v0.1.0
+	github.com/stretchr/testify v1.7.0
 	github.com/wcgcyx/fc-retrieval/common v0.0.0-00010101000000-000000000000
 )
@@ -21,25 +21,30 @@ package p2papi
 import (
 	"fmt"
 	"math/big"
-	"math/rand"
 	"os"
-	"path/filepath"
 
 	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
 )
 
-// DataRetrievalRequester requests a data retrieval
-func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+// DataRetrievalRequester requests a data retrieval of a data range of the content, the range is paid incrementally.
+// The arguments are the target ID, the sub CID offer, the path of the file to write the range to at its offset, the data range
+// and the leaves of the DAG covering the range. Every leaf is verified as soon as its data is received, then it is written.
+// A data range of zero length retrieves the leaves of the DAG only, they are verified against the cid of the offer
+// and can be decoded from the returned response.
+// The leaves can be followed by a callback called with every amount paid as it is paid, refunds are not deducted,
+// then by a callback called with the index of every leaf of the range once it is written and synced to the file.
+func DataRetrievalRequester(c *core.Core, reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
 	// Get parameters
-	if len(args) != 3 {
-		err := fmt.Errorf("Wrong arguments, expect length 3, got length %v", len(args))
+	if len(args) < 5 || len(args) > 7 {
+		err := fmt.Errorf("Wrong arguments, expect length 5, 6 or 7, got length %v", len(args))
 		logging.Error(err.Error())
 		return nil, err
 	}
@@ -61,23 +66,55 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		logging.Error(err.Error())
 		return nil, err
 	}
-
-	// Get core structure
-	c := core.GetSingleInstance()
+	dataRange, ok := args[3].(*fcrmessages.DataRange)
+	if !ok || dataRange == nil {
+		err := fmt.Errorf("Wrong arguments, expect a data range in *fcrmessages.DataRange")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	leaves, ok := args[4].([]cid.Leaf)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect leaves in []cid.Leaf")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	covered := uint64(0)
+	for _, leaf := range leaves {
+		covered += leaf.DataSize
+	}
+	if dataRange.Length > 0 && covered != dataRange.Length {
+		err := fmt.Errorf("Wrong arguments, leaves cover %v bytes, expect range length %v", covered, dataRange.Length)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	var onPaid func(*big.Int)
+	var onVerified func(int, int)
+	if len(args) >= 6 {
+		onPaid, ok = args[5].(func(*big.Int))
+		if !ok {
+			err := fmt.Errorf("Wrong arguments, expect a payment callback in func(*big.Int)")
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
+	if len(args) == 7 {
+		onVerified, ok = args[6].(func(int, int))
+		if !ok {
+			err := fmt.Errorf("Wrong arguments, expect a leaf callback in func(int, int)")
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
 
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
-	// Get provider information
-	pvdInfo := c.PeerMgr.GetPVDInfo(targetID)
+	// Get provider information, it can also be a gateway serving cached content
+	pvdInfo := getRetrievalPeerInfo(c, targetID, false)
 	if pvdInfo == nil {
-		// Not found, try sync once
-		pvdInfo = c.PeerMgr.SyncPVD(targetID)
-		if pvdInfo == nil {
-			err := fmt.Errorf("Error in obtaining information for provider %v", targetID)
-			logging.Error(err.Error())
-			return nil, err
-		}
+		err := fmt.Errorf("Error in obtaining information for provider %v", targetID)
+		logging.Error(err.Error())
+		return nil, err
 	}
 
 	// Check if the provider is blocked/pending
@@ -101,6 +138,23 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		return nil, err
 	}
 	expected := big.NewInt(0).Add(c.SearchPrice, offer.GetPrice())
+	paymentInterval := c.PaymentInterval
+	if paymentInterval == 0 {
+		// A data range must be paid incrementally
+		paymentInterval = 1
+	}
+	// Only the search price is paid upfront.
+	// Make sure the channel covers the full price so that no topup is needed while streaming.
+	_, balance, redeemed, err := c.PaymentMgr.GetOutboundChStatus(recipientAddr)
+	if err == nil && big.NewInt(0).Sub(balance, redeemed).Cmp(expected) < 0 {
+		err = c.PaymentMgr.Topup(recipientAddr, c.TopupAmount)
+		if err != nil {
+			err = fmt.Errorf("Error in topup a payment channel to %v with wallet address %v with topup amount of %v: %v", targetID, recipientAddr, c.TopupAmount.String(), err.Error())
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
+	expected = big.NewInt(0).Set(c.SearchPrice)
 	voucher, create, topup, err := c.PaymentMgr.Pay(recipientAddr, 1, expected)
 	if err != nil {
 		err = fmt.Errorf("Error in paying provider %v with expected amount of %v: %v", targetID, expected.String(), err.Error())
@@ -135,7 +189,7 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 
 	// Now we have got a voucher
 	// Encode request
-	request, err := fcrmessages.EncodeDataRetrievalRequest(nonce, c.NodeID, offer, c.WalletAddr, voucher)
+	request, err := fcrmessages.EncodeDataRetrievalRequest(nonce, c.NodeID, offer, c.WalletAddr, voucher, paymentInterval, dataRange)
 	if err != nil {
 		c.PaymentMgr.RevertPay(recipientAddr, 0)
 		err = fmt.Errorf("Internal error in encoding response: %v", err.Error())
@@ -157,20 +211,23 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 	// Get a response
 	response, err := reader.Read(c.TCPInactivityTimeout)
 	if err != nil {
-		err = fmt.Errorf("Error in receiving response from %v: %v", targetID, err.Error())
-		logging.Error(err.Error())
-		// Pend PVD
-		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
-		c.ReputationMgr.PendPeer(targetID)
+		err = handleReadError(c, targetID, recipientAddr, 1, err)
+		if onPaid != nil && !fcrserver.IsReplayedRequestError(err) {
+			// The payment is not reverted
+			onPaid(expected)
+		}
 		return nil, err
 	}
+	if onPaid != nil {
+		onPaid(expected)
+	}
 
 	// Verify the response
-	if response.Verify(pvdInfo.MsgSigningKey, pvdInfo.MsgSigningKeyVer) != nil {
+	if pvdInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
-		pvdInfo = c.PeerMgr.SyncPVD(targetID)
-		if pvdInfo == nil || response.Verify(pvdInfo.MsgSigningKey, pvdInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		pvdInfo = getRetrievalPeerInfo(c, targetID, true)
+		if pvdInfo == nil || pvdInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			// Pend PVD
 			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
@@ -181,16 +238,11 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 
 	// Check response
 	if !response.ACK() {
-		err = fmt.Errorf("Reponse contains an error: %v", response.Error())
-		logging.Error(err.Error())
-		// Pend PVD
-		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-		c.ReputationMgr.PendPeer(targetID)
-		return nil, err
+		return nil, handleErrorResponse(c, targetID, recipientAddr, response)
 	}
 
-	// Decode response
-	nonceRecv, tag, data, err := fcrmessages.DecodeDataRetrievalResponse(response)
+	// Decode response header
+	nonceRecv, _, size, chunks, dagParams, allLeaves, err := fcrmessages.DecodeDataRetrievalResponse(response, dataRange)
 	if err != nil {
 		err = fmt.Errorf("Error in decoding response from %v: %v", targetID, err.Error())
 		logging.Error(err.Error())
@@ -209,50 +261,227 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		return nil, err
 	}
 
-	// Save file
-	if _, err := os.Stat(filepath.Join(retrievalPath, tag)); os.IsNotExist(err) {
-		// Not exist, save
-		f, err := os.Create(filepath.Join(retrievalPath, tag))
-		if err == nil {
-			_, err = f.Write(data)
-			f.Close()
-		}
+	if dataRange.Length == 0 {
+		// Verify the leaves against the cid of the offer
+		err = verifyLeaves(allLeaves, size, dagParams, offer.GetSubCID())
 		if err != nil {
-			err = fmt.Errorf("Error saving file: %v", err.Error())
+			err = fmt.Errorf("Error in verifying leaves from %v: %v", targetID, err.Error())
 			logging.Error(err.Error())
+			// Pend PVD
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
 			return nil, err
 		}
-	} else {
-		// Exist
-		err = fmt.Errorf("Filename already existed %v", tag)
-		logging.Error(err.Error())
-		return nil, err
+		return response, nil
 	}
+	return response, receiveRange(c, reader, writer, targetID, pvdInfo, recipientAddr, nonce, offer, retrievalPath, dataRange, leaves, size, chunks, dagParams, paymentInterval, onPaid, onVerified)
+}
 
-	// Read file
-	fileReader, err := os.Open(filepath.Join(retrievalPath, tag))
+// payTranche pays a given amount for the tranche of chunks starting at given index in an incremental retrieval.
+// It returns a boolean indicating whether or not the voucher has been issued, and error.
+func payTranche(c *core.Core, writer fcrserver.FCRServerRequestWriter, nonce uint64, recipientAddr string, amt *big.Int, index uint64) (bool, error) {
+	voucher, create, topup, err := c.PaymentMgr.Pay(recipientAddr, 1, amt)
 	if err != nil {
-		err = fmt.Errorf("Fail to open file for cid calculation %v: %v", tag, err.Error())
-		logging.Error(err.Error())
-		return nil, err
+		return false, err
 	}
-	cid, err := cid.NewContentIDFromFile(fileReader)
+	if create || topup {
+		return false, fmt.Errorf("Payment channel does not have enough balance for %v", amt.String())
+	}
+	payment, err := fcrmessages.EncodeDataRetrievalPayment(nonce, index, voucher)
+	if err != nil {
+		c.PaymentMgr.RevertPay(recipientAddr, 1)
+		return false, err
+	}
+	return true, writer.Write(payment, c.MsgKey, 0, c.TCPInactivityTimeout)
+}
+
+// receiveRange receives the chunks of a given data range of the content and writes them to the given file at the range offset.
+// The range is paid in tranches, every tranche paid is passed to the payment callback if not nil.
+// Every leaf of the range is verified against the given leaves of the DAG as soon as its data is received, then it is written
+// to the file. The file is synced once per tranche, before the next tranche is paid and when the range stops, and the indexes
+// of the leaves synced, from (inclusive) to (exclusive), are passed to the leaf callback if not nil.
+// At most a leaf and a chunk are held in memory.
+func receiveRange(
+	c *core.Core,
+	reader fcrserver.FCRServerResponseReader,
+	writer fcrserver.FCRServerRequestWriter,
+	targetID string,
+	pvdInfo *fcrpeermgr.Peer,
+	recipientAddr string,
+	nonce uint64,
+	offer *cidoffer.SubCIDOffer,
+	filename string,
+	dataRange *fcrmessages.DataRange,
+	leaves []cid.Leaf,
+	size uint64,
+	chunks uint64,
+	dagParams cid.DAGParams,
+	paymentInterval uint64,
+	onPaid func(*big.Int),
+	onVerified func(int, int),
+) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0)
 	if err != nil {
-		err = fmt.Errorf("Invalid CID: %v", err.Error())
+		err = fmt.Errorf("Error saving file: %v", err.Error())
 		logging.Error(err.Error())
-		return nil, err
+		return err
+	}
+	defer f.Close()
+	// Data received of the leaves not yet verified
+	pending := make([]byte, 0)
+	received := uint64(0)
+	offset := dataRange.Offset
+	next := 0
+	// Leaves before synced are on disk and reported
+	synced := 0
+	syncLeaves := func() error {
+		if synced == next {
+			return nil
+		}
+		if err := f.Sync(); err != nil {
+			err = fmt.Errorf("Error saving file: %v", err.Error())
+			logging.Error(err.Error())
+			return err
+		}
+		if onVerified != nil {
+			onVerified(synced, next)
+		}
+		synced = next
+		return nil
+	}
+	// The leaves written are kept if the range stops early
+	defer syncLeaves()
+	for index := uint64(0); index < chunks; index++ {
+		if index%paymentInterval == 0 {
+			if err := syncLeaves(); err != nil {
+				return err
+			}
+			// Pay the next tranche, all chunks received so far have been checked
+			amt := fcrmessages.GetRangeTranchePrice(offer.GetPrice(), size, dataRange, index, index+paymentInterval)
+			paid, err := payTranche(c, writer, nonce, recipientAddr, amt, index)
+			if paid && onPaid != nil {
+				onPaid(amt)
+			}
+			if err != nil {
+				err = fmt.Errorf("Error in paying chunk %v to %v: %v", index, targetID, err.Error())
+				logging.Error(err.Error())
+				if paid {
+					// Pend PVD
+					c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
+					c.ReputationMgr.PendPeer(targetID)
+				}
+				return err
+			}
+		}
+		chunk, err := reader.Read(c.TCPInactivityTimeout)
+		if err != nil {
+			err = fmt.Errorf("Error in receiving chunk %v from %v: %v", index, targetID, err.Error())
+			logging.Error(err.Error())
+			// Pend PVD
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return err
+		}
+		if pvdInfo.VerifyMsg(chunk.Verify) != nil {
+			err = fmt.Errorf("Error in verifying chunk %v from %v", index, targetID)
+			logging.Error(err.Error())
+			// Pend PVD
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return err
+		}
+		if !chunk.ACK() {
+			// The stream is stopped, e.g. a tranche payment is rejected with a refund
+			return handleErrorResponse(c, targetID, recipientAddr, chunk)
+		}
+		nonceRecv, indexRecv, chunkData, err := fcrmessages.DecodeDataChunkResponse(chunk)
+		if err == nil {
+			if nonceRecv != nonce {
+				err = fmt.Errorf("Nonce mismatch: expected %v got %v", nonce, nonceRecv)
+			} else if indexRecv != index {
+				err = fmt.Errorf("Chunk index mismatch: expected %v got %v", index, indexRecv)
+			} else if received+uint64(len(chunkData)) > dataRange.Length {
+				err = fmt.Errorf("Chunk exceeds range length %v", dataRange.Length)
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("Error in decoding chunk %v from %v: %v", index, targetID, err.Error())
+			logging.Error(err.Error())
+			// Pend PVD
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return err
+		}
+		received += uint64(len(chunkData))
+		pending = append(pending, chunkData...)
+		// Verify and write every leaf fully received
+		for ; next < len(leaves) && uint64(len(pending)) >= leaves[next].DataSize; next++ {
+			data := pending[:leaves[next].DataSize]
+			err = cid.VerifyLeaves(data, leaves[next:next+1], dagParams)
+			if err != nil {
+				err = fmt.Errorf("Received leaf at %v from %v fails to verify: %v", offset, targetID, err.Error())
+				logging.Error(err.Error())
+				// Pend PVD
+				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+				c.ReputationMgr.PendPeer(targetID)
+				return err
+			}
+			_, err = f.WriteAt(data, int64(offset))
+			if err != nil {
+				err = fmt.Errorf("Error saving file: %v", err.Error())
+				logging.Error(err.Error())
+				return err
+			}
+			offset += leaves[next].DataSize
+			pending = pending[leaves[next].DataSize:]
+		}
 	}
-	// Check file cid
-	if cid.ToString() != offer.GetSubCID().ToString() {
-		err = fmt.Errorf("Received data with wrong cid expected: %v got: %v", offer.GetSubCID().ToString(), cid.ToString())
+	if next != len(leaves) {
+		err = fmt.Errorf("Received %v of %v leaves of range %v+%v from %v", next, len(leaves), dataRange.Offset, dataRange.Length, targetID)
 		logging.Error(err.Error())
 		// Pend PVD
 		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
 		c.ReputationMgr.PendPeer(targetID)
-		return nil, err
+		return err
+	}
+	if err = syncLeaves(); err != nil {
+		return err
 	}
-
-	// Succeed
 	c.ReputationMgr.UpdatePeerRecord(targetID, reputation.ContentRetrieved.Copy(), 0)
-	return response, nil
+	return nil
+}
+
+// verifyLeaves checks if the given leaves of the DAG built with given parameters add up to the given size and match the given cid.
+func verifyLeaves(leaves []cid.Leaf, size uint64, dagParams cid.DAGParams, id *cid.ContentID) error {
+	total := uint64(0)
+	for _, leaf := range leaves {
+		total += leaf.DataSize
+	}
+	if total != size {
+		return fmt.Errorf("Leaves add up to %v bytes, expected %v", total, size)
+	}
+	root, err := cid.NewContentIDFromLeaves(leaves, dagParams)
+	if err != nil {
+		return err
+	}
+	if root.ToString() != id.ToString() {
+		return fmt.Errorf("Leaves give wrong cid expected: %v got: %v", id.ToString(), root.ToString())
+	}
+	return nil
+}
+
+// getRetrievalPeerInfo gets the information of the peer serving a retrieval, it can be a provider or a gateway.
+func getRetrievalPeerInfo(c *core.Core, targetID string, sync bool) *fcrpeermgr.Peer {
+	if !sync {
+		if peerInfo := c.PeerMgr.GetPVDInfo(targetID); peerInfo != nil {
+			return peerInfo
+		}
+		if peerInfo := c.PeerMgr.GetGWInfo(targetID); peerInfo != nil {
+			return peerInfo
+		}
+	}
+	if peerInfo := c.PeerMgr.SyncPVD(targetID); peerInfo != nil {
+		return peerInfo
+	}
+	return c.PeerMgr.SyncGW(targetID)
 }
@@ -0,0 +1,162 @@
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
+)
+
+// mockPaymentMgr issues a voucher of every amount paid.
+type mockPaymentMgr struct {
+	fcrpaymentmgr.FCRPaymentMgr
+}
+
+func (mgr *mockPaymentMgr) Pay(recipientAddr string, lane uint64, amt *big.Int) (string, bool, bool, error) {
+	return amt.String(), false, false, nil
+}
+
+// mockReader reads the given responses in order.
+type mockReader struct {
+	responses []*fcrmessages.FCRACKMsg
+}
+
+func (r *mockReader) Read(timeout time.Duration) (*fcrmessages.FCRACKMsg, error) {
+	if len(r.responses) == 0 {
+		return nil, errors.New("Test error")
+	}
+	res := r.responses[0]
+	r.responses = r.responses[1:]
+	return res, nil
+}
+
+// mockWriter records the requests written.
+type mockWriter struct {
+	requests []*fcrmessages.FCRReqMsg
+}
+
+func (w *mockWriter) Write(msg *fcrmessages.FCRReqMsg, privKey string, keyVer byte, timeout time.Duration) error {
+	w.requests = append(w.requests, msg)
+	return nil
+}
+
+func TestReceiveRange(t *testing.T) {
+	// Five leaves in two chunks
+	data := make([]byte, 5*cid.DefaultDAGParams.ChunkSize)
+	_, err := rand.Read(data)
+	assert.Empty(t, err)
+	leaves, err := cid.GetLeaves(bytes.NewReader(data), cid.DefaultDAGParams)
+	assert.Empty(t, err)
+	assert.Equal(t, 5, len(leaves))
+	id, err := cid.NewContentIDFromLeaves(leaves, cid.DefaultDAGParams)
+	assert.Empty(t, err)
+	offer, err := cidoffer.NewCIDOffer("provider", []cid.ContentID{*id}, big.NewInt(1000), time.Now().Add(time.Hour).Unix(), 0)
+	assert.Empty(t, err)
+	subOffer, err := offer.GenerateSubCIDOffer(id)
+	assert.Empty(t, err)
+	privKey, pubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	pvdInfo := &fcrpeermgr.Peer{NodeID: "provider", MsgSigningKey: pubKey}
+	c := core.NewCore()
+	c.PaymentMgr = &mockPaymentMgr{}
+	c.ReputationMgr = fcrreputationmgr.NewFCRReputationMgrImpV1()
+	c.ReputationMgr.AddPeer("provider")
+	dataRange := &fcrmessages.DataRange{Offset: 0, Length: uint64(len(data))}
+	chunks := fcrmessages.GetChunkCount(dataRange.Length)
+	assert.Equal(t, uint64(2), chunks)
+
+	// responses gets the chunks of the range, signed by the provider
+	responses := func(data []byte) []*fcrmessages.FCRACKMsg {
+		res := make([]*fcrmessages.FCRACKMsg, 0)
+		for index := uint64(0); index < chunks; index++ {
+			end := (index + 1) * fcrmessages.DataChunkSize
+			if end > uint64(len(data)) {
+				end = uint64(len(data))
+			}
+			chunk, err := fcrmessages.EncodeDataChunkResponse(1, index, data[index*fcrmessages.DataChunkSize:end])
+			assert.Empty(t, err)
+			err = chunk.Sign(privKey, 0)
+			assert.Empty(t, err)
+			res = append(res, chunk)
+		}
+		return res
+	}
+	newFile := func() string {
+		filename := filepath.Join(t.TempDir(), "content")
+		err := os.WriteFile(filename, make([]byte, len(data)), 0644)
+		assert.Empty(t, err)
+		return filename
+	}
+
+	// Every leaf is written and reported in order once its tranche is synced, every tranche is reported as it is paid
+	filename := newFile()
+	paid := make([]string, 0)
+	verified := make([]int, 0)
+	synced := make([]int, 0)
+	writer := &mockWriter{}
+	err = receiveRange(c, &mockReader{responses: responses(data)}, writer, "provider", pvdInfo, "addr", 1, subOffer, filename, dataRange, leaves, uint64(len(data)), chunks, cid.DefaultDAGParams, 1,
+		func(amt *big.Int) { paid = append(paid, amt.String()) },
+		func(from int, to int) {
+			for index := from; index < to; index++ {
+				verified = append(verified, index)
+			}
+			synced = append(synced, to)
+		})
+	assert.Empty(t, err)
+	assert.Equal(t, 2, len(writer.requests))
+	assert.Equal(t, []string{"800", "200"}, paid)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, verified)
+	assert.Equal(t, []int{4, 5}, synced)
+	content, err := os.ReadFile(filename)
+	assert.Empty(t, err)
+	assert.Equal(t, data, content)
+
+	// A corrupted leaf in the second chunk, the leaves before it are kept
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-1]++
+	filename = newFile()
+	verified = make([]int, 0)
+	err = receiveRange(c, &mockReader{responses: responses(corrupted)}, &mockWriter{}, "provider", pvdInfo, "addr", 1, subOffer, filename, dataRange, leaves, uint64(len(data)), chunks, cid.DefaultDAGParams, 1,
+		nil, func(from int, to int) {
+			for index := from; index < to; index++ {
+				verified = append(verified, index)
+			}
+		})
+	assert.NotEmpty(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, verified)
+	content, err = os.ReadFile(filename)
+	assert.Empty(t, err)
+	written := 4 * cid.DefaultDAGParams.ChunkSize
+	assert.Equal(t, data[:written], content[:written])
+	assert.Equal(t, make([]byte, len(data)-int(written)), content[written:])
+	assert.True(t, c.ReputationMgr.GetPeerReputation("provider").Pending)
+}
@@ -0,0 +1,202 @@
+package p2papi
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
+)
+
+// DHTLookupRequester sends a request of one hop of an iterative DHT lookup.
+// The response carries the offers of the gateway and the IDs of the gateways closer to the cid in its view.
+func DHTLookupRequester(c *core.Core, reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+	// Get parameters
+	if len(args) != 4 {
+		err := fmt.Errorf("Wrong arguments, expect length 4, got length %v", len(args))
+		logging.Error(err.Error())
+		return nil, err
+	}
+	targetID, ok := args[0].(string)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a target ID in string")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	pieceCID, ok := args[1].(*cid.ContentID)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a piece CID in string")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	maxOfferRequested, ok := args[2].(uint32)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a max offer requested in uint32")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	numCloser, ok := args[3].(uint32)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a number of closer gateways in uint32")
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Generate random nonce
+	nonce := fcrserver.NewNonce()
+
+	// Get gateway information
+	gwInfo := c.PeerMgr.GetGWInfo(targetID)
+	if gwInfo == nil {
+		// Not found, try sync once
+		gwInfo = c.PeerMgr.SyncGW(targetID)
+		if gwInfo == nil {
+			err := fmt.Errorf("Error in obtaining information for gateway %v", targetID)
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	// Check if the gateway is blocked/pending
+	rep := c.ReputationMgr.GetPeerReputation(targetID)
+	if rep == nil {
+		err := fmt.Errorf("Gateway %v is not active", targetID)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	if rep.Pending || rep.Blocked {
+		err := fmt.Errorf("Gateway %v is in pending %v, blocked %v", targetID, rep.Pending, rep.Blocked)
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Pay the recipient
+	recipientAddr, err := fcrcrypto.GetWalletAddress(gwInfo.RootKey)
+	if err != nil {
+		err = fmt.Errorf("Error in obtaining wallet addreess for gateway %v with root key %v: %v", targetID, gwInfo.RootKey, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	expected := big.NewInt(0).Add(c.SearchPrice, big.NewInt(0).Mul(c.OfferPrice, big.NewInt(int64(maxOfferRequested))))
+	voucher, create, topup, err := c.PaymentMgr.Pay(recipientAddr, 0, expected)
+	if err != nil {
+		err = fmt.Errorf("Error in paying gateway %v with expected amount of %v: %v", targetID, expected.String(), err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	if create {
+		err = fmt.Errorf("No payment channel to %v", targetID)
+		logging.Error(err.Error())
+		return nil, err
+	} else if topup {
+		// Need to topup
+		err = c.PaymentMgr.Topup(recipientAddr, c.TopupAmount)
+		if err != nil {
+			err = fmt.Errorf("Error in topup a payment channel to %v with wallet address %v with topup amount of %v: %v", targetID, recipientAddr, c.TopupAmount.String(), err.Error())
+			logging.Error(err.Error())
+			return nil, err
+		}
+		voucher, _, topup, err = c.PaymentMgr.Pay(recipientAddr, 0, expected)
+		if topup {
+			// This should never happen
+			err = fmt.Errorf("Error in paying gateway %v, needs to create/topup after just topup", targetID)
+			logging.Error(err.Error())
+			return nil, err
+		}
+		if err != nil {
+			err = fmt.Errorf("Error in paying gateway %v with expected amount of %v: %v after just topup", targetID, expected.String(), err.Error())
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	// Now we have got a voucher
+	// Encode request
+	request, err := fcrmessages.EncodeDHTLookupRequest(nonce, c.NodeID, pieceCID, maxOfferRequested, numCloser, c.WalletAddr, voucher)
+	if err != nil {
+		c.PaymentMgr.RevertPay(recipientAddr, 0)
+		err = fmt.Errorf("Internal error in encoding response: %v", err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Write request
+	err = writer.Write(request, c.MsgKey, 0, c.TCPInactivityTimeout)
+	if err != nil {
+		err = fmt.Errorf("Error in sending request to %v: %v", targetID, err.Error())
+		logging.Error(err.Error())
+		// Pend GW
+		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
+		c.ReputationMgr.PendPeer(targetID)
+		return nil, err
+	}
+
+	// Get a response
+	response, err := reader.Read(c.TCPInactivityTimeout)
+	if err != nil {
+		return nil, handleReadError(c, targetID, recipientAddr, 0, err)
+	}
+
+	// Verify the response
+	if gwInfo.VerifyMsg(response.Verify) != nil {
+		// Try update
+		gwInfo = c.PeerMgr.SyncGW(targetID)
+		if gwInfo == nil || gwInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
+			logging.Error(err.Error())
+			// Pend GW
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return nil, err
+		}
+	}
+
+	// Check response
+	if !response.ACK() {
+		return nil, handleErrorResponse(c, targetID, recipientAddr, response)
+	}
+
+	// Decode response
+	nonceRecv, offers, closer, refundVoucher, err := fcrmessages.DecodeDHTLookupResponse(response)
+	if err != nil {
+		err = fmt.Errorf("Error in decoding response from %v: %v", targetID, err.Error())
+		logging.Error(err.Error())
+		// Pend GW
+		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+		c.ReputationMgr.PendPeer(targetID)
+		return nil, err
+	}
+
+	if nonceRecv != nonce {
+		err = fmt.Errorf("Nonce mismatch: expected %v got %v", nonce, nonceRecv)
+		logging.Error(err.Error())
+		// Pend GW
+		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+		c.ReputationMgr.PendPeer(targetID)
+		return nil, err
+	}
+
+	if len(closer) > int(numCloser) {
+		err = fmt.Errorf("Received %v closer gateways, more than requested %v", len(closer), numCloser)
+		logging.Error(err.Error())
+		// Pend GW
+		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+		c.ReputationMgr.PendPeer(targetID)
+		return nil, err
+	}
+
+	// Check payment and offer
+	err = receiveOffers(c, targetID, recipientAddr, pieceCID, offers, maxOfferRequested, refundVoucher)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return response
+	return response, nil
+}
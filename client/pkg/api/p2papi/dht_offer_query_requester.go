@@ -3,8 +3,6 @@ package p2papi
 import (
 	"fmt"
 	"math/big"
-	"math/rand"
-	"time"
 
 	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
@@ -16,10 +14,10 @@ import (
 )
 
 // DHTOfferQueryRequester sends an offer query request.
-func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+func DHTOfferQueryRequester(c *core.Core, reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
 	// Get parameters
-	if len(args) != 4 {
-		err := fmt.Errorf("Wrong arguments, expect length 3, got length %v", len(args))
+	if len(args) != 5 {
+		err := fmt.Errorf("Wrong arguments, expect length 5, got length %v", len(args))
 		logging.Error(err.Error())
 		return nil, err
 	}
@@ -41,18 +39,21 @@ func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		logging.Error(err.Error())
 		return nil, err
 	}
-	maxOfferRequestedPerDHT, ok := args[2].(uint32)
+	maxOfferRequestedPerDHT, ok := args[3].(uint32)
 	if !ok {
 		err := fmt.Errorf("Wrong arguments, expect a max offer requested per DHT in uint32")
 		logging.Error(err.Error())
 		return nil, err
 	}
-
-	// Get core structure
-	c := core.GetSingleInstance()
+	maxOfferRequested, ok := args[4].(uint32)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a max offer requested in uint32")
+		logging.Error(err.Error())
+		return nil, err
+	}
 
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
 	// Maximum numDHT is 16.
 	if numDHT > 16 {
@@ -128,7 +129,7 @@ func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 
 	// Now we have got a voucher
 	// Encode request
-	request, err := fcrmessages.EncodeDHTOfferDiscoveryRequest(nonce, c.NodeID, pieceCID, numDHT, maxOfferRequestedPerDHT, c.WalletAddr, voucher)
+	request, err := fcrmessages.EncodeDHTOfferDiscoveryRequest(nonce, c.NodeID, pieceCID, numDHT, maxOfferRequestedPerDHT, maxOfferRequested, c.WalletAddr, voucher)
 	if err != nil {
 		c.PaymentMgr.RevertPay(recipientAddr, 0)
 		err = fmt.Errorf("Internal error in encoding response: %v", err.Error())
@@ -150,20 +151,15 @@ func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 	// Get a response
 	response, err := reader.Read(c.LongTCPInactivityTimeout)
 	if err != nil {
-		err = fmt.Errorf("Error in receiving response from %v: %v", targetID, err.Error())
-		logging.Error(err.Error())
-		// Pend GW
-		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
-		c.ReputationMgr.PendPeer(targetID)
-		return nil, err
+		return nil, handleReadError(c, targetID, recipientAddr, 0, err)
 	}
 
 	// Verify the response
-	if response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
+	if gwInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
 		gwInfo = c.PeerMgr.SyncGW(targetID)
-		if gwInfo == nil || response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		if gwInfo == nil || gwInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			// Pend GW
 			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
@@ -174,12 +170,7 @@ func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 
 	// Check response
 	if !response.ACK() {
-		err = fmt.Errorf("Reponse contains an error: %v", response.Error())
-		logging.Error(err.Error())
-		// Pend GW
-		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-		c.ReputationMgr.PendPeer(targetID)
-		return nil, err
+		return nil, handleErrorResponse(c, targetID, recipientAddr, response)
 	}
 
 	nonceRecv, contacted, refundVoucher, err := fcrmessages.DecodeDHTOfferDiscoveryResponse(response)
@@ -218,11 +209,11 @@ func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 				return nil, err
 			}
 		}
-		if resp.Verify(subGWInfo.MsgSigningKey, subGWInfo.MsgSigningKeyVer) != nil {
+		if subGWInfo.VerifyMsg(resp.Verify) != nil {
 			// Try update
 			subGWInfo = c.PeerMgr.SyncGW(subID)
-			if subGWInfo == nil || resp.Verify(subGWInfo.MsgSigningKey, subGWInfo.MsgSigningKeyVer) != nil {
-				err = fmt.Errorf("Error in verifying sub response from %v: %v", subID, err.Error())
+			if subGWInfo == nil || subGWInfo.VerifyMsg(resp.Verify) != nil {
+				err = fmt.Errorf("Error in verifying sub response from %v", subID)
 				logging.Error(err.Error())
 				// Pend GW
 				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
@@ -251,72 +242,11 @@ func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		}
 
 		// Check offer
-		remainSub := int(maxOfferRequestedPerDHT)
-		duplicateCheck := make(map[string]bool)
-		for _, offer := range offers {
-			// Verify offer one by one
-			// Get offer signing key
-			pvdID := offer.GetProviderID()
-			pvdInfo := c.PeerMgr.GetPVDInfo(pvdID)
-			if err == nil {
-				// Not found, try again
-				pvdInfo = c.PeerMgr.SyncPVD(pvdID)
-				if pvdInfo == nil {
-					// Not found, return error
-					err = fmt.Errorf("Error in obtaining information for provider %v", pvdID)
-					logging.Error(err.Error())
-					c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-					c.ReputationMgr.PendPeer(targetID)
-					return nil, err
-				}
-			}
-			// Verify sub cid.
-			if offer.GetSubCID().ToString() != pieceCID.ToString() {
-				err = fmt.Errorf("Received offer that doesn't contain requested cid, expect: %v, got: %v", pieceCID.ToString(), offer.GetSubCID().ToString())
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-				return nil, err
-			}
-			// Verify offer signature
-			if offer.Verify(pvdInfo.OfferSigningKey) != nil {
-				err = fmt.Errorf("Received offer fails to verify against signature of provider %v", pvdID)
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-				return nil, err
-			}
-			// Verify offer merkle proof
-			if offer.VerifyMerkleProof() != nil {
-				err = fmt.Errorf("Received offer fails to verify merkle proof")
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-				return nil, err
-			}
-			// Check duplicates
-			_, ok := duplicateCheck[offer.GetMessageDigest()]
-			if ok {
-				err = fmt.Errorf("Received duplicated offers")
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-				return nil, err
-			}
-			// Check offer expiry, reject if less than 1 hour
-			if offer.GetExpiry()-time.Now().Unix() < 3600 {
-				// Offer is soon to expire
-				err = fmt.Errorf("Received soon to expire offer")
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-				return nil, err
-			}
-			// Offer verified
-			remainSub--
-			c.OfferMgr.AddSubOffer(&offer)
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.DHTOfferRetrieved.Copy(), 0)
+		err = verifyOffers(c, targetID, pieceCID, offers, &reputation.DHTOfferRetrieved)
+		if err != nil {
+			return nil, err
 		}
+		remainSub := int(maxOfferRequestedPerDHT) - len(offers)
 		if remainSub < 0 {
 			remainSub = 0
 		}
@@ -324,22 +254,7 @@ func DHTOfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 	}
 
 	// Check remain total
-	if remainTotal > 0 {
-		// Check refund
-		refunded, err := c.PaymentMgr.ReceiveRefund(recipientAddr, refundVoucher)
-		if err != nil {
-			// Refund is wrong, but we can still respond to client, no need to return error
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidRefund.Copy(), 0)
-			c.ReputationMgr.PendPeer(targetID)
-		} else {
-			expectedRefund := big.NewInt(0).Mul(c.OfferPrice, big.NewInt(remainTotal))
-			if refunded.Cmp(expectedRefund) < 0 {
-				// Refund is wrong, but we can still respond to client, no need to return error
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidRefund.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-			}
-		}
-	}
+	receiveOfferRefund(c, targetID, recipientAddr, refundVoucher, remainTotal)
 
 	// Return response
 	return response, nil
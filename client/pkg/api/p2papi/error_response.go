@@ -0,0 +1,83 @@
+/*
+Package p2papi contains the API code for the p2p communication.
+*/
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
+)
+
+// handleReadError reacts to an error in reading the response from a given peer paid on a given lane.
+// A replayed request is rejected before the payment is received, so the payment is reverted and the peer is not at fault.
+func handleReadError(c *core.Core, targetID string, recipientAddr string, lane uint64, err error) error {
+	err = fmt.Errorf("Error in receiving response from %v: %w", targetID, err)
+	logging.Error(err.Error())
+	if fcrserver.IsReplayedRequestError(err) {
+		c.PaymentMgr.RevertPay(recipientAddr, lane)
+		return err
+	}
+	// Pend peer
+	c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
+	c.ReputationMgr.PendPeer(targetID)
+	return err
+}
+
+// handleErrorResponse reacts to a verified error response from a given peer per its error code.
+// It redeems the refund voucher embedded in the error if any, and pends the peer unless the error is caused by the request
+// or the peer asks to retry later. The returned error wraps the fcrmessages.FCRError of the response.
+func handleErrorResponse(c *core.Core, targetID string, recipientAddr string, response *fcrmessages.FCRACKMsg) error {
+	fcrErr := response.ErrorDetails()
+	err := fmt.Errorf("Reponse contains an error: %w", fcrErr)
+	logging.Error(err.Error())
+	if fcrErr.RefundVoucher != "" {
+		refunded, ierr := c.PaymentMgr.ReceiveRefund(recipientAddr, fcrErr.RefundVoucher)
+		if ierr != nil {
+			logging.Error("Error in receiving refund from %v: %v", targetID, ierr.Error())
+			// Pend peer
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidRefund.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return err
+		}
+		logging.Info("Received refund of %v from %v", refunded.String(), targetID)
+	}
+	switch fcrErr.Code {
+	case fcrmessages.ErrorCodeInvalidRequest,
+		fcrmessages.ErrorCodeUnauthorised,
+		fcrmessages.ErrorCodeBlocked,
+		fcrmessages.ErrorCodeInvalidPayment,
+		fcrmessages.ErrorCodeShortPayment,
+		fcrmessages.ErrorCodeInvalidOffer,
+		fcrmessages.ErrorCodeOfferExpired:
+		// Caused by the request, the peer is not at fault
+		return err
+	}
+	if fcrErr.RetryAfter > 0 {
+		logging.Info("Peer %v asks to retry after %v", targetID, fcrErr.RetryAfter)
+		return err
+	}
+	// Pend peer
+	c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+	c.ReputationMgr.PendPeer(targetID)
+	return err
+}
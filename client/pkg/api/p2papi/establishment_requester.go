@@ -1,9 +1,9 @@
 package p2papi
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
 
 	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
@@ -13,7 +13,7 @@ import (
 )
 
 // EstablishmentRequester sends an establishment request.
-func EstablishmentRequester(reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+func EstablishmentRequester(c *core.Core, reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
 	// Get parameters
 	if len(args) != 2 {
 		err := fmt.Errorf("Wrong arguments, expect length 2, got length %v", len(args))
@@ -33,11 +33,8 @@ func EstablishmentRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		return nil, err
 	}
 
-	// Get core structure
-	c := core.GetSingleInstance()
-
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
 	challengeBytes := make([]byte, 32)
 	rand.Read(challengeBytes)
@@ -90,15 +87,15 @@ func EstablishmentRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 			}
 		}
 	}
-	if response.Verify(nodeInfo.MsgSigningKey, nodeInfo.MsgSigningKeyVer) != nil {
+	if nodeInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
 		if gateway {
 			nodeInfo = c.PeerMgr.SyncGW(targetID)
 		} else {
 			nodeInfo = c.PeerMgr.SyncPVD(targetID)
 		}
-		if nodeInfo == nil || response.Verify(nodeInfo.MsgSigningKey, nodeInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		if nodeInfo == nil || nodeInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			return nil, err
 		}
@@ -3,8 +3,6 @@ package p2papi
 import (
 	"fmt"
 	"math/big"
-	"math/rand"
-	"time"
 
 	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
@@ -16,7 +14,7 @@ import (
 )
 
 // OfferQueryRequester sends an offer query request.
-func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+func OfferQueryRequester(c *core.Core, reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
 	// Get parameters
 	if len(args) != 3 {
 		err := fmt.Errorf("Wrong arguments, expect length 3, got length %v", len(args))
@@ -42,11 +40,8 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 		return nil, err
 	}
 
-	// Get core structure
-	c := core.GetSingleInstance()
-
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
 	// Get gateway information
 	gwInfo := c.PeerMgr.GetGWInfo(targetID)
@@ -138,20 +133,15 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 	// Get a response
 	response, err := reader.Read(c.TCPInactivityTimeout)
 	if err != nil {
-		err = fmt.Errorf("Error in receiving response from %v: %v", targetID, err.Error())
-		logging.Error(err.Error())
-		// Pend GW
-		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
-		c.ReputationMgr.PendPeer(targetID)
-		return nil, err
+		return nil, handleReadError(c, targetID, recipientAddr, 0, err)
 	}
 
 	// Verify the response
-	if response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
+	if gwInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
 		gwInfo = c.PeerMgr.SyncGW(targetID)
-		if gwInfo == nil || response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		if gwInfo == nil || gwInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			// Pend GW
 			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
@@ -162,12 +152,7 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 
 	// Check response
 	if !response.ACK() {
-		err = fmt.Errorf("Reponse contains an error: %v", response.Error())
-		logging.Error(err.Error())
-		// Pend GW
-		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-		c.ReputationMgr.PendPeer(targetID)
-		return nil, err
+		return nil, handleErrorResponse(c, targetID, recipientAddr, response)
 	}
 
 	// Decode response
@@ -191,92 +176,9 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 	}
 
 	// Check payment and offer
-	remain := int64(maxOfferRequested)
-	duplicateCheck := make(map[string]bool)
-	for _, offer := range offers {
-		// Verify offer one by one
-		// Get offer signing key
-		pvdID := offer.GetProviderID()
-		pvdInfo := c.PeerMgr.GetPVDInfo(pvdID)
-		if err == nil {
-			// Not found, try again
-			pvdInfo = c.PeerMgr.SyncPVD(pvdID)
-			if pvdInfo == nil {
-				// Not found, return error
-				err = fmt.Errorf("Error in obtaining information for provider %v", pvdID)
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-				return nil, err
-			}
-		}
-		// Verify sub cid.
-		if offer.GetSubCID().ToString() != pieceCID.ToString() {
-			err = fmt.Errorf("Received offer that doesn't contain requested cid, expect: %v, got: %v", pieceCID.ToString(), offer.GetSubCID().ToString())
-			logging.Error(err.Error())
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-			c.ReputationMgr.PendPeer(targetID)
-			return nil, err
-		}
-		// Verify offer signature
-		if offer.Verify(pvdInfo.OfferSigningKey) != nil {
-			err = fmt.Errorf("Received offer fails to verify against signature of provider %v", pvdID)
-			logging.Error(err.Error())
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-			c.ReputationMgr.PendPeer(targetID)
-			return nil, err
-		}
-		// Verify offer merkle proof
-		if offer.VerifyMerkleProof() != nil {
-			err = fmt.Errorf("Received offer fails to verify merkle proof")
-			logging.Error(err.Error())
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-			c.ReputationMgr.PendPeer(targetID)
-			return nil, err
-		}
-		// Check duplicates
-		_, ok := duplicateCheck[offer.GetMessageDigest()]
-		if ok {
-			err = fmt.Errorf("Received duplicated offers")
-			logging.Error(err.Error())
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-			c.ReputationMgr.PendPeer(targetID)
-			return nil, err
-		}
-		// Check offer expiry, reject if less than 1 hour
-		if offer.GetExpiry()-time.Now().Unix() < 3600 {
-			// Offer is soon to expire
-			err = fmt.Errorf("Received soon to expire offer")
-			logging.Error(err.Error())
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-			c.ReputationMgr.PendPeer(targetID)
-			return nil, err
-		}
-		// Offer verified
-		remain--
-		c.OfferMgr.AddSubOffer(&offer)
-		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.StandardOfferRetrieved.Copy(), 0)
-	}
-
-	if remain > 0 {
-		// Check refund
-		refunded, err := c.PaymentMgr.ReceiveRefund(recipientAddr, refundVoucher)
-		if err != nil {
-			// Refund is wrong, but we can still respond to client, no need to return error
-			err = fmt.Errorf("Error in receiving refund %v", err.Error())
-			logging.Error(err.Error())
-			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidRefund.Copy(), 0)
-			c.ReputationMgr.PendPeer(targetID)
-		} else {
-			expectedRefund := big.NewInt(0).Mul(c.OfferPrice, big.NewInt(remain))
-			if refunded.Cmp(expectedRefund) < 0 {
-				// Refund is wrong, but we can still respond to client, no need to return error
-				err = fmt.Errorf("Error in receiving refund expect %v got %v", expectedRefund.String(), refunded.String())
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidRefund.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-			}
-		}
+	err = receiveOffers(c, targetID, recipientAddr, pieceCID, offers, maxOfferRequested, refundVoucher)
+	if err != nil {
+		return nil, err
 	}
 
 	// Return response
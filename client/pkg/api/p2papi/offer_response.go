@@ -0,0 +1,147 @@
+/*
+Package p2papi contains the API code for the p2p communication.
+*/
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
+)
+
+// receiveOffers verifies and stores the offers of a given cid in a response from a given gateway, paid for a given
+// number of offers, then receives the refund of the offers not supplied. It pends the gateway and returns error
+// if the response has more offers than paid for or any offer is invalid.
+func receiveOffers(c *core.Core, targetID string, recipientAddr string, pieceCID *cid.ContentID, offers []cidoffer.SubCIDOffer, maxOfferRequested uint32, refundVoucher string) error {
+	if len(offers) > int(maxOfferRequested) {
+		err := fmt.Errorf("Received %v offers, more than requested %v", len(offers), maxOfferRequested)
+		logging.Error(err.Error())
+		// Pend GW
+		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+		c.ReputationMgr.PendPeer(targetID)
+		return err
+	}
+	err := verifyOffers(c, targetID, pieceCID, offers, &reputation.StandardOfferRetrieved)
+	if err != nil {
+		return err
+	}
+	receiveOfferRefund(c, targetID, recipientAddr, refundVoucher, int64(maxOfferRequested)-int64(len(offers)))
+	return nil
+}
+
+// verifyOffers verifies the offers of a given cid received from a given gateway, they are stored once all verified
+// and each of them adds a given record to the gateway. It pends the gateway and returns error if any offer is invalid.
+func verifyOffers(c *core.Core, targetID string, pieceCID *cid.ContentID, offers []cidoffer.SubCIDOffer, record *reputation.Record) error {
+	duplicateCheck := make(map[string]bool)
+	for _, offer := range offers {
+		err := verifyOffer(c, pieceCID, &offer)
+		if err == nil && duplicateCheck[offer.GetMessageDigest()] {
+			err = fmt.Errorf("Received duplicated offers")
+		}
+		if err != nil {
+			logging.Error(err.Error())
+			// Pend GW
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return err
+		}
+		duplicateCheck[offer.GetMessageDigest()] = true
+	}
+	for _, offer := range offers {
+		c.OfferMgr.AddSubOffer(&offer)
+		c.ReputationMgr.UpdatePeerRecord(targetID, record.Copy(), 0)
+	}
+	return nil
+}
+
+// verifyOffer verifies a given offer of a given cid against the signature of its supplier.
+func verifyOffer(c *core.Core, pieceCID *cid.ContentID, offer *cidoffer.SubCIDOffer) error {
+	// Get offer signing key
+	pvdID := offer.GetProviderID()
+	offerSigningKey, err := getOfferSigningKey(c, pvdID)
+	if err != nil {
+		return err
+	}
+	// Verify sub cid.
+	if offer.GetSubCID().ToString() != pieceCID.ToString() {
+		return fmt.Errorf("Received offer that doesn't contain requested cid, expect: %v, got: %v", pieceCID.ToString(), offer.GetSubCID().ToString())
+	}
+	// Verify offer signature
+	if offer.Verify(offerSigningKey) != nil {
+		return fmt.Errorf("Received offer fails to verify against signature of %v", pvdID)
+	}
+	// Verify offer merkle proof
+	if offer.VerifyMerkleProof() != nil {
+		return fmt.Errorf("Received offer fails to verify merkle proof")
+	}
+	// Check offer expiry, reject if less than 1 hour
+	if offer.GetExpiry()-time.Now().Unix() < 3600 {
+		// Offer is soon to expire
+		return fmt.Errorf("Received soon to expire offer")
+	}
+	return nil
+}
+
+// receiveOfferRefund receives the refund from a given gateway of a given number of offers paid for but not supplied.
+// A wrong refund pends the gateway, but the offers are still returned to the client.
+func receiveOfferRefund(c *core.Core, targetID string, recipientAddr string, refundVoucher string, remain int64) {
+	if remain <= 0 {
+		return
+	}
+	refunded, err := c.PaymentMgr.ReceiveRefund(recipientAddr, refundVoucher)
+	if err != nil {
+		logging.Error("Error in receiving refund %v", err.Error())
+		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidRefund.Copy(), 0)
+		c.ReputationMgr.PendPeer(targetID)
+		return
+	}
+	expectedRefund := big.NewInt(0).Mul(c.OfferPrice, big.NewInt(remain))
+	if refunded.Cmp(expectedRefund) < 0 {
+		logging.Error("Error in receiving refund expect %v got %v", expectedRefund.String(), refunded.String())
+		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidRefund.Copy(), 0)
+		c.ReputationMgr.PendPeer(targetID)
+	}
+}
+
+// getOfferSigningKey gets the public key to verify offers supplied by a given peer.
+// Providers sign offers with their offer signing key, gateways sign resale offers of cached content with their msg signing key.
+func getOfferSigningKey(c *core.Core, peerID string) (string, error) {
+	pvdInfo := c.PeerMgr.GetPVDInfo(peerID)
+	if pvdInfo == nil {
+		// Not found, try sync once
+		pvdInfo = c.PeerMgr.SyncPVD(peerID)
+	}
+	if pvdInfo != nil {
+		return pvdInfo.OfferSigningKey, nil
+	}
+	gwInfo := c.PeerMgr.GetGWInfo(peerID)
+	if gwInfo == nil {
+		// Not found, try sync once
+		gwInfo = c.PeerMgr.SyncGW(peerID)
+	}
+	if gwInfo != nil {
+		return gwInfo.MsgSigningKey, nil
+	}
+	return "", fmt.Errorf("Error in obtaining information for provider %v", peerID)
+}
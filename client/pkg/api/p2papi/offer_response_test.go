@@ -0,0 +1,135 @@
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
+)
+
+// mockPeerMgr knows the providers by ID.
+type mockPeerMgr struct {
+	fcrpeermgr.FCRPeerMgr
+	pvds map[string]*fcrpeermgr.Peer
+}
+
+func (mgr *mockPeerMgr) GetPVDInfo(pvdID string) *fcrpeermgr.Peer {
+	return mgr.pvds[pvdID]
+}
+
+func (mgr *mockPeerMgr) SyncPVD(pvdID string) *fcrpeermgr.Peer {
+	return mgr.pvds[pvdID]
+}
+
+func (mgr *mockPeerMgr) GetGWInfo(gwID string) *fcrpeermgr.Peer {
+	return nil
+}
+
+func (mgr *mockPeerMgr) SyncGW(gwID string) *fcrpeermgr.Peer {
+	return nil
+}
+
+// mockRefundPaymentMgr receives refund vouchers of the amount in the voucher.
+type mockRefundPaymentMgr struct {
+	fcrpaymentmgr.FCRPaymentMgr
+	refunded []string
+}
+
+func (mgr *mockRefundPaymentMgr) ReceiveRefund(recipientAddr string, voucher string) (*big.Int, error) {
+	amt, ok := big.NewInt(0).SetString(voucher, 10)
+	if !ok {
+		return nil, errors.New("Test error")
+	}
+	mgr.refunded = append(mgr.refunded, voucher)
+	return amt, nil
+}
+
+func TestReceiveOffers(t *testing.T) {
+	pieceCID, err := cid.NewContentID("QmYb36f6SPpEN8oeznyxD5qSwztygQrK4jn3JEaCvwxUBx")
+	assert.Empty(t, err)
+	privKey, pubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	newSubOffer := func(price int64) cidoffer.SubCIDOffer {
+		offer, err := cidoffer.NewCIDOffer("provider", []cid.ContentID{*pieceCID}, big.NewInt(price), time.Now().Add(2*time.Hour).Unix(), 0)
+		assert.Empty(t, err)
+		assert.Empty(t, offer.Sign(privKey))
+		subOffer, err := offer.GenerateSubCIDOffer(pieceCID)
+		assert.Empty(t, err)
+		return *subOffer
+	}
+	offer1 := newSubOffer(1)
+	offer2 := newSubOffer(2)
+	newCore := func() (*core.Core, *mockRefundPaymentMgr) {
+		c := core.NewCore()
+		c.OfferPrice = big.NewInt(10)
+		c.PeerMgr = &mockPeerMgr{pvds: map[string]*fcrpeermgr.Peer{"provider": {NodeID: "provider", OfferSigningKey: pubKey}}}
+		paymentMgr := &mockRefundPaymentMgr{}
+		c.PaymentMgr = paymentMgr
+		c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(false)
+		c.ReputationMgr = fcrreputationmgr.NewFCRReputationMgrImpV1()
+		c.ReputationMgr.AddPeer("gateway")
+		return c, paymentMgr
+	}
+
+	// Offers are stored and the offers not supplied are refunded
+	c, paymentMgr := newCore()
+	err = receiveOffers(c, "gateway", "addr", pieceCID, []cidoffer.SubCIDOffer{offer1, offer2}, 3, "10")
+	assert.Empty(t, err)
+	assert.Equal(t, 2, len(c.OfferMgr.GetSubOffers(pieceCID)))
+	assert.Equal(t, []string{"10"}, paymentMgr.refunded)
+	assert.False(t, c.ReputationMgr.GetPeerReputation("gateway").Pending)
+
+	// Short refund
+	c, _ = newCore()
+	err = receiveOffers(c, "gateway", "addr", pieceCID, []cidoffer.SubCIDOffer{offer1}, 3, "10")
+	assert.Empty(t, err)
+	assert.Equal(t, 1, len(c.OfferMgr.GetSubOffers(pieceCID)))
+	assert.True(t, c.ReputationMgr.GetPeerReputation("gateway").Pending)
+
+	// More offers than requested
+	c, paymentMgr = newCore()
+	err = receiveOffers(c, "gateway", "addr", pieceCID, []cidoffer.SubCIDOffer{offer1, offer2}, 1, "")
+	assert.NotEmpty(t, err)
+	assert.Empty(t, c.OfferMgr.GetSubOffers(pieceCID))
+	assert.Empty(t, paymentMgr.refunded)
+	assert.True(t, c.ReputationMgr.GetPeerReputation("gateway").Pending)
+
+	// Duplicated offers, none is stored
+	c, _ = newCore()
+	err = receiveOffers(c, "gateway", "addr", pieceCID, []cidoffer.SubCIDOffer{offer1, offer1}, 2, "")
+	assert.NotEmpty(t, err)
+	assert.Empty(t, c.OfferMgr.GetSubOffers(pieceCID))
+	assert.True(t, c.ReputationMgr.GetPeerReputation("gateway").Pending)
+
+	// Offer of an unknown provider
+	c, _ = newCore()
+	c.PeerMgr = &mockPeerMgr{}
+	err = receiveOffers(c, "gateway", "addr", pieceCID, []cidoffer.SubCIDOffer{offer1}, 1, "")
+	assert.NotEmpty(t, err)
+	assert.True(t, c.ReputationMgr.GetPeerReputation("gateway").Pending)
+}
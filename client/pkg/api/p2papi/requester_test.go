@@ -0,0 +1,55 @@
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+)
+
+func TestBind(t *testing.T) {
+	c1 := core.NewCore()
+	c1.NodeID = "client1"
+	c2 := core.NewCore()
+	c2.NodeID = "client2"
+
+	// The requester records the core it runs on behalf of
+	var used *core.Core
+	var usedArgs []interface{}
+	requester := func(c *core.Core, reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+		used = c
+		usedArgs = args
+		return fcrmessages.CreateFCRACKMsg(0, []byte(c.NodeID)), nil
+	}
+	bound1 := Bind(c1, requester)
+	bound2 := Bind(c2, requester)
+
+	res, err := bound1(nil, nil, "arg1", 1)
+	assert.Empty(t, err)
+	assert.Same(t, c1, used)
+	assert.Equal(t, []interface{}{"arg1", 1}, usedArgs)
+	assert.Equal(t, []byte("client1"), res.Body())
+
+	res, err = bound2(nil, nil)
+	assert.Empty(t, err)
+	assert.Same(t, c2, used)
+	assert.Equal(t, 0, len(usedArgs))
+	assert.Equal(t, []byte("client2"), res.Body())
+}
@@ -24,6 +24,11 @@ import (
 	"fmt"
 	"math/big"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	crypto "github.com/libp2p/go-libp2p-crypto"
@@ -31,7 +36,9 @@ import (
 	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/dhtring"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrjournal"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
@@ -41,8 +48,19 @@ import (
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/register"
 )
 
+// DHTLookupClosest is the number of closest gateways tracked in an iterative DHT lookup,
+// it is also the number of closer gateways asked from each contacted gateway.
+const DHTLookupClosest = 4
+
+// SearchProbeConcurrency is the maximum number of gateways whose latency is measured at the same time in a search.
+const SearchProbeConcurrency = 16
+
+// SearchMaxProbes is the maximum number of gateways whose latency is measured in a search.
+const SearchMaxProbes = 64
+
 // FilecoinRetrievalClient is an example implementation using the api,
 // which holds information about the interaction of the Filecoin
 // Retrieval Client with Filecoin Retrieval Gateways/Providers.
@@ -50,7 +68,21 @@ type FilecoinRetrievalClient struct {
 	core *core.Core
 }
 
-// NewFilecoinRetrievalClient initialise the Filecoin Retrieval Client.
+// DefaultDataDir is the dir relative to the home dir where a client keeps its data by default, in a sub dir per wallet address.
+const DefaultDataDir = ".fc-retrieval/client"
+
+// Settings are the settings of a client.
+type Settings struct {
+	// DataDir is the dir keeping the payment channel states and the journal of retrievals in progress,
+	// so they survive a restart. Empty to use the default data dir.
+	DataDir string
+
+	// InMemory keeps everything in memory instead of the data dir, nothing survives a restart.
+	InMemory bool
+}
+
+// NewFilecoinRetrievalClient initialise the Filecoin Retrieval Client with the default settings,
+// its data is kept in the default data dir.
 func NewFilecoinRetrievalClient(
 	walletPrivKey string,
 	lotusAPIAddr string,
@@ -58,12 +90,25 @@ func NewFilecoinRetrievalClient(
 	registerPrivKey string,
 	registerAPIAddr string,
 	registerAuthToken string,
+) (*FilecoinRetrievalClient, error) {
+	return NewFilecoinRetrievalClientWithSettings(Settings{}, walletPrivKey, lotusAPIAddr, lotusAuthToken, registerPrivKey, registerAPIAddr, registerAuthToken)
+}
+
+// NewFilecoinRetrievalClientWithSettings initialise the Filecoin Retrieval Client with given settings.
+func NewFilecoinRetrievalClientWithSettings(
+	settings Settings,
+	walletPrivKey string,
+	lotusAPIAddr string,
+	lotusAuthToken string,
+	registerPrivKey string,
+	registerAPIAddr string,
+	registerAuthToken string,
 ) (*FilecoinRetrievalClient, error) {
 	// Logging init
 	logging.InitWithoutConfig("debug", "STDOUT", "client", "RFC3339")
 
-	// Initialise client
-	c := core.GetSingleInstance()
+	// Initialise client, it owns its core so that multiple clients can coexist
+	c := core.NewCore()
 	res := &FilecoinRetrievalClient{
 		core: c,
 	}
@@ -98,6 +143,27 @@ func NewFilecoinRetrievalClient(
 	}
 	c.NodeID = nodeID
 
+	// Get data dir
+	dataDir := ""
+	if !settings.InMemory {
+		dataDir = settings.DataDir
+		if dataDir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				err = fmt.Errorf("Error in obtaining the home dir: %v", err.Error())
+				logging.Error(err.Error())
+				return nil, err
+			}
+			dataDir = filepath.Join(home, DefaultDataDir, c.WalletAddr)
+		}
+		err = os.MkdirAll(dataDir, 0700)
+		if err != nil {
+			err = fmt.Errorf("Error in creating data dir %v: %v", dataDir, err.Error())
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
+
 	// Initialise P2P Server
 	// Generate Keypair
 	privKey, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
@@ -115,10 +181,11 @@ func NewFilecoinRetrievalClient(
 	// Initialise components
 	c.P2PServer = fcrserver.NewFCRServerImplV1(hex.EncodeToString(privKeyBytes), 0, time.Second*60)
 	c.P2PServer.
-		AddRequester(fcrmessages.EstablishmentRequestType, p2papi.EstablishmentRequester).
-		AddRequester(fcrmessages.StandardOfferDiscoveryRequestType, p2papi.OfferQueryRequester).
-		AddRequester(fcrmessages.DHTOfferDiscoveryRequestType, p2papi.DHTOfferQueryRequester).
-		AddRequester(fcrmessages.DataRetrievalRequestType, p2papi.DataRetrievalRequester)
+		AddRequester(fcrmessages.EstablishmentRequestType, p2papi.Bind(c, p2papi.EstablishmentRequester)).
+		AddRequester(fcrmessages.StandardOfferDiscoveryRequestType, p2papi.Bind(c, p2papi.OfferQueryRequester)).
+		AddRequester(fcrmessages.DHTOfferDiscoveryRequestType, p2papi.Bind(c, p2papi.DHTOfferQueryRequester)).
+		AddRequester(fcrmessages.DHTLookupRequestType, p2papi.Bind(c, p2papi.DHTLookupRequester)).
+		AddRequester(fcrmessages.DataRetrievalRequestType, p2papi.Bind(c, p2papi.DataRetrievalRequester))
 	err = c.P2PServer.Start()
 	if err != nil {
 		err = fmt.Errorf("Error in starting P2P server: %v", err.Error())
@@ -137,7 +204,7 @@ func NewFilecoinRetrievalClient(
 	}
 
 	c.RegisterMgr = fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
-	c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(c.RegisterMgr, c.ReputationMgr, false, false, false, nodeID, time.Hour)
+	c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(c.RegisterMgr, c.ReputationMgr, false, false, false, nodeID, 0, time.Hour)
 	err = c.PeerMgr.Start()
 	if err != nil {
 		err = fmt.Errorf("Error in starting peer manager: %v", err.Error())
@@ -147,7 +214,11 @@ func NewFilecoinRetrievalClient(
 	}
 
 	lotusMgr := fcrlotusmgr.NewFCRLotusMgrImplV1(lotusAPIAddr, lotusAuthToken, nil)
-	c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(walletPrivKey, lotusMgr)
+	if dataDir != "" {
+		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV2(walletPrivKey, lotusMgr, filepath.Join(dataDir, "payment.db"))
+	} else {
+		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(walletPrivKey, lotusMgr)
+	}
 	err = c.PaymentMgr.Start()
 	if err != nil {
 		err = fmt.Errorf("Error in starting payment manager: %v", err.Error())
@@ -165,6 +236,19 @@ func NewFilecoinRetrievalClient(
 		return nil, err
 	}
 
+	if dataDir != "" {
+		c.Journal = fcrjournal.NewFCRJournalImplV2(filepath.Join(dataDir, "journal.db"))
+	} else {
+		c.Journal = fcrjournal.NewFCRJournalImplV1()
+	}
+	err = c.Journal.Start()
+	if err != nil {
+		err = fmt.Errorf("Error in starting journal: %v", err.Error())
+		logging.Error(err.Error())
+		res.Shutdown()
+		return nil, err
+	}
+
 	// At start-up, updating all active gateways and providers
 	for _, peerID := range c.ReputationMgr.ListPeers() {
 		if c.PeerMgr.GetGWInfo(peerID) != nil {
@@ -179,6 +263,7 @@ func NewFilecoinRetrievalClient(
 }
 
 // Shutdown shuts down the client's routine.
+// It is safe to call more than once, and a new client can be created afterwards in the same process.
 func (c *FilecoinRetrievalClient) Shutdown() {
 	if c.core.P2PServer != nil {
 		c.core.P2PServer.Shutdown()
@@ -195,24 +280,136 @@ func (c *FilecoinRetrievalClient) Shutdown() {
 	if c.core.ReputationMgr != nil {
 		c.core.ReputationMgr.Shutdown()
 	}
+	if c.core.Journal != nil {
+		c.core.Journal.Shutdown()
+	}
 }
 
-// Search searches gateways that are in given location.
+// Search searches gateways, those in given location first.
+// Gateways are ordered by region match, then by reputation, then by the latency measured with an establishment request.
+// Gateways failing to respond come last among those with the same region match and reputation.
+// The gateways in given location are listed page by page from the register, the other gateways are only listed
+// if there are fewer than SearchMaxProbes in the location, and they are taken by reputation.
+// At most SearchMaxProbes gateways are measured, SearchProbeConcurrency at a time.
 func (c *FilecoinRetrievalClient) Search(location string) ([]string, error) {
-	// TODO, Search by location
-	res := make([]string, 0)
-	infos, err := c.core.RegisterMgr.GetAllRegisteredGateway(0, 0)
+	infos, err := c.searchCandidates(location)
 	if err != nil {
-		err = fmt.Errorf("Error in getting all registered gateways: %v", err.Error())
 		logging.Error(err.Error())
 		return nil, err
 	}
-	for _, info := range infos {
-		res = append(res, info.NodeID)
+	results := make([]searchResult, len(infos))
+	var wg sync.WaitGroup
+	probes := make(chan bool, SearchProbeConcurrency)
+	for i, info := range infos {
+		results[i] = searchResult{
+			nodeID:   info.NodeID,
+			inRegion: location != "" && strings.EqualFold(info.RegionCode, location),
+			score:    c.getScore(info.NodeID),
+			latency:  -1,
+		}
+		wg.Add(1)
+		probes <- true
+		go func(res *searchResult, networkAddr string) {
+			defer func() {
+				<-probes
+				wg.Done()
+			}()
+			res.latency = c.measureLatency(res.nodeID, networkAddr)
+		}(&results[i], info.NetworkAddr)
+	}
+	wg.Wait()
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].inRegion != results[j].inRegion {
+			return results[i].inRegion
+		}
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		if (results[i].latency < 0) != (results[j].latency < 0) {
+			return results[j].latency < 0
+		}
+		return results[i].latency < results[j].latency
+	})
+	res := make([]string, 0, len(results))
+	for _, result := range results {
+		res = append(res, result.nodeID)
 	}
 	return res, nil
 }
 
+// searchCandidates gets the registered gateways to measure in a search in given location, at most SearchMaxProbes.
+// The gateways in the location come first, then the other gateways with the highest reputation.
+func (c *FilecoinRetrievalClient) searchCandidates(location string) ([]register.GatewayRegisteredInfo, error) {
+	res := make([]register.GatewayRegisteredInfo, 0)
+	found := make(map[string]bool)
+	if location != "" {
+		maxPage, err := c.core.RegisterMgr.GetGWMaxPageByRegion(0, location)
+		if err != nil {
+			return nil, fmt.Errorf("Error in getting the number of registered gateways in %v: %v", location, err.Error())
+		}
+		for page := uint64(0); page <= maxPage && len(res) < SearchMaxProbes; page++ {
+			infos, err := c.core.RegisterMgr.GetRegisteredGatewaysByRegion(0, location, page)
+			if err != nil {
+				return nil, fmt.Errorf("Error in getting registered gateways in %v: %v", location, err.Error())
+			}
+			for _, info := range infos {
+				if len(res) >= SearchMaxProbes {
+					break
+				}
+				if !found[info.NodeID] {
+					found[info.NodeID] = true
+					res = append(res, info)
+				}
+			}
+		}
+		if len(res) >= SearchMaxProbes {
+			return res, nil
+		}
+	}
+	infos, err := c.core.RegisterMgr.GetAllRegisteredGateway(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Error in getting all registered gateways: %v", err.Error())
+	}
+	others := make([]register.GatewayRegisteredInfo, 0)
+	for _, info := range infos {
+		if !found[info.NodeID] {
+			others = append(others, info)
+		}
+	}
+	sort.SliceStable(others, func(i, j int) bool {
+		return c.getScore(others[i].NodeID) > c.getScore(others[j].NodeID)
+	})
+	if len(others) > SearchMaxProbes-len(res) {
+		others = others[:SearchMaxProbes-len(res)]
+	}
+	return append(res, others...), nil
+}
+
+// getScore gets the reputation score of a given peer, 0 if it is not active.
+func (c *FilecoinRetrievalClient) getScore(peerID string) int64 {
+	rep := c.core.ReputationMgr.GetPeerReputation(peerID)
+	if rep == nil {
+		return 0
+	}
+	return rep.Score
+}
+
+// measureLatency measures the round-trip time of an establishment request to a given gateway, negative if it fails to respond.
+// The gateway information is synced from the register beforehand, so only the P2P exchange is timed.
+func (c *FilecoinRetrievalClient) measureLatency(gwID string, networkAddr string) time.Duration {
+	if c.core.PeerMgr.GetGWInfo(gwID) == nil && c.core.PeerMgr.SyncGW(gwID) == nil {
+		logging.Warn("Fail to measure latency to gateway %v: Error in obtaining information for gateway", gwID)
+		return -1
+	}
+	start := time.Now()
+	_, err := c.core.P2PServer.Request(networkAddr, fcrmessages.EstablishmentRequestType, gwID, true)
+	if err != nil {
+		logging.Warn("Fail to measure latency to gateway %v: %v", gwID, err.Error())
+		return -1
+	}
+	return time.Since(start)
+}
+
 // AddActivePeer adds an active peer by its ID
 func (c *FilecoinRetrievalClient) AddActivePeer(targetID string) error {
 	if c.core.ReputationMgr.GetPeerReputation(targetID) != nil {
@@ -221,24 +418,11 @@ func (c *FilecoinRetrievalClient) AddActivePeer(targetID string) error {
 		return err
 	}
 
-	// Get peer info as it is a gateway
-	peerInfo := c.core.PeerMgr.GetGWInfo(targetID)
+	peerInfo := c.getPeerInfo(targetID)
 	if peerInfo == nil {
-		// Not found, try sync once
-		peerInfo = c.core.PeerMgr.SyncGW(targetID)
-		if peerInfo == nil {
-			// Get peer info as it is a provider
-			peerInfo = c.core.PeerMgr.GetPVDInfo(targetID)
-			if peerInfo == nil {
-				// Not found, try sync once
-				peerInfo = c.core.PeerMgr.SyncPVD(targetID)
-				if peerInfo == nil {
-					err := fmt.Errorf("Error in obtaining information for peer %v", targetID)
-					logging.Error(err.Error())
-					return err
-				}
-			}
-		}
+		err := fmt.Errorf("Error in obtaining information for peer %v", targetID)
+		logging.Error(err.Error())
+		return err
 	}
 	_, err := c.core.P2PServer.Request(peerInfo.NetworkAddr, fcrmessages.EstablishmentRequestType, targetID, true)
 	if err != nil {
@@ -265,6 +449,54 @@ func (c *FilecoinRetrievalClient) AddActivePeer(targetID string) error {
 	return nil
 }
 
+// GetCostToCreate gets the estimated on-chain cost to create a payment channel to a given peer.
+// The cost excludes the topup amount deposited into the channel, it is zero if a channel already exists.
+func (c *FilecoinRetrievalClient) GetCostToCreate(targetID string) (*big.Int, error) {
+	peerInfo := c.getPeerInfo(targetID)
+	if peerInfo == nil {
+		err := fmt.Errorf("Error in obtaining information for peer %v", targetID)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	recipientAddr, err := fcrcrypto.GetWalletAddress(peerInfo.RootKey)
+	if err != nil {
+		err = fmt.Errorf("Error in obtaining wallet addreess for peer %v with root key %v: %v", targetID, peerInfo.RootKey, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	_, _, _, err = c.core.PaymentMgr.GetOutboundChStatus(recipientAddr)
+	if err == nil {
+		// Channel exists
+		return big.NewInt(0), nil
+	}
+	cost, err := c.core.PaymentMgr.GetCostToCreate(recipientAddr, c.core.TopupAmount)
+	if err != nil {
+		err = fmt.Errorf("Error in estimating cost to create a payment channel to %v with wallet address %v: %v", targetID, recipientAddr, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	return cost, nil
+}
+
+// EstimateRetrievalCost estimates the total cost to retrieve a file using the offer with given digest.
+// It is twice the search price, one request for the leaves of the content and one for the content, plus the offer price,
+// plus the cost to create a payment channel if there is none to the provider.
+func (c *FilecoinRetrievalClient) EstimateRetrievalCost(digest string) (*big.Int, error) {
+	suboffer := c.core.OfferMgr.GetSubOfferByDigest(digest)
+	if suboffer == nil {
+		err := fmt.Errorf("Cannot find offer with given digest %v", digest)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	createCost, err := c.GetCostToCreate(suboffer.GetProviderID())
+	if err != nil {
+		return nil, err
+	}
+	cost := big.NewInt(0).Mul(c.core.SearchPrice, big.NewInt(2))
+	cost.Add(cost, suboffer.GetPrice())
+	return cost.Add(cost, createCost), nil
+}
+
 // ListActivePeers lists all active peers
 func (c *FilecoinRetrievalClient) ListActivePeers() []string {
 	return c.core.ReputationMgr.ListPeers()
@@ -317,7 +549,10 @@ func (c *FilecoinRetrievalClient) ListOffers(cidStr string) ([]cidoffer.SubCIDOf
 	return c.core.OfferMgr.GetSubOffers(pieceCID), nil
 }
 
-// Retrieve retrieves a file to a given location
+// Retrieve retrieves a file to a given location using the offer with given digest.
+// The leaves of the DAG of the content are retrieved first and verified against the cid, then the content is retrieved as
+// a data range, every leaf is verified and journaled as it is written. If the retrieval fails, the partial file is kept,
+// and retrieving the same cid to the same location again, with this offer or another, resumes it, see ParallelRetrieve.
 func (c *FilecoinRetrievalClient) Retrieve(digest string, location string) error {
 	suboffer := c.core.OfferMgr.GetSubOfferByDigest(digest)
 	if suboffer == nil {
@@ -325,25 +560,28 @@ func (c *FilecoinRetrievalClient) Retrieve(digest string, location string) error
 		logging.Error(err.Error())
 		return err
 	}
-	// Get provider information
-	pvdInfo := c.core.PeerMgr.GetPVDInfo(suboffer.GetProviderID())
-	if pvdInfo == nil {
-		// Not found, try sync once
-		pvdInfo = c.core.PeerMgr.SyncPVD(suboffer.GetProviderID())
-		if pvdInfo == nil {
-			err := fmt.Errorf("Cannot find provider %v that supplied the offer", suboffer.GetProviderID())
-			logging.Error(err.Error())
-			return err
-		}
+	cidStr := suboffer.GetSubCID().ToString()
+	report := &ParallelRetrievalReport{
+		CID: cidStr,
+	}
+
+	// Check if the retrieval is in progress
+	journaled, err := c.resumeRetrieval(cidStr, location, report)
+	if err != nil {
+		return err
 	}
-	if c.core.ReputationMgr.GetPeerReputation(suboffer.GetProviderID()) == nil {
-		// If the provider isn't active, add it.
-		c.AddActivePeer(suboffer.GetProviderID())
+	if journaled != nil && len(journaled.Missing()) == 0 {
+		// Every leaf has been retrieved
+		return c.core.Journal.Remove(cidStr)
 	}
+	return c.retrieveParts(cidStr, location, journaled, []cidoffer.SubCIDOffer{*suboffer}, 1, report)
+}
 
-	// Do data retrieval
-	_, err := c.core.P2PServer.Request(pvdInfo.NetworkAddr, fcrmessages.DataRetrievalRequestType, pvdInfo.NodeID, suboffer, location)
-	return err
+// SetPaymentInterval sets the number of chunks paid by each tranche in data retrieval.
+// The provider pauses after every tranche until the next one is paid. 0 means paying every chunk,
+// a retrieval is always paid incrementally so that it can be resumed.
+func (c *FilecoinRetrievalClient) SetPaymentInterval(interval uint64) {
+	c.core.PaymentInterval = interval
 }
 
 // StandardDiscovery performs a standard discovery.
@@ -410,7 +648,8 @@ func (c *FilecoinRetrievalClient) DHTDiscovery(cidStr string, targetID string) (
 		}
 	}
 	temp := make(map[string]*cidoffer.SubCIDOffer, 0)
-	response, err := c.core.P2PServer.Request(gwInfo.NetworkAddr, fcrmessages.DHTOfferDiscoveryRequestType, targetID, pieceCID, uint32(4), uint32(1))
+	// Ask 4 gateways for up to 1 offer each, and up to 4 offers in total
+	response, err := c.core.P2PServer.Request(gwInfo.NetworkAddr, fcrmessages.DHTOfferDiscoveryRequestType, targetID, pieceCID, uint32(4), uint32(1), uint32(4))
 	if err != nil {
 		err = fmt.Errorf("Error in requesting gateway %v for offers in DHT: %v", targetID, err.Error())
 		logging.Error(err.Error())
@@ -430,33 +669,157 @@ func (c *FilecoinRetrievalClient) DHTDiscovery(cidStr string, targetID string) (
 	return res, nil
 }
 
-func (c *FilecoinRetrievalClient) FastRetrieve(cidStr string, location string, maxPrice *big.Int) error {
+// DHTLookup performs an iterative DHT lookup, it finds up to maxOffers offers within maxHops hops.
+// Starting from the active gateways, each hop pays and queries the closest gateway to the cid hash that has not been queried,
+// and learns the gateways closer to the cid hash from the view of the queried gateway. The lookup stops once the closest
+// gateways known have all been queried. A gateway that is not active is added as an active peer before being queried.
+func (c *FilecoinRetrievalClient) DHTLookup(cidStr string, maxHops uint32, maxOffers uint32) ([]cidoffer.SubCIDOffer, error) {
+	pieceCID, err := cid.NewContentID(cidStr)
+	if err != nil {
+		err = fmt.Errorf("Error in decoding cid: %v: %v", cidStr, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	cidHash, err := pieceCID.CalculateHash()
+	if err != nil {
+		err = fmt.Errorf("Error in calculating hash of cid: %v: %v", cidStr, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	hash := hex.EncodeToString(cidHash)
+	// Seed the lookup with the active gateways
+	ring := dhtring.CreateRing()
+	for _, gw := range c.core.ReputationMgr.ListPeers() {
+		if c.core.PeerMgr.GetGWInfo(gw) != nil {
+			ring.Insert(gw)
+		}
+	}
+	queried := make(map[string]bool)
+	temp := make(map[string]*cidoffer.SubCIDOffer, 0)
+	for hop := uint32(0); hop < maxHops && uint32(len(temp)) < maxOffers; hop++ {
+		targetID := nextToQuery(ring, hash, queried)
+		if targetID == "" {
+			logging.Info("DHT lookup of %v has queried all closest gateways after %v hops", cidStr, hop)
+			break
+		}
+		queried[targetID] = true
+		if c.core.ReputationMgr.GetPeerReputation(targetID) == nil {
+			// If the gateway isn't active, add it.
+			err = c.AddActivePeer(targetID)
+			if err != nil {
+				logging.Error("Error in adding gateway %v in DHT lookup: %v", targetID, err.Error())
+				ring.Remove(targetID)
+				continue
+			}
+		}
+		gwInfo := c.core.PeerMgr.GetGWInfo(targetID)
+		if gwInfo == nil {
+			logging.Error("Error in obtaining information for gateway %v", targetID)
+			ring.Remove(targetID)
+			continue
+		}
+		response, err := c.core.P2PServer.Request(gwInfo.NetworkAddr, fcrmessages.DHTLookupRequestType, targetID, pieceCID, maxOffers-uint32(len(temp)), uint32(DHTLookupClosest))
+		if err != nil {
+			logging.Error("Error in requesting gateway %v for DHT lookup: %v", targetID, err.Error())
+			ring.Remove(targetID)
+			continue
+		}
+		_, offers, closer, _, _ := fcrmessages.DecodeDHTLookupResponse(response)
+		for i := range offers {
+			temp[offers[i].GetMessageDigest()] = &offers[i]
+		}
+		for _, gw := range closer {
+			if !queried[gw] {
+				ring.Insert(gw)
+			}
+		}
+	}
+	res := make([]cidoffer.SubCIDOffer, 0)
+	for _, offer := range temp {
+		res = append(res, *offer)
+	}
+	return res, nil
+}
+
+// FastRetrieve discovers offers for a given cid and retrieves it to a given location, trying the offers in the planned order
+// until one succeeds, see PlanRetrieval. No offer with a price above max price is tried.
+// It returns the report of the attempts, which is not nil unless no offer is found.
+func (c *FilecoinRetrievalClient) FastRetrieve(cidStr string, location string, maxPrice *big.Int) (*RetrievalReport, error) {
 	// Do standard search
 	res, err := c.StandardDiscovery(cidStr)
 	if len(res) == 0 {
 		err = fmt.Errorf("No offer found for given cid: %v", cidStr)
 		logging.Error(err.Error())
-		return err
+		return nil, err
 	}
 	logging.Info("Find %v offers containing given cid: %v", len(res), cidStr)
-	logging.Info("Start data retrieval.")
-
-	// TODO:
-	// Sort the result, from cheapest offer in active providers, all the way to most expensive offer in active providers.
-	// Then from cheapest offer in inactive providers, all the way to most expensive offer in inactive providers.
-	// And they must not exceed max price.
-	// At the moment, it iterates through the offers and retrieve offer from active providers.
-	for _, offer := range res {
-		if offer.GetPrice().Cmp(maxPrice) < 0 {
-			err = c.Retrieve(offer.GetMessageDigest(), location)
-			if err == nil {
-				return nil
-			}
-			logging.Error("Error retrieving content %v using offer %v from %v", cidStr, offer.GetMessageDigest(), offer.GetProviderID())
+
+	planned, skipped := c.PlanRetrieval(res, maxPrice)
+	report := &RetrievalReport{
+		CID:       cidStr,
+		Attempts:  make([]RetrievalAttempt, 0),
+		Skipped:   skipped,
+		Succeeded: false,
+	}
+	logging.Info("Start data retrieval with %v planned offers, %v offers skipped.", len(planned), len(skipped))
+	for _, p := range planned {
+		start := time.Now()
+		err = c.Retrieve(p.Offer.GetMessageDigest(), location)
+		report.Attempts = append(report.Attempts, RetrievalAttempt{
+			PlannedOffer: p,
+			Duration:     time.Since(start),
+			Err:          err,
+		})
+		if err == nil {
+			report.Succeeded = true
+			return report, nil
 		}
+		logging.Error("Error retrieving content %v using offer %v from %v: %v", cidStr, p.Offer.GetMessageDigest(), p.Offer.GetProviderID(), err.Error())
 	}
 
-	err = fmt.Errorf("Fail to retrieve content with cid %v", cidStr)
+	err = fmt.Errorf("Fail to retrieve content with cid %v after %v attempts", cidStr, len(report.Attempts))
 	logging.Error(err.Error())
-	return err
+	return report, err
+}
+
+// searchResult is a gateway found in a search, with the measures used to order the search results.
+type searchResult struct {
+	nodeID   string
+	inRegion bool
+	score    int64
+	// latency is the round-trip time of an establishment request, negative if the gateway fails to respond
+	latency time.Duration
+}
+
+// getPeerInfo gets the information of a peer, it can be a gateway or a provider.
+func (c *FilecoinRetrievalClient) getPeerInfo(targetID string) *fcrpeermgr.Peer {
+	// Get peer info as it is a gateway
+	peerInfo := c.core.PeerMgr.GetGWInfo(targetID)
+	if peerInfo == nil {
+		// Not found, try sync once
+		peerInfo = c.core.PeerMgr.SyncGW(targetID)
+		if peerInfo == nil {
+			// Get peer info as it is a provider
+			peerInfo = c.core.PeerMgr.GetPVDInfo(targetID)
+			if peerInfo == nil {
+				// Not found, try sync once
+				peerInfo = c.core.PeerMgr.SyncPVD(targetID)
+			}
+		}
+	}
+	return peerInfo
+}
+
+// nextToQuery gets the closest gateway to a given hash in the ring that has not been queried,
+// among the DHTLookupClosest closest gateways. It returns empty string if they have all been queried.
+func nextToQuery(ring *dhtring.Ring, hash string, queried map[string]bool) string {
+	// The closest n gateways include the closest n - 1 gateways
+	for n := 1; n <= DHTLookupClosest && n <= ring.Size(); n++ {
+		for _, id := range ring.GetClosest(hash, n, "") {
+			if !queried[id] {
+				return id
+			}
+		}
+	}
+	return ""
 }
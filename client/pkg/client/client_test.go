@@ -0,0 +1,430 @@
+package client
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/dhtring"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrregistermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/register"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
+)
+
+const testCID = "QmX5Rg8t9zh26JcaTk7VnDXqv5SHH2bT6AfeoTFLSsp4dK"
+
+// lookupResponse is the response of a gateway to a dht lookup request in tests.
+type lookupResponse struct {
+	offers []cidoffer.SubCIDOffer
+	closer []string
+	err    error
+}
+
+// mockServer answers requests with the lookup responses of the target gateways, by network address.
+type mockServer struct {
+	fcrserver.FCRServer
+	lock      sync.Mutex
+	responses map[string]lookupResponse
+	// lookups are the network addresses of the dht lookup requests, in the order sent
+	lookups []string
+}
+
+func (s *mockServer) Request(multiaddrStr string, msgType byte, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch msgType {
+	case fcrmessages.EstablishmentRequestType:
+		return fcrmessages.CreateFCRACKMsg(0, nil), nil
+	case fcrmessages.DHTLookupRequestType:
+		s.lookups = append(s.lookups, multiaddrStr)
+		res, ok := s.responses[multiaddrStr]
+		if !ok {
+			return nil, errors.New("Test error")
+		}
+		if res.err != nil {
+			return nil, res.err
+		}
+		return fcrmessages.EncodeDHTLookupResponse(0, res.offers, res.closer, "")
+	}
+	return nil, fmt.Errorf("Unexpected message type %v", msgType)
+}
+
+// mockPeerMgr knows a fixed set of gateways.
+type mockPeerMgr struct {
+	fcrpeermgr.FCRPeerMgr
+	gws map[string]*fcrpeermgr.Peer
+}
+
+func (mgr *mockPeerMgr) GetGWInfo(gwID string) *fcrpeermgr.Peer {
+	return mgr.gws[gwID]
+}
+
+func (mgr *mockPeerMgr) SyncGW(gwID string) *fcrpeermgr.Peer {
+	return mgr.gws[gwID]
+}
+
+func (mgr *mockPeerMgr) GetPVDInfo(pvdID string) *fcrpeermgr.Peer {
+	return nil
+}
+
+func (mgr *mockPeerMgr) SyncPVD(pvdID string) *fcrpeermgr.Peer {
+	return nil
+}
+
+// mockPaymentMgr records the payment channels created.
+type mockPaymentMgr struct {
+	fcrpaymentmgr.FCRPaymentMgr
+	lock    sync.Mutex
+	created []string
+}
+
+func (mgr *mockPaymentMgr) Create(recipientAddr string, amt *big.Int) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.created = append(mgr.created, recipientAddr)
+	return nil
+}
+
+// mockRegisterMgr lists a fixed set of gateways, the gateways in a region are listed mockRegionPageSize a page.
+type mockRegisterMgr struct {
+	fcrregistermgr.FCRRegisterMgr
+	gws []register.GatewayRegisteredInfo
+	// listed is the number of times all gateways are listed
+	listed int
+}
+
+const mockRegionPageSize = 10
+
+func (mgr *mockRegisterMgr) GetAllRegisteredGateway(from uint64, to uint64) ([]register.GatewayRegisteredInfo, error) {
+	mgr.listed++
+	return mgr.gws, nil
+}
+
+func (mgr *mockRegisterMgr) regionGWS(region string) []register.GatewayRegisteredInfo {
+	res := make([]register.GatewayRegisteredInfo, 0)
+	for _, gw := range mgr.gws {
+		if strings.EqualFold(gw.RegionCode, region) {
+			res = append(res, gw)
+		}
+	}
+	return res
+}
+
+func (mgr *mockRegisterMgr) GetGWMaxPageByRegion(height uint64, region string) (uint64, error) {
+	total := len(mgr.regionGWS(region))
+	if total == 0 {
+		return 0, nil
+	}
+	return uint64((total - 1) / mockRegionPageSize), nil
+}
+
+func (mgr *mockRegisterMgr) GetRegisteredGatewaysByRegion(height uint64, region string, page uint64) ([]register.GatewayRegisteredInfo, error) {
+	gws := mgr.regionGWS(region)
+	start := int(page) * mockRegionPageSize
+	if start >= len(gws) {
+		return []register.GatewayRegisteredInfo{}, nil
+	}
+	end := start + mockRegionPageSize
+	if end > len(gws) {
+		end = len(gws)
+	}
+	return gws[start:end], nil
+}
+
+// testGWID gets the ID of a gateway at a given clockwise distance from the hash of the test cid.
+func testGWID(t *testing.T, dist int64) string {
+	pieceCID, err := cid.NewContentID(testCID)
+	assert.Empty(t, err)
+	cidHash, err := pieceCID.CalculateHash()
+	assert.Empty(t, err)
+	id := big.NewInt(0).Add(big.NewInt(0).SetBytes(cidHash), big.NewInt(dist))
+	id.Mod(id, big.NewInt(0).Lsh(big.NewInt(1), 256))
+	return fmt.Sprintf("%064x", id)
+}
+
+// testOffer gets a sub offer of the test cid, offers of different prices have different digests.
+func testOffer(t *testing.T, price int64) cidoffer.SubCIDOffer {
+	pieceCID, err := cid.NewContentID(testCID)
+	assert.Empty(t, err)
+	offer, err := cidoffer.NewCIDOffer("testprovider", []cid.ContentID{*pieceCID}, big.NewInt(price), 100, 10)
+	assert.Empty(t, err)
+	subOffer, err := offer.GenerateSubCIDOffer(pieceCID)
+	assert.Empty(t, err)
+	return *subOffer
+}
+
+// newTestClient creates a client knowing given gateways, the active ones are added as peers.
+// The network address of a gateway is its ID.
+func newTestClient(t *testing.T, gws []string, active []string, responses map[string]lookupResponse) (*FilecoinRetrievalClient, *mockServer, *mockPaymentMgr) {
+	_, rootKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	peerMgr := &mockPeerMgr{gws: make(map[string]*fcrpeermgr.Peer)}
+	for _, gw := range gws {
+		peerMgr.gws[gw] = &fcrpeermgr.Peer{RootKey: rootKey, NodeID: gw, NetworkAddr: gw}
+	}
+	reputationMgr := fcrreputationmgr.NewFCRReputationMgrImpV1()
+	for _, gw := range active {
+		reputationMgr.AddPeer(gw)
+	}
+	server := &mockServer{responses: responses, lookups: make([]string, 0)}
+	paymentMgr := &mockPaymentMgr{created: make([]string, 0)}
+	c := &FilecoinRetrievalClient{core: &core.Core{
+		P2PServer:     server,
+		PeerMgr:       peerMgr,
+		PaymentMgr:    paymentMgr,
+		ReputationMgr: reputationMgr,
+		TopupAmount:   big.NewInt(1000),
+	}}
+	return c, server, paymentMgr
+}
+
+func TestSearch(t *testing.T) {
+	gws := []string{testGWID(t, 1), testGWID(t, 2), testGWID(t, 3), testGWID(t, 4)}
+	c, _, _ := newTestClient(t, gws, []string{gws[2], gws[3]}, nil)
+	c.core.ReputationMgr.UpdatePeerRecord(gws[3], &reputation.MockGoodRecord, 0)
+	c.core.RegisterMgr = &mockRegisterMgr{gws: []register.GatewayRegisteredInfo{
+		{NodeID: gws[0], RegionCode: "us", NetworkAddr: gws[0]},
+		{NodeID: gws[1], RegionCode: "AU", NetworkAddr: gws[1]},
+		{NodeID: gws[2], RegionCode: "us", NetworkAddr: gws[2]},
+		{NodeID: gws[3], RegionCode: "us", NetworkAddr: gws[3]},
+	}}
+
+	// The gateways in the region come first, the other gateways fill up the probes
+	res, err := c.Search("au")
+	assert.Empty(t, err)
+	assert.Equal(t, 4, len(res))
+	assert.Equal(t, gws[1], res[0])
+	assert.Equal(t, gws[3], res[1])
+
+	res, err = c.Search("")
+	assert.Empty(t, err)
+	assert.Equal(t, gws[3], res[0])
+}
+
+func TestSearchMaxProbes(t *testing.T) {
+	gws := make([]string, 0)
+	infos := make([]register.GatewayRegisteredInfo, 0)
+	for i := 0; i < SearchMaxProbes+20; i++ {
+		gws = append(gws, testGWID(t, int64(i+1)))
+		region := "au"
+		if i%2 == 1 && i < 20 {
+			region = "us"
+		}
+		infos = append(infos, register.GatewayRegisteredInfo{NodeID: gws[i], RegionCode: region, NetworkAddr: gws[i]})
+	}
+	last := gws[len(gws)-1]
+	c, _, _ := newTestClient(t, gws, []string{last}, nil)
+	c.core.ReputationMgr.UpdatePeerRecord(last, &reputation.MockGoodRecord, 0)
+	registerMgr := &mockRegisterMgr{gws: infos}
+	c.core.RegisterMgr = registerMgr
+
+	// Enough gateways in the region, the other gateways are not listed
+	res, err := c.Search("au")
+	assert.Empty(t, err)
+	assert.Equal(t, SearchMaxProbes, len(res))
+	assert.Equal(t, 0, registerMgr.listed)
+	for _, id := range res {
+		assert.Equal(t, "au", infos[indexOf(gws, id)].RegionCode)
+	}
+
+	// Few gateways in the region, the others are taken by reputation
+	res, err = c.Search("us")
+	assert.Empty(t, err)
+	assert.Equal(t, SearchMaxProbes, len(res))
+	assert.Equal(t, 1, registerMgr.listed)
+	for _, id := range res[:10] {
+		assert.Equal(t, "us", infos[indexOf(gws, id)].RegionCode)
+	}
+	assert.Equal(t, last, res[10])
+
+	res, err = c.Search("")
+	assert.Empty(t, err)
+	assert.Equal(t, SearchMaxProbes, len(res))
+	assert.Equal(t, last, res[0])
+}
+
+// indexOf gets the index of a given string in a given list, -1 if not found.
+func indexOf(list []string, str string) int {
+	for i, s := range list {
+		if s == str {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestNextToQuery(t *testing.T) {
+	hash := testGWID(t, 0)
+	ring := dhtring.CreateRing()
+	assert.Equal(t, "", nextToQuery(ring, hash, map[string]bool{}))
+
+	for i := int64(1); i <= 6; i++ {
+		ring.Insert(testGWID(t, i*10))
+	}
+	queried := make(map[string]bool)
+	for i := int64(1); i <= DHTLookupClosest; i++ {
+		next := nextToQuery(ring, hash, queried)
+		assert.Equal(t, testGWID(t, i*10), next)
+		queried[next] = true
+	}
+	// Gateways beyond the closest ones are never queried
+	assert.Equal(t, "", nextToQuery(ring, hash, queried))
+
+	// A closer gateway learnt later is queried next
+	ring.Insert(testGWID(t, 5))
+	assert.Equal(t, testGWID(t, 5), nextToQuery(ring, hash, queried))
+	queried[testGWID(t, 5)] = true
+	assert.Equal(t, "", nextToQuery(ring, hash, queried))
+}
+
+func TestDHTLookupWalk(t *testing.T) {
+	far, mid, near := testGWID(t, 100), testGWID(t, 10), testGWID(t, 1)
+	responses := map[string]lookupResponse{
+		far:  {offers: []cidoffer.SubCIDOffer{testOffer(t, 1)}, closer: []string{mid}},
+		mid:  {offers: []cidoffer.SubCIDOffer{testOffer(t, 2), testOffer(t, 1)}, closer: []string{near, far}},
+		near: {offers: []cidoffer.SubCIDOffer{testOffer(t, 3)}},
+	}
+	c, server, paymentMgr := newTestClient(t, []string{far, mid, near}, []string{far}, responses)
+
+	offers, err := c.DHTLookup(testCID, 10, 10)
+	assert.Empty(t, err)
+	// Duplicated offers are counted once
+	assert.Equal(t, 3, len(offers))
+	// Each hop queries the closest gateway not queried, the walk ends once the closest gateways are all queried
+	assert.Equal(t, []string{far, mid, near}, server.lookups)
+	// The gateways learnt are added as active peers before being queried
+	assert.Equal(t, 2, len(paymentMgr.created))
+	assert.NotEmpty(t, c.core.ReputationMgr.GetPeerReputation(mid))
+	assert.NotEmpty(t, c.core.ReputationMgr.GetPeerReputation(near))
+}
+
+func TestDHTLookupTermination(t *testing.T) {
+	far, mid, near := testGWID(t, 100), testGWID(t, 10), testGWID(t, 1)
+	responses := map[string]lookupResponse{
+		far:  {offers: []cidoffer.SubCIDOffer{testOffer(t, 1)}, closer: []string{mid}},
+		mid:  {offers: []cidoffer.SubCIDOffer{testOffer(t, 2)}, closer: []string{near}},
+		near: {offers: []cidoffer.SubCIDOffer{testOffer(t, 3)}},
+	}
+
+	// Stops after max hops
+	c, server, _ := newTestClient(t, []string{far, mid, near}, []string{far}, responses)
+	offers, err := c.DHTLookup(testCID, 2, 10)
+	assert.Empty(t, err)
+	assert.Equal(t, 2, len(offers))
+	assert.Equal(t, []string{far, mid}, server.lookups)
+
+	// Stops once enough offers are found
+	c, server, _ = newTestClient(t, []string{far, mid, near}, []string{far}, responses)
+	offers, err = c.DHTLookup(testCID, 10, 1)
+	assert.Empty(t, err)
+	assert.Equal(t, 1, len(offers))
+	assert.Equal(t, []string{far}, server.lookups)
+
+	// A gateway failing to respond is dropped, the gateways it would have led to are never learnt
+	responses[mid] = lookupResponse{err: errors.New("Test error")}
+	c, server, _ = newTestClient(t, []string{far, mid, near}, []string{far}, responses)
+	offers, err = c.DHTLookup(testCID, 10, 10)
+	assert.Empty(t, err)
+	assert.Equal(t, 1, len(offers))
+	assert.Equal(t, []string{far, mid}, server.lookups)
+
+	// Only the closest active gateways are queried when none knows a closer one
+	gws := make([]string, 0)
+	for i := int64(1); i <= DHTLookupClosest+2; i++ {
+		gws = append(gws, testGWID(t, i))
+	}
+	c, server, _ = newTestClient(t, gws, gws, map[string]lookupResponse{})
+	for _, gw := range gws {
+		server.responses[gw] = lookupResponse{}
+	}
+	offers, err = c.DHTLookup(testCID, 10, 10)
+	assert.Empty(t, err)
+	assert.Equal(t, 0, len(offers))
+	assert.Equal(t, gws[:DHTLookupClosest], server.lookups)
+
+	// Invalid cid
+	_, err = c.DHTLookup("invalid", 10, 10)
+	assert.NotEmpty(t, err)
+}
+
+func TestMultipleClients(t *testing.T) {
+	walletKey1, _, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	walletKey2, _, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	dataDir := t.TempDir()
+
+	// Two clients coexist, each with its own core
+	c1, err := NewFilecoinRetrievalClientWithSettings(Settings{DataDir: dataDir}, walletKey1, "http://localhost:1234/rpc/v0", "", "", "localhost:9020", "")
+	assert.Empty(t, err)
+	c2, err := NewFilecoinRetrievalClientWithSettings(Settings{InMemory: true}, walletKey2, "http://localhost:1234/rpc/v0", "", "", "localhost:9020", "")
+	assert.Empty(t, err)
+	assert.NotEqual(t, c1.core.NodeID, c2.core.NodeID)
+	assert.NotEqual(t, c1.core.WalletAddr, c2.core.WalletAddr)
+	c1.SetPaymentInterval(10)
+	assert.Equal(t, uint64(10), c1.core.PaymentInterval)
+	assert.Equal(t, uint64(0), c2.core.PaymentInterval)
+
+	// A client shut down can be replaced by a new one using the same data dir, leaving the other client running
+	c1.Shutdown()
+	c3, err := NewFilecoinRetrievalClientWithSettings(Settings{DataDir: dataDir}, walletKey1, "http://localhost:1234/rpc/v0", "", "", "localhost:9020", "")
+	assert.Empty(t, err)
+	assert.NotEqual(t, c1.core.NodeID, c3.core.NodeID)
+	assert.Equal(t, c1.core.WalletAddr, c3.core.WalletAddr)
+	assert.Equal(t, uint64(0), c3.core.PaymentInterval)
+	assert.Equal(t, 0, len(c3.ListRetrievals()))
+	assert.Equal(t, 0, len(c2.ListRetrievals()))
+	c2.Shutdown()
+	c3.Shutdown()
+	_, err = os.Stat(filepath.Join(dataDir, "payment.db"))
+	assert.Empty(t, err)
+}
+
+func TestDefaultDataDir(t *testing.T) {
+	walletKey, walletPubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	walletAddr, err := fcrcrypto.GetWalletAddress(walletPubKey)
+	assert.Empty(t, err)
+	home := os.Getenv("HOME")
+	defer os.Setenv("HOME", home)
+	os.Setenv("HOME", t.TempDir())
+
+	// The data is kept on disk under the home dir by default
+	c, err := NewFilecoinRetrievalClient(walletKey, "http://localhost:1234/rpc/v0", "", "", "localhost:9020", "")
+	assert.Empty(t, err)
+	c.Shutdown()
+	_, err = os.Stat(filepath.Join(os.Getenv("HOME"), DefaultDataDir, walletAddr, "payment.db"))
+	assert.Empty(t, err)
+	_, err = os.Stat(filepath.Join(os.Getenv("HOME"), DefaultDataDir, walletAddr, "journal.db"))
+	assert.Empty(t, err)
+}
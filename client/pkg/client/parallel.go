@@ -0,0 +1,535 @@
+/*
+Package client - contains the client code.
+*/
+package client
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrjournal"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// ParallelPartsPerProvider is the number of parts per provider the content is split into in a parallel retrieval.
+// More parts balance the work better, but every part is a request that costs the search price.
+// A part is written and journaled leaf by leaf, the rest of a part that fails is taken by another provider.
+const ParallelPartsPerProvider = 4
+
+// ParallelSlowFactor is the factor by which a provider must be slower than the fastest provider to stop taking parts,
+// it takes parts again if the faster providers fail.
+const ParallelSlowFactor = 4
+
+// ProviderShare is the share of a provider in a parallel retrieval.
+type ProviderShare struct {
+	// ProviderID is the ID of the provider
+	ProviderID string
+
+	// Digest is the digest of the offer used
+	Digest string
+
+	// Parts is the number of parts delivered
+	Parts int
+
+	// Bytes is the number of bytes delivered
+	Bytes uint64
+
+	// Paid is the content price paid for the parts delivered, excluding the search price paid for every request
+	Paid *big.Int
+
+	// Err is the error that stopped the provider from taking parts, nil if it does not fail
+	Err error
+}
+
+// ParallelRetrievalReport is the report of a parallel retrieval.
+type ParallelRetrievalReport struct {
+	// CID is the content retrieved
+	CID string
+
+	// Size is the size in bytes of the content
+	Size uint64
+
+	// ResumedBytes is the number of bytes retrieved before the retrieval is resumed, 0 if it is not resumed
+	ResumedBytes uint64
+
+	// Parts is the number of parts the missing content is split into
+	Parts int
+
+	// Shares are the shares of the providers, in the planned order
+	Shares []ProviderShare
+}
+
+// ParallelRetrieve retrieves a given cid to a given location from all providers offering it concurrently, at most one offer
+// per provider is used and no offer with a price above max price. The leaves of the DAG of the content are retrieved first
+// and verified against the cid, then the content is split into parts of whole leaves, every part is verified against its leaves.
+// Providers take parts as they finish their previous part, so faster providers take more parts, and providers much slower
+// than the fastest stop taking parts. The rest of a part that fails is taken by another provider, every provider is paid in
+// tranches only for the data it delivers.
+// The retrieval is journaled, every leaf is recorded once it is verified and synced to the file, and every payment is recorded
+// as it is sent. If the retrieval fails, the partial file is kept, and retrieving the same cid to the same location again
+// resumes it, only the missing leaves are requested.
+func (c *FilecoinRetrievalClient) ParallelRetrieve(cidStr string, location string, maxPrice *big.Int) (*ParallelRetrievalReport, error) {
+	id, err := cid.NewContentID(cidStr)
+	if err != nil {
+		err = fmt.Errorf("Error in decoding cid: %v: %v", cidStr, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	report := &ParallelRetrievalReport{
+		CID: cidStr,
+	}
+
+	// Check if the retrieval is in progress
+	journaled, err := c.resumeRetrieval(cidStr, location, report)
+	if err != nil {
+		return nil, err
+	}
+	if journaled != nil && len(journaled.Missing()) == 0 {
+		// Every part has been retrieved
+		return report, c.core.Journal.Remove(cidStr)
+	}
+
+	offers := c.core.OfferMgr.GetSubOffers(id)
+	if len(offers) == 0 {
+		// Do standard search, the offers found are stored
+		c.StandardDiscovery(cidStr)
+		offers = c.core.OfferMgr.GetSubOffers(id)
+	}
+	// One offer per provider, the best planned one
+	planned, _ := c.PlanRetrieval(offers, maxPrice)
+	selected := make([]cidoffer.SubCIDOffer, 0)
+	seen := make(map[string]bool)
+	for _, p := range planned {
+		if !seen[p.Offer.GetProviderID()] {
+			seen[p.Offer.GetProviderID()] = true
+			selected = append(selected, p.Offer)
+		}
+	}
+	if len(selected) == 0 {
+		err = fmt.Errorf("No offer found for given cid within max price: %v", cidStr)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	return report, c.retrieveParts(cidStr, location, journaled, selected, len(selected)*ParallelPartsPerProvider, report)
+}
+
+// resumeRetrieval gets the journaled retrieval of a given cid to resume, nil if there is none.
+// The size and the bytes retrieved of a journaled retrieval are set in the given report.
+// It returns error if the retrieval is in progress to another location than the given one.
+func (c *FilecoinRetrievalClient) resumeRetrieval(cidStr string, location string, report *ParallelRetrievalReport) (*fcrjournal.Retrieval, error) {
+	journaled := c.core.Journal.Get(cidStr)
+	if journaled == nil {
+		return nil, nil
+	}
+	if _, err := os.Stat(journaled.Path); err != nil {
+		// The partial file is gone, start over
+		logging.Warn("Partial file %v of retrieval of %v is not accessible, start over: %v", journaled.Path, cidStr, err.Error())
+		err = c.core.Journal.Remove(cidStr)
+		if err != nil {
+			err = fmt.Errorf("Error in removing retrieval of %v from journal: %v", cidStr, err.Error())
+			logging.Error(err.Error())
+			return nil, err
+		}
+		return nil, nil
+	}
+	if journaled.Path != filepath.Join(location, journaled.Tag) {
+		err := fmt.Errorf("Retrieval of %v is in progress to %v, cancel it before retrieving to another location", cidStr, journaled.Path)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	report.Size = journaled.Size
+	report.ResumedBytes = journaled.VerifiedSize()
+	logging.Info("Resume retrieval of %v, %v of %v bytes retrieved", cidStr, report.ResumedBytes, report.Size)
+	return journaled, nil
+}
+
+// retrieveParts retrieves the content of a given cid to a given location from the selected offers concurrently, the content
+// missing from the given journaled retrieval is split into the given number of parts. If the journaled retrieval is nil,
+// the retrieval is begun and journaled first. The shares of the providers are set in the given report.
+func (c *FilecoinRetrievalClient) retrieveParts(cidStr string, location string, journaled *fcrjournal.Retrieval, selected []cidoffer.SubCIDOffer, parts int, report *ParallelRetrievalReport) error {
+	report.Shares = make([]ProviderShare, len(selected))
+	for i, offer := range selected {
+		report.Shares[i] = ProviderShare{
+			ProviderID: offer.GetProviderID(),
+			Digest:     offer.GetMessageDigest(),
+			Paid:       big.NewInt(0),
+		}
+		if c.core.ReputationMgr.GetPeerReputation(offer.GetProviderID()) == nil {
+			// If the provider isn't active, add it.
+			report.Shares[i].Err = c.AddActivePeer(offer.GetProviderID())
+		}
+	}
+
+	if journaled == nil {
+		var err error
+		journaled, err = c.beginParallelRetrieval(cidStr, location, selected, report)
+		if err != nil {
+			return err
+		}
+	}
+	filename := journaled.Path
+
+	// Retrieve the missing parts concurrently
+	split := splitParts(journaled.Leaves, journaled.Missing(), parts)
+	report.Parts = len(split)
+	sched := newPartScheduler(split, len(selected))
+	var wg sync.WaitGroup
+	for i := range selected {
+		if report.Shares[i].Err != nil {
+			sched.fail(i, nil)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			offer := &selected[i]
+			share := &report.Shares[i]
+			pvdInfo := c.getPeerInfo(offer.GetProviderID())
+			if pvdInfo == nil {
+				share.Err = fmt.Errorf("Cannot find provider or gateway %v that supplied the offer", offer.GetProviderID())
+				sched.fail(i, nil)
+				return
+			}
+			onPaid := func(amt *big.Int) {
+				c.journalPayment(cidStr, offer.GetProviderID(), amt)
+			}
+			for p := sched.next(i); p != nil; p = sched.next(i) {
+				start := time.Now()
+				verified := 0
+				onVerified := func(from int, to int) {
+					verified = to
+					for index := from; index < to; index++ {
+						share.Bytes += p.leaves[index].DataSize
+					}
+					if err := c.core.Journal.MarkVerified(cidStr, p.start+from, p.start+to); err != nil {
+						// The leaves are on disk, they are retrieved again if the retrieval is resumed
+						logging.Error("Error in journaling leaves %v-%v of %v: %v", p.start+from, p.start+to, cidStr, err.Error())
+					}
+				}
+				_, err := c.core.P2PServer.Request(pvdInfo.NetworkAddr, fcrmessages.DataRetrievalRequestType, pvdInfo.NodeID, offer, filename, p.dataRange, p.leaves, onPaid, onVerified)
+				if err != nil {
+					logging.Error("Error retrieving range %v+%v of %v from %v: %v", p.dataRange.Offset, p.dataRange.Length, cidStr, offer.GetProviderID(), err.Error())
+					share.Err = err
+					rest := p.rest(verified)
+					if rest == nil {
+						// Every leaf has been written
+						sched.done(i, p.dataRange.Length, time.Since(start))
+					}
+					sched.fail(i, rest)
+					return
+				}
+				share.Parts++
+				share.Paid.Add(share.Paid, fcrmessages.GetRangeTranchePrice(offer.GetPrice(), report.Size, p.dataRange, 0, fcrmessages.GetChunkCount(p.dataRange.Length)))
+				sched.done(i, p.dataRange.Length, time.Since(start))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if sched.completed != len(split) {
+		err := fmt.Errorf("Fail to retrieve content with cid %v, %v of %v parts retrieved, retrieve it again to resume", cidStr, sched.completed, len(split))
+		logging.Error(err.Error())
+		return err
+	}
+	err := c.core.Journal.Remove(cidStr)
+	if err != nil {
+		logging.Error("Error in removing retrieval of %v from journal: %v", cidStr, err.Error())
+	}
+	return nil
+}
+
+// ListRetrievals lists the retrievals in progress, those can be resumed by retrieving the same cid in parallel again.
+func (c *FilecoinRetrievalClient) ListRetrievals() []fcrjournal.Retrieval {
+	return c.core.Journal.List()
+}
+
+// CancelRetrieval cancels the retrieval in progress of a given cid, the partial file is removed.
+func (c *FilecoinRetrievalClient) CancelRetrieval(cidStr string) error {
+	journaled := c.core.Journal.Get(cidStr)
+	if journaled == nil {
+		err := fmt.Errorf("No retrieval of %v is in progress", cidStr)
+		logging.Error(err.Error())
+		return err
+	}
+	err := c.core.Journal.Remove(cidStr)
+	if err != nil {
+		err = fmt.Errorf("Error in removing retrieval of %v from journal: %v", cidStr, err.Error())
+		logging.Error(err.Error())
+		return err
+	}
+	if err = os.Remove(journaled.Path); err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("Error in removing partial file %v: %v", journaled.Path, err.Error())
+		logging.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// beginParallelRetrieval retrieves the leaves of the content of a given cid from the first of the selected offers that serves
+// them, creates the file under the given location and journals the retrieval. It returns the journaled retrieval and error.
+func (c *FilecoinRetrievalClient) beginParallelRetrieval(cidStr string, location string, selected []cidoffer.SubCIDOffer, report *ParallelRetrievalReport) (*fcrjournal.Retrieval, error) {
+	var tag string
+	var leaves []cid.Leaf
+	spent := big.NewInt(0)
+	found := -1
+	for i, offer := range selected {
+		if report.Shares[i].Err != nil {
+			continue
+		}
+		tag, report.Size, leaves, report.Shares[i].Err = c.retrieveLeaves(&offer, func(amt *big.Int) {
+			// The retrieval is journaled once the leaves are retrieved
+			spent.Add(spent, amt)
+		})
+		if report.Shares[i].Err == nil {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		err := fmt.Errorf("Fail to retrieve the leaves of content with cid %v", cidStr)
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Create file
+	filename := filepath.Join(location, tag)
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		// Exist
+		err = fmt.Errorf("Filename already existed %v", tag)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	f, err := os.Create(filename)
+	if err == nil {
+		err = f.Truncate(int64(report.Size))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = c.core.Journal.Begin(cidStr, tag, filename, report.Size, leaves)
+	}
+	if err != nil {
+		os.Remove(filename)
+		err = fmt.Errorf("Error saving file: %v", err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	c.journalPayment(cidStr, selected[found].GetProviderID(), spent)
+	return c.core.Journal.Get(cidStr), nil
+}
+
+// journalPayment records a given amount paid to a given provider in the retrieval of a given cid, if the amount is not zero.
+func (c *FilecoinRetrievalClient) journalPayment(cidStr string, providerID string, amt *big.Int) {
+	if amt.Sign() == 0 {
+		return
+	}
+	if err := c.core.Journal.AddPayment(cidStr, providerID, amt); err != nil {
+		logging.Error("Error in journaling payment of %v to %v for %v: %v", amt.String(), providerID, cidStr, err.Error())
+	}
+}
+
+// retrieveLeaves retrieves the leaves of the DAG of the content of a given offer, they are verified against the cid.
+// Every payment sent is passed to the given callback.
+// It returns the tag and the size of the content, the leaves and error.
+func (c *FilecoinRetrievalClient) retrieveLeaves(offer *cidoffer.SubCIDOffer, onPaid func(*big.Int)) (string, uint64, []cid.Leaf, error) {
+	pvdInfo := c.getPeerInfo(offer.GetProviderID())
+	if pvdInfo == nil {
+		err := fmt.Errorf("Cannot find provider or gateway %v that supplied the offer", offer.GetProviderID())
+		logging.Error(err.Error())
+		return "", 0, nil, err
+	}
+	dataRange := &fcrmessages.DataRange{Offset: 0, Length: 0}
+	response, err := c.core.P2PServer.Request(pvdInfo.NetworkAddr, fcrmessages.DataRetrievalRequestType, pvdInfo.NodeID, offer, "", dataRange, []cid.Leaf(nil), onPaid)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	_, tag, size, _, _, leaves, err := fcrmessages.DecodeDataRetrievalResponse(response, dataRange)
+	return tag, size, leaves, err
+}
+
+// part is a part of the content in a parallel retrieval, it covers whole leaves.
+type part struct {
+	dataRange *fcrmessages.DataRange
+	leaves    []cid.Leaf
+
+	// Index of the first leaf (inclusive) and of the last leaf (exclusive)
+	start int
+	end   int
+}
+
+// rest gets the rest of the part after its first given number of leaves, nil if there is none.
+func (p *part) rest(n int) *part {
+	if n >= len(p.leaves) {
+		return nil
+	}
+	dataRange := &fcrmessages.DataRange{Offset: p.dataRange.Offset, Length: p.dataRange.Length}
+	for _, leaf := range p.leaves[:n] {
+		dataRange.Offset += leaf.DataSize
+		dataRange.Length -= leaf.DataSize
+	}
+	return &part{
+		dataRange: dataRange,
+		leaves:    p.leaves[n:],
+		start:     p.start + n,
+		end:       p.end,
+	}
+}
+
+// splitParts splits the given missing ranges of the given leaves of the content into about the given number of parts of
+// similar size, a part never spans two missing ranges.
+func splitParts(leaves []cid.Leaf, missing []fcrjournal.LeafRange, parts int) []*part {
+	// Offset of every leaf
+	offsets := make([]uint64, len(leaves))
+	for i := 1; i < len(leaves); i++ {
+		offsets[i] = offsets[i-1] + leaves[i-1].DataSize
+	}
+	size := uint64(0)
+	for _, r := range missing {
+		for i := r.Start; i < r.End; i++ {
+			size += leaves[i].DataSize
+		}
+	}
+	target := (size + uint64(parts) - 1) / uint64(parts)
+	res := make([]*part, 0)
+	for _, r := range missing {
+		for start := r.Start; start < r.End; {
+			length := uint64(0)
+			end := start
+			// Every part has at least one leaf
+			for end < r.End && (end == start || length+leaves[end].DataSize <= target) {
+				length += leaves[end].DataSize
+				end++
+			}
+			res = append(res, &part{
+				dataRange: &fcrmessages.DataRange{Offset: offsets[start], Length: length},
+				leaves:    leaves[start:end],
+				start:     start,
+				end:       end,
+			})
+			start = end
+		}
+	}
+	return res
+}
+
+// partScheduler hands out the parts of a parallel retrieval to the providers as they ask for them.
+type partScheduler struct {
+	pending   []*part
+	inFlight  int
+	completed int
+
+	// Measures of every provider, a failed provider stops taking parts
+	failed   []bool
+	bytes    []uint64
+	duration []time.Duration
+
+	lock sync.Mutex
+	cond *sync.Cond
+}
+
+func newPartScheduler(parts []*part, providers int) *partScheduler {
+	sched := &partScheduler{
+		pending:  parts,
+		failed:   make([]bool, providers),
+		bytes:    make([]uint64, providers),
+		duration: make([]time.Duration, providers),
+		lock:     sync.Mutex{},
+	}
+	sched.cond = sync.NewCond(&sched.lock)
+	return sched
+}
+
+// next gets the next part for a given provider, it waits while the provider is much slower than the fastest provider.
+// It returns nil if there is no part left.
+func (s *partScheduler) next(provider int) *part {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for {
+		if len(s.pending) == 0 && s.inFlight == 0 {
+			return nil
+		}
+		if len(s.pending) > 0 && !s.isSlow(provider) {
+			p := s.pending[0]
+			s.pending = s.pending[1:]
+			s.inFlight++
+			return p
+		}
+		s.cond.Wait()
+	}
+}
+
+// done records a part of a given length delivered by a given provider in a given duration.
+func (s *partScheduler) done(provider int, length uint64, duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inFlight--
+	s.completed++
+	s.bytes[provider] += length
+	s.duration[provider] += duration
+	s.cond.Broadcast()
+}
+
+// fail records a failed provider, the given part it fails to deliver is taken by another provider if not nil.
+func (s *partScheduler) fail(provider int, p *part) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failed[provider] = true
+	if p != nil {
+		s.inFlight--
+		s.pending = append(s.pending, p)
+	}
+	if s.allFailed() {
+		// No provider is left to take the pending parts
+		s.pending = nil
+	}
+	s.cond.Broadcast()
+}
+
+// isSlow checks if a given provider is slower than the fastest provider that has not failed by more than ParallelSlowFactor.
+func (s *partScheduler) isSlow(provider int) bool {
+	if s.duration[provider] == 0 {
+		return false
+	}
+	rate := float64(s.bytes[provider]) / s.duration[provider].Seconds()
+	for i := range s.failed {
+		if i == provider || s.failed[i] || s.duration[i] == 0 {
+			continue
+		}
+		if float64(s.bytes[i])/s.duration[i].Seconds() > rate*ParallelSlowFactor {
+			return true
+		}
+	}
+	return false
+}
+
+// allFailed checks if every provider has failed.
+func (s *partScheduler) allFailed() bool {
+	for _, failed := range s.failed {
+		if !failed {
+			return false
+		}
+	}
+	return true
+}
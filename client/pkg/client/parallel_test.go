@@ -0,0 +1,286 @@
+package client
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrjournal"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+)
+
+// mockRetrievalServer serves data retrievals of a content, writing the leaves of a data range to the file directly.
+type mockRetrievalServer struct {
+	fcrserver.FCRServer
+	lock   sync.Mutex
+	data   []byte
+	leaves []cid.Leaf
+	// failAfter is the number of leaves delivered before every data range request fails, negative if they never fail
+	failAfter int
+	// ranges are the data ranges requested, excluding the requests of the leaves
+	ranges []fcrmessages.DataRange
+}
+
+func (s *mockRetrievalServer) Request(multiaddrStr string, msgType byte, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if msgType != fcrmessages.DataRetrievalRequestType {
+		return nil, fmt.Errorf("Unexpected message type %v", msgType)
+	}
+	filename := args[2].(string)
+	dataRange := args[3].(*fcrmessages.DataRange)
+	leaves := args[4].([]cid.Leaf)
+	onPaid := args[5].(func(*big.Int))
+	// Search price
+	onPaid(big.NewInt(1))
+	if dataRange.Length == 0 {
+		return fcrmessages.EncodeDataRetrievalResponse(0, "content", uint64(len(s.data)), 0, cid.DefaultDAGParams, s.leaves)
+	}
+	onVerified := args[6].(func(int, int))
+	s.ranges = append(s.ranges, *dataRange)
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	offset := dataRange.Offset
+	for i, leaf := range leaves {
+		if s.failAfter == 0 {
+			return nil, errors.New("Test error")
+		}
+		s.failAfter--
+		onPaid(big.NewInt(1))
+		if _, err = f.WriteAt(s.data[offset:offset+leaf.DataSize], int64(offset)); err != nil {
+			return nil, err
+		}
+		onVerified(i, i+1)
+		offset += leaf.DataSize
+	}
+	return fcrmessages.CreateFCRACKMsg(0, nil), nil
+}
+
+// newRetrievalTestClient creates a client with an offer of a random content from an active provider,
+// the provider is served by the returned server. It returns the client, the server, the content id and the offer.
+func newRetrievalTestClient(t *testing.T) (*FilecoinRetrievalClient, *mockRetrievalServer, string, *cidoffer.SubCIDOffer) {
+	data := make([]byte, 10*cid.DefaultDAGParams.ChunkSize+100)
+	_, err := rand.Read(data)
+	assert.Empty(t, err)
+	leaves, err := cid.GetLeaves(bytes.NewReader(data), cid.DefaultDAGParams)
+	assert.Empty(t, err)
+	path := filepath.Join(t.TempDir(), "content")
+	err = os.WriteFile(path, data, 0644)
+	assert.Empty(t, err)
+	file, err := os.Open(path)
+	assert.Empty(t, err)
+	id, err := cid.NewContentIDFromFile(file)
+	file.Close()
+	assert.Empty(t, err)
+	offer, err := cidoffer.NewCIDOffer("pvd", []cid.ContentID{*id}, big.NewInt(1000), time.Now().Add(time.Hour).Unix(), 10)
+	assert.Empty(t, err)
+	subOffer, err := offer.GenerateSubCIDOffer(id)
+	assert.Empty(t, err)
+
+	_, rootKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	addr, err := fcrcrypto.GetWalletAddress(rootKey)
+	assert.Empty(t, err)
+	offerMgr := fcroffermgr.NewFCROfferMgrImplV1(false)
+	offerMgr.AddSubOffer(subOffer)
+	reputationMgr := fcrreputationmgr.NewFCRReputationMgrImpV1()
+	reputationMgr.AddPeer("pvd")
+	journal := fcrjournal.NewFCRJournalImplV1()
+	err = journal.Start()
+	assert.Empty(t, err)
+	server := &mockRetrievalServer{data: data, leaves: leaves, failAfter: -1}
+	c := &FilecoinRetrievalClient{core: &core.Core{
+		P2PServer:     server,
+		PeerMgr:       &mockPeerMgr{gws: map[string]*fcrpeermgr.Peer{"pvd": {RootKey: rootKey, NodeID: "pvd", NetworkAddr: "pvd"}}},
+		PaymentMgr:    &mockSetupPaymentMgr{setupCost: map[string]*big.Int{addr: big.NewInt(0)}},
+		ReputationMgr: reputationMgr,
+		OfferMgr:      offerMgr,
+		Journal:       journal,
+		TopupAmount:   big.NewInt(1000),
+	}}
+	return c, server, id.ToString(), subOffer
+}
+
+func TestSplitParts(t *testing.T) {
+	leaves := []cid.Leaf{{DataSize: 4}, {DataSize: 4}, {DataSize: 4}, {DataSize: 4}, {DataSize: 4}, {DataSize: 4}, {DataSize: 2}}
+	type expected struct {
+		start  int
+		end    int
+		offset uint64
+		length uint64
+	}
+	tests := []struct {
+		name     string
+		missing  []fcrjournal.LeafRange
+		parts    int
+		expected []expected
+	}{
+		{
+			name:     "whole content",
+			missing:  []fcrjournal.LeafRange{{Start: 0, End: 7}},
+			parts:    2,
+			expected: []expected{{0, 3, 0, 12}, {3, 6, 12, 12}, {6, 7, 24, 2}},
+		},
+		{
+			name:     "whole content in one part",
+			missing:  []fcrjournal.LeafRange{{Start: 0, End: 7}},
+			parts:    1,
+			expected: []expected{{0, 7, 0, 26}},
+		},
+		{
+			name:     "several missing ranges are never joined",
+			missing:  []fcrjournal.LeafRange{{Start: 0, End: 2}, {Start: 3, End: 7}},
+			parts:    2,
+			expected: []expected{{0, 2, 0, 8}, {3, 5, 12, 8}, {5, 7, 20, 6}},
+		},
+		{
+			name:     "one part per missing range",
+			missing:  []fcrjournal.LeafRange{{Start: 1, End: 2}, {Start: 4, End: 6}},
+			parts:    1,
+			expected: []expected{{1, 2, 4, 4}, {4, 6, 16, 8}},
+		},
+		{
+			name:     "a part has at least one leaf",
+			missing:  []fcrjournal.LeafRange{{Start: 1, End: 3}, {Start: 6, End: 7}},
+			parts:    100,
+			expected: []expected{{1, 2, 4, 4}, {2, 3, 8, 4}, {6, 7, 24, 2}},
+		},
+		{
+			name:     "nothing missing",
+			missing:  []fcrjournal.LeafRange{},
+			parts:    4,
+			expected: []expected{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := splitParts(leaves, test.missing, test.parts)
+			res := make([]expected, 0)
+			for _, p := range parts {
+				res = append(res, expected{p.start, p.end, p.dataRange.Offset, p.dataRange.Length})
+				assert.Equal(t, leaves[p.start:p.end], p.leaves)
+			}
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestPartRest(t *testing.T) {
+	leaves := []cid.Leaf{{DataSize: 4}, {DataSize: 4}, {DataSize: 2}}
+	p := &part{dataRange: &fcrmessages.DataRange{Offset: 8, Length: 10}, leaves: leaves, start: 2, end: 5}
+	rest := p.rest(0)
+	assert.Equal(t, p, rest)
+	rest = p.rest(2)
+	assert.Equal(t, &part{dataRange: &fcrmessages.DataRange{Offset: 16, Length: 2}, leaves: leaves[2:], start: 4, end: 5}, rest)
+	assert.Nil(t, p.rest(3))
+	// The part is not changed
+	assert.Equal(t, &fcrmessages.DataRange{Offset: 8, Length: 10}, p.dataRange)
+}
+
+func TestParallelRetrieveResume(t *testing.T) {
+	c, server, cidStr, _ := newRetrievalTestClient(t)
+	location := t.TempDir()
+	filename := filepath.Join(location, "content")
+
+	// The provider delivers its first part of two leaves, then fails after a leaf of its second part
+	server.failAfter = 3
+	report, err := c.ParallelRetrieve(cidStr, location, nil)
+	assert.NotEmpty(t, err)
+	// Parts of whole leaves, the last one takes the small last leaf
+	assert.Equal(t, 5, report.Parts)
+	assert.Equal(t, 1, len(report.Shares))
+	assert.NotEmpty(t, report.Shares[0].Err)
+	verified := uint64(3 * cid.DefaultDAGParams.ChunkSize)
+	assert.Equal(t, verified, report.Shares[0].Bytes)
+	// Every leaf written and every payment sent is journaled, the partial file is kept
+	journaled := c.core.Journal.Get(cidStr)
+	assert.NotNil(t, journaled)
+	assert.Equal(t, verified, journaled.VerifiedSize())
+	assert.Equal(t, []fcrjournal.LeafRange{{Start: 3, End: len(server.leaves)}}, journaled.Missing())
+	// A search price for each of the three requests, and one per leaf delivered
+	assert.Equal(t, "6", journaled.Paid["pvd"].String())
+	partial, err := os.ReadFile(filename)
+	assert.Empty(t, err)
+	assert.Equal(t, server.data[:verified], partial[:verified])
+
+	// Resume, only the missing leaves are requested
+	server.failAfter = -1
+	server.ranges = nil
+	report, err = c.ParallelRetrieve(cidStr, location, nil)
+	assert.Empty(t, err)
+	assert.Equal(t, verified, report.ResumedBytes)
+	assert.Equal(t, uint64(len(server.data)), report.Size)
+	assert.Empty(t, report.Shares[0].Err)
+	assert.Equal(t, uint64(len(server.data))-verified, report.Shares[0].Bytes)
+	for _, r := range server.ranges {
+		assert.GreaterOrEqual(t, r.Offset, verified)
+	}
+	content, err := os.ReadFile(filename)
+	assert.Empty(t, err)
+	assert.Equal(t, server.data, content)
+	assert.Nil(t, c.core.Journal.Get(cidStr))
+}
+
+func TestRetrieveResume(t *testing.T) {
+	c, server, cidStr, offer := newRetrievalTestClient(t)
+	location := t.TempDir()
+
+	// The whole content is requested as one data range, the provider fails after some leaves
+	server.failAfter = 4
+	err := c.Retrieve(offer.GetMessageDigest(), location)
+	assert.NotEmpty(t, err)
+	assert.Equal(t, []fcrmessages.DataRange{{Offset: 0, Length: uint64(len(server.data))}}, server.ranges)
+	journaled := c.core.Journal.Get(cidStr)
+	assert.NotNil(t, journaled)
+	verified := uint64(4 * cid.DefaultDAGParams.ChunkSize)
+	assert.Equal(t, verified, journaled.VerifiedSize())
+
+	// Retrieving to another location is rejected while the retrieval is in progress
+	err = c.Retrieve(offer.GetMessageDigest(), t.TempDir())
+	assert.NotEmpty(t, err)
+
+	// Resume from the first missing leaf
+	server.failAfter = -1
+	server.ranges = nil
+	err = c.Retrieve(offer.GetMessageDigest(), location)
+	assert.Empty(t, err)
+	assert.Equal(t, []fcrmessages.DataRange{{Offset: verified, Length: uint64(len(server.data)) - verified}}, server.ranges)
+	content, err := os.ReadFile(filepath.Join(location, "content"))
+	assert.Empty(t, err)
+	assert.Equal(t, server.data, content)
+	assert.Nil(t, c.core.Journal.Get(cidStr))
+}
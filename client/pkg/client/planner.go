@@ -0,0 +1,146 @@
+/*
+Package client - contains the client code.
+*/
+package client
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// MinExpiryHeadroom is the minimum time left before an offer expires for it to be planned,
+// an offer expiring sooner risks being rejected as expired by the time it is used.
+const MinExpiryHeadroom = time.Minute
+
+// PlannedOffer is an offer in a retrieval plan, with the measures used to order the plan.
+type PlannedOffer struct {
+	// Offer is the offer to retrieve with
+	Offer cidoffer.SubCIDOffer
+
+	// Active indicates whether the offer's provider is an active peer
+	Active bool
+
+	// SetupCost is the estimated on-chain cost to create a payment channel to the provider, zero if a channel exists
+	SetupCost *big.Int
+
+	// TotalCost is the offer price plus the setup cost
+	TotalCost *big.Int
+
+	// Reputation is the reputation score of the provider, zero if it is not active
+	Reputation int64
+
+	// Headroom is the time left before the offer expires
+	Headroom time.Duration
+
+	// SkipReason is the reason why the offer is not planned, empty if it is planned
+	SkipReason string
+}
+
+// RetrievalAttempt is an attempt to retrieve with a planned offer.
+type RetrievalAttempt struct {
+	PlannedOffer
+
+	// Duration is the time taken by the attempt
+	Duration time.Duration
+
+	// Err is the error of the attempt, nil if it succeeds
+	Err error
+}
+
+// RetrievalReport is the report of a planned retrieval.
+type RetrievalReport struct {
+	// CID is the content retrieved
+	CID string
+
+	// Attempts are the attempts in the order they are made, the last one succeeds if the retrieval succeeds
+	Attempts []RetrievalAttempt
+
+	// Skipped are the offers found but not planned
+	Skipped []PlannedOffer
+
+	// Succeeded indicates whether the retrieval succeeds
+	Succeeded bool
+}
+
+// PlanRetrieval plans a retrieval with the given offers, no offer with a price above max price (no limit if nil) is planned.
+// It returns the planned offers in the order they should be tried, and the offers that are skipped.
+// Offers of active providers come first, each group is ordered by the total cost including the channel setup cost,
+// then by provider reputation, then by QoS, then by expiry headroom.
+func (c *FilecoinRetrievalClient) PlanRetrieval(offers []cidoffer.SubCIDOffer, maxPrice *big.Int) ([]PlannedOffer, []PlannedOffer) {
+	planned := make([]PlannedOffer, 0)
+	skipped := make([]PlannedOffer, 0)
+	for _, offer := range offers {
+		p := PlannedOffer{
+			Offer:     offer,
+			Active:    false,
+			SetupCost: big.NewInt(0),
+			TotalCost: big.NewInt(0).Set(offer.GetPrice()),
+			Headroom:  time.Until(time.Unix(offer.GetExpiry(), 0)),
+		}
+		if maxPrice != nil && offer.GetPrice().Cmp(maxPrice) > 0 {
+			p.SkipReason = fmt.Sprintf("Price %v is above max price %v", offer.GetPrice().String(), maxPrice.String())
+			skipped = append(skipped, p)
+			continue
+		}
+		if p.Headroom < MinExpiryHeadroom {
+			p.SkipReason = fmt.Sprintf("Offer expires in %v", p.Headroom)
+			skipped = append(skipped, p)
+			continue
+		}
+		rep := c.core.ReputationMgr.GetPeerReputation(offer.GetProviderID())
+		if rep != nil {
+			if rep.Blocked || rep.Pending {
+				p.SkipReason = fmt.Sprintf("Provider %v is blocked or pending", offer.GetProviderID())
+				skipped = append(skipped, p)
+				continue
+			}
+			p.Active = true
+			p.Reputation = rep.Score
+		}
+		setupCost, err := c.GetCostToCreate(offer.GetProviderID())
+		if err != nil {
+			// The offer is still worth trying, the channel setup cost is unknown
+			logging.Warn("Fail to estimate channel setup cost to %v: %v", offer.GetProviderID(), err.Error())
+		} else {
+			p.SetupCost = setupCost
+			p.TotalCost.Add(p.TotalCost, setupCost)
+		}
+		planned = append(planned, p)
+	}
+	sort.SliceStable(planned, func(i, j int) bool {
+		if planned[i].Active != planned[j].Active {
+			return planned[i].Active
+		}
+		if cmp := planned[i].TotalCost.Cmp(planned[j].TotalCost); cmp != 0 {
+			return cmp < 0
+		}
+		if planned[i].Reputation != planned[j].Reputation {
+			return planned[i].Reputation > planned[j].Reputation
+		}
+		if planned[i].Offer.GetQoS() != planned[j].Offer.GetQoS() {
+			return planned[i].Offer.GetQoS() > planned[j].Offer.GetQoS()
+		}
+		return planned[i].Headroom > planned[j].Headroom
+	})
+	return planned, skipped
+}
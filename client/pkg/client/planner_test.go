@@ -0,0 +1,202 @@
+package client
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/client/pkg/core"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
+)
+
+// mockSetupPaymentMgr has a channel to every recipient with a zero setup cost,
+// and estimates the setup cost of a channel to the others.
+type mockSetupPaymentMgr struct {
+	fcrpaymentmgr.FCRPaymentMgr
+	setupCost map[string]*big.Int
+}
+
+func (mgr *mockSetupPaymentMgr) GetOutboundChStatus(recipientAddr string) (string, *big.Int, *big.Int, error) {
+	cost, ok := mgr.setupCost[recipientAddr]
+	if ok && cost.Sign() == 0 {
+		return "testpaych", big.NewInt(0), big.NewInt(0), nil
+	}
+	return "", nil, nil, errors.New("Test error")
+}
+
+func (mgr *mockSetupPaymentMgr) GetCostToCreate(recipientAddr string, amt *big.Int) (*big.Int, error) {
+	cost, ok := mgr.setupCost[recipientAddr]
+	if !ok {
+		return nil, errors.New("Test error")
+	}
+	return big.NewInt(0).Set(cost), nil
+}
+
+// planTestOffer is an offer of the test cid in a planner test case.
+type planTestOffer struct {
+	provider string
+	price    int64
+	expiry   time.Duration
+	qos      uint64
+	// setupCost is the cost to create a channel to the provider, zero if one exists, negative if unknown
+	setupCost int64
+}
+
+func TestPlanRetrieval(t *testing.T) {
+	tests := []struct {
+		name     string
+		offers   []planTestOffer
+		active   map[string]uint
+		blocked  []string
+		maxPrice *big.Int
+		planned  []string
+		skipped  []string
+	}{
+		{
+			name: "active providers first",
+			offers: []planTestOffer{
+				{provider: "pvd1", price: 1, expiry: time.Hour, qos: 10},
+				{provider: "pvd2", price: 100, expiry: time.Hour, qos: 10},
+				{provider: "pvd3", price: 10, expiry: time.Hour, qos: 10},
+			},
+			active:  map[string]uint{"pvd2": 0},
+			planned: []string{"pvd2", "pvd1", "pvd3"},
+		},
+		{
+			name: "total cost includes channel setup cost",
+			offers: []planTestOffer{
+				{provider: "pvd1", price: 5, expiry: time.Hour, qos: 10, setupCost: 100},
+				{provider: "pvd2", price: 50, expiry: time.Hour, qos: 10},
+				{provider: "pvd3", price: 20, expiry: time.Hour, qos: 10, setupCost: 20},
+			},
+			planned: []string{"pvd3", "pvd2", "pvd1"},
+		},
+		{
+			name: "reputation breaks total cost ties",
+			offers: []planTestOffer{
+				{provider: "pvd1", price: 10, expiry: time.Hour, qos: 10},
+				{provider: "pvd2", price: 10, expiry: time.Hour, qos: 10},
+				{provider: "pvd3", price: 5, expiry: time.Hour, qos: 10, setupCost: 5},
+			},
+			active:  map[string]uint{"pvd1": 0, "pvd2": 2, "pvd3": 1},
+			planned: []string{"pvd2", "pvd3", "pvd1"},
+		},
+		{
+			name: "qos breaks reputation ties",
+			offers: []planTestOffer{
+				{provider: "pvd1", price: 10, expiry: time.Hour, qos: 5},
+				{provider: "pvd2", price: 10, expiry: time.Hour, qos: 20},
+				{provider: "pvd3", price: 10, expiry: time.Hour, qos: 10},
+			},
+			planned: []string{"pvd2", "pvd3", "pvd1"},
+		},
+		{
+			name: "expiry headroom breaks qos ties",
+			offers: []planTestOffer{
+				{provider: "pvd1", price: 10, expiry: time.Hour, qos: 10},
+				{provider: "pvd2", price: 10, expiry: 3 * time.Hour, qos: 10},
+				{provider: "pvd3", price: 10, expiry: 2 * time.Hour, qos: 10},
+			},
+			planned: []string{"pvd2", "pvd3", "pvd1"},
+		},
+		{
+			name: "skipped offers",
+			offers: []planTestOffer{
+				{provider: "pvd1", price: 101, expiry: time.Hour, qos: 10},
+				{provider: "pvd2", price: 10, expiry: MinExpiryHeadroom / 2, qos: 10},
+				{provider: "pvd3", price: 10, expiry: time.Hour, qos: 10},
+				{provider: "pvd4", price: 100, expiry: time.Hour, qos: 10, setupCost: -1},
+				{provider: "pvd5", price: 1, expiry: time.Hour, qos: 10},
+			},
+			active:   map[string]uint{"pvd3": 0},
+			blocked:  []string{"pvd3"},
+			maxPrice: big.NewInt(100),
+			planned:  []string{"pvd5", "pvd4"},
+			skipped:  []string{"pvd1", "pvd2", "pvd3"},
+		},
+	}
+	pieceCID, err := cid.NewContentID(testCID)
+	assert.Empty(t, err)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peerMgr := &mockPeerMgr{gws: make(map[string]*fcrpeermgr.Peer)}
+			paymentMgr := &mockSetupPaymentMgr{setupCost: make(map[string]*big.Int)}
+			reputationMgr := fcrreputationmgr.NewFCRReputationMgrImpV1()
+			for id, replica := range test.active {
+				reputationMgr.AddPeer(id)
+				reputationMgr.UpdatePeerRecord(id, &reputation.MockGoodRecord, replica)
+			}
+			for _, id := range test.blocked {
+				reputationMgr.BlockPeer(id)
+			}
+			offers := make([]cidoffer.SubCIDOffer, 0)
+			totalCost := make(map[string]int64)
+			for _, o := range test.offers {
+				_, rootKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+				assert.Empty(t, err)
+				peerMgr.gws[o.provider] = &fcrpeermgr.Peer{RootKey: rootKey, NodeID: o.provider, NetworkAddr: o.provider}
+				addr, err := fcrcrypto.GetWalletAddress(rootKey)
+				assert.Empty(t, err)
+				totalCost[o.provider] = o.price
+				if o.setupCost >= 0 {
+					paymentMgr.setupCost[addr] = big.NewInt(o.setupCost)
+					totalCost[o.provider] += o.setupCost
+				}
+				offer, err := cidoffer.NewCIDOffer(o.provider, []cid.ContentID{*pieceCID}, big.NewInt(o.price), time.Now().Add(o.expiry).Unix(), o.qos)
+				assert.Empty(t, err)
+				subOffer, err := offer.GenerateSubCIDOffer(pieceCID)
+				assert.Empty(t, err)
+				offers = append(offers, *subOffer)
+			}
+			c := &FilecoinRetrievalClient{core: &core.Core{
+				PeerMgr:       peerMgr,
+				PaymentMgr:    paymentMgr,
+				ReputationMgr: reputationMgr,
+				TopupAmount:   big.NewInt(1000),
+			}}
+
+			planned, skipped := c.PlanRetrieval(offers, test.maxPrice)
+			plannedIDs := make([]string, 0)
+			for _, p := range planned {
+				plannedIDs = append(plannedIDs, p.Offer.GetProviderID())
+				assert.Equal(t, totalCost[p.Offer.GetProviderID()], p.TotalCost.Int64())
+				_, active := test.active[p.Offer.GetProviderID()]
+				assert.Equal(t, active, p.Active)
+				assert.Empty(t, p.SkipReason)
+			}
+			assert.Equal(t, test.planned, plannedIDs)
+			skippedIDs := make([]string, 0)
+			for _, p := range skipped {
+				skippedIDs = append(skippedIDs, p.Offer.GetProviderID())
+				assert.NotEmpty(t, p.SkipReason)
+			}
+			if test.skipped == nil {
+				test.skipped = []string{}
+			}
+			assert.Equal(t, test.skipped, skippedIDs)
+		})
+	}
+}
@@ -21,9 +21,9 @@ package core
 
 import (
 	"math/big"
-	"sync"
 	"time"
 
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrjournal"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
@@ -59,6 +59,9 @@ type Core struct {
 	// The Reputation Manager
 	ReputationMgr fcrreputationmgr.FCRReputationMgr
 
+	// The Journal of retrievals in progress
+	Journal fcrjournal.FCRJournal
+
 	// Timeout constants
 	TCPInactivityTimeout     time.Duration
 	LongTCPInactivityTimeout time.Duration
@@ -67,31 +70,28 @@ type Core struct {
 	SearchPrice *big.Int
 	OfferPrice  *big.Int
 	TopupAmount *big.Int
+	// PaymentInterval is the number of chunks paid by each tranche in data retrieval, 0 means paying every chunk
+	PaymentInterval uint64
 }
 
-// Single instance of the gateway
-var instance *Core
-var doOnce sync.Once
-
-// GetSingleInstance returns the single instance of the gateway
-func GetSingleInstance() *Core {
-	doOnce.Do(func() {
-		instance = &Core{
-			MsgKey:                   "",
-			NodeID:                   "",
-			WalletAddr:               "",
-			P2PServer:                nil,
-			RegisterMgr:              nil,
-			PeerMgr:                  nil,
-			PaymentMgr:               nil,
-			OfferMgr:                 nil,
-			ReputationMgr:            nil,
-			TCPInactivityTimeout:     5000 * time.Millisecond,
-			LongTCPInactivityTimeout: 300000 * time.Millisecond,
-			SearchPrice:              big.NewInt(1_000_000_000_000_000),
-			OfferPrice:               big.NewInt(1_000_000_000_000_000),
-			TopupAmount:              big.NewInt(100_000_000_000_000_000),
-		}
-	})
-	return instance
+// NewCore creates a core with default settings, every client owns its own core.
+func NewCore() *Core {
+	return &Core{
+		MsgKey:                   "",
+		NodeID:                   "",
+		WalletAddr:               "",
+		P2PServer:                nil,
+		RegisterMgr:              nil,
+		PeerMgr:                  nil,
+		PaymentMgr:               nil,
+		OfferMgr:                 nil,
+		ReputationMgr:            nil,
+		Journal:                  nil,
+		TCPInactivityTimeout:     5000 * time.Millisecond,
+		LongTCPInactivityTimeout: 300000 * time.Millisecond,
+		SearchPrice:              big.NewInt(1_000_000_000_000_000),
+		OfferPrice:               big.NewInt(1_000_000_000_000_000),
+		TopupAmount:              big.NewInt(100_000_000_000_000_000),
+		PaymentInterval:          0,
+	}
 }
@@ -11,6 +11,10 @@ require (
 	github.com/filecoin-project/lotus v1.10.1
 	github.com/filecoin-project/specs-actors/v4 v4.0.1
 	github.com/ipfs/go-cid v0.0.7
+	github.com/ipfs/go-ipfs-chunker v0.0.5
+	github.com/ipfs/go-ipld-format v0.2.0
+	github.com/ipfs/go-merkledag v0.3.2
+	github.com/ipfs/go-unixfs v0.2.4
 	github.com/libp2p/go-libp2p v0.14.3
 	github.com/libp2p/go-libp2p-connmgr v0.2.4
 	github.com/libp2p/go-libp2p-core v0.8.5
@@ -22,5 +26,6 @@ require (
 	github.com/rs/zerolog v1.23.0
 	github.com/spf13/viper v1.8.1
 	github.com/stretchr/testify v1.7.0
+	go.etcd.io/bbolt v1.3.6
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
@@ -26,7 +26,6 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"io"
-	"io/ioutil"
 
 	"github.com/cbergoon/merkletree"
 	"github.com/ipfs/go-cid"
@@ -47,26 +46,20 @@ func NewContentID(cidStr string) (*ContentID, error) {
 	return &ContentID{cidStr}, nil
 }
 
-// NewContentIDFromFile creates a ContentID object from a given file.
+// NewContentIDFromFile creates a ContentID object from a given file, using the default DAG parameters.
+// The result matches the cid computed by "ipfs add" with default options.
 func NewContentIDFromFile(reader io.Reader) (*ContentID, error) {
-	// TODO:
-	// 1. Might have problem for large file.
-	// 2. For now, it just uses the plain bytes, we need to generate a merkle DAG
-	data, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-	pref := cid.Prefix{
-		Version:  0,
-		Codec:    cid.DagProtobuf,
-		MhType:   multihash.SHA2_256,
-		MhLength: -1, // Default length
-	}
-	id, err := pref.Sum(data)
+	return NewContentIDFromFileWithParams(reader, DefaultDAGParams)
+}
+
+// NewContentIDFromFileWithParams creates a ContentID object from a given file, using the given DAG parameters.
+// The file is read in a streaming fashion, only the root cid of the UnixFS DAG is kept.
+func NewContentIDFromFileWithParams(reader io.Reader, params DAGParams) (*ContentID, error) {
+	root, err := buildDAG(reader, params)
 	if err != nil {
 		return nil, err
 	}
-	return &ContentID{id.String()}, nil
+	return &ContentID{root.String()}, nil
 }
 
 // NewRandomContentID creates a random ContentID object.
@@ -21,6 +21,7 @@ package cid
  */
 
 import (
+	"bytes"
 	"encoding/hex"
 	"testing"
 	"testing/fstest"
@@ -52,14 +53,48 @@ func TestNewContentID(t *testing.T) {
 func TestNewContentIDFromFile(t *testing.T) {
 	m := fstest.MapFS{
 		"test.txt": {
-			Data: []byte("test, test, test"),
+			Data: []byte("hello world"),
+		},
+		"empty.txt": {
+			Data: []byte{},
 		},
 	}
+	// Values computed by "ipfs add"
 	file, err := m.Open("test.txt")
 	assert.Empty(t, err)
 	id, err := NewContentIDFromFile(file)
 	assert.Empty(t, err)
-	assert.Equal(t, "QmYiTrMsaCM9LRRybYzm3DkR93dt25Va75m5BbeggVNFRB", id.ToString())
+	assert.Equal(t, "Qmf412jQZiuVUtdgnB36FXFX7xg5V6KEbSJ4dpQuhkLyfD", id.ToString())
+
+	file, err = m.Open("empty.txt")
+	assert.Empty(t, err)
+	id, err = NewContentIDFromFile(file)
+	assert.Empty(t, err)
+	assert.Equal(t, "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", id.ToString())
+
+	// Values computed by "ipfs add --cid-version=1"
+	file, err = m.Open("test.txt")
+	assert.Empty(t, err)
+	id, err = NewContentIDFromFileWithParams(file, DAGParams{ChunkSize: DefaultDAGParams.ChunkSize, CIDVersion: 1, RawLeaves: true})
+	assert.Empty(t, err)
+	assert.Equal(t, "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e", id.ToString())
+
+	// Multiple chunks, different chunk sizes give different roots
+	data := bytes.Repeat([]byte("test, test, test"), 100)
+	id1, err := NewContentIDFromFileWithParams(bytes.NewReader(data), DAGParams{ChunkSize: 256, CIDVersion: 1, RawLeaves: true})
+	assert.Empty(t, err)
+	id2, err := NewContentIDFromFileWithParams(bytes.NewReader(data), DAGParams{ChunkSize: 512, CIDVersion: 1, RawLeaves: true})
+	assert.Empty(t, err)
+	assert.NotEqual(t, id1.ToString(), id2.ToString())
+	id3, err := NewContentIDFromFileWithParams(bytes.NewReader(data), DAGParams{ChunkSize: 256, CIDVersion: 1, RawLeaves: true})
+	assert.Empty(t, err)
+	assert.Equal(t, id1.ToString(), id3.ToString())
+
+	// Invalid params
+	_, err = NewContentIDFromFileWithParams(bytes.NewReader(data), DAGParams{ChunkSize: 0})
+	assert.NotEmpty(t, err)
+	_, err = NewContentIDFromFileWithParams(bytes.NewReader(data), DAGParams{ChunkSize: 256, CIDVersion: 2})
+	assert.NotEmpty(t, err)
 }
 
 func TestRandomContentID(t *testing.T) {
@@ -0,0 +1,129 @@
+/*
+Package cid - provides methods for ContentID struct.
+
+ContentID is wrapper over cid of a file stored in the system.
+*/
+package cid
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+)
+
+// DAGParams represents the parameters used to build the UnixFS DAG of a file.
+// The same file built with different parameters has a different root cid.
+type DAGParams struct {
+	// ChunkSize is the size in bytes of every leaf of the DAG
+	ChunkSize int64 `json:"chunk_size"`
+
+	// CIDVersion is the version of the cids in the DAG, either 0 or 1
+	CIDVersion uint64 `json:"cid_version"`
+
+	// RawLeaves indicates whether or not leaves are raw blocks instead of UnixFS nodes
+	RawLeaves bool `json:"raw_leaves"`
+}
+
+// DefaultDAGParams is the default DAG parameters, same as the defaults of "ipfs add".
+var DefaultDAGParams = DAGParams{
+	ChunkSize:  chunker.DefaultBlockSize,
+	CIDVersion: 0,
+	RawLeaves:  false,
+}
+
+// Validate checks if the DAG parameters are supported.
+func (p DAGParams) Validate() error {
+	if p.ChunkSize <= 0 || p.ChunkSize > int64(chunker.ChunkSizeLimit) {
+		return fmt.Errorf("Chunk size must be between 1 and %v, got %v", chunker.ChunkSizeLimit, p.ChunkSize)
+	}
+	if p.CIDVersion > 1 {
+		return fmt.Errorf("CID version must be 0 or 1, got %v", p.CIDVersion)
+	}
+	return nil
+}
+
+// buildDAG builds a balanced UnixFS DAG from the given reader and returns the root cid.
+// Blocks are discarded once their cid is computed, so the memory used is bounded by the DAG depth.
+func buildDAG(reader io.Reader, params DAGParams) (cid.Cid, error) {
+	db, err := newDAGBuilder(reader, params)
+	if err != nil {
+		return cid.Undef, err
+	}
+	root, err := balanced.Layout(db)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return root.Cid(), nil
+}
+
+// newDAGBuilder creates a helper to build the UnixFS DAG of the given reader with the given DAG parameters.
+func newDAGBuilder(reader io.Reader, params DAGParams) (*helpers.DagBuilderHelper, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	prefix, err := merkledag.PrefixForCidVersion(int(params.CIDVersion))
+	if err != nil {
+		return nil, err
+	}
+	dbp := helpers.DagBuilderParams{
+		Maxlinks:   helpers.DefaultLinksPerBlock,
+		RawLeaves:  params.RawLeaves,
+		CidBuilder: prefix,
+		Dagserv:    discardDAGService{},
+	}
+	return dbp.New(chunker.NewSizeSplitter(reader, params.ChunkSize))
+}
+
+// discardDAGService implements ipld.DAGService, it drops every node added.
+type discardDAGService struct{}
+
+func (discardDAGService) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	return nil, ipld.ErrNotFound
+}
+
+func (discardDAGService) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	res := make(chan *ipld.NodeOption, len(cids))
+	for range cids {
+		res <- &ipld.NodeOption{Err: ipld.ErrNotFound}
+	}
+	close(res)
+	return res
+}
+
+func (discardDAGService) Add(ctx context.Context, node ipld.Node) error {
+	return nil
+}
+
+func (discardDAGService) AddMany(ctx context.Context, nodes []ipld.Node) error {
+	return nil
+}
+
+func (discardDAGService) Remove(ctx context.Context, c cid.Cid) error {
+	return nil
+}
+
+func (discardDAGService) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+Package cid - provides methods for ContentID struct.
+
+ContentID is wrapper over cid of a file stored in the system.
+*/
+package cid
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfs/importer/helpers"
+)
+
+// Leaf represents a leaf of the UnixFS DAG of a file, leaves are in the order of the file content.
+// The leaves of a file can be verified against its root cid without the content, see NewContentIDFromLeaves.
+type Leaf struct {
+	// CID is the cid of the leaf block
+	CID string `json:"cid"`
+
+	// BlockSize is the size in bytes of the leaf block, as linked from its parent
+	BlockSize uint64 `json:"block_size"`
+
+	// DataSize is the size in bytes of the file content in the leaf
+	DataSize uint64 `json:"data_size"`
+}
+
+// GetLeaves gets the leaves of the UnixFS DAG of a given file, built with the given DAG parameters.
+func GetLeaves(reader io.Reader, params DAGParams) ([]Leaf, error) {
+	db, err := newDAGBuilder(reader, params)
+	if err != nil {
+		return nil, err
+	}
+	leaves := make([]Leaf, 0)
+	for !db.Done() {
+		node, dataSize, err := db.NewLeafDataNode(ft.TFile)
+		if err != nil {
+			return nil, err
+		}
+		blockSize, err := node.Size()
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, Leaf{CID: node.Cid().String(), BlockSize: blockSize, DataSize: dataSize})
+	}
+	return leaves, nil
+}
+
+// NewContentIDFromLeaves creates a ContentID object from the leaves of a file, using the given DAG parameters.
+// It builds the same balanced layout as NewContentIDFromFileWithParams, with the leaves in place of the content.
+// The sizes of a single leaf are not part of the root cid, they are only checked against the content, see VerifyLeaves.
+func NewContentIDFromLeaves(leaves []Leaf, params DAGParams) (*ContentID, error) {
+	db, err := newDAGBuilder(bytes.NewReader(nil), params)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 {
+		// Empty file
+		root, err := db.NewLeafNode(nil, ft.TFile)
+		if err != nil {
+			return nil, err
+		}
+		return &ContentID{root.Cid().String()}, nil
+	}
+	nodes := make([]ipld.Node, len(leaves))
+	for i, leaf := range leaves {
+		id, err := cid.Parse(leaf.CID)
+		if err != nil {
+			return nil, err
+		}
+		if leaf.DataSize == 0 || leaf.DataSize > uint64(params.ChunkSize) {
+			return nil, fmt.Errorf("Leaf %v has data size %v, expect between 1 and %v", i, leaf.DataSize, params.ChunkSize)
+		}
+		nodes[i] = &leafLink{id: id, size: leaf.BlockSize}
+	}
+	// Same as balanced.Layout, where every leaf data node is taken from the given leaves
+	next := 0
+	var fill func(node *helpers.FSNodeOverDag, depth int) (ipld.Node, uint64, error)
+	fill = func(node *helpers.FSNodeOverDag, depth int) (ipld.Node, uint64, error) {
+		if node == nil {
+			node = db.NewFSNodeOverDag(ft.TFile)
+		}
+		for node.NumChildren() < db.Maxlinks() && next < len(leaves) {
+			var child ipld.Node
+			var childSize uint64
+			if depth == 1 {
+				child, childSize = nodes[next], leaves[next].DataSize
+				next++
+			} else {
+				var err error
+				child, childSize, err = fill(nil, depth-1)
+				if err != nil {
+					return nil, 0, err
+				}
+			}
+			if err := node.AddChild(child, childSize, db); err != nil {
+				return nil, 0, err
+			}
+		}
+		filled, err := node.Commit()
+		if err != nil {
+			return nil, 0, err
+		}
+		return filled, node.FileSize(), nil
+	}
+	root, rootSize := nodes[0], leaves[0].DataSize
+	next = 1
+	for depth := 1; next < len(leaves); depth++ {
+		newRoot := db.NewFSNodeOverDag(ft.TFile)
+		if err := newRoot.AddChild(root, rootSize, db); err != nil {
+			return nil, err
+		}
+		root, rootSize, err = fill(newRoot, depth)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ContentID{root.Cid().String()}, nil
+}
+
+// VerifyLeaves checks if the given content is exactly the content of the given leaves, built with the given DAG parameters.
+func VerifyLeaves(data []byte, leaves []Leaf, params DAGParams) error {
+	got, err := GetLeaves(bytes.NewReader(data), params)
+	if err != nil {
+		return err
+	}
+	if len(got) != len(leaves) {
+		return fmt.Errorf("Leaf count mismatch: expected %v got %v", len(leaves), len(got))
+	}
+	for i := range leaves {
+		if got[i] != leaves[i] {
+			return fmt.Errorf("Leaf %v mismatch: expected %v got %v", i, leaves[i].CID, got[i].CID)
+		}
+	}
+	return nil
+}
+
+// leafLink is a leaf known by its cid and block size only, it can only be linked from a parent node.
+type leafLink struct {
+	ipld.Node
+	id   cid.Cid
+	size uint64
+}
+
+func (l *leafLink) Cid() cid.Cid {
+	return l.id
+}
+
+func (l *leafLink) Size() (uint64, error) {
+	return l.size, nil
+}
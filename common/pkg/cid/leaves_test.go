@@ -0,0 +1,80 @@
+/*
+Package cid - provides methods for ContentID struct.
+
+ContentID is wrapper over cid of a file stored in the system.
+*/
+package cid
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaves(t *testing.T) {
+	paramsList := []DAGParams{
+		{ChunkSize: 16, CIDVersion: 0, RawLeaves: false},
+		{ChunkSize: 16, CIDVersion: 1, RawLeaves: true},
+		{ChunkSize: 1, CIDVersion: 1, RawLeaves: false},
+	}
+	// Sizes covering an empty file, a single leaf and DAGs of depth 1, 2 and 3
+	sizes := []int{0, 10, 16, 100, 16*174 + 5, 174*174 + 3}
+	for _, params := range paramsList {
+		for _, size := range sizes {
+			data := make([]byte, size)
+			rand.Read(data)
+			expected, err := NewContentIDFromFileWithParams(bytes.NewReader(data), params)
+			assert.Empty(t, err)
+			leaves, err := GetLeaves(bytes.NewReader(data), params)
+			assert.Empty(t, err)
+			total := uint64(0)
+			for _, leaf := range leaves {
+				total += leaf.DataSize
+			}
+			assert.Equal(t, uint64(size), total)
+			id, err := NewContentIDFromLeaves(leaves, params)
+			assert.Empty(t, err)
+			assert.Equal(t, expected.ToString(), id.ToString())
+			assert.Empty(t, VerifyLeaves(data, leaves, params))
+			if size == 0 {
+				continue
+			}
+			// Tampered content fails to verify
+			data[0]++
+			assert.NotEmpty(t, VerifyLeaves(data, leaves, params))
+			if len(leaves) == 1 {
+				// The root is the leaf itself, the sizes are only checked with the content
+				continue
+			}
+			// Tampered leaves give a different cid
+			leaves[0].BlockSize++
+			id, err = NewContentIDFromLeaves(leaves, params)
+			assert.Empty(t, err)
+			assert.NotEqual(t, expected.ToString(), id.ToString())
+		}
+	}
+	_, err := NewContentIDFromLeaves([]Leaf{{CID: "invalid", BlockSize: 1, DataSize: 1}}, DefaultDAGParams)
+	assert.NotEmpty(t, err)
+	leaves, err := GetLeaves(bytes.NewReader([]byte("hello world")), DefaultDAGParams)
+	assert.Empty(t, err)
+	leaves[0].DataSize = 0
+	_, err = NewContentIDFromLeaves(leaves, DefaultDAGParams)
+	assert.NotEmpty(t, err)
+}
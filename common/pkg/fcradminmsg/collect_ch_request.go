@@ -0,0 +1,48 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "encoding/json"
+
+// collectChRequestJson represents the request to collect a settling inbound payment channel.
+type collectChRequestJson struct {
+	SenderAddr string `json:"sender_addr"`
+}
+
+// EncodeCollectChRequest is used to get the byte array of collectChRequestJson
+func EncodeCollectChRequest(
+	senderAddr string,
+) ([]byte, error) {
+	return json.Marshal(&collectChRequestJson{
+		SenderAddr: senderAddr,
+	})
+}
+
+// DecodeCollectChRequest is used to get the fields from byte array of collectChRequestJson
+func DecodeCollectChRequest(data []byte) (
+	string, // sender addr
+	error, // error
+) {
+	msg := collectChRequestJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return "", err
+	}
+	return msg.SenderAddr, nil
+}
@@ -0,0 +1,96 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "encoding/json"
+
+// getCacheStatsResponseJson represents the response of getting cache statistics.
+type getCacheStatsResponseJson struct {
+	AutoCache  bool     `json:"auto_cache"`
+	Hits       uint64   `json:"hits"`
+	Misses     uint64   `json:"misses"`
+	Fetched    uint64   `json:"fetched"`
+	Evicted    uint64   `json:"evicted"`
+	Spent      string   `json:"spent"`
+	SpendLimit string   `json:"spend_limit"`
+	Size       int64    `json:"size"`
+	Quota      int64    `json:"quota"`
+	CIDs       []string `json:"cids"`
+	Sizes      []int64  `json:"sizes"`
+	CIDHits    []uint64 `json:"cid_hits"`
+	LastAccess []int64  `json:"last_access"`
+}
+
+// EncodeGetCacheStatsResponse is used to get the byte array of getCacheStatsResponseJson
+func EncodeGetCacheStatsResponse(
+	autoCache bool,
+	hits uint64,
+	misses uint64,
+	fetched uint64,
+	evicted uint64,
+	spent string,
+	spendLimit string,
+	size int64,
+	quota int64,
+	cids []string,
+	sizes []int64,
+	cidHits []uint64,
+	lastAccess []int64,
+) ([]byte, error) {
+	return json.Marshal(&getCacheStatsResponseJson{
+		AutoCache:  autoCache,
+		Hits:       hits,
+		Misses:     misses,
+		Fetched:    fetched,
+		Evicted:    evicted,
+		Spent:      spent,
+		SpendLimit: spendLimit,
+		Size:       size,
+		Quota:      quota,
+		CIDs:       cids,
+		Sizes:      sizes,
+		CIDHits:    cidHits,
+		LastAccess: lastAccess,
+	})
+}
+
+// DecodeGetCacheStatsResponse is used to get the fields from byte array of getCacheStatsResponseJson
+func DecodeGetCacheStatsResponse(data []byte) (
+	bool, // auto cache
+	uint64, // hits
+	uint64, // misses
+	uint64, // fetched
+	uint64, // evicted
+	string, // spent
+	string, // spend limit
+	int64, // size
+	int64, // quota
+	[]string, // cids
+	[]int64, // sizes
+	[]uint64, // cid hits
+	[]int64, // last access
+	error, // error
+) {
+	msg := getCacheStatsResponseJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return false, 0, 0, 0, 0, "", "", 0, 0, nil, nil, nil, nil, err
+	}
+	return msg.AutoCache, msg.Hits, msg.Misses, msg.Fetched, msg.Evicted, msg.Spent, msg.SpendLimit, msg.Size, msg.Quota, msg.CIDs, msg.Sizes, msg.CIDHits, msg.LastAccess, nil
+}
@@ -0,0 +1,53 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCacheStatsResponse(t *testing.T) {
+	mockCIDs := []string{"cid0", "cid1"}
+	mockSizes := []int64{100, 200}
+	mockCIDHits := []uint64{1, 2}
+	mockLastAccess := []int64{1000, 2000}
+
+	data, err := EncodeGetCacheStatsResponse(true, 3, 4, 5, 6, "70", "80", 300, 400, mockCIDs, mockSizes, mockCIDHits, mockLastAccess)
+	assert.Empty(t, err)
+	assert.Equal(t, "7b226175746f5f6361636865223a747275652c2268697473223a332c226d6973736573223a342c2266657463686564223a352c2265766963746564223a362c227370656e74223a223730222c227370656e645f6c696d6974223a223830222c2273697a65223a3330302c2271756f7461223a3430302c2263696473223a5b2263696430222c2263696431225d2c2273697a6573223a5b3130302c3230305d2c226369645f68697473223a5b312c325d2c226c6173745f616363657373223a5b313030302c323030305d7d", hex.EncodeToString(data))
+
+	autoCache, hits, misses, fetched, evicted, spent, spendLimit, size, quota, cids, sizes, cidHits, lastAccess, err := DecodeGetCacheStatsResponse(data)
+	assert.Empty(t, err)
+	assert.Equal(t, true, autoCache)
+	assert.Equal(t, uint64(3), hits)
+	assert.Equal(t, uint64(4), misses)
+	assert.Equal(t, uint64(5), fetched)
+	assert.Equal(t, uint64(6), evicted)
+	assert.Equal(t, "70", spent)
+	assert.Equal(t, "80", spendLimit)
+	assert.Equal(t, int64(300), size)
+	assert.Equal(t, int64(400), quota)
+	assert.Equal(t, mockCIDs, cids)
+	assert.Equal(t, mockSizes, sizes)
+	assert.Equal(t, mockCIDHits, cidHits)
+	assert.Equal(t, mockLastAccess, lastAccess)
+}
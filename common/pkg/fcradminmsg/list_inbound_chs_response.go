@@ -0,0 +1,64 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "encoding/json"
+
+// listInboundChsResponseJson represents the response of listing inbound payment channels.
+type listInboundChsResponseJson struct {
+	Senders  []string `json:"senders"`
+	ChAddrs  []string `json:"ch_addrs"`
+	Balances []string `json:"balances"`
+	Redeemed []string `json:"redeemed"`
+	Settling []bool   `json:"settling"`
+}
+
+// EncodeListInboundChsResponse is used to get the byte array of listInboundChsResponseJson
+func EncodeListInboundChsResponse(
+	senders []string,
+	chAddrs []string,
+	balances []string,
+	redeemed []string,
+	settling []bool,
+) ([]byte, error) {
+	return json.Marshal(&listInboundChsResponseJson{
+		Senders:  senders,
+		ChAddrs:  chAddrs,
+		Balances: balances,
+		Redeemed: redeemed,
+		Settling: settling,
+	})
+}
+
+// DecodeListInboundChsResponse is used to get the fields from byte array of listInboundChsResponseJson
+func DecodeListInboundChsResponse(data []byte) (
+	[]string, // senders
+	[]string, // channel addresses
+	[]string, // balances
+	[]string, // redeemed
+	[]bool, // settling
+	error, // error
+) {
+	msg := listInboundChsResponseJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	return msg.Senders, msg.ChAddrs, msg.Balances, msg.Redeemed, msg.Settling, nil
+}
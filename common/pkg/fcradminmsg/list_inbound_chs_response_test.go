@@ -0,0 +1,46 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListInboundChsResponse(t *testing.T) {
+	mockSenders := []string{"sender0", "sender1"}
+	mockChAddrs := []string{"ch0", "ch1"}
+	mockBalances := []string{"1000", "2000"}
+	mockRedeemed := []string{"100", "200"}
+	mockSettling := []bool{true, false}
+
+	data, err := EncodeListInboundChsResponse(mockSenders, mockChAddrs, mockBalances, mockRedeemed, mockSettling)
+	assert.Empty(t, err)
+	assert.Equal(t, "7b2273656e64657273223a5b2273656e64657230222c2273656e64657231225d2c2263685f6164647273223a5b22636830222c22636831225d2c2262616c616e636573223a5b2231303030222c2232303030225d2c2272656465656d6564223a5b22313030222c22323030225d2c22736574746c696e67223a5b747275652c66616c73655d7d", hex.EncodeToString(data))
+
+	resSenders, resChAddrs, resBalances, resRedeemed, resSettling, err := DecodeListInboundChsResponse(data)
+	assert.Empty(t, err)
+	assert.Equal(t, mockSenders, resSenders)
+	assert.Equal(t, mockChAddrs, resChAddrs)
+	assert.Equal(t, mockBalances, resBalances)
+	assert.Equal(t, mockRedeemed, resRedeemed)
+	assert.Equal(t, mockSettling, resSettling)
+}
@@ -0,0 +1,76 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "encoding/json"
+
+// listSettleDecisionsResponseJson represents the response of listing settlement decisions.
+type listSettleDecisionsResponseJson struct {
+	Times    []int64  `json:"times"`
+	Senders  []string `json:"senders"`
+	ChAddrs  []string `json:"ch_addrs"`
+	Redeemed []string `json:"redeemed"`
+	Costs    []string `json:"costs"`
+	Reasons  []string `json:"reasons"`
+	Settled  []bool   `json:"settled"`
+	Outcomes []string `json:"outcomes"`
+}
+
+// EncodeListSettleDecisionsResponse is used to get the byte array of listSettleDecisionsResponseJson
+func EncodeListSettleDecisionsResponse(
+	times []int64,
+	senders []string,
+	chAddrs []string,
+	redeemed []string,
+	costs []string,
+	reasons []string,
+	settled []bool,
+	outcomes []string,
+) ([]byte, error) {
+	return json.Marshal(&listSettleDecisionsResponseJson{
+		Times:    times,
+		Senders:  senders,
+		ChAddrs:  chAddrs,
+		Redeemed: redeemed,
+		Costs:    costs,
+		Reasons:  reasons,
+		Settled:  settled,
+		Outcomes: outcomes,
+	})
+}
+
+// DecodeListSettleDecisionsResponse is used to get the fields from byte array of listSettleDecisionsResponseJson
+func DecodeListSettleDecisionsResponse(data []byte) (
+	[]int64, // times
+	[]string, // senders
+	[]string, // channel addresses
+	[]string, // redeemed
+	[]string, // costs
+	[]string, // reasons
+	[]bool, // settled
+	[]string, // outcomes
+	error, // error
+) {
+	msg := listSettleDecisionsResponseJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, nil, nil, err
+	}
+	return msg.Times, msg.Senders, msg.ChAddrs, msg.Redeemed, msg.Costs, msg.Reasons, msg.Settled, msg.Outcomes, nil
+}
@@ -0,0 +1,52 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListSettleDecisionsResponse(t *testing.T) {
+	mockTimes := []int64{1000, 2000}
+	mockSenders := []string{"sender0", "sender1"}
+	mockChAddrs := []string{"ch0", "ch1"}
+	mockRedeemed := []string{"100", "200"}
+	mockCosts := []string{"10", ""}
+	mockReasons := []string{"reason0", "reason1"}
+	mockSettled := []bool{true, false}
+	mockOutcomes := []string{"outcome0", "outcome1"}
+
+	data, err := EncodeListSettleDecisionsResponse(mockTimes, mockSenders, mockChAddrs, mockRedeemed, mockCosts, mockReasons, mockSettled, mockOutcomes)
+	assert.Empty(t, err)
+	assert.Equal(t, "7b2274696d6573223a5b313030302c323030305d2c2273656e64657273223a5b2273656e64657230222c2273656e64657231225d2c2263685f6164647273223a5b22636830222c22636831225d2c2272656465656d6564223a5b22313030222c22323030225d2c22636f737473223a5b223130222c22225d2c22726561736f6e73223a5b22726561736f6e30222c22726561736f6e31225d2c22736574746c6564223a5b747275652c66616c73655d2c226f7574636f6d6573223a5b226f7574636f6d6530222c226f7574636f6d6531225d7d", hex.EncodeToString(data))
+
+	resTimes, resSenders, resChAddrs, resRedeemed, resCosts, resReasons, resSettled, resOutcomes, err := DecodeListSettleDecisionsResponse(data)
+	assert.Empty(t, err)
+	assert.Equal(t, mockTimes, resTimes)
+	assert.Equal(t, mockSenders, resSenders)
+	assert.Equal(t, mockChAddrs, resChAddrs)
+	assert.Equal(t, mockRedeemed, resRedeemed)
+	assert.Equal(t, mockCosts, resCosts)
+	assert.Equal(t, mockReasons, resReasons)
+	assert.Equal(t, mockSettled, resSettled)
+	assert.Equal(t, mockOutcomes, resOutcomes)
+}
@@ -0,0 +1,82 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// setOfferRenewalRequestJson represents the request to set the renewal policy of a published offer.
+type setOfferRenewalRequestJson struct {
+	Digest      string `json:"digest"`
+	Renew       bool   `json:"renew"`
+	RenewBefore int64  `json:"renew_before"`
+	Period      int64  `json:"period"`
+	Price       string `json:"price"`
+}
+
+// EncodeSetOfferRenewalRequest is used to get the byte array of setOfferRenewalRequestJson
+// A nil price keeps the current price of the offer.
+func EncodeSetOfferRenewalRequest(
+	digest string,
+	renew bool,
+	renewBefore time.Duration,
+	period time.Duration,
+	price *big.Int,
+) ([]byte, error) {
+	priceStr := ""
+	if price != nil {
+		priceStr = price.String()
+	}
+	return json.Marshal(&setOfferRenewalRequestJson{
+		Digest:      digest,
+		Renew:       renew,
+		RenewBefore: int64(renewBefore / time.Second),
+		Period:      int64(period / time.Second),
+		Price:       priceStr,
+	})
+}
+
+// DecodeSetOfferRenewalRequest is used to get the fields from byte array of setOfferRenewalRequestJson
+func DecodeSetOfferRenewalRequest(data []byte) (
+	string, // digest
+	bool, // renew
+	time.Duration, // renew before
+	time.Duration, // period
+	*big.Int, // price, nil to keep
+	error, // error
+) {
+	msg := setOfferRenewalRequestJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return "", false, 0, 0, nil, err
+	}
+	var price *big.Int
+	if msg.Price != "" {
+		var ok bool
+		price, ok = big.NewInt(0).SetString(msg.Price, 10)
+		if !ok {
+			return "", false, 0, 0, nil, errors.New("Error in decoding price")
+		}
+	}
+	return msg.Digest, msg.Renew, time.Duration(msg.RenewBefore) * time.Second, time.Duration(msg.Period) * time.Second, price, nil
+}
@@ -0,0 +1,59 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOfferRenewalRequest(t *testing.T) {
+	mockDigest := "digest"
+	mockRenew := true
+	mockRenewBefore := 6 * time.Hour
+	mockPeriod := 24 * time.Hour
+	mockPrice := big.NewInt(100)
+
+	data, err := EncodeSetOfferRenewalRequest(mockDigest, mockRenew, mockRenewBefore, mockPeriod, mockPrice)
+	assert.Empty(t, err)
+	assert.Equal(t, "7b22646967657374223a22646967657374222c2272656e6577223a747275652c2272656e65775f6265666f7265223a32313630302c22706572696f64223a38363430302c227072696365223a22313030227d", hex.EncodeToString(data))
+
+	resDigest, resRenew, resRenewBefore, resPeriod, resPrice, err := DecodeSetOfferRenewalRequest(data)
+	assert.Empty(t, err)
+	assert.Equal(t, mockDigest, resDigest)
+	assert.Equal(t, mockRenew, resRenew)
+	assert.Equal(t, mockRenewBefore, resRenewBefore)
+	assert.Equal(t, mockPeriod, resPeriod)
+	assert.Equal(t, mockPrice.String(), resPrice.String())
+
+	// Nil price keeps the current price
+	data, err = EncodeSetOfferRenewalRequest(mockDigest, false, 0, 0, nil)
+	assert.Empty(t, err)
+	_, resRenew, _, _, resPrice, err = DecodeSetOfferRenewalRequest(data)
+	assert.Empty(t, err)
+	assert.False(t, resRenew)
+	assert.Empty(t, resPrice)
+
+	_, _, _, _, _, err = DecodeSetOfferRenewalRequest([]byte{100, 100, 100})
+	assert.NotEmpty(t, err)
+}
@@ -0,0 +1,52 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "encoding/json"
+
+// settleChRequestJson represents the request to settle an inbound payment channel.
+type settleChRequestJson struct {
+	SenderAddr   string `json:"sender_addr"`
+	EstimateOnly bool   `json:"estimate_only"`
+}
+
+// EncodeSettleChRequest is used to get the byte array of settleChRequestJson
+func EncodeSettleChRequest(
+	senderAddr string,
+	estimateOnly bool,
+) ([]byte, error) {
+	return json.Marshal(&settleChRequestJson{
+		SenderAddr:   senderAddr,
+		EstimateOnly: estimateOnly,
+	})
+}
+
+// DecodeSettleChRequest is used to get the fields from byte array of settleChRequestJson
+func DecodeSettleChRequest(data []byte) (
+	string, // sender addr
+	bool, // estimate only
+	error, // error
+) {
+	msg := settleChRequestJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return "", false, err
+	}
+	return msg.SenderAddr, msg.EstimateOnly, nil
+}
@@ -0,0 +1,40 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettleChRequest(t *testing.T) {
+	mockSenderAddr := "sender"
+	mockEstimateOnly := true
+
+	data, err := EncodeSettleChRequest(mockSenderAddr, mockEstimateOnly)
+	assert.Empty(t, err)
+	assert.Equal(t, "7b2273656e6465725f61646472223a2273656e646572222c22657374696d6174655f6f6e6c79223a747275657d", hex.EncodeToString(data))
+
+	resSenderAddr, resEstimateOnly, err := DecodeSettleChRequest(data)
+	assert.Empty(t, err)
+	assert.Equal(t, mockSenderAddr, resSenderAddr)
+	assert.Equal(t, mockEstimateOnly, resEstimateOnly)
+}
@@ -0,0 +1,60 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// settleChResponseJson represents the response of settling an inbound payment channel.
+type settleChResponseJson struct {
+	Cost      string `json:"cost"`
+	Submitted bool   `json:"submitted"`
+}
+
+// EncodeSettleChResponse is used to get the byte array of settleChResponseJson
+func EncodeSettleChResponse(
+	cost *big.Int,
+	submitted bool,
+) ([]byte, error) {
+	return json.Marshal(&settleChResponseJson{
+		Cost:      cost.String(),
+		Submitted: submitted,
+	})
+}
+
+// DecodeSettleChResponse is used to get the fields from byte array of settleChResponseJson
+func DecodeSettleChResponse(data []byte) (
+	*big.Int, // estimated cost
+	bool, // submitted
+	error, // error
+) {
+	msg := settleChResponseJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return nil, false, err
+	}
+	cost, ok := big.NewInt(0).SetString(msg.Cost, 10)
+	if !ok {
+		return nil, false, fmt.Errorf("Invalid cost %v", msg.Cost)
+	}
+	return cost, msg.Submitted, nil
+}
@@ -0,0 +1,41 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettleChResponse(t *testing.T) {
+	mockCost := big.NewInt(1000)
+	mockSubmitted := true
+
+	data, err := EncodeSettleChResponse(mockCost, mockSubmitted)
+	assert.Empty(t, err)
+	assert.Equal(t, "7b22636f7374223a2231303030222c227375626d6974746564223a747275657d", hex.EncodeToString(data))
+
+	resCost, resSubmitted, err := DecodeSettleChResponse(data)
+	assert.Empty(t, err)
+	assert.Equal(t, mockCost, resCost)
+	assert.Equal(t, mockSubmitted, resSubmitted)
+}
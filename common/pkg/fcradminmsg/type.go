@@ -19,21 +19,32 @@ package fcradminmsg
  */
 
 const (
-	InitialisationRequestType     = 0
-	ListPeersRequestType          = 1
-	ListPeersResponseType         = 2
-	InspectPeerRequestType        = 3
-	InspectPeerResponseType       = 5
-	ChangePeerStatusRequestType   = 6
-	ListCIDFrequencyRequestType   = 12
-	ListCIDFrequencyResponseType  = 13
-	GetOfferByCIDRequestType      = 14
-	GetOfferByCIDResponseType     = 15
-	CacheOfferByDigestRequestType = 16
-	ListFilesRequestType          = 17
-	ListFilesResponseType         = 18
-	PublishOfferRequestType       = 19
-	UploadFileRequestType         = 20
-	ForceSyncRequestType          = 21
-	ACKType                       = 22
+	InitialisationRequestType       = 0
+	ListPeersRequestType            = 1
+	ListPeersResponseType           = 2
+	InspectPeerRequestType          = 3
+	InspectPeerResponseType         = 5
+	ChangePeerStatusRequestType     = 6
+	ListCIDFrequencyRequestType     = 12
+	ListCIDFrequencyResponseType    = 13
+	GetOfferByCIDRequestType        = 14
+	GetOfferByCIDResponseType       = 15
+	CacheOfferByDigestRequestType   = 16
+	ListFilesRequestType            = 17
+	ListFilesResponseType           = 18
+	PublishOfferRequestType         = 19
+	UploadFileRequestType           = 20
+	ForceSyncRequestType            = 21
+	ACKType                         = 22
+	ListInboundChsRequestType       = 23
+	ListInboundChsResponseType      = 24
+	SettleChRequestType             = 25
+	SettleChResponseType            = 26
+	CollectChRequestType            = 27
+	ListSettleDecisionsRequestType  = 28
+	ListSettleDecisionsResponseType = 29
+	GetCacheStatsRequestType        = 30
+	GetCacheStatsResponseType       = 31
+	WithdrawOfferRequestType        = 32
+	SetOfferRenewalRequestType      = 33
 )
@@ -0,0 +1,48 @@
+/*
+Package fcradminmsg - stores all the admin messages.
+*/
+package fcradminmsg
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "encoding/json"
+
+// withdrawOfferRequestJson represents the request to withdraw a published offer.
+type withdrawOfferRequestJson struct {
+	Digest string `json:"digest"`
+}
+
+// EncodeWithdrawOfferRequest is used to get the byte array of withdrawOfferRequestJson
+func EncodeWithdrawOfferRequest(
+	digest string,
+) ([]byte, error) {
+	return json.Marshal(&withdrawOfferRequestJson{
+		Digest: digest,
+	})
+}
+
+// DecodeWithdrawOfferRequest is used to get the fields from byte array of withdrawOfferRequestJson
+func DecodeWithdrawOfferRequest(data []byte) (
+	string, // digest
+	error, // error
+) {
+	msg := withdrawOfferRequestJson{}
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return "", err
+	}
+	return msg.Digest, nil
+}
@@ -0,0 +1,106 @@
+/*
+Package fcrcachectrl - cache controller caches popular content automatically and evicts cached content based on a policy.
+*/
+package fcrcachectrl
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+)
+
+// Eviction policies
+const (
+	// EvictLRU evicts the least recently used content first.
+	EvictLRU = "lru"
+	// EvictLFU evicts the least frequently used content first.
+	EvictLFU = "lfu"
+)
+
+// FCRCacheCtrl represents the controller that fetches the most accessed content into the cache and evicts content by policy.
+type FCRCacheCtrl interface {
+	// Start starts the controller's routine.
+	Start() error
+
+	// Shutdown ends the controller's routine safely.
+	Shutdown()
+
+	// Check forces the controller to check the access counts and the cache against the policy.
+	Check()
+
+	// RecordLookup records a lookup of a given cid, a hit if it is cached or a miss otherwise.
+	RecordLookup(id *cid.ContentID)
+
+	// GetStats gets the statistics of the cache.
+	GetStats() Stats
+}
+
+// Fetcher fetches the content of a given sub cid offer into the cache.
+type Fetcher func(offer *cidoffer.SubCIDOffer) error
+
+// CachePolicy represents the limits the controller works within.
+type CachePolicy struct {
+	// Eviction is the eviction policy, EvictLRU or EvictLFU.
+	Eviction string
+
+	// MaxSize is the disk quota of the cache in bytes, zero to disable.
+	MaxSize int64
+
+	// MaxSpend is the total amount to spend on fetching content, nil or zero to disable automatic fetching.
+	MaxSpend *big.Int
+
+	// MaxPrice is the maximum price to pay for a single content, nil or zero to disable.
+	MaxPrice *big.Int
+
+	// TopN is the number of the most accessed cids to consider at every check.
+	TopN uint
+
+	// MinAccess is the minimum access count of a cid to be fetched.
+	MinAccess int
+}
+
+// Stats represents the statistics of the cache since the controller starts.
+type Stats struct {
+	// Hits is the number of lookups of cached content.
+	Hits uint64
+
+	// Misses is the number of lookups of content not cached.
+	Misses uint64
+
+	// Fetched is the number of content fetched by the controller.
+	Fetched uint64
+
+	// Evicted is the number of content evicted by the controller.
+	Evicted uint64
+
+	// Spent is the amount spent on fetching content.
+	Spent *big.Int
+
+	// SpendLimit is the total amount to spend on fetching content.
+	SpendLimit *big.Int
+
+	// Size is the total size of cached content in bytes.
+	Size int64
+
+	// Quota is the disk quota of the cache in bytes.
+	Quota int64
+
+	// Count is the number of cached content.
+	Count int
+}
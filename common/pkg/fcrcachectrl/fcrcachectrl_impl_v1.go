@@ -0,0 +1,309 @@
+/*
+Package fcrcachectrl - cache controller caches popular content automatically and evicts cached content based on a policy.
+*/
+package fcrcachectrl
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachemgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// FCRCacheCtrlImplV1 implements FCRCacheCtrl, it is an in-memory version.
+type FCRCacheCtrlImplV1 struct {
+	// Boolean indicates if the controller has started
+	start bool
+
+	// Node ID of this node, offers supplied by this node are never used to fetch
+	nodeID string
+
+	cacheMgr fcrcachemgr.FCRCacheMgr
+	offerMgr fcroffermgr.FCROfferMgr
+	fetcher  Fetcher
+
+	policy CachePolicy
+
+	// Duration to wait between two checks
+	checkDuration time.Duration
+
+	// Size of the content that could not fit in the quota by cid, it is not fetched again until there is room for it.
+	// It is only accessed by the checking routine.
+	unfit map[string]int64
+
+	// Channels to control the thread
+	shutdownCh chan bool
+	checkCh    chan bool
+
+	// Statistics
+	hits      uint64
+	misses    uint64
+	fetched   uint64
+	evicted   uint64
+	spent     *big.Int
+	statsLock sync.RWMutex
+}
+
+func NewFCRCacheCtrlImplV1(nodeID string, cacheMgr fcrcachemgr.FCRCacheMgr, offerMgr fcroffermgr.FCROfferMgr, fetcher Fetcher, policy CachePolicy, checkDuration time.Duration) FCRCacheCtrl {
+	return &FCRCacheCtrlImplV1{
+		start:         false,
+		nodeID:        nodeID,
+		cacheMgr:      cacheMgr,
+		offerMgr:      offerMgr,
+		fetcher:       fetcher,
+		policy:        policy,
+		checkDuration: checkDuration,
+		unfit:         make(map[string]int64),
+		shutdownCh:    make(chan bool),
+		checkCh:       make(chan bool),
+		spent:         big.NewInt(0),
+		statsLock:     sync.RWMutex{},
+	}
+}
+
+func (ctrl *FCRCacheCtrlImplV1) Start() error {
+	if ctrl.start {
+		return errors.New("FCRCacheController has already started")
+	}
+	ctrl.start = true
+	go ctrl.checkRoutine()
+	return nil
+}
+
+func (ctrl *FCRCacheCtrlImplV1) Shutdown() {
+	if !ctrl.start {
+		return
+	}
+	ctrl.shutdownCh <- true
+	<-ctrl.shutdownCh
+	ctrl.start = false
+}
+
+func (ctrl *FCRCacheCtrlImplV1) Check() {
+	if !ctrl.start {
+		return
+	}
+	ctrl.checkCh <- true
+	<-ctrl.checkCh
+}
+
+func (ctrl *FCRCacheCtrlImplV1) RecordLookup(id *cid.ContentID) {
+	hit := ctrl.cacheMgr.Get(id) != nil
+	ctrl.statsLock.Lock()
+	defer ctrl.statsLock.Unlock()
+	if hit {
+		ctrl.hits++
+	} else {
+		ctrl.misses++
+	}
+}
+
+func (ctrl *FCRCacheCtrlImplV1) GetStats() Stats {
+	ctrl.statsLock.RLock()
+	defer ctrl.statsLock.RUnlock()
+	spendLimit := big.NewInt(0)
+	if ctrl.policy.MaxSpend != nil {
+		spendLimit.Set(ctrl.policy.MaxSpend)
+	}
+	return Stats{
+		Hits:       ctrl.hits,
+		Misses:     ctrl.misses,
+		Fetched:    ctrl.fetched,
+		Evicted:    ctrl.evicted,
+		Spent:      big.NewInt(0).Set(ctrl.spent),
+		SpendLimit: spendLimit,
+		Size:       ctrl.cacheMgr.Size(),
+		Quota:      ctrl.policy.MaxSize,
+		Count:      len(ctrl.cacheMgr.List()),
+	}
+}
+
+// checkRoutine checks the access counts and the cache periodically or when forced.
+func (ctrl *FCRCacheCtrlImplV1) checkRoutine() {
+	for {
+		forced := false
+		afterChan := time.After(ctrl.checkDuration)
+		select {
+		case <-ctrl.checkCh:
+			// Need to check
+			logging.Info("FCRCacheController force check.")
+			forced = true
+		case <-afterChan:
+			// Need to check
+		case <-ctrl.shutdownCh:
+			// Need to shutdown
+			logging.Info("FCRCacheController shutdown checking routine.")
+			ctrl.shutdownCh <- true
+			return
+		}
+		ctrl.checkAll()
+		if forced {
+			ctrl.checkCh <- true
+		}
+	}
+}
+
+// checkAll evicts content over the quota, then fetches the most accessed content not yet cached within the budget.
+func (ctrl *FCRCacheCtrlImplV1) checkAll() {
+	// Content fetched in this check is not evicted in favour of less accessed content
+	protected := make(map[string]bool)
+	ctrl.evict(protected)
+	if ctrl.remainingBudget().Cmp(big.NewInt(0)) <= 0 || ctrl.policy.TopN == 0 {
+		return
+	}
+	cids, counts := ctrl.offerMgr.ListAccessCount(0, ctrl.policy.TopN)
+	for i, cidStr := range cids {
+		if counts[i] < ctrl.policy.MinAccess {
+			// The rest are less accessed
+			return
+		}
+		id, err := cid.NewContentID(cidStr)
+		if err != nil || ctrl.cacheMgr.Get(id) != nil {
+			continue
+		}
+		if size, ok := ctrl.unfit[cidStr]; ok {
+			if size > ctrl.policy.MaxSize-ctrl.cacheMgr.Size() {
+				continue
+			}
+			delete(ctrl.unfit, cidStr)
+		}
+		offer := ctrl.cheapestOffer(id)
+		if offer == nil {
+			logging.Debug("FCRCacheController fail to find an offer within budget for %v", cidStr)
+			continue
+		}
+		err = ctrl.fetcher(offer)
+		if err != nil {
+			logging.Warn("FCRCacheController fail to fetch %v from %v: %v", cidStr, offer.GetProviderID(), err.Error())
+			continue
+		}
+		ctrl.statsLock.Lock()
+		ctrl.fetched++
+		ctrl.spent.Add(ctrl.spent, offer.GetPrice())
+		ctrl.statsLock.Unlock()
+		logging.Info("FCRCacheController fetched %v from %v with price %v", cidStr, offer.GetProviderID(), offer.GetPrice().String())
+		protected[cidStr] = true
+		if !ctrl.evict(protected) {
+			// Quota is taken by content fetched in this check, or the content is larger than the quota
+			if entry := ctrl.cacheMgr.Get(id); entry != nil {
+				ctrl.unfit[cidStr] = entry.Size
+			}
+			ctrl.remove(id)
+			return
+		}
+		if ctrl.remainingBudget().Cmp(big.NewInt(0)) <= 0 {
+			return
+		}
+	}
+}
+
+// cheapestOffer gets the cheapest valid offer of a given cid within the budget, nil if not found.
+func (ctrl *FCRCacheCtrlImplV1) cheapestOffer(id *cid.ContentID) *cidoffer.SubCIDOffer {
+	budget := ctrl.remainingBudget()
+	if ctrl.policy.MaxPrice != nil && ctrl.policy.MaxPrice.Cmp(big.NewInt(0)) > 0 && ctrl.policy.MaxPrice.Cmp(budget) < 0 {
+		budget = ctrl.policy.MaxPrice
+	}
+	var res *cidoffer.SubCIDOffer
+	for _, offer := range ctrl.offerMgr.GetOffers(id) {
+		if offer.GetProviderID() == ctrl.nodeID || offer.HasExpired() || offer.GetPrice().Cmp(budget) > 0 {
+			continue
+		}
+		if res != nil && offer.GetPrice().Cmp(res.GetPrice()) >= 0 {
+			continue
+		}
+		subOffer, err := offer.GenerateSubCIDOffer(id)
+		if err != nil {
+			continue
+		}
+		res = subOffer
+	}
+	return res
+}
+
+// remainingBudget gets the amount left to spend.
+func (ctrl *FCRCacheCtrlImplV1) remainingBudget() *big.Int {
+	ctrl.statsLock.RLock()
+	defer ctrl.statsLock.RUnlock()
+	if ctrl.policy.MaxSpend == nil {
+		return big.NewInt(0)
+	}
+	return big.NewInt(0).Sub(ctrl.policy.MaxSpend, ctrl.spent)
+}
+
+// evict evicts unprotected content by the eviction policy until the cache is within the quota.
+// It returns false if the cache is still over the quota.
+func (ctrl *FCRCacheCtrlImplV1) evict(protected map[string]bool) bool {
+	if ctrl.policy.MaxSize <= 0 || ctrl.cacheMgr.Size() <= ctrl.policy.MaxSize {
+		return true
+	}
+	entries := ctrl.cacheMgr.List()
+	if ctrl.policy.Eviction == EvictLFU {
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].Hits != entries[j].Hits {
+				return entries[i].Hits < entries[j].Hits
+			}
+			return entries[i].LastAccess < entries[j].LastAccess
+		})
+	} else {
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].LastAccess != entries[j].LastAccess {
+				return entries[i].LastAccess < entries[j].LastAccess
+			}
+			return entries[i].AddedAt < entries[j].AddedAt
+		})
+	}
+	for _, entry := range entries {
+		if ctrl.cacheMgr.Size() <= ctrl.policy.MaxSize {
+			return true
+		}
+		if protected[entry.CID] {
+			continue
+		}
+		id, err := cid.NewContentID(entry.CID)
+		if err != nil {
+			continue
+		}
+		ctrl.remove(id)
+	}
+	return ctrl.cacheMgr.Size() <= ctrl.policy.MaxSize
+}
+
+// remove removes a given cid from the cache, together with the offers of this node for it.
+func (ctrl *FCRCacheCtrlImplV1) remove(id *cid.ContentID) {
+	err := ctrl.cacheMgr.Remove(id)
+	if err != nil {
+		logging.Warn("FCRCacheController fail to evict %v: %v", id.ToString(), err.Error())
+		return
+	}
+	for _, offer := range ctrl.offerMgr.GetOffers(id) {
+		if offer.GetProviderID() == ctrl.nodeID {
+			ctrl.offerMgr.RemoveOffer(offer.GetMessageDigest())
+		}
+	}
+	ctrl.statsLock.Lock()
+	ctrl.evicted++
+	ctrl.statsLock.Unlock()
+	logging.Info("FCRCacheController evicted %v", id.ToString())
+}
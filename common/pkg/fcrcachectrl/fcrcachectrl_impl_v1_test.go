@@ -0,0 +1,192 @@
+/*
+Package fcrcachectrl - cache controller caches popular content automatically and evicts cached content based on a policy.
+*/
+package fcrcachectrl
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachemgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+)
+
+const (
+	CID1   = "QmWJi2BHLpKpCnD3sA3jcSWv5M51D6Zf1WY4rN8BrQtCgi"
+	CID2   = "QmVPhUbiWEoFJ26p4uZveuMhnZvVuFx9Drras6FyD8aw22"
+	CID3   = "QmcVy3EpcDPeVkJExZQxx5ZStaey19min1LLkgwt9cJYYM"
+	NodeID = "self"
+)
+
+func addOffer(t *testing.T, offerMgr fcroffermgr.FCROfferMgr, providerID string, id *cid.ContentID, price int64) {
+	offer, err := cidoffer.NewCIDOffer(providerID, []cid.ContentID{*id}, big.NewInt(price), time.Now().Add(time.Hour).Unix(), 10)
+	assert.Empty(t, err)
+	offerMgr.AddOffer(offer)
+}
+
+func TestCacheCtrl(t *testing.T) {
+	cacheMgr := fcrcachemgr.NewFCRCacheMgrImplV1(t.TempDir())
+	assert.Empty(t, cacheMgr.Start())
+	defer cacheMgr.Shutdown()
+	offerMgr := fcroffermgr.NewFCROfferMgrImplV1(true)
+	assert.Empty(t, offerMgr.Start())
+	defer offerMgr.Shutdown()
+
+	cid1, err := cid.NewContentID(CID1)
+	assert.Empty(t, err)
+	cid2, err := cid.NewContentID(CID2)
+	assert.Empty(t, err)
+	cid3, err := cid.NewContentID(CID3)
+	assert.Empty(t, err)
+
+	addOffer(t, offerMgr, "pvd1", cid1, 10)
+	addOffer(t, offerMgr, "pvd2", cid1, 5)
+	addOffer(t, offerMgr, NodeID, cid1, 1)
+	addOffer(t, offerMgr, "pvd1", cid2, 20)
+	addOffer(t, offerMgr, "pvd2", cid2, 25)
+	addOffer(t, offerMgr, "pvd1", cid3, 5)
+	for i := 0; i < 3; i++ {
+		offerMgr.IncrementCIDAccessCount(cid1)
+	}
+	for i := 0; i < 2; i++ {
+		offerMgr.IncrementCIDAccessCount(cid2)
+	}
+	offerMgr.IncrementCIDAccessCount(cid3)
+
+	fetchedFrom := make([]string, 0)
+	fetcher := func(offer *cidoffer.SubCIDOffer) error {
+		fetchedFrom = append(fetchedFrom, offer.GetProviderID())
+		f, err := cacheMgr.NewTempFile()
+		if err != nil {
+			return err
+		}
+		f.Write([]byte("123456"))
+		f.Close()
+		_, err = cacheMgr.Add(offer.GetSubCID(), f.Name(), cid.DefaultDAGParams)
+		return err
+	}
+	ctrl := NewFCRCacheCtrlImplV1(NodeID, cacheMgr, offerMgr, fetcher, CachePolicy{
+		Eviction:  EvictLFU,
+		MaxSize:   12,
+		MaxSpend:  big.NewInt(30),
+		MaxPrice:  big.NewInt(20),
+		TopN:      10,
+		MinAccess: 2,
+	}, time.Hour)
+	assert.Empty(t, ctrl.Start())
+	assert.NotEmpty(t, ctrl.Start())
+	defer ctrl.Shutdown()
+
+	// Fetches the two most accessed cids from the cheapest offers of other nodes
+	ctrl.Check()
+	assert.Equal(t, []string{"pvd2", "pvd1"}, fetchedFrom)
+	assert.NotEmpty(t, cacheMgr.Get(cid1))
+	assert.NotEmpty(t, cacheMgr.Get(cid2))
+	assert.Empty(t, cacheMgr.Get(cid3))
+
+	ctrl.RecordLookup(cid1)
+	ctrl.RecordLookup(cid3)
+	stats := ctrl.GetStats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Fetched)
+	assert.Equal(t, big.NewInt(25), stats.Spent)
+	assert.Equal(t, big.NewInt(30), stats.SpendLimit)
+	assert.Equal(t, int64(12), stats.Size)
+	assert.Equal(t, 2, stats.Count)
+
+	// Fetching the now most accessed cid evicts the least frequently used content, with the offer of this node
+	addOffer(t, offerMgr, NodeID, cid2, 30)
+	cacheMgr.RecordAccess(cid1)
+	for i := 0; i < 5; i++ {
+		offerMgr.IncrementCIDAccessCount(cid3)
+	}
+	ctrl.Check()
+	assert.Equal(t, []string{"pvd2", "pvd1", "pvd1"}, fetchedFrom)
+	assert.NotEmpty(t, cacheMgr.Get(cid1))
+	assert.Empty(t, cacheMgr.Get(cid2))
+	assert.NotEmpty(t, cacheMgr.Get(cid3))
+	for _, offer := range offerMgr.GetOffers(cid2) {
+		assert.NotEqual(t, NodeID, offer.GetProviderID())
+	}
+	stats = ctrl.GetStats()
+	assert.Equal(t, uint64(3), stats.Fetched)
+	assert.Equal(t, uint64(1), stats.Evicted)
+	assert.Equal(t, big.NewInt(30), stats.Spent)
+
+	// Budget is used up
+	ctrl.Check()
+	assert.Equal(t, 3, len(fetchedFrom))
+}
+
+func TestCacheCtrlUnfit(t *testing.T) {
+	cacheMgr := fcrcachemgr.NewFCRCacheMgrImplV1(t.TempDir())
+	assert.Empty(t, cacheMgr.Start())
+	defer cacheMgr.Shutdown()
+	offerMgr := fcroffermgr.NewFCROfferMgrImplV1(true)
+	assert.Empty(t, offerMgr.Start())
+	defer offerMgr.Shutdown()
+
+	cid1, err := cid.NewContentID(CID1)
+	assert.Empty(t, err)
+	addOffer(t, offerMgr, "pvd1", cid1, 10)
+	for i := 0; i < 2; i++ {
+		offerMgr.IncrementCIDAccessCount(cid1)
+	}
+
+	fetched := 0
+	fetcher := func(offer *cidoffer.SubCIDOffer) error {
+		fetched++
+		f, err := cacheMgr.NewTempFile()
+		if err != nil {
+			return err
+		}
+		f.Write([]byte("123456"))
+		f.Close()
+		_, err = cacheMgr.Add(offer.GetSubCID(), f.Name(), cid.DefaultDAGParams)
+		return err
+	}
+	ctrl := NewFCRCacheCtrlImplV1(NodeID, cacheMgr, offerMgr, fetcher, CachePolicy{
+		Eviction:  EvictLRU,
+		MaxSize:   5,
+		MaxSpend:  big.NewInt(100),
+		TopN:      10,
+		MinAccess: 2,
+	}, time.Hour)
+	assert.Empty(t, ctrl.Start())
+	defer ctrl.Shutdown()
+
+	// The content is larger than the quota, it is paid for once and removed
+	ctrl.Check()
+	assert.Equal(t, 1, fetched)
+	assert.Empty(t, cacheMgr.Get(cid1))
+	stats := ctrl.GetStats()
+	assert.Equal(t, big.NewInt(10), stats.Spent)
+	assert.Equal(t, int64(0), stats.Size)
+
+	// It is not fetched again, however popular it is
+	offerMgr.IncrementCIDAccessCount(cid1)
+	ctrl.Check()
+	ctrl.Check()
+	assert.Equal(t, 1, fetched)
+	assert.Equal(t, big.NewInt(10), ctrl.GetStats().Spent)
+}
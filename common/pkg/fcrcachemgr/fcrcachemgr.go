@@ -0,0 +1,80 @@
+/*
+Package fcrcachemgr - cache manager manages content cached on disk by a gateway.
+*/
+package fcrcachemgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"os"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+)
+
+// FCRCacheMgr represents the manager that stores cached content under a managed directory.
+type FCRCacheMgr interface {
+	// Start starts the manager's routine.
+	Start() error
+
+	// Shutdown ends the manager's routine safely.
+	Shutdown()
+
+	// NewTempFile creates a temporary file in the managed directory to receive content before it is added.
+	NewTempFile() (*os.File, error)
+
+	// Add moves a received file into the cache under the given cid, built with the given dag parameters.
+	// If the cid is already cached, the received file is removed and the existing entry is returned.
+	Add(id *cid.ContentID, tempPath string, dag cid.DAGParams) (*CacheEntry, error)
+
+	// Get gets the cache entry of a given cid, nil if not cached.
+	Get(id *cid.ContentID) *CacheEntry
+
+	// RecordAccess records a hit of a given cid.
+	RecordAccess(id *cid.ContentID)
+
+	// Remove removes a given cid from the cache.
+	Remove(id *cid.ContentID) error
+
+	// List lists all cache entries.
+	List() []CacheEntry
+
+	// Size gets the total size in bytes of all cached content.
+	Size() int64
+}
+
+// CacheEntry represents a piece of content stored in the cache.
+type CacheEntry struct {
+	// CID is the content id.
+	CID string
+
+	// Path is the path of the cached file.
+	Path string
+
+	// Size is the size of the cached file in bytes.
+	Size int64
+
+	// DAG is the dag parameters used to compute the content id.
+	DAG cid.DAGParams
+
+	// AddedAt is the unix time at which the content is added.
+	AddedAt int64
+
+	// LastAccess is the unix time at which the content is last accessed.
+	LastAccess int64
+
+	// Hits is the number of times the content has been served.
+	Hits uint64
+}
@@ -0,0 +1,309 @@
+/*
+Package fcrcachemgr - cache manager manages content cached on disk by a gateway.
+*/
+package fcrcachemgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrdatabase"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+const (
+	// contentDir is the sub directory storing cached content, one file per cid
+	contentDir = "content"
+	// tempDir is the sub directory storing content being received
+	tempDir = "tmp"
+	// dbFile is the database file storing cache entries
+	dbFile = "cache.db"
+	// entryNamespace maps cid string -> cache entry json
+	entryNamespace = "entry"
+)
+
+// FCRCacheMgrImplV1 implements FCRCacheMgr, it keeps cached content as files named by cid
+// and persists the entries so that the cache survives a restart.
+type FCRCacheMgrImplV1 struct {
+	// Boolean indicates if the manager has started
+	start bool
+
+	// The managed directory
+	dir string
+
+	// db persists cache entries
+	db fcrdatabase.FCRDatabase
+
+	// entries maps cid string -> cache entry
+	entries map[string]*CacheEntry
+
+	// size is the total size of cached content
+	size int64
+
+	lock sync.RWMutex
+}
+
+// cacheEntryJson is used to serialise a cache entry
+type cacheEntryJson struct {
+	Size       int64         `json:"size"`
+	DAG        cid.DAGParams `json:"dag"`
+	AddedAt    int64         `json:"added_at"`
+	LastAccess int64         `json:"last_access"`
+	Hits       uint64        `json:"hits"`
+}
+
+func NewFCRCacheMgrImplV1(dir string) FCRCacheMgr {
+	return &FCRCacheMgrImplV1{
+		start:   false,
+		dir:     dir,
+		entries: make(map[string]*CacheEntry),
+		size:    0,
+		lock:    sync.RWMutex{},
+	}
+}
+
+func (mgr *FCRCacheMgrImplV1) Start() error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if mgr.start {
+		return errors.New("FCRCacheManager has already started")
+	}
+	// Anything left in the temp dir is from an interrupted retrieval
+	err := os.RemoveAll(filepath.Join(mgr.dir, tempDir))
+	if err != nil {
+		return fmt.Errorf("Error in clearing temp dir: %v", err.Error())
+	}
+	for _, sub := range []string{contentDir, tempDir} {
+		err = os.MkdirAll(filepath.Join(mgr.dir, sub), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("Error in creating cache dir: %v", err.Error())
+		}
+	}
+	db := fcrdatabase.NewFCRDatabaseImplV1(filepath.Join(mgr.dir, dbFile), entryNamespace)
+	err = db.Start()
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]*CacheEntry)
+	size := int64(0)
+	missing := make([]string, 0)
+	err = db.ForEach(entryNamespace, func(key []byte, value []byte) error {
+		entryJson := cacheEntryJson{}
+		if err := json.Unmarshal(value, &entryJson); err != nil {
+			return fmt.Errorf("Error in loading cache entry %v: %v", string(key), err.Error())
+		}
+		path := filepath.Join(mgr.dir, contentDir, string(key))
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != entryJson.Size {
+			// The file is gone or has been tampered with
+			missing = append(missing, string(key))
+			return nil
+		}
+		entries[string(key)] = &CacheEntry{
+			CID:        string(key),
+			Path:       path,
+			Size:       entryJson.Size,
+			DAG:        entryJson.DAG,
+			AddedAt:    entryJson.AddedAt,
+			LastAccess: entryJson.LastAccess,
+			Hits:       entryJson.Hits,
+		}
+		size += entryJson.Size
+		return nil
+	})
+	if err != nil {
+		db.Shutdown()
+		return err
+	}
+	for _, key := range missing {
+		logging.Warn("Cached content %v is missing, remove it from the cache", key)
+		os.Remove(filepath.Join(mgr.dir, contentDir, key))
+		if err = db.Delete(entryNamespace, []byte(key)); err != nil {
+			logging.Error("Error in removing cache entry %v: %v", key, err.Error())
+		}
+	}
+	logging.Info("FCRCacheManager loaded %v cached content of %v bytes from %v", len(entries), size, mgr.dir)
+	mgr.db = db
+	mgr.entries = entries
+	mgr.size = size
+	mgr.start = true
+	return nil
+}
+
+func (mgr *FCRCacheMgrImplV1) Shutdown() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	mgr.db.Shutdown()
+	mgr.start = false
+}
+
+func (mgr *FCRCacheMgrImplV1) NewTempFile() (*os.File, error) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return nil, errors.New("FCRCacheManager has not started")
+	}
+	return ioutil.TempFile(filepath.Join(mgr.dir, tempDir), "retrieval-")
+}
+
+func (mgr *FCRCacheMgrImplV1) Add(id *cid.ContentID, tempPath string, dag cid.DAGParams) (*CacheEntry, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return nil, errors.New("FCRCacheManager has not started")
+	}
+	key := id.ToString()
+	if entry, ok := mgr.entries[key]; ok {
+		// Already cached
+		os.Remove(tempPath)
+		res := *entry
+		return &res, nil
+	}
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error in reading received file: %v", err.Error())
+	}
+	now := time.Now().Unix()
+	entry := &CacheEntry{
+		CID:        key,
+		Path:       filepath.Join(mgr.dir, contentDir, key),
+		Size:       info.Size(),
+		DAG:        dag,
+		AddedAt:    now,
+		LastAccess: now,
+		Hits:       0,
+	}
+	err = os.Rename(tempPath, entry.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Error in moving received file into cache: %v", err.Error())
+	}
+	err = mgr.saveEntry(entry)
+	if err != nil {
+		os.Remove(entry.Path)
+		return nil, fmt.Errorf("Error in persisting cache entry %v: %v", key, err.Error())
+	}
+	mgr.entries[key] = entry
+	mgr.size += entry.Size
+	res := *entry
+	return &res, nil
+}
+
+func (mgr *FCRCacheMgrImplV1) Get(id *cid.ContentID) *CacheEntry {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return nil
+	}
+	entry, ok := mgr.entries[id.ToString()]
+	if !ok {
+		return nil
+	}
+	res := *entry
+	return &res
+}
+
+func (mgr *FCRCacheMgrImplV1) RecordAccess(id *cid.ContentID) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	entry, ok := mgr.entries[id.ToString()]
+	if !ok {
+		return
+	}
+	updated := *entry
+	updated.LastAccess = time.Now().Unix()
+	updated.Hits++
+	err := mgr.saveEntry(&updated)
+	if err != nil {
+		logging.Error("Error in persisting access of cached content %v: %v", entry.CID, err.Error())
+		return
+	}
+	*entry = updated
+}
+
+func (mgr *FCRCacheMgrImplV1) Remove(id *cid.ContentID) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return errors.New("FCRCacheManager has not started")
+	}
+	key := id.ToString()
+	entry, ok := mgr.entries[key]
+	if !ok {
+		return fmt.Errorf("Content %v is not cached", key)
+	}
+	err := mgr.db.Delete(entryNamespace, []byte(key))
+	if err != nil {
+		return fmt.Errorf("Error in removing cache entry %v: %v", key, err.Error())
+	}
+	delete(mgr.entries, key)
+	mgr.size -= entry.Size
+	// Content being served keeps readable until it is closed
+	err = os.Remove(entry.Path)
+	if err != nil && !os.IsNotExist(err) {
+		logging.Error("Error in removing cached file %v: %v", entry.Path, err.Error())
+	}
+	return nil
+}
+
+func (mgr *FCRCacheMgrImplV1) List() []CacheEntry {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	res := make([]CacheEntry, 0, len(mgr.entries))
+	for _, entry := range mgr.entries {
+		res = append(res, *entry)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CID < res[j].CID
+	})
+	return res
+}
+
+func (mgr *FCRCacheMgrImplV1) Size() int64 {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	return mgr.size
+}
+
+// saveEntry persists a cache entry, it must be called with the lock held.
+func (mgr *FCRCacheMgrImplV1) saveEntry(entry *CacheEntry) error {
+	data, err := json.Marshal(cacheEntryJson{
+		Size:       entry.Size,
+		DAG:        entry.DAG,
+		AddedAt:    entry.AddedAt,
+		LastAccess: entry.LastAccess,
+		Hits:       entry.Hits,
+	})
+	if err != nil {
+		return err
+	}
+	return mgr.db.Put(entryNamespace, []byte(entry.CID), data)
+}
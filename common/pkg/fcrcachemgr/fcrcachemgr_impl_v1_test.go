@@ -0,0 +1,120 @@
+/*
+Package fcrcachemgr - cache manager manages content cached on disk by a gateway.
+*/
+package fcrcachemgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+)
+
+const (
+	CID1 = "QmWJi2BHLpKpCnD3sA3jcSWv5M51D6Zf1WY4rN8BrQtCgi"
+	CID2 = "QmVPhUbiWEoFJ26p4uZveuMhnZvVuFx9Drras6FyD8aw22"
+)
+
+func receive(t *testing.T, mgr FCRCacheMgr, data string) string {
+	f, err := mgr.NewTempFile()
+	assert.Empty(t, err)
+	_, err = f.Write([]byte(data))
+	assert.Empty(t, err)
+	assert.Empty(t, f.Close())
+	return f.Name()
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	mgr := NewFCRCacheMgrImplV1(dir)
+	_, err := mgr.NewTempFile()
+	assert.NotEmpty(t, err)
+	err = mgr.Start()
+	assert.Empty(t, err)
+	err = mgr.Start()
+	assert.NotEmpty(t, err)
+
+	cid1, err := cid.NewContentID(CID1)
+	assert.Empty(t, err)
+	cid2, err := cid.NewContentID(CID2)
+	assert.Empty(t, err)
+
+	assert.Empty(t, mgr.Get(cid1))
+	entry, err := mgr.Add(cid1, receive(t, mgr, "content1"), cid.DefaultDAGParams)
+	assert.Empty(t, err)
+	assert.Equal(t, CID1, entry.CID)
+	assert.Equal(t, int64(8), entry.Size)
+	data, err := ioutil.ReadFile(entry.Path)
+	assert.Empty(t, err)
+	assert.Equal(t, "content1", string(data))
+
+	// Adding the same cid again keeps the existing content
+	temp := receive(t, mgr, "content")
+	entry, err = mgr.Add(cid1, temp, cid.DefaultDAGParams)
+	assert.Empty(t, err)
+	assert.Equal(t, int64(8), entry.Size)
+	_, err = os.Stat(temp)
+	assert.True(t, os.IsNotExist(err))
+
+	dag := cid.DAGParams{ChunkSize: 1024, CIDVersion: 1, RawLeaves: true}
+	_, err = mgr.Add(cid2, receive(t, mgr, "content22"), dag)
+	assert.Empty(t, err)
+	assert.Equal(t, int64(17), mgr.Size())
+
+	mgr.RecordAccess(cid2)
+	mgr.RecordAccess(cid2)
+	entry = mgr.Get(cid2)
+	assert.Equal(t, uint64(2), entry.Hits)
+	assert.Equal(t, dag, entry.DAG)
+	assert.Equal(t, 2, len(mgr.List()))
+	mgr.Shutdown()
+
+	// Restart
+	leftover := filepath.Join(dir, tempDir, "leftover")
+	assert.Empty(t, ioutil.WriteFile(leftover, []byte("partial"), 0644))
+	mgr = NewFCRCacheMgrImplV1(dir)
+	err = mgr.Start()
+	assert.Empty(t, err)
+	_, err = os.Stat(leftover)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(17), mgr.Size())
+	entry = mgr.Get(cid2)
+	assert.Equal(t, uint64(2), entry.Hits)
+	assert.Equal(t, dag, entry.DAG)
+
+	err = mgr.Remove(cid1)
+	assert.Empty(t, err)
+	err = mgr.Remove(cid1)
+	assert.NotEmpty(t, err)
+	assert.Empty(t, mgr.Get(cid1))
+	assert.Equal(t, int64(9), mgr.Size())
+
+	// Missing content is dropped at start
+	assert.Empty(t, os.Remove(entry.Path))
+	mgr.Shutdown()
+	mgr = NewFCRCacheMgrImplV1(dir)
+	err = mgr.Start()
+	assert.Empty(t, err)
+	defer mgr.Shutdown()
+	assert.Empty(t, mgr.Get(cid2))
+	assert.Equal(t, 0, len(mgr.List()))
+	assert.Equal(t, int64(0), mgr.Size())
+}
@@ -153,3 +153,20 @@ func VerifyByID(id string, sigStr string, data []byte) error {
 	}
 	return nil
 }
+
+// RecoverPublicKey recovers the public key that signed the given msg from the signature, regardless of the key version.
+func RecoverPublicKey(sigStr string, data []byte) (string, error) {
+	sig, err := hex.DecodeString(sigStr)
+	if err != nil {
+		return "", err
+	}
+	if len(sig) < 2 {
+		return "", errors.New("Invalid signature length")
+	}
+	b2sum := blake2b.Sum256(data)
+	pubk, err := crypto.EcRecover(b2sum[:], sig[1:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(pubk), nil
+}
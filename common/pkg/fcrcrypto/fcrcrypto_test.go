@@ -109,3 +109,23 @@ func TestVerifyByID(t *testing.T) {
 	err = VerifyByID(IDWrong, "006e9654ac82348a7ff3ff5e0bf906a34c799f3841e0119ed32a64d32ba92258f2735c90af4295684485735c63d85514beda21037cdb2b501735cdccec6d3a625301", []byte{0x00, 0x01, 0x02, 0x03, 0x04})
 	assert.NotEmpty(t, err)
 }
+
+func TestRecoverPublicKey(t *testing.T) {
+	pubKey, err := RecoverPublicKey("006e9654ac82348a7ff3ff5e0bf906a34c799f3841e0119ed32a64d32ba92258f2735c90af4295684485735c63d85514beda21037cdb2b501735cdccec6d3a625301", []byte{0x00, 0x01, 0x02, 0x03, 0x04})
+	assert.Empty(t, err)
+	assert.Equal(t, PubKey, pubKey)
+
+	// Key version is ignored
+	pubKey, err = RecoverPublicKey("106e9654ac82348a7ff3ff5e0bf906a34c799f3841e0119ed32a64d32ba92258f2735c90af4295684485735c63d85514beda21037cdb2b501735cdccec6d3a625301", []byte{0x00, 0x01, 0x02, 0x03, 0x04})
+	assert.Empty(t, err)
+	assert.Equal(t, PubKey, pubKey)
+
+	pubKey, err = RecoverPublicKey("006e9654ac82348a7ff3ff5e0bf906a34c799f3841e0119ed32a64d32ba92258f2735c90af4295684485735c63d85514beda21037cdb2b501735cdccec6d3a625301", []byte{0x00, 0x01, 0x02, 0x03})
+	assert.Empty(t, err)
+	assert.NotEqual(t, PubKey, pubKey)
+
+	_, err = RecoverPublicKey("abcdefg", []byte{0x00, 0x01, 0x02, 0x03, 0x04})
+	assert.NotEmpty(t, err)
+	_, err = RecoverPublicKey("", []byte{0x00, 0x01, 0x02, 0x03, 0x04})
+	assert.NotEmpty(t, err)
+}
@@ -18,6 +18,15 @@ package fcrdatabase
  * SPDX-License-Identifier: Apache-2.0
  */
 
+import "errors"
+
+// CurrentSchemaVersion is the on-disk schema version of this release.
+// It must be increased whenever the layout of any namespace changes, together with a registered migration.
+const CurrentSchemaVersion uint32 = 1
+
+// ErrNotFound is returned when a key does not exist in the given namespace.
+var ErrNotFound = errors.New("Key not found")
+
 // FCRDatabase represents the database.
 type FCRDatabase interface {
 	// Start starts the database routine.
@@ -25,4 +34,58 @@ type FCRDatabase interface {
 
 	// Shutdown ends the database routine safely.
 	Shutdown()
+
+	// AddMigration registers a migration that upgrades the schema from version "from" to version "from + 1".
+	// It must be called before Start.
+	AddMigration(from uint32, migration Migration) error
+
+	// GetSchemaVersion gets the schema version stored on disk.
+	GetSchemaVersion() (uint32, error)
+
+	// Put stores a key value pair in the given namespace.
+	Put(namespace string, key []byte, value []byte) error
+
+	// Get gets the value of a given key in the given namespace, returns ErrNotFound if the key does not exist.
+	Get(namespace string, key []byte) ([]byte, error)
+
+	// Delete removes a given key in the given namespace.
+	Delete(namespace string, key []byte) error
+
+	// ForEach iterates over all key value pairs in the given namespace in key order.
+	ForEach(namespace string, fn func(key []byte, value []byte) error) error
+
+	// Update executes all writes inside fn atomically. If fn returns an error, none of the writes is applied.
+	Update(fn func(txn Txn) error) error
+
+	// View executes fn on a consistent read-only snapshot of the database.
+	View(fn func(snapshot Snapshot) error) error
 }
+
+// Snapshot represents a consistent read-only view of the database.
+type Snapshot interface {
+	// Get gets the value of a given key in the given namespace, returns ErrNotFound if the key does not exist.
+	Get(namespace string, key []byte) ([]byte, error)
+
+	// ForEach iterates over all key value pairs in the given namespace in key order.
+	ForEach(namespace string, fn func(key []byte, value []byte) error) error
+}
+
+// Txn represents a read-write transaction of the database.
+type Txn interface {
+	Snapshot
+
+	// Put stores a key value pair in the given namespace.
+	Put(namespace string, key []byte, value []byte) error
+
+	// Delete removes a given key in the given namespace.
+	Delete(namespace string, key []byte) error
+
+	// CreateNamespace creates a namespace if it does not exist.
+	CreateNamespace(namespace string) error
+
+	// DeleteNamespace removes a namespace and all its content.
+	DeleteNamespace(namespace string) error
+}
+
+// Migration upgrades the on-disk layout by one schema version, it runs inside a single transaction.
+type Migration func(txn Txn) error
@@ -3,8 +3,6 @@ Package fcrdatabase - handles the database inside the system.
 */
 package fcrdatabase
 
-import "errors"
-
 /*
  * Copyright 2020 ConsenSys Software Inc.
  *
@@ -20,26 +18,336 @@ import "errors"
  * SPDX-License-Identifier: Apache-2.0
  */
 
-// FCRDatabaseImplV1 implements the FCRDatabase interface, it is a placeholder.
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// metaNamespace is the reserved namespace storing database metadata.
+	metaNamespace = "_meta"
+	// schemaVersionKey is the key of the schema version marker in the meta namespace.
+	schemaVersionKey = "schema_version"
+	// openTimeout is the time to wait for the file lock held by another process.
+	openTimeout = 5 * time.Second
+)
+
+// FCRDatabaseImplV1 implements the FCRDatabase interface, it is backed by an embedded bbolt key-value store.
+// Every write is committed with fsync before returning, so the database stays consistent after a crash.
 type FCRDatabaseImplV1 struct {
+	// Boolean indicates if the database has started
 	start bool
+
+	// Path to the database file
+	path string
+
+	// Namespaces to be created at start
+	namespaces []string
+
+	// Registered migrations, from schema version -> migration
+	migrations map[uint32]Migration
+
+	// The underlying store
+	db *bolt.DB
+
+	lock sync.RWMutex
 }
 
-func NewFCRDatabaseImplV1() FCRDatabase {
-	return &FCRDatabaseImplV1{start: false}
+func NewFCRDatabaseImplV1(path string, namespaces ...string) FCRDatabase {
+	return &FCRDatabaseImplV1{
+		start:      false,
+		path:       path,
+		namespaces: namespaces,
+		migrations: make(map[uint32]Migration),
+		lock:       sync.RWMutex{},
+	}
 }
 
 func (db *FCRDatabaseImplV1) Start() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
 	if db.start {
 		return errors.New("FCRDatabase has already started")
 	}
+	for _, namespace := range db.namespaces {
+		if namespace == metaNamespace {
+			return fmt.Errorf("Namespace %v is reserved", metaNamespace)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(db.path), 0755); err != nil {
+		return fmt.Errorf("Error in creating database directory: %v", err.Error())
+	}
+	store, err := bolt.Open(db.path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return fmt.Errorf("Error in opening database at %v: %v", db.path, err.Error())
+	}
+	if err = db.migrate(store); err != nil {
+		store.Close()
+		return err
+	}
+	err = store.Update(func(tx *bolt.Tx) error {
+		for _, namespace := range db.namespaces {
+			if _, err := tx.CreateBucketIfNotExists([]byte(namespace)); err != nil {
+				return fmt.Errorf("Error in creating namespace %v: %v", namespace, err.Error())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		store.Close()
+		return err
+	}
+	db.db = store
 	db.start = true
 	return nil
 }
 
 func (db *FCRDatabaseImplV1) Shutdown() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
 	if !db.start {
 		return
 	}
+	if err := db.db.Close(); err != nil {
+		logging.Error("Error in closing database at %v: %v", db.path, err.Error())
+	}
+	db.db = nil
 	db.start = false
 }
+
+func (db *FCRDatabaseImplV1) AddMigration(from uint32, migration Migration) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.start {
+		return errors.New("FCRDatabase has already started")
+	}
+	if migration == nil {
+		return errors.New("Nil migration")
+	}
+	if _, ok := db.migrations[from]; ok {
+		return fmt.Errorf("Migration from schema version %v already exists", from)
+	}
+	db.migrations[from] = migration
+	return nil
+}
+
+func (db *FCRDatabaseImplV1) GetSchemaVersion() (uint32, error) {
+	var version uint32
+	err := db.View(func(snapshot Snapshot) error {
+		var err error
+		version, err = readSchemaVersion(snapshot.(*boltTxn).tx)
+		return err
+	})
+	return version, err
+}
+
+func (db *FCRDatabaseImplV1) Put(namespace string, key []byte, value []byte) error {
+	return db.Update(func(txn Txn) error {
+		return txn.Put(namespace, key, value)
+	})
+}
+
+func (db *FCRDatabaseImplV1) Get(namespace string, key []byte) ([]byte, error) {
+	var res []byte
+	err := db.View(func(snapshot Snapshot) error {
+		var err error
+		res, err = snapshot.Get(namespace, key)
+		return err
+	})
+	return res, err
+}
+
+func (db *FCRDatabaseImplV1) Delete(namespace string, key []byte) error {
+	return db.Update(func(txn Txn) error {
+		return txn.Delete(namespace, key)
+	})
+}
+
+func (db *FCRDatabaseImplV1) ForEach(namespace string, fn func(key []byte, value []byte) error) error {
+	return db.View(func(snapshot Snapshot) error {
+		return snapshot.ForEach(namespace, fn)
+	})
+}
+
+func (db *FCRDatabaseImplV1) Update(fn func(txn Txn) error) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if !db.start {
+		return errors.New("FCRDatabase has not started")
+	}
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTxn{tx: tx})
+	})
+}
+
+func (db *FCRDatabaseImplV1) View(fn func(snapshot Snapshot) error) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if !db.start {
+		return errors.New("FCRDatabase has not started")
+	}
+	return db.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTxn{tx: tx})
+	})
+}
+
+// migrate checks the schema version marker and runs the registered migrations until the current schema version.
+func (db *FCRDatabaseImplV1) migrate(store *bolt.DB) error {
+	// Initialise the marker for a fresh database
+	err := store.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(metaNamespace))
+		if err != nil {
+			return err
+		}
+		if meta.Get([]byte(schemaVersionKey)) == nil {
+			return writeSchemaVersion(tx, CurrentSchemaVersion)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error in initialising schema version: %v", err.Error())
+	}
+	for {
+		var version uint32
+		err = store.View(func(tx *bolt.Tx) error {
+			version, err = readSchemaVersion(tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if version == CurrentSchemaVersion {
+			return nil
+		}
+		if version > CurrentSchemaVersion {
+			return fmt.Errorf("Database schema version %v is newer than supported version %v", version, CurrentSchemaVersion)
+		}
+		migration, ok := db.migrations[version]
+		if !ok {
+			return fmt.Errorf("Missing migration from schema version %v", version)
+		}
+		logging.Info("Migrating database %v from schema version %v to %v", db.path, version, version+1)
+		// Migration and the version bump are committed together
+		err = store.Update(func(tx *bolt.Tx) error {
+			if err := migration(&boltTxn{tx: tx}); err != nil {
+				return err
+			}
+			return writeSchemaVersion(tx, version+1)
+		})
+		if err != nil {
+			return fmt.Errorf("Error in migrating from schema version %v: %v", version, err.Error())
+		}
+	}
+}
+
+// readSchemaVersion reads the schema version marker.
+func readSchemaVersion(tx *bolt.Tx) (uint32, error) {
+	meta := tx.Bucket([]byte(metaNamespace))
+	if meta == nil {
+		return 0, errors.New("Schema version not found")
+	}
+	data := meta.Get([]byte(schemaVersionKey))
+	if len(data) != 4 {
+		return 0, errors.New("Schema version not found")
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+// writeSchemaVersion writes the schema version marker.
+func writeSchemaVersion(tx *bolt.Tx, version uint32) error {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, version)
+	return tx.Bucket([]byte(metaNamespace)).Put([]byte(schemaVersionKey), data)
+}
+
+// boltTxn implements Txn and Snapshot on top of a bbolt transaction.
+type boltTxn struct {
+	tx *bolt.Tx
+}
+
+func (t *boltTxn) bucket(namespace string) (*bolt.Bucket, error) {
+	if namespace == metaNamespace {
+		return nil, fmt.Errorf("Namespace %v is reserved", metaNamespace)
+	}
+	bucket := t.tx.Bucket([]byte(namespace))
+	if bucket == nil {
+		return nil, fmt.Errorf("Namespace %v does not exist", namespace)
+	}
+	return bucket, nil
+}
+
+func (t *boltTxn) Get(namespace string, key []byte) ([]byte, error) {
+	bucket, err := t.bucket(namespace)
+	if err != nil {
+		return nil, err
+	}
+	value := bucket.Get(key)
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	// Values are only valid during the transaction, return a copy
+	res := make([]byte, len(value))
+	copy(res, value)
+	return res, nil
+}
+
+func (t *boltTxn) ForEach(namespace string, fn func(key []byte, value []byte) error) error {
+	bucket, err := t.bucket(namespace)
+	if err != nil {
+		return err
+	}
+	return bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			// Nested bucket, skip
+			return nil
+		}
+		key := make([]byte, len(k))
+		copy(key, k)
+		value := make([]byte, len(v))
+		copy(value, v)
+		return fn(key, value)
+	})
+}
+
+func (t *boltTxn) Put(namespace string, key []byte, value []byte) error {
+	bucket, err := t.bucket(namespace)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, value)
+}
+
+func (t *boltTxn) Delete(namespace string, key []byte) error {
+	bucket, err := t.bucket(namespace)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(key)
+}
+
+func (t *boltTxn) CreateNamespace(namespace string) error {
+	if namespace == metaNamespace {
+		return fmt.Errorf("Namespace %v is reserved", metaNamespace)
+	}
+	_, err := t.tx.CreateBucketIfNotExists([]byte(namespace))
+	return err
+}
+
+func (t *boltTxn) DeleteNamespace(namespace string) error {
+	if namespace == metaNamespace {
+		return fmt.Errorf("Namespace %v is reserved", metaNamespace)
+	}
+	err := t.tx.DeleteBucket([]byte(namespace))
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
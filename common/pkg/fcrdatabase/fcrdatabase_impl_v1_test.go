@@ -19,17 +19,168 @@ package fcrdatabase
  */
 
 import (
+	"errors"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
 )
 
-func TestPlaceholder(t *testing.T) {
-	db := NewFCRDatabaseImplV1()
+func TestStartShutdown(t *testing.T) {
+	db := NewFCRDatabaseImplV1(filepath.Join(t.TempDir(), "test.db"), "ns1")
 	db.Shutdown()
+	err := db.Put("ns1", []byte("key"), []byte("value"))
+	assert.NotEmpty(t, err)
+	err = db.Start()
+	assert.Empty(t, err)
+	defer db.Shutdown()
+	err = db.Start()
+	assert.NotEmpty(t, err)
+	version, err := db.GetSchemaVersion()
+	assert.Empty(t, err)
+	assert.Equal(t, CurrentSchemaVersion, version)
+}
+
+func TestReservedNamespace(t *testing.T) {
+	db := NewFCRDatabaseImplV1(filepath.Join(t.TempDir(), "test.db"), metaNamespace)
+	err := db.Start()
+	assert.NotEmpty(t, err)
+}
+
+func TestPutGetDelete(t *testing.T) {
+	db := NewFCRDatabaseImplV1(filepath.Join(t.TempDir(), "test.db"), "ns1", "ns2")
+	err := db.Start()
+	assert.Empty(t, err)
+	defer db.Shutdown()
+
+	err = db.Put("ns1", []byte("key"), []byte("value1"))
+	assert.Empty(t, err)
+	err = db.Put("ns2", []byte("key"), []byte("value2"))
+	assert.Empty(t, err)
+	err = db.Put("ns3", []byte("key"), []byte("value3"))
+	assert.NotEmpty(t, err)
+
+	value, err := db.Get("ns1", []byte("key"))
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("value1"), value)
+	value, err = db.Get("ns2", []byte("key"))
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("value2"), value)
+	_, err = db.Get("ns1", []byte("key2"))
+	assert.Equal(t, ErrNotFound, err)
+
+	err = db.Delete("ns1", []byte("key"))
+	assert.Empty(t, err)
+	_, err = db.Get("ns1", []byte("key"))
+	assert.Equal(t, ErrNotFound, err)
+	_, err = db.Get(metaNamespace, []byte(schemaVersionKey))
+	assert.NotEmpty(t, err)
+}
+
+func TestUpdateAtomic(t *testing.T) {
+	db := NewFCRDatabaseImplV1(filepath.Join(t.TempDir(), "test.db"), "ns1")
+	err := db.Start()
+	assert.Empty(t, err)
+	defer db.Shutdown()
+
+	err = db.Update(func(txn Txn) error {
+		txn.Put("ns1", []byte("key1"), []byte("value1"))
+		txn.Put("ns1", []byte("key2"), []byte("value2"))
+		return errors.New("Abort")
+	})
+	assert.NotEmpty(t, err)
+	_, err = db.Get("ns1", []byte("key1"))
+	assert.Equal(t, ErrNotFound, err)
+
+	err = db.Update(func(txn Txn) error {
+		txn.Put("ns1", []byte("key1"), []byte("value1"))
+		txn.Put("ns1", []byte("key2"), []byte("value2"))
+		return nil
+	})
+	assert.Empty(t, err)
+	keys := make([]string, 0)
+	err = db.ForEach("ns1", func(key []byte, value []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	assert.Empty(t, err)
+	assert.Equal(t, []string{"key1", "key2"}, keys)
+}
+
+func TestView(t *testing.T) {
+	db := NewFCRDatabaseImplV1(filepath.Join(t.TempDir(), "test.db"), "ns1")
 	err := db.Start()
 	assert.Empty(t, err)
 	defer db.Shutdown()
+
+	err = db.Put("ns1", []byte("key1"), []byte("value1"))
+	assert.Empty(t, err)
+	err = db.View(func(snapshot Snapshot) error {
+		value, err := snapshot.Get("ns1", []byte("key1"))
+		assert.Empty(t, err)
+		assert.Equal(t, []byte("value1"), value)
+		return nil
+	})
+	assert.Empty(t, err)
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := NewFCRDatabaseImplV1(path, "ns1")
+	err := db.Start()
+	assert.Empty(t, err)
+	err = db.Put("ns1", []byte("key1"), []byte("value1"))
+	assert.Empty(t, err)
+	db.Shutdown()
+
+	db = NewFCRDatabaseImplV1(path, "ns1")
+	err = db.Start()
+	assert.Empty(t, err)
+	defer db.Shutdown()
+	value, err := db.Get("ns1", []byte("key1"))
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("value1"), value)
+}
+
+func TestMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := NewFCRDatabaseImplV1(path, "ns1")
+	err := db.Start()
+	assert.Empty(t, err)
+	err = db.Put("ns1", []byte("key1"), []byte("value1"))
+	assert.Empty(t, err)
+	// Pretend the database is created by an older release
+	err = db.(*FCRDatabaseImplV1).db.Update(func(tx *bolt.Tx) error {
+		return writeSchemaVersion(tx, CurrentSchemaVersion-1)
+	})
+	assert.Empty(t, err)
+	db.Shutdown()
+
+	// Missing migration
+	db = NewFCRDatabaseImplV1(path, "ns1", "ns2")
 	err = db.Start()
 	assert.NotEmpty(t, err)
+
+	db = NewFCRDatabaseImplV1(path, "ns1", "ns2")
+	err = db.AddMigration(CurrentSchemaVersion-1, func(txn Txn) error {
+		if err := txn.CreateNamespace("ns2"); err != nil {
+			return err
+		}
+		return txn.ForEach("ns1", func(key []byte, value []byte) error {
+			return txn.Put("ns2", key, value)
+		})
+	})
+	assert.Empty(t, err)
+	err = db.AddMigration(CurrentSchemaVersion-1, func(txn Txn) error { return nil })
+	assert.NotEmpty(t, err)
+	err = db.Start()
+	assert.Empty(t, err)
+	defer db.Shutdown()
+	version, err := db.GetSchemaVersion()
+	assert.Empty(t, err)
+	assert.Equal(t, CurrentSchemaVersion, version)
+	value, err := db.Get("ns2", []byte("key1"))
+	assert.Empty(t, err)
+	assert.Equal(t, []byte("value1"), value)
 }
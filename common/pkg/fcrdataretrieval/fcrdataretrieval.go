@@ -0,0 +1,305 @@
+/*
+Package fcrdataretrieval - data retrieval serves the content of an offer, streamed in chunks and paid upfront or in tranches.
+*/
+package fcrdataretrieval
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
+)
+
+// Handler handles data retrieval requests for a node serving content.
+// The checks of the request, the payment and the stream are shared, the parts specific to the node are given as functions.
+type Handler struct {
+	// The Peer Manager, used to verify requests signed by a gateway
+	PeerMgr fcrpeermgr.FCRPeerMgr
+
+	// The Payment Manager
+	PaymentMgr fcrpaymentmgr.FCRPaymentMgr
+
+	// The Reputation Manager
+	ReputationMgr fcrreputationmgr.FCRReputationMgr
+
+	// SearchPrice is the price charged for every request, it is never refunded
+	SearchPrice *big.Int
+
+	// Timeout is the inactivity timeout of every read and write
+	Timeout time.Duration
+
+	// Write signs a given response with the msg signing key of the node and writes it
+	Write func(writer fcrserver.FCRServerResponseWriter, response *fcrmessages.FCRACKMsg) error
+
+	// VerifyOffer verifies the signature of an offer, only offers of the node can be served
+	VerifyOffer func(offer *cidoffer.SubCIDOffer) error
+
+	// GetContent gets the content of a given cid, nil if the node does not have it
+	GetContent func(id *cid.ContentID) *Content
+
+	// RecordAccess records a retrieval of the content of a given cid
+	RecordAccess func(id *cid.ContentID)
+}
+
+// Content is the content of a cid served by a node.
+type Content struct {
+	// Tag is the tag of the content sent to the client
+	Tag string
+
+	// Path is the path of the file of the content
+	Path string
+
+	// DAGParams are the parameters the DAG of the content is built with
+	DAGParams cid.DAGParams
+}
+
+// Handle handles a data retrieval request.
+func (h *Handler) Handle(reader fcrserver.FCRServerRequestReader, writer fcrserver.FCRServerResponseWriter, request *fcrmessages.FCRReqMsg) error {
+	// Message decoding
+	nonce, senderID, offer, accountAddr, voucher, paymentInterval, dataRange, err := fcrmessages.DecodeDataRetrievalRequest(request)
+	if err != nil {
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payload: %v", err.Error())})
+	}
+
+	// Verify signature
+	// gwInfo stays nil if the request is signed by the sender ID directly
+	var gwInfo *fcrpeermgr.Peer
+	if request.VerifyByID(senderID) != nil {
+		// Verify by signing key
+		gwInfo = h.PeerMgr.GetGWInfo(senderID)
+		if gwInfo == nil {
+			// Not found, try sync once
+			gwInfo = h.PeerMgr.SyncGW(senderID)
+			if gwInfo == nil {
+				return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in obtaining information for gateway %v", senderID)})
+			}
+		}
+		if gwInfo.VerifyMsg(request.Verify) != nil {
+			// Try update
+			gwInfo = h.PeerMgr.SyncGW(senderID)
+			if gwInfo == nil || gwInfo.VerifyMsg(request.Verify) != nil {
+				return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from gateway %v", senderID)})
+			}
+		}
+	}
+
+	// Check if the sender is blocked
+	h.ReputationMgr.AddPeer(senderID)
+	if rep := h.ReputationMgr.GetPeerReputation(senderID); rep != nil && rep.Blocked {
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeBlocked, Message: fmt.Sprintf("Sender %v is blocked", senderID)})
+	}
+
+	// Check payment
+	// In incremental mode, only the search price is paid upfront, the content is paid in tranches while streaming
+	received, lane, err := h.PaymentMgr.Receive(accountAddr, voucher)
+	if err != nil {
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidPayment, Message: fmt.Sprintf("Error in receiving voucher %v:", err.Error())})
+	}
+	if lane != 1 {
+		// Wrong lane, refund all
+		refundVoucher := h.refund(accountAddr, lane, received)
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidPayment, Message: fmt.Sprintf("Not correct lane received expect 1 got %v, refund voucher %v", lane, refundVoucher), RefundVoucher: refundVoucher})
+	}
+	expected := big.NewInt(0).Add(h.SearchPrice, offer.GetPrice())
+	if paymentInterval > 0 {
+		expected = big.NewInt(0).Set(h.SearchPrice)
+	}
+	// Refunds keep the search price, except for internal errors
+	refundable := big.NewInt(0).Sub(received, h.SearchPrice)
+	if received.Cmp(expected) < 0 {
+		// Short payment
+		refundVoucher := ""
+		if refundable.Sign() > 0 {
+			refundVoucher = h.refund(accountAddr, lane, refundable)
+		}
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeShortPayment, Message: fmt.Sprintf("Short payment received, expect %v got %v, refund voucher %v", expected.String(), received.String(), refundVoucher), ExpectedAmount: expected, RefundVoucher: refundVoucher})
+	}
+
+	// Payment is fine, verify offer
+	if h.VerifyOffer(offer) != nil {
+		refundVoucher := h.refund(accountAddr, lane, refundable)
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidOffer, Message: fmt.Sprintf("Fail to verify the offer signature, refund voucher %v", refundVoucher), RefundVoucher: refundVoucher})
+	}
+	// Verify offer merkle proof
+	if offer.VerifyMerkleProof() != nil {
+		refundVoucher := h.refund(accountAddr, lane, refundable)
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidOffer, Message: fmt.Sprintf("Fail to verify the offer merkle proof, refund voucher %v", refundVoucher), RefundVoucher: refundVoucher})
+	}
+	// Verify offer expiry
+	if offer.HasExpired() {
+		refundVoucher := h.refund(accountAddr, lane, refundable)
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeOfferExpired, Message: fmt.Sprintf("Offer has expired, refund voucher %v", refundVoucher), RefundVoucher: refundVoucher})
+	}
+	// Offer is verified. Respond
+	// First get the content
+	content := h.GetContent(offer.GetSubCID())
+	if content == nil {
+		// Content is no longer available, refund all
+		refundVoucher := h.refund(accountAddr, lane, received)
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeContentUnavailable, Message: fmt.Sprintf("Content is no longer available, refund voucher %v", refundVoucher), RefundVoucher: refundVoucher})
+	}
+	// Second open the data
+	file, err := os.Open(content.Path)
+	var info os.FileInfo
+	if err == nil {
+		defer file.Close()
+		info, err = file.Stat()
+	}
+	if err != nil {
+		// Internal error, refund all
+		refundVoucher := h.refund(accountAddr, lane, received)
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in finding the content, refund voucher %v", refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter})
+	}
+	// Third check the data range
+	size := uint64(info.Size())
+	length := size
+	var leaves []cid.Leaf
+	if dataRange != nil {
+		if dataRange.Exceeds(size) {
+			refundVoucher := h.refund(accountAddr, lane, refundable)
+			return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Data range %v+%v exceeds size %v, refund voucher %v", dataRange.Offset, dataRange.Length, size, refundVoucher), RefundVoucher: refundVoucher})
+		}
+		length = dataRange.Length
+		if length == 0 {
+			// Only the header is requested, with the leaves of the DAG
+			leaves, err = cid.GetLeaves(file, content.DAGParams)
+		} else {
+			_, err = file.Seek(int64(dataRange.Offset), io.SeekStart)
+		}
+		if err != nil {
+			// Internal error, refund all
+			refundVoucher := h.refund(accountAddr, lane, received)
+			return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in reading the content, refund voucher %v", refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter})
+		}
+	}
+	// Fourth encoding response header
+	chunks := fcrmessages.GetChunkCount(length)
+	response, err := fcrmessages.EncodeDataRetrievalResponse(nonce, content.Tag, size, chunks, content.DAGParams, leaves)
+	if err != nil {
+		// Internal error, refund all
+		refundVoucher := h.refund(accountAddr, lane, received)
+		return h.fail(writer, nonce, &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in encoding the response, refund voucher %v", refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter})
+	}
+	err = h.Write(writer, response)
+	if err != nil {
+		return err
+	}
+
+	// Stream the content in chunks, each chunk is signed and written with its own timeout
+	// In incremental mode, the stream pauses at the start of every tranche until it is paid
+	buf := make([]byte, fcrmessages.DataChunkSize)
+	remaining := length
+	for index := uint64(0); index < chunks; index++ {
+		if paymentInterval > 0 && index%paymentInterval == 0 {
+			expected := fcrmessages.GetTranchePrice(offer.GetPrice(), chunks, index, index+paymentInterval)
+			if dataRange != nil {
+				expected = fcrmessages.GetRangeTranchePrice(offer.GetPrice(), size, dataRange, index, index+paymentInterval)
+			}
+			err = h.receiveTranche(reader, nonce, senderID, gwInfo, accountAddr, expected, index)
+			if err != nil {
+				return h.fail(writer, nonce, err)
+			}
+		}
+		if remaining < uint64(len(buf)) {
+			buf = buf[:remaining]
+		}
+		n, err := io.ReadFull(file, buf)
+		remaining -= uint64(n)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("Error in reading chunk %v of %v: %v", index, content.Tag, err.Error())
+		}
+		chunk, err := fcrmessages.EncodeDataChunkResponse(nonce, index, buf[:n])
+		if err != nil {
+			return fmt.Errorf("Error in encoding chunk %v of %v: %v", index, content.Tag, err.Error())
+		}
+		err = h.Write(writer, chunk)
+		if err != nil {
+			return fmt.Errorf("Error in sending chunk %v of %v: %v", index, content.Tag, err.Error())
+		}
+	}
+	if dataRange == nil || dataRange.Length == 0 {
+		// A ranged retrieval is counted once, by its request of the leaves
+		h.RecordAccess(offer.GetSubCID())
+	}
+	return nil
+}
+
+// receiveTranche receives the payment of an expected amount for the tranche of chunks starting at given index in an incremental retrieval.
+// A sender that stops paying or pays incorrectly gets a violation recorded.
+func (h *Handler) receiveTranche(reader fcrserver.FCRServerRequestReader, nonce uint64, senderID string, gwInfo *fcrpeermgr.Peer, accountAddr string, expected *big.Int, index uint64) error {
+	payment, err := reader.Read(h.Timeout)
+	if err != nil {
+		h.ReputationMgr.UpdatePeerRecord(senderID, reputation.PaymentStoppedDuringRetrieval.Copy(), 0)
+		return fmt.Errorf("Error in receiving payment for chunk %v from %v: %v", index, senderID, err.Error())
+	}
+	if payment.VerifyByID(senderID) != nil && (gwInfo == nil || gwInfo.VerifyMsg(payment.Verify) != nil) {
+		h.ReputationMgr.UpdatePeerRecord(senderID, reputation.InvalidPaymentDuringRetrieval.Copy(), 0)
+		return &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying payment for chunk %v from %v", index, senderID)}
+	}
+	nonceRecv, indexRecv, voucher, err := fcrmessages.DecodeDataRetrievalPayment(payment)
+	if err == nil {
+		if nonceRecv != nonce {
+			err = fmt.Errorf("Nonce mismatch: expected %v got %v", nonce, nonceRecv)
+		} else if indexRecv != index {
+			err = fmt.Errorf("Chunk index mismatch: expected %v got %v", index, indexRecv)
+		}
+	}
+	if err != nil {
+		h.ReputationMgr.UpdatePeerRecord(senderID, reputation.InvalidPaymentDuringRetrieval.Copy(), 0)
+		return &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payment for chunk %v from %v: %v", index, senderID, err.Error())}
+	}
+	received, lane, err := h.PaymentMgr.Receive(accountAddr, voucher)
+	if err != nil {
+		h.ReputationMgr.UpdatePeerRecord(senderID, reputation.InvalidPaymentDuringRetrieval.Copy(), 0)
+		return &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidPayment, Message: fmt.Sprintf("Error in receiving voucher for chunk %v: %v", index, err.Error())}
+	}
+	if lane != 1 || received.Cmp(expected) < 0 {
+		refundVoucher := h.refund(accountAddr, lane, received)
+		h.ReputationMgr.UpdatePeerRecord(senderID, reputation.InvalidPaymentDuringRetrieval.Copy(), 0)
+		return &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeShortPayment, Message: fmt.Sprintf("Invalid payment for chunk %v, expect %v on lane 1 got %v on lane %v, refund voucher %v", index, expected.String(), received.String(), lane, refundVoucher), ExpectedAmount: expected, RefundVoucher: refundVoucher}
+	}
+	return nil
+}
+
+// refund refunds a given amount received from a given account on a given lane.
+// It returns the refund voucher, empty if it fails to refund.
+func (h *Handler) refund(accountAddr string, lane uint64, amt *big.Int) string {
+	refundVoucher, err := h.PaymentMgr.Refund(accountAddr, lane, amt)
+	if err != nil {
+		// This should never happen
+		logging.Error("Error in refunding: %v", err.Error())
+	}
+	return refundVoucher
+}
+
+// fail logs a given error and writes it in the response.
+func (h *Handler) fail(writer fcrserver.FCRServerResponseWriter, nonce uint64, err error) error {
+	logging.Error(err.Error())
+	return h.Write(writer, fcrmessages.CreateFCRACKErrorMsg(nonce, err))
+}
@@ -0,0 +1,340 @@
+/*
+Package fcrdataretrieval - data retrieval serves the content of an offer, streamed in chunks and paid upfront or in tranches.
+*/
+package fcrdataretrieval
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/reputation"
+)
+
+// mockPaymentMgr only implements the functions used by the handler, a voucher is the amount it pays on lane 1 unless a lane is set
+type mockPaymentMgr struct {
+	fcrpaymentmgr.FCRPaymentMgr
+
+	lane         uint64
+	refunded     *big.Int
+	refundedLane uint64
+}
+
+func (m *mockPaymentMgr) Receive(senderAddr string, voucher string) (*big.Int, uint64, error) {
+	amt, ok := big.NewInt(0).SetString(voucher, 10)
+	if !ok {
+		return nil, 0, errors.New("Invalid voucher")
+	}
+	if m.lane != 0 {
+		return amt, m.lane, nil
+	}
+	return amt, 1, nil
+}
+
+func (m *mockPaymentMgr) Refund(senderAddr string, lane uint64, amt *big.Int) (string, error) {
+	m.refunded.Add(m.refunded, amt)
+	m.refundedLane = lane
+	return "refund-" + amt.String(), nil
+}
+
+// mockReputationMgr only implements the functions used by the handler
+type mockReputationMgr struct {
+	fcrreputationmgr.FCRReputationMgr
+
+	records int
+}
+
+func (m *mockReputationMgr) AddPeer(peerID string) {
+}
+
+func (m *mockReputationMgr) GetPeerReputation(peerID string) *fcrreputationmgr.Reputation {
+	return &fcrreputationmgr.Reputation{NodeID: peerID}
+}
+
+func (m *mockReputationMgr) UpdatePeerRecord(peerID string, record *reputation.Record, replica uint) {
+	m.records++
+}
+
+// mockPeerMgr only implements the functions used by the handler
+type mockPeerMgr struct {
+	fcrpeermgr.FCRPeerMgr
+
+	gws map[string]*fcrpeermgr.Peer
+}
+
+func (m *mockPeerMgr) GetGWInfo(gwID string) *fcrpeermgr.Peer {
+	return m.gws[gwID]
+}
+
+func (m *mockPeerMgr) SyncGW(gwID string) *fcrpeermgr.Peer {
+	return m.gws[gwID]
+}
+
+// mockReader reads the given payments in order
+type mockReader struct {
+	payments []*fcrmessages.FCRReqMsg
+}
+
+func (m *mockReader) Read(timeout time.Duration) (*fcrmessages.FCRReqMsg, error) {
+	if len(m.payments) == 0 {
+		return nil, errors.New("Timeout")
+	}
+	payment := m.payments[0]
+	m.payments = m.payments[1:]
+	return payment, nil
+}
+
+// mockWriter records the responses written
+type mockWriter struct {
+	responses []*fcrmessages.FCRACKMsg
+}
+
+func (m *mockWriter) Write(msg *fcrmessages.FCRACKMsg, privKey string, keyVer byte, timeout time.Duration) error {
+	m.responses = append(m.responses, msg)
+	return nil
+}
+
+type testEnv struct {
+	handler    *Handler
+	paymentMgr *mockPaymentMgr
+	repMgr     *mockReputationMgr
+	accessed   int
+	data       []byte
+	offer      *cidoffer.SubCIDOffer
+	privKey    string
+	senderID   string
+}
+
+func newTestEnv(t *testing.T, available bool) *testEnv {
+	env := &testEnv{
+		paymentMgr: &mockPaymentMgr{refunded: big.NewInt(0)},
+		repMgr:     &mockReputationMgr{},
+		data:       make([]byte, fcrmessages.DataChunkSize*5/2),
+	}
+	_, err := rand.Read(env.data)
+	assert.Empty(t, err)
+	path := filepath.Join(t.TempDir(), "content")
+	err = os.WriteFile(path, env.data, 0644)
+	assert.Empty(t, err)
+	file, err := os.Open(path)
+	assert.Empty(t, err)
+	id, err := cid.NewContentIDFromFile(file)
+	file.Close()
+	assert.Empty(t, err)
+	offer, err := cidoffer.NewCIDOffer("provider", []cid.ContentID{*id}, big.NewInt(1000), time.Now().Add(time.Hour).Unix(), 0)
+	assert.Empty(t, err)
+	env.offer, err = offer.GenerateSubCIDOffer(id)
+	assert.Empty(t, err)
+	env.privKey, _, env.senderID, err = fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+
+	_, gwPubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	env.handler = &Handler{
+		PeerMgr: &mockPeerMgr{gws: map[string]*fcrpeermgr.Peer{
+			"gateway": {NodeID: "gateway", MsgSigningKey: gwPubKey, MsgSigningKeyVer: 0},
+		}},
+		PaymentMgr:    env.paymentMgr,
+		ReputationMgr: env.repMgr,
+		SearchPrice:   big.NewInt(10),
+		Timeout:       time.Second,
+		Write: func(writer fcrserver.FCRServerResponseWriter, response *fcrmessages.FCRACKMsg) error {
+			return writer.Write(response, "", 0, time.Second)
+		},
+		VerifyOffer: func(offer *cidoffer.SubCIDOffer) error {
+			return nil
+		},
+		GetContent: func(id *cid.ContentID) *Content {
+			if !available {
+				return nil
+			}
+			return &Content{Tag: "content", Path: path, DAGParams: cid.DefaultDAGParams}
+		},
+		RecordAccess: func(id *cid.ContentID) {
+			env.accessed++
+		},
+	}
+	return env
+}
+
+// request creates a request signed by the sender
+func (env *testEnv) request(t *testing.T, senderID string, paid int64, paymentInterval uint64, dataRange *fcrmessages.DataRange) *fcrmessages.FCRReqMsg {
+	request, err := fcrmessages.EncodeDataRetrievalRequest(1, senderID, env.offer, "account", big.NewInt(paid).String(), paymentInterval, dataRange)
+	assert.Empty(t, err)
+	err = request.Sign(env.privKey, 0)
+	assert.Empty(t, err)
+	return request
+}
+
+// payments creates the payments of every tranche of a ranged retrieval
+func (env *testEnv) payments(t *testing.T, dataRange *fcrmessages.DataRange, paymentInterval uint64) []*fcrmessages.FCRReqMsg {
+	res := make([]*fcrmessages.FCRReqMsg, 0)
+	chunks := fcrmessages.GetChunkCount(dataRange.Length)
+	for index := uint64(0); index < chunks; index += paymentInterval {
+		amt := fcrmessages.GetRangeTranchePrice(env.offer.GetPrice(), uint64(len(env.data)), dataRange, index, index+paymentInterval)
+		payment, err := fcrmessages.EncodeDataRetrievalPayment(1, index, amt.String())
+		assert.Empty(t, err)
+		err = payment.Sign(env.privKey, 0)
+		assert.Empty(t, err)
+		res = append(res, payment)
+	}
+	return res
+}
+
+// received decodes the data received from the responses
+func received(t *testing.T, responses []*fcrmessages.FCRACKMsg, dataRange *fcrmessages.DataRange) []byte {
+	assert.True(t, responses[0].ACK())
+	_, _, _, chunks, _, _, err := fcrmessages.DecodeDataRetrievalResponse(responses[0], dataRange)
+	assert.Empty(t, err)
+	assert.Equal(t, int(chunks)+1, len(responses))
+	data := make([]byte, 0)
+	for i, response := range responses[1:] {
+		_, index, chunk, err := fcrmessages.DecodeDataChunkResponse(response)
+		assert.Empty(t, err)
+		assert.Equal(t, uint64(i), index)
+		data = append(data, chunk...)
+	}
+	return data
+}
+
+func TestRetrieveUpfront(t *testing.T) {
+	env := newTestEnv(t, true)
+	writer := &mockWriter{}
+	err := env.handler.Handle(&mockReader{}, writer, env.request(t, env.senderID, 1010, 0, nil))
+	assert.Empty(t, err)
+	assert.Equal(t, env.data, received(t, writer.responses, nil))
+	assert.Equal(t, 1, env.accessed)
+	assert.Equal(t, "0", env.paymentMgr.refunded.String())
+}
+
+func TestRetrieveRange(t *testing.T) {
+	env := newTestEnv(t, true)
+	dataRange := &fcrmessages.DataRange{Offset: 1000, Length: fcrmessages.DataChunkSize + 500}
+	writer := &mockWriter{}
+	err := env.handler.Handle(&mockReader{payments: env.payments(t, dataRange, 1)}, writer, env.request(t, env.senderID, 10, 1, dataRange))
+	assert.Empty(t, err)
+	assert.Equal(t, env.data[1000:1000+dataRange.Length], received(t, writer.responses, dataRange))
+	// A ranged retrieval is only counted by its request of the leaves
+	assert.Equal(t, 0, env.accessed)
+
+	// Leaves only
+	dataRange = &fcrmessages.DataRange{Offset: 0, Length: 0}
+	writer = &mockWriter{}
+	err = env.handler.Handle(&mockReader{}, writer, env.request(t, env.senderID, 10, 1, dataRange))
+	assert.Empty(t, err)
+	assert.Equal(t, 1, len(writer.responses))
+	_, _, size, _, _, leaves, err := fcrmessages.DecodeDataRetrievalResponse(writer.responses[0], dataRange)
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(len(env.data)), size)
+	total := uint64(0)
+	for _, leaf := range leaves {
+		total += leaf.DataSize
+	}
+	assert.Equal(t, size, total)
+	assert.Equal(t, 1, env.accessed)
+}
+
+func TestRetrieveStopPaying(t *testing.T) {
+	env := newTestEnv(t, true)
+	dataRange := &fcrmessages.DataRange{Offset: 0, Length: uint64(len(env.data))}
+	writer := &mockWriter{}
+	// Only the first tranche is paid
+	payments := env.payments(t, dataRange, 1)[:1]
+	err := env.handler.Handle(&mockReader{payments: payments}, writer, env.request(t, env.senderID, 10, 1, dataRange))
+	assert.Empty(t, err)
+	// Header, first chunk, error
+	assert.Equal(t, 3, len(writer.responses))
+	assert.False(t, writer.responses[2].ACK())
+	assert.Equal(t, 1, env.repMgr.records)
+	assert.Equal(t, 0, env.accessed)
+}
+
+func TestRetrieveErrors(t *testing.T) {
+	env := newTestEnv(t, true)
+
+	// Request claims a gateway ID but fails to verify
+	writer := &mockWriter{}
+	err := env.handler.Handle(&mockReader{}, writer, env.request(t, "gateway", 1010, 0, nil))
+	assert.Empty(t, err)
+	assert.Equal(t, 1, len(writer.responses))
+	assert.Equal(t, fcrmessages.ErrorCodeUnauthorised, writer.responses[0].ErrorDetails().Code)
+
+	// Unknown gateway
+	writer = &mockWriter{}
+	err = env.handler.Handle(&mockReader{}, writer, env.request(t, "unknown", 1010, 0, nil))
+	assert.Empty(t, err)
+	assert.Equal(t, fcrmessages.ErrorCodeUnauthorised, writer.responses[0].ErrorDetails().Code)
+
+	// Short payment, the search price is kept
+	writer = &mockWriter{}
+	err = env.handler.Handle(&mockReader{}, writer, env.request(t, env.senderID, 500, 0, nil))
+	assert.Empty(t, err)
+	assert.Equal(t, fcrmessages.ErrorCodeShortPayment, writer.responses[0].ErrorDetails().Code)
+	assert.Equal(t, "490", env.paymentMgr.refunded.String())
+
+	// Payment on a wrong lane, refund all on that lane
+	env.paymentMgr.refunded = big.NewInt(0)
+	env.paymentMgr.lane = 2
+	writer = &mockWriter{}
+	err = env.handler.Handle(&mockReader{}, writer, env.request(t, env.senderID, 1010, 0, nil))
+	assert.Empty(t, err)
+	assert.Equal(t, fcrmessages.ErrorCodeInvalidPayment, writer.responses[0].ErrorDetails().Code)
+	assert.Equal(t, "refund-1010", writer.responses[0].ErrorDetails().RefundVoucher)
+	assert.Equal(t, "1010", env.paymentMgr.refunded.String())
+	assert.Equal(t, uint64(2), env.paymentMgr.refundedLane)
+	env.paymentMgr.lane = 0
+
+	// Range out of the content
+	env.paymentMgr.refunded = big.NewInt(0)
+	dataRange := &fcrmessages.DataRange{Offset: uint64(len(env.data)), Length: 1}
+	writer = &mockWriter{}
+	err = env.handler.Handle(&mockReader{}, writer, env.request(t, env.senderID, 10, 1, dataRange))
+	assert.Empty(t, err)
+	assert.Equal(t, fcrmessages.ErrorCodeInvalidRequest, writer.responses[0].ErrorDetails().Code)
+	assert.Equal(t, "0", env.paymentMgr.refunded.String())
+
+	// Range whose end overflows
+	dataRange = &fcrmessages.DataRange{Offset: math.MaxUint64, Length: 2}
+	writer = &mockWriter{}
+	err = env.handler.Handle(&mockReader{}, writer, env.request(t, env.senderID, 10, 1, dataRange))
+	assert.Empty(t, err)
+	assert.Equal(t, 1, len(writer.responses))
+	assert.Equal(t, fcrmessages.ErrorCodeInvalidRequest, writer.responses[0].ErrorDetails().Code)
+
+	// Content no longer available, refund all
+	env = newTestEnv(t, false)
+	writer = &mockWriter{}
+	err = env.handler.Handle(&mockReader{}, writer, env.request(t, env.senderID, 1010, 0, nil))
+	assert.Empty(t, err)
+	assert.Equal(t, fcrmessages.ErrorCodeContentUnavailable, writer.responses[0].ErrorDetails().Code)
+	assert.Equal(t, "1010", env.paymentMgr.refunded.String())
+}
@@ -0,0 +1,130 @@
+/*
+Package fcrjournal - retrieval journal records the progress of retrievals, so that a failed retrieval can be resumed.
+*/
+package fcrjournal
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+)
+
+// FCRJournal represents the journal of the retrievals in progress.
+// A retrieval is journaled with the leaves of the DAG of its content, and the content is retrieved in parts of whole leaves.
+// The journal records which leaves have been verified and written to the file, and the amount paid to every provider.
+type FCRJournal interface {
+	// Start starts the journal.
+	Start() error
+
+	// Shutdown stops the journal.
+	Shutdown()
+
+	// Begin journals a new retrieval of a given cid, with nothing verified and nothing paid.
+	// It returns error if a retrieval of the cid is already journaled.
+	Begin(cidStr string, tag string, path string, size uint64, leaves []cid.Leaf) error
+
+	// Get gets a copy of the retrieval of a given cid, nil if not found.
+	Get(cidStr string) *Retrieval
+
+	// List lists copies of all the journaled retrievals.
+	List() []Retrieval
+
+	// MarkVerified records that the leaves from index "from" (inclusive) to index "to" (exclusive) of the retrieval of a given cid
+	// have been verified and written to the file.
+	MarkVerified(cidStr string, from int, to int) error
+
+	// AddPayment adds a given amount to the amount paid to a given provider in the retrieval of a given cid.
+	AddPayment(cidStr string, providerID string, amt *big.Int) error
+
+	// Remove removes the retrieval of a given cid, after it completes or is cancelled.
+	Remove(cidStr string) error
+}
+
+// Retrieval is a retrieval in progress.
+type Retrieval struct {
+	// CID is the cid of the content
+	CID string
+
+	// Tag is the tag of the content
+	Tag string
+
+	// Path is the path of the file the content is written to
+	Path string
+
+	// Size is the size in bytes of the content
+	Size uint64
+
+	// Leaves are the leaves of the DAG of the content, verified against its cid
+	Leaves []cid.Leaf
+
+	// Verified indicates for every leaf whether it has been verified and written to the file
+	Verified []bool
+
+	// Paid maps provider ID -> amount paid in this retrieval
+	Paid map[string]*big.Int
+}
+
+// LeafRange is a range of leaves, from index Start (inclusive) to index End (exclusive).
+type LeafRange struct {
+	Start int
+	End   int
+}
+
+// Missing gets the ranges of leaves not yet verified, in order.
+func (r *Retrieval) Missing() []LeafRange {
+	res := make([]LeafRange, 0)
+	for i := 0; i < len(r.Verified); i++ {
+		if r.Verified[i] {
+			continue
+		}
+		start := i
+		for i < len(r.Verified) && !r.Verified[i] {
+			i++
+		}
+		res = append(res, LeafRange{Start: start, End: i})
+	}
+	return res
+}
+
+// VerifiedSize gets the number of bytes verified.
+func (r *Retrieval) VerifiedSize() uint64 {
+	res := uint64(0)
+	for i, verified := range r.Verified {
+		if verified {
+			res += r.Leaves[i].DataSize
+		}
+	}
+	return res
+}
+
+// Copy gets a deep copy of the retrieval.
+func (r *Retrieval) Copy() *Retrieval {
+	res := &Retrieval{
+		CID:      r.CID,
+		Tag:      r.Tag,
+		Path:     r.Path,
+		Size:     r.Size,
+		Leaves:   append([]cid.Leaf{}, r.Leaves...),
+		Verified: append([]bool{}, r.Verified...),
+		Paid:     make(map[string]*big.Int),
+	}
+	for providerID, amt := range r.Paid {
+		res.Paid[providerID] = big.NewInt(0).Set(amt)
+	}
+	return res
+}
@@ -0,0 +1,177 @@
+/*
+Package fcrjournal - retrieval journal records the progress of retrievals, so that a failed retrieval can be resumed.
+*/
+package fcrjournal
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+)
+
+// FCRJournalImplV1 implements FCRJournal, it is an in-memory version.
+type FCRJournalImplV1 struct {
+	// Boolean indicates if the journal has started
+	start bool
+
+	// retrievals maps cid -> retrieval
+	retrievals map[string]*Retrieval
+	lock       sync.RWMutex
+
+	// store persists retrievals, nil for the in-memory version.
+	store retrievalStore
+}
+
+// retrievalStore persists retrievals.
+type retrievalStore interface {
+	// saveRetrieval saves the given new retrieval.
+	saveRetrieval(r *Retrieval) error
+
+	// saveVerified saves that the leaves from index "from" (inclusive) to index "to" (exclusive) of the retrieval of the given cid are verified.
+	saveVerified(cidStr string, from int, to int) error
+
+	// savePaid saves the total amount paid to the given provider in the retrieval of the given cid.
+	savePaid(cidStr string, providerID string, paid *big.Int) error
+
+	// removeRetrieval removes the given retrieval.
+	removeRetrieval(r *Retrieval) error
+}
+
+func NewFCRJournalImplV1() FCRJournal {
+	return &FCRJournalImplV1{
+		start:      false,
+		retrievals: make(map[string]*Retrieval),
+		lock:       sync.RWMutex{},
+	}
+}
+
+func (j *FCRJournalImplV1) Start() error {
+	if j.start {
+		return errors.New("FCRJournal has already started")
+	}
+	j.start = true
+	return nil
+}
+
+func (j *FCRJournalImplV1) Shutdown() {
+	j.start = false
+}
+
+func (j *FCRJournalImplV1) Begin(cidStr string, tag string, path string, size uint64, leaves []cid.Leaf) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.retrievals[cidStr]; ok {
+		return fmt.Errorf("Retrieval of %v is already journaled", cidStr)
+	}
+	r := &Retrieval{
+		CID:      cidStr,
+		Tag:      tag,
+		Path:     path,
+		Size:     size,
+		Leaves:   append([]cid.Leaf{}, leaves...),
+		Verified: make([]bool, len(leaves)),
+		Paid:     make(map[string]*big.Int),
+	}
+	if j.store != nil {
+		if err := j.store.saveRetrieval(r); err != nil {
+			return err
+		}
+	}
+	j.retrievals[cidStr] = r
+	return nil
+}
+
+func (j *FCRJournalImplV1) Get(cidStr string) *Retrieval {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	r, ok := j.retrievals[cidStr]
+	if !ok {
+		return nil
+	}
+	return r.Copy()
+}
+
+func (j *FCRJournalImplV1) List() []Retrieval {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	res := make([]Retrieval, 0, len(j.retrievals))
+	for _, r := range j.retrievals {
+		res = append(res, *r.Copy())
+	}
+	return res
+}
+
+func (j *FCRJournalImplV1) MarkVerified(cidStr string, from int, to int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	r, ok := j.retrievals[cidStr]
+	if !ok {
+		return fmt.Errorf("Retrieval of %v is not journaled", cidStr)
+	}
+	if from < 0 || from > to || to > len(r.Verified) {
+		return fmt.Errorf("Invalid leaf range %v-%v of %v leaves", from, to, len(r.Verified))
+	}
+	if j.store != nil {
+		if err := j.store.saveVerified(cidStr, from, to); err != nil {
+			return err
+		}
+	}
+	for i := from; i < to; i++ {
+		r.Verified[i] = true
+	}
+	return nil
+}
+
+func (j *FCRJournalImplV1) AddPayment(cidStr string, providerID string, amt *big.Int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	r, ok := j.retrievals[cidStr]
+	if !ok {
+		return fmt.Errorf("Retrieval of %v is not journaled", cidStr)
+	}
+	paid := big.NewInt(0).Set(amt)
+	if prev, ok := r.Paid[providerID]; ok {
+		paid.Add(paid, prev)
+	}
+	if j.store != nil {
+		if err := j.store.savePaid(cidStr, providerID, paid); err != nil {
+			return err
+		}
+	}
+	r.Paid[providerID] = paid
+	return nil
+}
+
+func (j *FCRJournalImplV1) Remove(cidStr string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	r, ok := j.retrievals[cidStr]
+	if !ok {
+		return fmt.Errorf("Retrieval of %v is not journaled", cidStr)
+	}
+	if j.store != nil {
+		if err := j.store.removeRetrieval(r); err != nil {
+			return err
+		}
+	}
+	delete(j.retrievals, cidStr)
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Package fcrjournal - retrieval journal records the progress of retrievals, so that a failed retrieval can be resumed.
+*/
+package fcrjournal
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+)
+
+var testLeaves = []cid.Leaf{
+	{CID: "bafkreia7uzkcilvqbbbltoriesmmsx7fmfrfrzv4ahdpmjh5wwgtpxrrbm", BlockSize: 1024, DataSize: 1024},
+	{CID: "bafkreibp6pgrlf2ppy7yjwu3zcs6z4xw5vfquqnbdfjy7ckiwmczq7hhey", BlockSize: 1024, DataSize: 1024},
+	{CID: "bafkreicjnm7d5ad3jtdbrsv3u4n7wqr3bhlixh2vpgvfrqdxfqvvgf5l5u", BlockSize: 1024, DataSize: 1024},
+	{CID: "bafkreidv2q4ewsxlyqzhvx3ff6fbmrtygjwd3lgwfuvaydxpv42hgrdfq4", BlockSize: 512, DataSize: 512},
+}
+
+const testCID = "QmVXsSVjwxMsCwKRCUxEkGb4f4B98gXVy3ih3v4otvcURK"
+
+func TestBegin(t *testing.T) {
+	j := NewFCRJournalImplV1()
+	err := j.Start()
+	assert.Empty(t, err)
+	defer j.Shutdown()
+
+	assert.Empty(t, j.Get(testCID))
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.NotEmpty(t, err)
+
+	r := j.Get(testCID)
+	assert.NotEmpty(t, r)
+	assert.Equal(t, "test.txt", r.Tag)
+	assert.Equal(t, "/tmp/test.txt", r.Path)
+	assert.Equal(t, uint64(3584), r.Size)
+	assert.Equal(t, testLeaves, r.Leaves)
+	assert.Equal(t, []LeafRange{{Start: 0, End: 4}}, r.Missing())
+	assert.Equal(t, uint64(0), r.VerifiedSize())
+	assert.Equal(t, 0, len(r.Paid))
+	assert.Equal(t, 1, len(j.List()))
+}
+
+func TestMarkVerified(t *testing.T) {
+	j := NewFCRJournalImplV1()
+	err := j.Start()
+	assert.Empty(t, err)
+	defer j.Shutdown()
+
+	err = j.MarkVerified(testCID, 0, 1)
+	assert.NotEmpty(t, err)
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+	err = j.MarkVerified(testCID, 2, 5)
+	assert.NotEmpty(t, err)
+	err = j.MarkVerified(testCID, 2, 1)
+	assert.NotEmpty(t, err)
+
+	err = j.MarkVerified(testCID, 1, 2)
+	assert.Empty(t, err)
+	r := j.Get(testCID)
+	assert.Equal(t, []LeafRange{{Start: 0, End: 1}, {Start: 2, End: 4}}, r.Missing())
+	assert.Equal(t, uint64(1024), r.VerifiedSize())
+
+	err = j.MarkVerified(testCID, 3, 4)
+	assert.Empty(t, err)
+	r = j.Get(testCID)
+	assert.Equal(t, []LeafRange{{Start: 0, End: 1}, {Start: 2, End: 3}}, r.Missing())
+	assert.Equal(t, uint64(1536), r.VerifiedSize())
+
+	// Returned retrieval is a copy
+	r.Verified[0] = true
+	assert.Equal(t, 2, len(j.Get(testCID).Missing()))
+
+	err = j.MarkVerified(testCID, 0, 4)
+	assert.Empty(t, err)
+	r = j.Get(testCID)
+	assert.Equal(t, 0, len(r.Missing()))
+	assert.Equal(t, r.Size, r.VerifiedSize())
+}
+
+func TestAddPayment(t *testing.T) {
+	j := NewFCRJournalImplV1()
+	err := j.Start()
+	assert.Empty(t, err)
+	defer j.Shutdown()
+
+	err = j.AddPayment(testCID, "101112131415", big.NewInt(100))
+	assert.NotEmpty(t, err)
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+	err = j.AddPayment(testCID, "101112131415", big.NewInt(100))
+	assert.Empty(t, err)
+	err = j.AddPayment(testCID, "101112131415", big.NewInt(50))
+	assert.Empty(t, err)
+	err = j.AddPayment(testCID, "161718192021", big.NewInt(20))
+	assert.Empty(t, err)
+
+	r := j.Get(testCID)
+	assert.Equal(t, 2, len(r.Paid))
+	assert.Equal(t, "150", r.Paid["101112131415"].String())
+	assert.Equal(t, "20", r.Paid["161718192021"].String())
+}
+
+func TestRemove(t *testing.T) {
+	j := NewFCRJournalImplV1()
+	err := j.Start()
+	assert.Empty(t, err)
+	defer j.Shutdown()
+
+	err = j.Remove(testCID)
+	assert.NotEmpty(t, err)
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+	err = j.Remove(testCID)
+	assert.Empty(t, err)
+	assert.Empty(t, j.Get(testCID))
+	assert.Equal(t, 0, len(j.List()))
+
+	// Can begin again after removal
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+}
@@ -0,0 +1,227 @@
+/*
+Package fcrjournal - retrieval journal records the progress of retrievals, so that a failed retrieval can be resumed.
+*/
+package fcrjournal
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrdatabase"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// Namespaces used by the persistent journal
+const (
+	// retrievalNamespace maps cid -> retrieval json, it is written once when the retrieval begins
+	retrievalNamespace = "retrieval"
+	// verifiedNamespace maps cid/leaf index -> empty, a key is written for every leaf verified
+	verifiedNamespace = "verified"
+	// paidNamespace maps cid/provider ID -> total amount paid to the provider
+	paidNamespace = "paid"
+)
+
+// FCRJournalImplV2 implements FCRJournal, it is a persistent version.
+// Every change to a retrieval is saved to the database before it is acknowledged,
+// and all retrievals are restored at start. Every leaf verified and every amount paid
+// is saved under its own key, so a change never rewrites the rest of the retrieval.
+type FCRJournalImplV2 struct {
+	*FCRJournalImplV1
+
+	// Path to the database file
+	dbPath string
+
+	// db persists retrievals
+	db fcrdatabase.FCRDatabase
+}
+
+// retrievalJson is used to serialise the part of a retrieval that does not change
+type retrievalJson struct {
+	Tag    string     `json:"tag"`
+	Path   string     `json:"path"`
+	Size   uint64     `json:"size"`
+	Leaves []cid.Leaf `json:"leaves"`
+}
+
+func NewFCRJournalImplV2(dbPath string) FCRJournal {
+	j := &FCRJournalImplV2{
+		FCRJournalImplV1: NewFCRJournalImplV1().(*FCRJournalImplV1),
+		dbPath:           dbPath,
+		db:               fcrdatabase.NewFCRDatabaseImplV1(dbPath, retrievalNamespace, verifiedNamespace, paidNamespace),
+	}
+	j.store = j
+	return j
+}
+
+func (j *FCRJournalImplV2) Start() error {
+	err := j.FCRJournalImplV1.Start()
+	if err != nil {
+		return err
+	}
+	err = j.db.Start()
+	if err != nil {
+		j.FCRJournalImplV1.Shutdown()
+		return err
+	}
+	retrievals := make(map[string]*Retrieval)
+	err = j.db.View(func(snapshot fcrdatabase.Snapshot) error {
+		err := snapshot.ForEach(retrievalNamespace, func(key []byte, value []byte) error {
+			r, err := decodeRetrieval(string(key), value)
+			if err != nil {
+				return fmt.Errorf("Error in loading retrieval of %v: %v", string(key), err.Error())
+			}
+			retrievals[string(key)] = r
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = snapshot.ForEach(verifiedNamespace, func(key []byte, value []byte) error {
+			r, sub, err := splitKey(retrievals, key)
+			if err != nil {
+				return err
+			}
+			index, err := strconv.Atoi(sub)
+			if err != nil || index < 0 || index >= len(r.Verified) {
+				return fmt.Errorf("Invalid leaf %v of %v leaves in retrieval of %v", sub, len(r.Verified), r.CID)
+			}
+			r.Verified[index] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return snapshot.ForEach(paidNamespace, func(key []byte, value []byte) error {
+			r, providerID, err := splitKey(retrievals, key)
+			if err != nil {
+				return err
+			}
+			amt, ok := big.NewInt(0).SetString(string(value), 10)
+			if !ok {
+				return fmt.Errorf("Invalid amount paid to %v in retrieval of %v: %v", providerID, r.CID, string(value))
+			}
+			r.Paid[providerID] = amt
+			return nil
+		})
+	})
+	if err != nil {
+		j.FCRJournalImplV1.Shutdown()
+		j.db.Shutdown()
+		return err
+	}
+	j.lock.Lock()
+	j.retrievals = retrievals
+	j.lock.Unlock()
+	logging.Info("FCRJournal restored %v retrievals from %v", len(retrievals), j.dbPath)
+	return nil
+}
+
+func (j *FCRJournalImplV2) Shutdown() {
+	j.FCRJournalImplV1.Shutdown()
+	j.db.Shutdown()
+}
+
+func (j *FCRJournalImplV2) saveRetrieval(r *Retrieval) error {
+	data, err := json.Marshal(retrievalJson{
+		Tag:    r.Tag,
+		Path:   r.Path,
+		Size:   r.Size,
+		Leaves: r.Leaves,
+	})
+	if err != nil {
+		return err
+	}
+	return j.db.Put(retrievalNamespace, []byte(r.CID), data)
+}
+
+func (j *FCRJournalImplV2) saveVerified(cidStr string, from int, to int) error {
+	return j.db.Update(func(txn fcrdatabase.Txn) error {
+		for i := from; i < to; i++ {
+			if err := txn.Put(verifiedNamespace, subKey(cidStr, strconv.Itoa(i)), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (j *FCRJournalImplV2) savePaid(cidStr string, providerID string, paid *big.Int) error {
+	return j.db.Put(paidNamespace, subKey(cidStr, providerID), []byte(paid.String()))
+}
+
+func (j *FCRJournalImplV2) removeRetrieval(r *Retrieval) error {
+	return j.db.Update(func(txn fcrdatabase.Txn) error {
+		if err := txn.Delete(retrievalNamespace, []byte(r.CID)); err != nil {
+			return err
+		}
+		for i, verified := range r.Verified {
+			if !verified {
+				continue
+			}
+			if err := txn.Delete(verifiedNamespace, subKey(r.CID, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		for providerID := range r.Paid {
+			if err := txn.Delete(paidNamespace, subKey(r.CID, providerID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// subKey gets the key of a given sub key of the retrieval of a given cid.
+func subKey(cidStr string, sub string) []byte {
+	return []byte(cidStr + "/" + sub)
+}
+
+// splitKey splits a given key into the retrieval among the given retrievals and the sub key.
+func splitKey(retrievals map[string]*Retrieval, key []byte) (*Retrieval, string, error) {
+	index := strings.Index(string(key), "/")
+	if index < 0 {
+		return nil, "", fmt.Errorf("Invalid key %v", string(key))
+	}
+	r, ok := retrievals[string(key[:index])]
+	if !ok {
+		return nil, "", fmt.Errorf("Key %v of a retrieval not journaled", string(key))
+	}
+	return r, string(key[index+1:]), nil
+}
+
+// decodeRetrieval decodes the retrieval of a given cid from its bytes, with nothing verified and nothing paid.
+func decodeRetrieval(cidStr string, data []byte) (*Retrieval, error) {
+	rJson := retrievalJson{}
+	err := json.Unmarshal(data, &rJson)
+	if err != nil {
+		return nil, err
+	}
+	return &Retrieval{
+		CID:      cidStr,
+		Tag:      rJson.Tag,
+		Path:     rJson.Path,
+		Size:     rJson.Size,
+		Leaves:   rJson.Leaves,
+		Verified: make([]bool, len(rJson.Leaves)),
+		Paid:     make(map[string]*big.Int),
+	}, nil
+}
@@ -0,0 +1,96 @@
+/*
+Package fcrjournal - retrieval journal records the progress of retrievals, so that a failed retrieval can be resumed.
+*/
+package fcrjournal
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrdatabase"
+)
+
+func TestPersistentRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+	j := NewFCRJournalImplV2(path)
+	err := j.Start()
+	assert.Empty(t, err)
+
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+	err = j.MarkVerified(testCID, 1, 3)
+	assert.Empty(t, err)
+	err = j.AddPayment(testCID, "101112131415", big.NewInt(100))
+	assert.Empty(t, err)
+	err = j.AddPayment(testCID, "101112131415", big.NewInt(50))
+	assert.Empty(t, err)
+	err = j.Begin("QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB", "other.txt", "/tmp/other.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+	err = j.Remove("QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB")
+	assert.Empty(t, err)
+	j.Shutdown()
+
+	// Restart the journal
+	j = NewFCRJournalImplV2(path)
+	err = j.Start()
+	assert.Empty(t, err)
+
+	assert.Equal(t, 1, len(j.List()))
+	assert.Empty(t, j.Get("QmPZ9gcCEpqKTo6aq61g2nXGUhM4iCL3ewB6LDXZCtioEB"))
+	r := j.Get(testCID)
+	assert.NotEmpty(t, r)
+	assert.Equal(t, "test.txt", r.Tag)
+	assert.Equal(t, "/tmp/test.txt", r.Path)
+	assert.Equal(t, uint64(3584), r.Size)
+	assert.Equal(t, testLeaves, r.Leaves)
+	assert.Equal(t, []LeafRange{{Start: 0, End: 1}, {Start: 3, End: 4}}, r.Missing())
+	assert.Equal(t, "150", r.Paid["101112131415"].String())
+
+	// Progress continues after restart
+	err = j.MarkVerified(testCID, 0, 1)
+	assert.Empty(t, err)
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.NotEmpty(t, err)
+	assert.Equal(t, []LeafRange{{Start: 3, End: 4}}, j.Get(testCID).Missing())
+
+	// A removed retrieval leaves nothing behind
+	err = j.Remove(testCID)
+	assert.Empty(t, err)
+	j.Shutdown()
+	db := fcrdatabase.NewFCRDatabaseImplV1(path, retrievalNamespace, verifiedNamespace, paidNamespace)
+	err = db.Start()
+	assert.Empty(t, err)
+	for _, namespace := range []string{retrievalNamespace, verifiedNamespace, paidNamespace} {
+		err = db.ForEach(namespace, func(key []byte, value []byte) error {
+			t.Errorf("Key %v left in %v", string(key), namespace)
+			return nil
+		})
+		assert.Empty(t, err)
+	}
+	db.Shutdown()
+	j = NewFCRJournalImplV2(path)
+	err = j.Start()
+	assert.Empty(t, err)
+	err = j.Begin(testCID, "test.txt", "/tmp/test.txt", 3584, testLeaves)
+	assert.Empty(t, err)
+	assert.Equal(t, []LeafRange{{Start: 0, End: 4}}, j.Get(testCID).Missing())
+	assert.Empty(t, j.Get(testCID).Paid)
+	j.Shutdown()
+}
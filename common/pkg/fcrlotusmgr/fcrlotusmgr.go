@@ -25,8 +25,10 @@ import (
 	"math/big"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 	lotusbig "github.com/filecoin-project/go-state-types/big"
 	crypto2 "github.com/filecoin-project/go-state-types/crypto"
+	lotusapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/paych"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/ipfs/go-cid"
@@ -40,10 +42,16 @@ type FCRLotusMgr interface {
 	// TopupPaymentChannel topups a payment channel using the given private key, channel address and a given amount.
 	TopupPaymentChannel(privKey string, chAddr string, amt *big.Int) error
 
-	// SettlePaymentChannel settles a payment channel using the given private key, channel address and a final voucher.
+	// SettlePaymentChannel settles a payment channel using the given private key, channel address and final vouchers.
+	// Every voucher is submitted to update the channel state before the channel is called to settle.
 	SettlePaymentChannel(privKey string, chAddr string, vouchers []string) error
 
+	// UpdatePaymentChannel submits the given vouchers to update the state of a payment channel using the given private key.
+	// A voucher must be signed by the other party of the channel.
+	UpdatePaymentChannel(privKey string, chAddr string, vouchers []string) error
+
 	// CollectPaymentChannel collects a payment channel using the given private key, channel address.
+	// It fails if the channel is not settling or the settlement period has not passed.
 	CollectPaymentChannel(privKey string, chAddr string) error
 
 	// CheckPaymentChannel checks the state of a channel.
@@ -51,20 +59,30 @@ type FCRLotusMgr interface {
 	CheckPaymentChannel(chAddr string) (bool, *big.Int, string, error)
 
 	// GetCostToCreate gets the current cost to create a payment channel.
+	// The cost is the maximum gas fee of the create message, it does not include the amount deposited.
 	GetCostToCreate(privKey string, recipientAddr string, amt *big.Int) (*big.Int, error)
 
 	// GetCostToSettle gets the current cost to settle a payment channel + updating voucher.
 	GetCostToSettle(privKey string, chAddr string, vouchers []string) (*big.Int, error)
 
+	// GetCurrentBlock gets the block number of the current chain head.
+	GetCurrentBlock() (*big.Int, error)
+
 	// GetPaymentChannelCreationBlock gets the block number at which given payment channel is created.
+	// Only messages within a limited number of recent blocks are searched.
 	GetPaymentChannelCreationBlock(chAddr string) (*big.Int, error)
 
 	// GetPaymentChannelSettlementBlock gets the block number at which given payment channel is called to settle.
+	// It returns error if the channel is not settling.
 	GetPaymentChannelSettlementBlock(chAddr string) (*big.Int, error)
 }
 
 // LotusAPI is the minimum interface interacting with the Lotus to achieve payment function.
 type LotusAPI interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+
+	ChainGetMessage(ctx context.Context, msg cid.Cid) (*types.Message, error)
+
 	ChainReadObj(ctx context.Context, obj cid.Cid) ([]byte, error)
 
 	GasEstimateFeeCap(ctx context.Context, msg *types.Message, maxqueueblks int64, tsk types.TipSetKey) (types.BigInt, error)
@@ -82,6 +100,10 @@ type LotusAPI interface {
 	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
 
 	StateGetReceipt(ctx context.Context, msg cid.Cid, tsk types.TipSetKey) (*types.MessageReceipt, error)
+
+	StateListMessages(ctx context.Context, match *lotusapi.MessageMatch, tsk types.TipSetKey, toht abi.ChainEpoch) ([]cid.Cid, error)
+
+	StateSearchMsgLimited(ctx context.Context, msg cid.Cid, limit abi.ChainEpoch) (*lotusapi.MsgLookup, error)
 }
 
 // GenerateVoucher generates a voucher by given private key, channel address, lane number and amount.
@@ -32,12 +32,15 @@ import (
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-crypto"
 	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
 	lotusbig "github.com/filecoin-project/go-state-types/big"
 	crypto2 "github.com/filecoin-project/go-state-types/crypto"
+	lotusapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/api/v0api"
 	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/paych"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/specs-actors/v4/actors/builtin"
 	init4 "github.com/filecoin-project/specs-actors/v4/actors/builtin/init"
 	paych2 "github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
 	"github.com/ipfs/go-cid"
@@ -45,6 +48,9 @@ import (
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 )
 
+// msgLookback is the maximum number of blocks to look back when searching for messages of a payment channel.
+const msgLookback = abi.ChainEpoch(30 * builtin.EpochsInDay)
+
 type FCRLotusMgrImplV1 struct {
 	lotusAPIAddr string
 	authToken    string
@@ -164,11 +170,108 @@ func (mgr *FCRLotusMgrImplV1) TopupPaymentChannel(privKey string, chAddr string,
 }
 
 func (mgr *FCRLotusMgrImplV1) SettlePaymentChannel(privKey string, chAddr string, vouchers []string) error {
-	return errors.New("No implementation")
+	fromAddr, err := getAddr(privKey)
+	if err != nil {
+		return err
+	}
+	paychAddr, err := address.NewFromString(chAddr)
+	if err != nil {
+		return err
+	}
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	msgs, err := settleMsgs(fromAddr, paychAddr, vouchers)
+	if err != nil {
+		return err
+	}
+	// Submit the vouchers first and then settle
+	return pushMsgs(privKey, api, msgs)
+}
+
+func (mgr *FCRLotusMgrImplV1) UpdatePaymentChannel(privKey string, chAddr string, vouchers []string) error {
+	fromAddr, err := getAddr(privKey)
+	if err != nil {
+		return err
+	}
+	paychAddr, err := address.NewFromString(chAddr)
+	if err != nil {
+		return err
+	}
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	msgs, err := updateMsgs(fromAddr, paychAddr, vouchers)
+	if err != nil {
+		return err
+	}
+	return pushMsgs(privKey, api, msgs)
 }
 
 func (mgr *FCRLotusMgrImplV1) CollectPaymentChannel(privKey string, chAddr string) error {
-	return errors.New("No implementation")
+	fromAddr, err := getAddr(privKey)
+	if err != nil {
+		return err
+	}
+	paychAddr, err := address.NewFromString(chAddr)
+	if err != nil {
+		return err
+	}
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	state, err := getPaychState(api, paychAddr)
+	if err != nil {
+		return err
+	}
+	if state.SettlingAt == 0 {
+		return errors.New("Channel is not settling")
+	}
+	head, err := api.ChainHead(context.Background())
+	if err != nil {
+		return err
+	}
+	if head.Height() < state.SettlingAt || head.Height() < state.MinSettleHeight {
+		return fmt.Errorf("Channel is not collectable until height %v, current height %v", state.SettlingAt, head.Height())
+	}
+	// Message builder
+	builder := paych.Message(actors.Version4, fromAddr)
+	msg, err := builder.Collect(paychAddr)
+	if err != nil {
+		return err
+	}
+	// Get signed message
+	signedMsg, err := fillMsg(privKey, api, msg)
+	if err != nil {
+		return err
+	}
+	contentID, err := api.MpoolPush(context.Background(), signedMsg)
+	if err != nil {
+		return err
+	}
+	receipt, err := waitReceipt(&contentID, api)
+	if err != nil {
+		return err
+	}
+	if receipt.ExitCode != 0 {
+		return fmt.Errorf("Transaction fails to execute: %s", receipt.ExitCode.Error())
+	}
+	return nil
 }
 
 func (mgr *FCRLotusMgrImplV1) CheckPaymentChannel(chAddr string) (bool, *big.Int, string, error) {
@@ -207,19 +310,194 @@ func (mgr *FCRLotusMgrImplV1) CheckPaymentChannel(chAddr string) (bool, *big.Int
 }
 
 func (mgr *FCRLotusMgrImplV1) GetCostToCreate(privKey string, recipientAddr string, amt *big.Int) (*big.Int, error) {
-	return nil, errors.New("No implementation")
+	fromAddr, err := getAddr(privKey)
+	if err != nil {
+		return nil, err
+	}
+	toAddr, err := address.NewFromString(recipientAddr)
+	if err != nil {
+		return nil, err
+	}
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	// Message builder
+	builder := paych.Message(actors.Version4, fromAddr)
+	msg, err := builder.Create(toAddr, lotusbig.NewFromGo(amt))
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := api.MpoolGetNonce(context.Background(), fromAddr)
+	if err != nil {
+		return nil, err
+	}
+	msg.Nonce = nonce
+	err = estimateGas(api, msg)
+	if err != nil {
+		return nil, err
+	}
+	// The maximum cost of a message is gas fee cap * gas limit, it does not include the amount deposited
+	return big.NewInt(0).Mul(msg.GasFeeCap.Int, big.NewInt(msg.GasLimit)), nil
 }
 
 func (mgr *FCRLotusMgrImplV1) GetCostToSettle(privKey string, chAddr string, vouchers []string) (*big.Int, error) {
-	return nil, errors.New("No implementation")
+	fromAddr, err := getAddr(privKey)
+	if err != nil {
+		return nil, err
+	}
+	paychAddr, err := address.NewFromString(chAddr)
+	if err != nil {
+		return nil, err
+	}
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	msgs, err := settleMsgs(fromAddr, paychAddr, vouchers)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := api.MpoolGetNonce(context.Background(), fromAddr)
+	if err != nil {
+		return nil, err
+	}
+	// The maximum cost of a message is gas fee cap * gas limit
+	cost := big.NewInt(0)
+	for i, msg := range msgs {
+		msg.Nonce = nonce + uint64(i)
+		err = estimateGas(api, msg)
+		if err != nil {
+			return nil, err
+		}
+		cost.Add(cost, big.NewInt(0).Mul(msg.GasFeeCap.Int, big.NewInt(msg.GasLimit)))
+	}
+	return cost, nil
+}
+
+func (mgr *FCRLotusMgrImplV1) GetCurrentBlock() (*big.Int, error) {
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	head, err := api.ChainHead(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(int64(head.Height())), nil
 }
 
 func (mgr *FCRLotusMgrImplV1) GetPaymentChannelCreationBlock(chAddr string) (*big.Int, error) {
-	return nil, errors.New("No implementation")
+	paychAddr, err := address.NewFromString(chAddr)
+	if err != nil {
+		return nil, err
+	}
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	state, err := getPaychState(api, paychAddr)
+	if err != nil {
+		return nil, err
+	}
+	payer, err := api.StateAccountKey(context.Background(), state.From, types.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+	// The channel is created by the payer calling the init actor
+	msgs, err := listMsgs(api, &lotusapi.MessageMatch{To: builtin.InitActorAddr, From: payer})
+	if err != nil {
+		return nil, err
+	}
+	for _, msgCID := range msgs {
+		msg, err := api.ChainGetMessage(context.Background(), msgCID)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Method != builtin.MethodsInit.Exec {
+			continue
+		}
+		lookup, err := api.StateSearchMsgLimited(context.Background(), msgCID, msgLookback)
+		if err != nil {
+			return nil, err
+		}
+		if lookup == nil || lookup.Receipt.ExitCode != 0 {
+			continue
+		}
+		var decodedReturn init4.ExecReturn
+		err = decodedReturn.UnmarshalCBOR(bytes.NewReader(lookup.Receipt.Return))
+		if err != nil {
+			continue
+		}
+		if decodedReturn.RobustAddress == paychAddr || decodedReturn.IDAddress == paychAddr {
+			return big.NewInt(int64(lookup.Height)), nil
+		}
+	}
+	return nil, fmt.Errorf("Creation of channel %v not found in the last %v blocks", chAddr, msgLookback)
 }
 
 func (mgr *FCRLotusMgrImplV1) GetPaymentChannelSettlementBlock(chAddr string) (*big.Int, error) {
-	return nil, errors.New("No implementation")
+	paychAddr, err := address.NewFromString(chAddr)
+	if err != nil {
+		return nil, err
+	}
+	// Get API
+	api, closer, err := mgr.getLotusAPI(mgr.authToken, mgr.lotusAPIAddr)
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer()
+	}
+	state, err := getPaychState(api, paychAddr)
+	if err != nil {
+		return nil, err
+	}
+	if state.SettlingAt == 0 {
+		return nil, errors.New("Channel is not settling")
+	}
+	if state.SettlingAt > state.MinSettleHeight {
+		// Settling at is exactly the settlement block + settle delay
+		return big.NewInt(int64(state.SettlingAt - paych2.SettleDelay)), nil
+	}
+	// Settling at has been raised to the min settle height, look up the settle message
+	msgs, err := listMsgs(api, &lotusapi.MessageMatch{To: paychAddr})
+	if err != nil {
+		return nil, err
+	}
+	for _, msgCID := range msgs {
+		msg, err := api.ChainGetMessage(context.Background(), msgCID)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Method != builtin.MethodsPaych.Settle {
+			continue
+		}
+		lookup, err := api.StateSearchMsgLimited(context.Background(), msgCID, msgLookback)
+		if err != nil {
+			return nil, err
+		}
+		if lookup != nil && lookup.Receipt.ExitCode == 0 {
+			return big.NewInt(int64(lookup.Height)), nil
+		}
+	}
+	return nil, fmt.Errorf("Settlement of channel %v not found in the last %v blocks", chAddr, msgLookback)
 }
 
 // fillMsg will fill the gas and sign a given message
@@ -236,36 +514,145 @@ func fillMsg(privKeyStr string, api LotusAPI, msg *types.Message) (*types.Signed
 	msg.Nonce = nonce
 
 	// Calculate gas
-	limit, err := api.GasEstimateGasLimit(context.Background(), msg, types.EmptyTSK)
+	err = estimateGas(api, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign message
+	sig, err := Sign(privKey, msg.Cid().Bytes())
 	if err != nil {
 		return nil, err
 	}
+	return &types.SignedMessage{
+		Message: *msg,
+		Signature: crypto2.Signature{
+			Type: crypto2.SigTypeSecp256k1,
+			Data: sig,
+		},
+	}, nil
+}
+
+// estimateGas will fill the gas limit, gas premium and gas fee cap of a given message
+func estimateGas(api LotusAPI, msg *types.Message) error {
+	limit, err := api.GasEstimateGasLimit(context.Background(), msg, types.EmptyTSK)
+	if err != nil {
+		return err
+	}
 	msg.GasLimit = int64(float64(limit) * 1.25)
 
 	premium, err := api.GasEstimateGasPremium(context.Background(), 10, msg.From, msg.GasLimit, types.EmptyTSK)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	msg.GasPremium = premium
 
 	feeCap, err := api.GasEstimateFeeCap(context.Background(), msg, 20, types.EmptyTSK)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	msg.GasFeeCap = feeCap
+	return nil
+}
 
-	// Sign message
-	sig, err := Sign(privKey, msg.Cid().Bytes())
+// settleMsgs builds the messages to settle a channel, one update per voucher followed by the settle message
+func settleMsgs(fromAddr address.Address, paychAddr address.Address, vouchers []string) ([]*types.Message, error) {
+	msgs, err := updateMsgs(fromAddr, paychAddr, vouchers)
 	if err != nil {
 		return nil, err
 	}
-	return &types.SignedMessage{
-		Message: *msg,
-		Signature: crypto2.Signature{
-			Type: crypto2.SigTypeSecp256k1,
-			Data: sig,
-		},
-	}, nil
+	msg, err := paych.Message(actors.Version4, fromAddr).Settle(paychAddr)
+	if err != nil {
+		return nil, err
+	}
+	return append(msgs, msg), nil
+}
+
+// updateMsgs builds the messages to update the state of a channel, one update per voucher
+func updateMsgs(fromAddr address.Address, paychAddr address.Address, vouchers []string) ([]*types.Message, error) {
+	builder := paych.Message(actors.Version4, fromAddr)
+	msgs := make([]*types.Message, 0)
+	for _, voucher := range vouchers {
+		sv, err := paych.DecodeSignedVoucher(voucher)
+		if err != nil {
+			return nil, err
+		}
+		if sv.ChannelAddr != paychAddr {
+			return nil, fmt.Errorf("Voucher is for channel %v, expect %v", sv.ChannelAddr.String(), paychAddr.String())
+		}
+		msg, err := builder.Update(paychAddr, sv, nil)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// pushMsgs signs and pushes the given messages in order, each message must be executed before the next one
+func pushMsgs(privKey string, api LotusAPI, msgs []*types.Message) error {
+	for _, msg := range msgs {
+		signedMsg, err := fillMsg(privKey, api, msg)
+		if err != nil {
+			return err
+		}
+		contentID, err := api.MpoolPush(context.Background(), signedMsg)
+		if err != nil {
+			return err
+		}
+		receipt, err := waitReceipt(&contentID, api)
+		if err != nil {
+			return err
+		}
+		if receipt.ExitCode != 0 {
+			return fmt.Errorf("Transaction fails to execute: %s", receipt.ExitCode.Error())
+		}
+	}
+	return nil
+}
+
+// listMsgs lists the messages matching given filter within the lookback limit, the most recent first
+func listMsgs(api LotusAPI, match *lotusapi.MessageMatch) ([]cid.Cid, error) {
+	head, err := api.ChainHead(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	toHeight := head.Height() - msgLookback
+	if toHeight < 0 {
+		toHeight = 0
+	}
+	return api.StateListMessages(context.Background(), match, types.EmptyTSK, toHeight)
+}
+
+// getPaychState reads the on-chain state of a given payment channel
+func getPaychState(api LotusAPI, paychAddr address.Address) (*paych2.State, error) {
+	actor, err := api.StateGetActor(context.Background(), paychAddr, types.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+	data, err := api.ChainReadObj(context.Background(), actor.Head)
+	if err != nil {
+		return nil, err
+	}
+	state := paych2.State{}
+	err = state.UnmarshalCBOR(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// getAddr returns the secp256k1 address of a given private key
+func getAddr(privKey string) (address.Address, error) {
+	pubKey, _, err := fcrcrypto.GetPublicKey(privKey)
+	if err != nil {
+		return address.Undef, err
+	}
+	pubKeyBytes, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return address.Undef, err
+	}
+	return address.NewSecp256k1Address(pubKeyBytes)
 }
 
 // wait receipt will wait until receipt is received for a given cid
@@ -19,13 +19,19 @@ package fcrlotusmgr
  */
 
 import (
+	"bytes"
 	"context"
 	"math/big"
 	"testing"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	lotusbig "github.com/filecoin-project/go-state-types/big"
+	lotusapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
+	builtin "github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	paych2 "github.com/filecoin-project/specs-actors/v4/actors/builtin/paych"
 	"github.com/ipfs/go-cid"
 	"github.com/stretchr/testify/assert"
 )
@@ -37,6 +43,10 @@ const (
 )
 
 type mockLotusAPI struct {
+	chainHead func(ctx context.Context) (*types.TipSet, error)
+
+	chainGetMessage func(ctx context.Context, msg cid.Cid) (*types.Message, error)
+
 	chainReadObj func(ctx context.Context, obj cid.Cid) ([]byte, error)
 
 	gasEstimateFeeCap func(ctx context.Context, msg *types.Message, maxqueueblks int64, tsk types.TipSetKey) (types.BigInt, error)
@@ -54,6 +64,18 @@ type mockLotusAPI struct {
 	stateGetActor func(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
 
 	stateGetReceipt func(ctx context.Context, msg cid.Cid, tsk types.TipSetKey) (*types.MessageReceipt, error)
+
+	stateListMessages func(ctx context.Context, match *lotusapi.MessageMatch, tsk types.TipSetKey, toht abi.ChainEpoch) ([]cid.Cid, error)
+
+	stateSearchMsgLimited func(ctx context.Context, msg cid.Cid, limit abi.ChainEpoch) (*lotusapi.MsgLookup, error)
+}
+
+func (m *mockLotusAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return m.chainHead(ctx)
+}
+
+func (m *mockLotusAPI) ChainGetMessage(ctx context.Context, msg cid.Cid) (*types.Message, error) {
+	return m.chainGetMessage(ctx, msg)
 }
 
 func (m *mockLotusAPI) ChainReadObj(ctx context.Context, obj cid.Cid) ([]byte, error) {
@@ -92,6 +114,14 @@ func (m *mockLotusAPI) StateGetReceipt(ctx context.Context, msg cid.Cid, tsk typ
 	return m.stateGetReceipt(ctx, msg, tsk)
 }
 
+func (m *mockLotusAPI) StateListMessages(ctx context.Context, match *lotusapi.MessageMatch, tsk types.TipSetKey, toht abi.ChainEpoch) ([]cid.Cid, error) {
+	return m.stateListMessages(ctx, match, tsk, toht)
+}
+
+func (m *mockLotusAPI) StateSearchMsgLimited(ctx context.Context, msg cid.Cid, limit abi.ChainEpoch) (*lotusapi.MsgLookup, error) {
+	return m.stateSearchMsgLimited(ctx, msg, limit)
+}
+
 func TestCreate(t *testing.T) {
 	mock := mockLotusAPI{
 		gasEstimateFeeCap: func(ctx context.Context, msg *types.Message, maxqueueblks int64, tsk types.TipSetKey) (types.BigInt, error) {
@@ -122,6 +152,11 @@ func TestCreate(t *testing.T) {
 		return &mock, nil, nil
 	})
 
+	// Gas limit is 3823323 * 1.25, cost is gas fee cap * gas limit
+	cost, err := mgr.GetCostToCreate(PrivKey, "t1hn3o5excejl2uyea7efs3licozuycghzpdiikjy", big.NewInt(1000000))
+	assert.Empty(t, err)
+	assert.Equal(t, "480988295379", cost.String())
+
 	address, err := mgr.CreatePaymentChannel(PrivKey, "t1hn3o5excejl2uyea7efs3licozuycghzpdiikjy", big.NewInt(1000000))
 	assert.Empty(t, err)
 	assert.Equal(t, "f2n6prop4c3wmayti7d26hdwjitfu6ttkp5qhu6ni", address)
@@ -210,21 +245,257 @@ func TestVoucher(t *testing.T) {
 	assert.Equal(t, "1000000", newRedeemed.String())
 }
 
-func TestUnimplemented(t *testing.T) {
-	mock := mockLotusAPI{}
+func TestSettle(t *testing.T) {
+	chAddr := "f2n6prop4c3wmayti7d26hdwjitfu6ttkp5qhu6ni"
+	voucher1, err := GenerateVoucher(PrivKey, chAddr, 0, 1, big.NewInt(100))
+	assert.Empty(t, err)
+	voucher2, err := GenerateVoucher(PrivKey, chAddr, 1, 3, big.NewInt(200))
+	assert.Empty(t, err)
+
+	methods := make([]abi.MethodNum, 0)
+	mock := mockLotusAPI{
+		gasEstimateFeeCap: func(ctx context.Context, msg *types.Message, maxqueueblks int64, tsk types.TipSetKey) (types.BigInt, error) {
+			return types.NewInt(100), nil
+		},
+		gasEstimateGasPremium: func(ctx context.Context, nblocksincl uint64, sender address.Address, gaslimit int64, tsk types.TipSetKey) (types.BigInt, error) {
+			return types.NewInt(10), nil
+		},
+		gasEstimateGasLimit: func(ctx context.Context, msg *types.Message, tsk types.TipSetKey) (int64, error) {
+			return 1000, nil
+		},
+		mpoolGetNonce: func(ctx context.Context, addr address.Address) (uint64, error) {
+			return uint64(len(methods)), nil
+		},
+		mpoolPush: func(ctx context.Context, smsg *types.SignedMessage) (cid.Cid, error) {
+			methods = append(methods, smsg.Message.Method)
+			return cid.Parse("baga6ea4seaqesauho7j2thfi4g4u5zbnhn2okd74s2igpvc2lsb7rrsfstoy4by")
+		},
+		stateGetReceipt: func(ctx context.Context, msg cid.Cid, tsk types.TipSetKey) (*types.MessageReceipt, error) {
+			return &types.MessageReceipt{
+				ExitCode: 0,
+				Return:   []byte{},
+				GasUsed:  1000,
+			}, nil
+		},
+	}
+
 	mgr := NewFCRLotusMgrImplV1(LotusAPIAddr, LotusToken, func(authToken, lotusAPIAddr string) (LotusAPI, jsonrpc.ClientCloser, error) {
 		return &mock, nil, nil
 	})
-	err := mgr.SettlePaymentChannel("", "", []string{""})
+
+	// Two updates and one settle, each message costs 100 * 1250
+	cost, err := mgr.GetCostToSettle(PrivKey, chAddr, []string{voucher1, voucher2})
+	assert.Empty(t, err)
+	assert.Equal(t, "375000", cost.String())
+
+	err = mgr.SettlePaymentChannel(PrivKey, chAddr, []string{voucher1, voucher2})
+	assert.Empty(t, err)
+	assert.Equal(t, []abi.MethodNum{builtin.MethodsPaych.UpdateChannelState, builtin.MethodsPaych.UpdateChannelState, builtin.MethodsPaych.Settle}, methods)
+
+	// Voucher of another channel
+	err = mgr.SettlePaymentChannel(PrivKey, "f1hn3o5excejl2uyea7efs3licozuycghzpdiikjy", []string{voucher1})
 	assert.NotEmpty(t, err)
-	err = mgr.CollectPaymentChannel("", "")
+
+	// Updates only
+	methods = make([]abi.MethodNum, 0)
+	err = mgr.UpdatePaymentChannel(PrivKey, chAddr, []string{voucher1, voucher2})
+	assert.Empty(t, err)
+	assert.Equal(t, []abi.MethodNum{builtin.MethodsPaych.UpdateChannelState, builtin.MethodsPaych.UpdateChannelState}, methods)
+
+	err = mgr.UpdatePaymentChannel(PrivKey, "f1hn3o5excejl2uyea7efs3licozuycghzpdiikjy", []string{voucher1})
 	assert.NotEmpty(t, err)
-	_, err = mgr.GetCostToCreate("", "", nil)
+}
+
+func TestCollect(t *testing.T) {
+	codeCID, err := cid.Parse("bafkqafdgnfwc6nbpobqxs3lfnz2gg2dbnzxgk3a")
+	assert.Empty(t, err)
+	headCID, err := cid.Parse("bafy2bzaceazcxcw4ggk66uh76z7k2owst7d6xwkmrycz3cvnjf6g5havlo5lw")
+	assert.Empty(t, err)
+	from, err := address.NewFromString("f1hn3o5excejl2uyea7efs3licozuycghzpdiikjy")
+	assert.Empty(t, err)
+
+	settlingAt := abi.ChainEpoch(0)
+	height := abi.ChainEpoch(100)
+	pushed := 0
+	mock := mockLotusAPI{
+		chainHead: func(ctx context.Context) (*types.TipSet, error) {
+			return types.NewTipSet([]*types.BlockHeader{{
+				Miner:                 from,
+				Height:                height,
+				ParentStateRoot:       headCID,
+				ParentMessageReceipts: headCID,
+				Messages:              headCID,
+			}})
+		},
+		stateGetActor: func(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+			return &types.Actor{
+				Code:    codeCID,
+				Head:    headCID,
+				Nonce:   0,
+				Balance: types.NewInt(1000000),
+			}, nil
+		},
+		chainReadObj: func(ctx context.Context, obj cid.Cid) ([]byte, error) {
+			state := paych2.State{
+				From:       from,
+				To:         from,
+				ToSend:     lotusbig.Zero(),
+				SettlingAt: settlingAt,
+				LaneStates: headCID,
+			}
+			buf := new(bytes.Buffer)
+			err := state.MarshalCBOR(buf)
+			return buf.Bytes(), err
+		},
+		gasEstimateFeeCap: func(ctx context.Context, msg *types.Message, maxqueueblks int64, tsk types.TipSetKey) (types.BigInt, error) {
+			return types.NewInt(100), nil
+		},
+		gasEstimateGasPremium: func(ctx context.Context, nblocksincl uint64, sender address.Address, gaslimit int64, tsk types.TipSetKey) (types.BigInt, error) {
+			return types.NewInt(10), nil
+		},
+		gasEstimateGasLimit: func(ctx context.Context, msg *types.Message, tsk types.TipSetKey) (int64, error) {
+			return 1000, nil
+		},
+		mpoolGetNonce: func(ctx context.Context, addr address.Address) (uint64, error) {
+			return 1, nil
+		},
+		mpoolPush: func(ctx context.Context, smsg *types.SignedMessage) (cid.Cid, error) {
+			assert.Equal(t, builtin.MethodsPaych.Collect, smsg.Message.Method)
+			pushed++
+			return cid.Parse("baga6ea4seaqesauho7j2thfi4g4u5zbnhn2okd74s2igpvc2lsb7rrsfstoy4by")
+		},
+		stateGetReceipt: func(ctx context.Context, msg cid.Cid, tsk types.TipSetKey) (*types.MessageReceipt, error) {
+			return &types.MessageReceipt{
+				ExitCode: 0,
+				Return:   []byte{},
+				GasUsed:  1000,
+			}, nil
+		},
+	}
+
+	mgr := NewFCRLotusMgrImplV1(LotusAPIAddr, LotusToken, func(authToken, lotusAPIAddr string) (LotusAPI, jsonrpc.ClientCloser, error) {
+		return &mock, nil, nil
+	})
+
+	current, err := mgr.GetCurrentBlock()
+	assert.Empty(t, err)
+	assert.Equal(t, big.NewInt(100), current)
+
+	// Not settling
+	err = mgr.CollectPaymentChannel(PrivKey, "f2n6prop4c3wmayti7d26hdwjitfu6ttkp5qhu6ni")
 	assert.NotEmpty(t, err)
-	_, err = mgr.GetCostToSettle("", "", []string{""})
+	// Settlement period not passed
+	settlingAt = 200
+	err = mgr.CollectPaymentChannel(PrivKey, "f2n6prop4c3wmayti7d26hdwjitfu6ttkp5qhu6ni")
 	assert.NotEmpty(t, err)
-	_, err = mgr.GetPaymentChannelCreationBlock("")
+	assert.Equal(t, 0, pushed)
+	// Collectable
+	height = 200
+	err = mgr.CollectPaymentChannel(PrivKey, "f2n6prop4c3wmayti7d26hdwjitfu6ttkp5qhu6ni")
+	assert.Empty(t, err)
+	assert.Equal(t, 1, pushed)
+}
+
+func TestTimeline(t *testing.T) {
+	codeCID, err := cid.Parse("bafkqafdgnfwc6nbpobqxs3lfnz2gg2dbnzxgk3a")
+	assert.Empty(t, err)
+	headCID, err := cid.Parse("bafy2bzaceazcxcw4ggk66uh76z7k2owst7d6xwkmrycz3cvnjf6g5havlo5lw")
+	assert.Empty(t, err)
+	msgCID, err := cid.Parse("baga6ea4seaqesauho7j2thfi4g4u5zbnhn2okd74s2igpvc2lsb7rrsfstoy4by")
+	assert.Empty(t, err)
+	from, err := address.NewFromString("f1hn3o5excejl2uyea7efs3licozuycghzpdiikjy")
+	assert.Empty(t, err)
+	chAddr := "f2n6prop4c3wmayti7d26hdwjitfu6ttkp5qhu6ni"
+	paychAddr, err := address.NewFromString(chAddr)
+	assert.Empty(t, err)
+
+	settlingAt := abi.ChainEpoch(0)
+	minSettleHeight := abi.ChainEpoch(0)
+	// Two messages listed, the first one is not relevant
+	methods := map[cid.Cid]abi.MethodNum{headCID: builtin.MethodSend}
+	mock := mockLotusAPI{
+		chainHead: func(ctx context.Context) (*types.TipSet, error) {
+			return types.NewTipSet([]*types.BlockHeader{{
+				Miner:                 from,
+				Height:                100000,
+				ParentStateRoot:       headCID,
+				ParentMessageReceipts: headCID,
+				Messages:              headCID,
+			}})
+		},
+		stateGetActor: func(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+			return &types.Actor{
+				Code:    codeCID,
+				Head:    headCID,
+				Nonce:   0,
+				Balance: types.NewInt(1000000),
+			}, nil
+		},
+		chainReadObj: func(ctx context.Context, obj cid.Cid) ([]byte, error) {
+			state := paych2.State{
+				From:            from,
+				To:              from,
+				ToSend:          lotusbig.Zero(),
+				SettlingAt:      settlingAt,
+				MinSettleHeight: minSettleHeight,
+				LaneStates:      headCID,
+			}
+			buf := new(bytes.Buffer)
+			err := state.MarshalCBOR(buf)
+			return buf.Bytes(), err
+		},
+		stateAccountKey: func(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+			return from, nil
+		},
+		stateListMessages: func(ctx context.Context, match *lotusapi.MessageMatch, tsk types.TipSetKey, toht abi.ChainEpoch) ([]cid.Cid, error) {
+			assert.Equal(t, abi.ChainEpoch(100000)-msgLookback, toht)
+			if match.To == builtin.InitActorAddr {
+				assert.Equal(t, from, match.From)
+				methods[msgCID] = builtin.MethodsInit.Exec
+			} else {
+				assert.Equal(t, paychAddr, match.To)
+				methods[msgCID] = builtin.MethodsPaych.Settle
+			}
+			return []cid.Cid{headCID, msgCID}, nil
+		},
+		chainGetMessage: func(ctx context.Context, msg cid.Cid) (*types.Message, error) {
+			return &types.Message{Method: methods[msg]}, nil
+		},
+		stateSearchMsgLimited: func(ctx context.Context, msg cid.Cid, limit abi.ChainEpoch) (*lotusapi.MsgLookup, error) {
+			assert.Equal(t, msgCID, msg)
+			return &lotusapi.MsgLookup{
+				Message: msg,
+				Receipt: types.MessageReceipt{
+					ExitCode: 0,
+					Return:   []byte{130, 67, 0, 236, 7, 85, 2, 111, 159, 23, 63, 130, 221, 152, 12, 77, 31, 30, 188, 113, 217, 40, 153, 105, 233, 205, 79},
+				},
+				Height: 50000,
+			}, nil
+		},
+	}
+
+	mgr := NewFCRLotusMgrImplV1(LotusAPIAddr, LotusToken, func(authToken, lotusAPIAddr string) (LotusAPI, jsonrpc.ClientCloser, error) {
+		return &mock, nil, nil
+	})
+
+	created, err := mgr.GetPaymentChannelCreationBlock(chAddr)
+	assert.Empty(t, err)
+	assert.Equal(t, big.NewInt(50000), created)
+	// Created by another message
+	_, err = mgr.GetPaymentChannelCreationBlock("f2kb4izxsxu2jyyslzwmv2sfbrgpld56efedgru5i")
 	assert.NotEmpty(t, err)
-	_, err = mgr.GetPaymentChannelSettlementBlock("")
+
+	// Not settling
+	_, err = mgr.GetPaymentChannelSettlementBlock(chAddr)
 	assert.NotEmpty(t, err)
+	// Settling at is derived from the settlement block
+	settlingAt = 70000
+	settled, err := mgr.GetPaymentChannelSettlementBlock(chAddr)
+	assert.Empty(t, err)
+	assert.Equal(t, big.NewInt(int64(70000-paych2.SettleDelay)), settled)
+	// Settling at is raised to the min settle height
+	minSettleHeight = 70000
+	settled, err = mgr.GetPaymentChannelSettlementBlock(chAddr)
+	assert.Empty(t, err)
+	assert.Equal(t, big.NewInt(50000), settled)
 }
@@ -0,0 +1,78 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DataChunkSize is the maximum size in bytes of the content carried by a single data chunk response.
+const DataChunkSize = 1 << 20
+
+// dataChunkResponseJson represents one chunk of the content following a data retrieval response.
+type dataChunkResponseJson struct {
+	Index uint64 `json:"index"`
+	Data  []byte `json:"data"`
+}
+
+// EncodeDataChunkResponse is used to get the FCRMessage of dataChunkResponseJson.
+func EncodeDataChunkResponse(
+	nonce uint64,
+	index uint64,
+	data []byte,
+) (*FCRACKMsg, error) {
+	if len(data) > DataChunkSize {
+		return nil, fmt.Errorf("Chunk size %v exceeds maximum size %v", len(data), DataChunkSize)
+	}
+	body, err := json.Marshal(dataChunkResponseJson{
+		Index: index,
+		Data:  data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateFCRACKMsg(nonce, body), nil
+}
+
+// DecodeDataChunkResponse is used to get the fields from FCRMessage of dataChunkResponseJson.
+// It returns the nonce, chunk index, chunk data and error.
+func DecodeDataChunkResponse(fcrMsg *FCRACKMsg) (
+	uint64,
+	uint64,
+	[]byte,
+	error,
+) {
+	if !fcrMsg.ACK() {
+		return 0, 0, nil, fmt.Errorf("ACK is false")
+	}
+	msg := dataChunkResponseJson{}
+	err := json.Unmarshal(fcrMsg.Body(), &msg)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(msg.Data) > DataChunkSize {
+		return 0, 0, nil, fmt.Errorf("Chunk size %v exceeds maximum size %v", len(msg.Data), DataChunkSize)
+	}
+	return fcrMsg.Nonce(), msg.Index, msg.Data, nil
+}
+
+// GetChunkCount gets the number of data chunks needed to transfer content of given size.
+func GetChunkCount(size uint64) uint64 {
+	return (size + DataChunkSize - 1) / DataChunkSize
+}
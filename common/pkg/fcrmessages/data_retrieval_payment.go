@@ -0,0 +1,104 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// dataRetrievalPaymentJson represents the payment of the next tranche of chunks in an incremental data retrieval.
+type dataRetrievalPaymentJson struct {
+	Index   uint64 `json:"index"`
+	Voucher string `json:"voucher"`
+}
+
+// EncodeDataRetrievalPayment is used to get the FCRMessage of dataRetrievalPaymentJson.
+// Index is the index of the first chunk paid by this voucher.
+func EncodeDataRetrievalPayment(
+	nonce uint64,
+	index uint64,
+	voucher string,
+) (*FCRReqMsg, error) {
+	body, err := json.Marshal(dataRetrievalPaymentJson{
+		Index:   index,
+		Voucher: voucher,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateFCRReqMsg(DataRetrievalPaymentType, nonce, body), nil
+}
+
+// DecodeDataRetrievalPayment is used to get the fields from FCRMessage of dataRetrievalPaymentJson.
+// It returns the nonce, index of the first chunk paid, voucher and error.
+func DecodeDataRetrievalPayment(fcrMsg *FCRReqMsg) (
+	uint64,
+	uint64,
+	string,
+	error,
+) {
+	if fcrMsg.Type() != DataRetrievalPaymentType {
+		return 0, 0, "", fmt.Errorf("Message type mismatch, expect %v, got %v", DataRetrievalPaymentType, fcrMsg.Type())
+	}
+	msg := dataRetrievalPaymentJson{}
+	err := json.Unmarshal(fcrMsg.Body(), &msg)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	return fcrMsg.Nonce(), msg.Index, msg.Voucher, nil
+}
+
+// GetTranchePrice gets the price of chunks from index "from" (inclusive) to index "to" (exclusive),
+// when the given price is spread over the given number of chunks. All tranches add up to the given price.
+func GetTranchePrice(price *big.Int, chunks uint64, from uint64, to uint64) *big.Int {
+	if chunks == 0 || from >= to {
+		return big.NewInt(0)
+	}
+	if to > chunks {
+		to = chunks
+	}
+	total := new(big.Int).SetUint64(chunks)
+	paidTo := new(big.Int).Div(new(big.Int).Mul(price, new(big.Int).SetUint64(to)), total)
+	paidFrom := new(big.Int).Div(new(big.Int).Mul(price, new(big.Int).SetUint64(from)), total)
+	return paidTo.Sub(paidTo, paidFrom)
+}
+
+// GetRangeTranchePrice gets the price of chunks from index "from" (inclusive) to index "to" (exclusive) of a given data range,
+// when the given price is spread over the bytes of content of given size. All tranches of disjoint ranges add up to at most the given price.
+// A data range exceeding the content has no price.
+func GetRangeTranchePrice(price *big.Int, size uint64, dataRange *DataRange, from uint64, to uint64) *big.Int {
+	if dataRange.Exceeds(size) {
+		return big.NewInt(0)
+	}
+	start := dataRange.Offset + getRangePosition(dataRange.Length, from)
+	stop := dataRange.Offset + getRangePosition(dataRange.Length, to)
+	return GetTranchePrice(price, size, start, stop)
+}
+
+// getRangePosition gets the position of the chunk of given index in a data range of given length, capped at the length.
+func getRangePosition(length uint64, index uint64) uint64 {
+	if index > length/DataChunkSize {
+		return length
+	}
+	if pos := index * DataChunkSize; pos < length {
+		return pos
+	}
+	return length
+}
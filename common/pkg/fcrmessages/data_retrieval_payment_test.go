@@ -0,0 +1,92 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataRetrievalPayment(t *testing.T) {
+	mockNonce := uint64(100)
+	mockIndex := uint64(4)
+	mockVoucher := "mockVoucher"
+
+	msg, err := EncodeDataRetrievalPayment(mockNonce, mockIndex, mockVoucher)
+	assert.Empty(t, err)
+	assert.Equal(t, DataRetrievalPaymentType, msg.messageType)
+	assert.Equal(t, uint64(100), msg.nonce)
+	assert.Equal(t, "7b22696e646578223a342c22766f7563686572223a226d6f636b566f7563686572227d", hex.EncodeToString(msg.messageBody))
+	assert.Equal(t, "", msg.signature)
+
+	resNonce, resIndex, resVoucher, err := DecodeDataRetrievalPayment(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, mockNonce, resNonce)
+	assert.Equal(t, mockIndex, resIndex)
+	assert.Equal(t, mockVoucher, resVoucher)
+
+	msg.messageType = 100
+	_, _, _, err = DecodeDataRetrievalPayment(msg)
+	assert.NotEmpty(t, err)
+	msg.messageType = DataRetrievalPaymentType
+
+	msg.messageBody = []byte{100, 100, 100}
+	_, _, _, err = DecodeDataRetrievalPayment(msg)
+	assert.NotEmpty(t, err)
+}
+
+func TestGetTranchePrice(t *testing.T) {
+	price := big.NewInt(100)
+	assert.Equal(t, big.NewInt(33), GetTranchePrice(price, 3, 0, 1))
+	assert.Equal(t, big.NewInt(33), GetTranchePrice(price, 3, 1, 2))
+	assert.Equal(t, big.NewInt(34), GetTranchePrice(price, 3, 2, 3))
+	assert.Equal(t, big.NewInt(100), GetTranchePrice(price, 3, 0, 10))
+	assert.Equal(t, big.NewInt(0), GetTranchePrice(price, 3, 2, 2))
+	assert.Equal(t, big.NewInt(0), GetTranchePrice(price, 0, 0, 1))
+}
+
+func TestGetRangeTranchePrice(t *testing.T) {
+	price := big.NewInt(100)
+	size := uint64(4 * DataChunkSize)
+	first := &DataRange{Offset: 0, Length: DataChunkSize + 1}
+	second := &DataRange{Offset: DataChunkSize + 1, Length: size - DataChunkSize - 1}
+	assert.Equal(t, big.NewInt(25), GetRangeTranchePrice(price, size, first, 0, 1))
+	assert.Equal(t, "0", GetRangeTranchePrice(price, size, first, 1, 2).String())
+	assert.Equal(t, big.NewInt(25), GetRangeTranchePrice(price, size, first, 0, 10))
+	assert.Equal(t, big.NewInt(75), GetRangeTranchePrice(price, size, second, 0, 10))
+	assert.Equal(t, "0", GetRangeTranchePrice(price, size, second, 3, 4).String())
+	// Tranches of disjoint ranges add up to the price
+	total := big.NewInt(0)
+	for index := uint64(0); index < 2; index++ {
+		total.Add(total, GetRangeTranchePrice(price, size, first, index, index+1))
+	}
+	for index := uint64(0); index < 3; index++ {
+		total.Add(total, GetRangeTranchePrice(price, size, second, index, index+1))
+	}
+	assert.Equal(t, price, total)
+
+	// Ranges and indexes near the maximum do not overflow
+	assert.Equal(t, "0", GetRangeTranchePrice(price, size, &DataRange{Offset: math.MaxUint64, Length: 2}, 0, 1).String())
+	assert.Equal(t, "0", GetRangeTranchePrice(price, size, &DataRange{Offset: 1, Length: math.MaxUint64}, 0, 1).String())
+	assert.Equal(t, "0", GetRangeTranchePrice(price, size, second, math.MaxUint64/2, math.MaxUint64).String())
+	assert.Equal(t, big.NewInt(75), GetRangeTranchePrice(price, size, second, 0, math.MaxUint64))
+}
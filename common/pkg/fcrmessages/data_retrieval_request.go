@@ -32,6 +32,22 @@ type dataRetrievalRequestJson struct {
 	Offer       string `json:"offer"`
 	AccountAddr string `json:"account_addr"`
 	Voucher     string `json:"voucher"`
+	// PaymentInterval is the number of chunks paid by each tranche, 0 means the full price is paid upfront
+	PaymentInterval uint64 `json:"payment_interval"`
+	// Range is the range of the content to retrieve, nil means the full content
+	Range *DataRange `json:"range,omitempty"`
+}
+
+// DataRange represents a range of bytes of the content to retrieve, it must be paid incrementally.
+// A range of zero length retrieves only the response header, with the leaves of the DAG of the content.
+type DataRange struct {
+	Offset uint64 `json:"offset"`
+	Length uint64 `json:"length"`
+}
+
+// Exceeds checks if the data range exceeds content of given size, without overflowing on a range near the maximum offset.
+func (r *DataRange) Exceeds(size uint64) bool {
+	return r.Length > size || r.Offset > size-r.Length
 }
 
 // EncodeDataRetrievalRequest is used to get the FCRMessage of dataRetrievalRequest.
@@ -41,16 +57,20 @@ func EncodeDataRetrievalRequest(
 	offer *cidoffer.SubCIDOffer,
 	accountAddr string,
 	voucher string,
+	paymentInterval uint64,
+	dataRange *DataRange,
 ) (*FCRReqMsg, error) {
 	data, err := offer.ToBytes()
 	if err != nil {
 		return nil, err
 	}
 	body, err := json.Marshal(dataRetrievalRequestJson{
-		SenderID:    senderID,
-		Offer:       hex.EncodeToString(data),
-		AccountAddr: accountAddr,
-		Voucher:     voucher,
+		SenderID:        senderID,
+		Offer:           hex.EncodeToString(data),
+		AccountAddr:     accountAddr,
+		Voucher:         voucher,
+		PaymentInterval: paymentInterval,
+		Range:           dataRange,
 	})
 	if err != nil {
 		return nil, err
@@ -59,31 +79,36 @@ func EncodeDataRetrievalRequest(
 }
 
 // DecodeDataRetrievalRequest is used to get the fields from FCRMessage of dataRetrievalRequest.
-// It returns the nonce, offer, account address and voucher.
+// It returns the nonce, sender id, offer, account address, voucher, payment interval and data range (nil for the full content).
 func DecodeDataRetrievalRequest(fcrMsg *FCRReqMsg) (
 	uint64,
 	string,
 	*cidoffer.SubCIDOffer,
 	string,
 	string,
+	uint64,
+	*DataRange,
 	error,
 ) {
 	if fcrMsg.Type() != DataRetrievalRequestType {
-		return 0, "", nil, "", "", fmt.Errorf("Message type mismatch, expect %v, got %v", DataRetrievalRequestType, fcrMsg.Type())
+		return 0, "", nil, "", "", 0, nil, fmt.Errorf("Message type mismatch, expect %v, got %v", DataRetrievalRequestType, fcrMsg.Type())
 	}
 	msg := dataRetrievalRequestJson{}
 	err := json.Unmarshal(fcrMsg.Body(), &msg)
 	if err != nil {
-		return 0, "", nil, "", "", err
+		return 0, "", nil, "", "", 0, nil, err
 	}
 	data, err := hex.DecodeString(msg.Offer)
 	if err != nil {
-		return 0, "", nil, "", "", err
+		return 0, "", nil, "", "", 0, nil, err
 	}
 	offer := cidoffer.SubCIDOffer{}
 	err = offer.FromBytes(data)
 	if err != nil {
-		return 0, "", nil, "", "", err
+		return 0, "", nil, "", "", 0, nil, err
+	}
+	if msg.Range != nil && msg.PaymentInterval == 0 {
+		return 0, "", nil, "", "", 0, nil, fmt.Errorf("Data range must be paid incrementally")
 	}
-	return fcrMsg.Nonce(), msg.SenderID, &offer, msg.AccountAddr, msg.Voucher, nil
+	return fcrMsg.Nonce(), msg.SenderID, &offer, msg.AccountAddr, msg.Voucher, msg.PaymentInterval, msg.Range, nil
 }
@@ -20,6 +20,7 @@ package fcrmessages
 
 import (
 	"encoding/hex"
+	"math"
 	"math/big"
 	"testing"
 
@@ -42,27 +43,55 @@ func TestDataRetrievalRequest(t *testing.T) {
 	mockAddr := "mockAddr"
 	mockVoucher := "mockVoucher"
 
-	msg, err := EncodeDataRetrievalRequest(mockNonce, mockID, mockSubOffer, mockAddr, mockVoucher)
+	msg, err := EncodeDataRetrievalRequest(mockNonce, mockID, mockSubOffer, mockAddr, mockVoucher, 10, nil)
 	assert.Empty(t, err)
 	assert.Equal(t, DataRetrievalRequestType, msg.messageType)
 	assert.Equal(t, uint64(100), msg.nonce)
-	assert.Equal(t, "7b2273656e6465725f6964223a226d6f636b4944222c226f66666572223a22376232323730373236663736363936343635373235663639363432323361323237343635373337343730373236663736363936343635373232323263323237333735363235663633363936343232336132323531366435383335353236373338373433393761363833323336346136333631353436623337353636653434353837313736333535333438343833323632353433363431363636353666353434363463353337333730333436343462323232633232366436353732366236633635356637323666366637343232336132323338333133343636333536353334333433383636363536323631363133323338333636313636333733313330333533323333363633353339363536333333363133363335333933363335333733313336363233323332333036313331363233373339363336363633333633393330333033323633333333353333363136313333323232633232366436353732366236633635356637303732366636663636323233613232333233323334333133343331333433313334333133343634333433363337333333363339333533323336363233373338333436353334363433363632333533363336333133363335333636333334363533373339333533353336363433333335333436353335333933333332333733343335333433353631333433383335363133353336333633323336363433353631333433353335363133353336333433323334333533363332333433383335363133353333333436343336363133363334333533313335333533353337333333313337333733363335333533373336363333363634333633323335333833353332333433353334363233333330333733303336333133343636333433343333333033363339333533383335333133343331333433313334333133343331333436353336333233343634333533363333333033333634333233323232326332323730373236393633363532323361323233343330323232633232363537383730363937323739323233613334333032633232373136663733323233613331333033313263323237333639363736653631373437353732363532323361323232323764222c226163636f756e745f61646472223a226d6f636b41646472222c22766f7563686572223a226d6f636b566f7563686572227d", hex.EncodeToString(msg.messageBody))
+	assert.Equal(t, "7b2273656e6465725f6964223a226d6f636b4944222c226f66666572223a22376232323730373236663736363936343635373235663639363432323361323237343635373337343730373236663736363936343635373232323263323237333735363235663633363936343232336132323531366435383335353236373338373433393761363833323336346136333631353436623337353636653434353837313736333535333438343833323632353433363431363636353666353434363463353337333730333436343462323232633232366436353732366236633635356637323666366637343232336132323338333133343636333536353334333433383636363536323631363133323338333636313636333733313330333533323333363633353339363536333333363133363335333933363335333733313336363233323332333036313331363233373339363336363633333633393330333033323633333333353333363136313333323232633232366436353732366236633635356637303732366636663636323233613232333233323334333133343331333433313334333133343634333433363337333333363339333533323336363233373338333436353334363433363632333533363336333133363335333636333334363533373339333533353336363433333335333436353335333933333332333733343335333433353631333433383335363133353336333633323336363433353631333433353335363133353336333433323334333533363332333433383335363133353333333436343336363133363334333533313335333533353337333333313337333733363335333533373336363333363634333633323335333833353332333433353334363233333330333733303336333133343636333433343333333033363339333533383335333133343331333433313334333133343331333436353336333233343634333533363333333033333634333233323232326332323730373236393633363532323361323233343330323232633232363537383730363937323739323233613334333032633232373136663733323233613331333033313263323237333639363736653631373437353732363532323361323232323764222c226163636f756e745f61646472223a226d6f636b41646472222c22766f7563686572223a226d6f636b566f7563686572222c227061796d656e745f696e74657276616c223a31307d", hex.EncodeToString(msg.messageBody))
 	assert.Equal(t, "", msg.signature)
 
-	resNonce, resID, resSubOffer, resAddr, resVoucher, err := DecodeDataRetrievalRequest(msg)
+	resNonce, resID, resSubOffer, resAddr, resVoucher, resInterval, resRange, err := DecodeDataRetrievalRequest(msg)
 	assert.Empty(t, err)
 	assert.Equal(t, mockNonce, resNonce)
 	assert.Equal(t, mockID, resID)
 	assert.Equal(t, mockSubOffer.GetMessageDigest(), resSubOffer.GetMessageDigest())
 	assert.Equal(t, mockAddr, resAddr)
 	assert.Equal(t, mockVoucher, resVoucher)
+	assert.Equal(t, uint64(10), resInterval)
+	assert.Empty(t, resRange)
+
+	mockRange := &DataRange{Offset: 10, Length: 20}
+	msg, err = EncodeDataRetrievalRequest(mockNonce, mockID, mockSubOffer, mockAddr, mockVoucher, 1, mockRange)
+	assert.Empty(t, err)
+	_, _, _, _, _, _, resRange, err = DecodeDataRetrievalRequest(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, mockRange, resRange)
+
+	// A data range must be paid incrementally
+	msg, err = EncodeDataRetrievalRequest(mockNonce, mockID, mockSubOffer, mockAddr, mockVoucher, 0, mockRange)
+	assert.Empty(t, err)
+	_, _, _, _, _, _, _, err = DecodeDataRetrievalRequest(msg)
+	assert.NotEmpty(t, err)
 
 	msg.messageType = 100
-	_, _, _, _, _, err = DecodeDataRetrievalRequest(msg)
+	_, _, _, _, _, _, _, err = DecodeDataRetrievalRequest(msg)
 	assert.NotEmpty(t, err)
 	msg.messageType = DataRetrievalRequestType
 
 	msg.messageBody = []byte{100, 100, 100}
-	_, _, _, _, _, err = DecodeDataRetrievalRequest(msg)
+	_, _, _, _, _, _, _, err = DecodeDataRetrievalRequest(msg)
 	assert.NotEmpty(t, err)
 }
+
+func TestDataRangeExceeds(t *testing.T) {
+	assert.False(t, (&DataRange{Offset: 0, Length: 10}).Exceeds(10))
+	assert.False(t, (&DataRange{Offset: 10, Length: 0}).Exceeds(10))
+	assert.False(t, (&DataRange{Offset: 4, Length: 6}).Exceeds(10))
+	assert.True(t, (&DataRange{Offset: 4, Length: 7}).Exceeds(10))
+	assert.True(t, (&DataRange{Offset: 11, Length: 0}).Exceeds(10))
+	assert.True(t, (&DataRange{Offset: 0, Length: 11}).Exceeds(10))
+	// The sum of offset and length overflows
+	assert.True(t, (&DataRange{Offset: math.MaxUint64, Length: 2}).Exceeds(10))
+	assert.True(t, (&DataRange{Offset: 2, Length: math.MaxUint64}).Exceeds(10))
+	assert.False(t, (&DataRange{Offset: 1, Length: math.MaxUint64 - 1}).Exceeds(math.MaxUint64))
+}
@@ -21,23 +21,37 @@ package fcrmessages
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 )
 
-// dataRetrievalResponseJson represents the response to a request of asking for offers.
+// dataRetrievalResponseJson represents the response to a data retrieval request.
+// It is the header of the content, the content itself follows in data chunk responses.
 type dataRetrievalResponseJson struct {
-	Tag  string `json:"tag"`
-	Data []byte `json:"data"`
+	Tag    string `json:"tag"`
+	Size   uint64 `json:"size"`
+	Chunks uint64 `json:"chunks"`
+	// DAG is the parameters used to build the DAG of the content, to recompute its cid
+	DAG cid.DAGParams `json:"dag"`
+	// Leaves are the leaves of the DAG of the content, only sent in response to a data range of zero length
+	Leaves []cid.Leaf `json:"leaves,omitempty"`
 }
 
 // EncodeDataRetrievalResponse is used to get the FCRMessage of dataRetrievalResponseJson.
 func EncodeDataRetrievalResponse(
 	nonce uint64,
 	tag string,
-	data []byte,
+	size uint64,
+	chunks uint64,
+	dag cid.DAGParams,
+	leaves []cid.Leaf,
 ) (*FCRACKMsg, error) {
 	body, err := json.Marshal(dataRetrievalResponseJson{
-		Tag:  tag,
-		Data: data,
+		Tag:    tag,
+		Size:   size,
+		Chunks: chunks,
+		DAG:    dag,
+		Leaves: leaves,
 	})
 	if err != nil {
 		return nil, err
@@ -45,21 +59,44 @@ func EncodeDataRetrievalResponse(
 	return CreateFCRACKMsg(nonce, body), nil
 }
 
-// DecodeDataRetrievalResponse is used to get the fields from FCRMessage of dataRetrievalResponseJson.
-// It returns the nonce, tag, and file data and error.
-func DecodeDataRetrievalResponse(fcrMsg *FCRACKMsg) (
+// DecodeDataRetrievalResponse is used to get the fields from FCRMessage of dataRetrievalResponseJson, in response to a request of given data range.
+// It returns the nonce, tag, file size, number of chunks to follow, DAG parameters of the content, leaves of the DAG and error.
+// The leaves are only returned for a data range of zero length, the chunks to follow cover the data range or the full content if nil.
+func DecodeDataRetrievalResponse(fcrMsg *FCRACKMsg, dataRange *DataRange) (
 	uint64,
 	string,
-	[]byte,
+	uint64,
+	uint64,
+	cid.DAGParams,
+	[]cid.Leaf,
 	error,
 ) {
 	if !fcrMsg.ACK() {
-		return 0, "", nil, fmt.Errorf("ACK is false")
+		return 0, "", 0, 0, cid.DAGParams{}, nil, fmt.Errorf("ACK is false")
 	}
 	msg := dataRetrievalResponseJson{}
 	err := json.Unmarshal(fcrMsg.Body(), &msg)
 	if err != nil {
-		return 0, "", nil, err
+		return 0, "", 0, 0, cid.DAGParams{}, nil, err
+	}
+	if err = msg.DAG.Validate(); err != nil {
+		return 0, "", 0, 0, cid.DAGParams{}, nil, err
+	}
+	length := msg.Size
+	if dataRange != nil {
+		if dataRange.Exceeds(msg.Size) {
+			return 0, "", 0, 0, cid.DAGParams{}, nil, fmt.Errorf("Data range %v+%v exceeds size %v", dataRange.Offset, dataRange.Length, msg.Size)
+		}
+		length = dataRange.Length
+	}
+	if msg.Chunks != GetChunkCount(length) {
+		return 0, "", 0, 0, cid.DAGParams{}, nil, fmt.Errorf("Chunk count mismatch: size %v expects %v chunks got %v", length, GetChunkCount(length), msg.Chunks)
+	}
+	if dataRange == nil || dataRange.Length > 0 {
+		return fcrMsg.Nonce(), msg.Tag, msg.Size, msg.Chunks, msg.DAG, nil, nil
+	}
+	if msg.Size > 0 && len(msg.Leaves) == 0 {
+		return 0, "", 0, 0, cid.DAGParams{}, nil, fmt.Errorf("Missing leaves of content with size %v", msg.Size)
 	}
-	return fcrMsg.Nonce(), msg.Tag, msg.Data, nil
+	return fcrMsg.Nonce(), msg.Tag, msg.Size, msg.Chunks, msg.DAG, msg.Leaves, nil
 }
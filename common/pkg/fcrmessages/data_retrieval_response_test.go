@@ -20,35 +20,77 @@ package fcrmessages
 
 import (
 	"encoding/hex"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 )
 
 func TestDataRetrievalResponse(t *testing.T) {
 	mockNonce := uint64(100)
 	mockTag := "mocktag"
-	mockData := []byte{1, 2, 3}
+	mockSize := uint64(DataChunkSize + 1)
+	mockChunks := uint64(2)
+	mockDAG := cid.DAGParams{ChunkSize: 1024, CIDVersion: 1, RawLeaves: true}
 
-	msg, err := EncodeDataRetrievalResponse(mockNonce, mockTag, mockData)
+	msg, err := EncodeDataRetrievalResponse(mockNonce, mockTag, mockSize, mockChunks, mockDAG, nil)
 	assert.Empty(t, err)
 	assert.Equal(t, true, msg.ack)
 	assert.Equal(t, uint64(100), msg.nonce)
-	assert.Equal(t, "7b22746167223a226d6f636b746167222c2264617461223a2241514944227d", hex.EncodeToString(msg.messageBody))
+	assert.Equal(t, "7b22746167223a226d6f636b746167222c2273697a65223a313034383537372c226368756e6b73223a322c22646167223a7b226368756e6b5f73697a65223a313032342c226369645f76657273696f6e223a312c227261775f6c6561766573223a747275657d7d", hex.EncodeToString(msg.messageBody))
 	assert.Equal(t, "", msg.signature)
 
-	resNonce, resTag, resData, err := DecodeDataRetrievalResponse(msg)
+	resNonce, resTag, resSize, resChunks, resDAG, resLeaves, err := DecodeDataRetrievalResponse(msg, nil)
 	assert.Empty(t, err)
 	assert.Equal(t, mockNonce, resNonce)
 	assert.Equal(t, mockTag, resTag)
-	assert.Equal(t, mockData, resData)
+	assert.Equal(t, mockSize, resSize)
+	assert.Equal(t, mockChunks, resChunks)
+	assert.Equal(t, mockDAG, resDAG)
+	assert.Empty(t, resLeaves)
 
 	msg.ack = false
-	_, _, _, err = DecodeDataRetrievalResponse(msg)
+	_, _, _, _, _, _, err = DecodeDataRetrievalResponse(msg, nil)
 	assert.NotEmpty(t, err)
 	msg.ack = true
 
+	msg, err = EncodeDataRetrievalResponse(mockNonce, mockTag, mockSize, 1, mockDAG, nil)
+	assert.Empty(t, err)
+	_, _, _, _, _, _, err = DecodeDataRetrievalResponse(msg, nil)
+	assert.NotEmpty(t, err)
+
+	msg, err = EncodeDataRetrievalResponse(mockNonce, mockTag, mockSize, mockChunks, cid.DAGParams{}, nil)
+	assert.Empty(t, err)
+	_, _, _, _, _, _, err = DecodeDataRetrievalResponse(msg, nil)
+	assert.NotEmpty(t, err)
+
+	// A data range of zero length is answered with the leaves only
+	mockLeaves := []cid.Leaf{{CID: "QmX5Rg8t9zh26JcaTk7VnDXqv5SHH2bT6AfeoTFLSsp4dK", BlockSize: 1034, DataSize: 1024}}
+	msg, err = EncodeDataRetrievalResponse(mockNonce, mockTag, mockSize, 0, mockDAG, mockLeaves)
+	assert.Empty(t, err)
+	_, _, _, resChunks, _, resLeaves, err = DecodeDataRetrievalResponse(msg, &DataRange{Offset: 0, Length: 0})
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(0), resChunks)
+	assert.Equal(t, mockLeaves, resLeaves)
+	msg, err = EncodeDataRetrievalResponse(mockNonce, mockTag, mockSize, 0, mockDAG, nil)
+	assert.Empty(t, err)
+	_, _, _, _, _, _, err = DecodeDataRetrievalResponse(msg, &DataRange{Offset: 0, Length: 0})
+	assert.NotEmpty(t, err)
+
+	// Chunks cover the data range
+	msg, err = EncodeDataRetrievalResponse(mockNonce, mockTag, mockSize, 1, mockDAG, nil)
+	assert.Empty(t, err)
+	_, _, _, resChunks, _, _, err = DecodeDataRetrievalResponse(msg, &DataRange{Offset: DataChunkSize, Length: 1})
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(1), resChunks)
+	_, _, _, _, _, _, err = DecodeDataRetrievalResponse(msg, &DataRange{Offset: DataChunkSize, Length: 2})
+	assert.NotEmpty(t, err)
+	// The sum of offset and length overflows
+	_, _, _, _, _, _, err = DecodeDataRetrievalResponse(msg, &DataRange{Offset: math.MaxUint64, Length: 2})
+	assert.NotEmpty(t, err)
+
 	msg.messageBody = []byte{100, 100, 100}
-	_, _, _, err = DecodeDataRetrievalResponse(msg)
+	_, _, _, _, _, _, err = DecodeDataRetrievalResponse(msg, nil)
 	assert.NotEmpty(t, err)
 }
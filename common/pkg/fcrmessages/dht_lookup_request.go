@@ -0,0 +1,87 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+)
+
+// dhtLookupRequestJson represents the request to ask for offers and the gateways closer to a cid in one hop of an iterative DHT lookup.
+type dhtLookupRequestJson struct {
+	NodeID            string `json:"node_id"`
+	PieceCID          string `json:"piece_cid"`
+	MaxOfferRequested uint32 `json:"max_offer_requested"`
+	NumCloser         uint32 `json:"num_closer"`
+	AccountAddr       string `json:"account_addr"`
+	Voucher           string `json:"voucher"`
+}
+
+// EncodeDHTLookupRequest is used to get the FCRMessage of dhtLookupRequestJson.
+func EncodeDHTLookupRequest(
+	nonce uint64,
+	NodeID string,
+	pieceCID *cid.ContentID,
+	maxOfferRequested uint32,
+	numCloser uint32,
+	accountAddr string,
+	voucher string,
+) (*FCRReqMsg, error) {
+	body, err := json.Marshal(dhtLookupRequestJson{
+		NodeID:            NodeID,
+		PieceCID:          pieceCID.ToString(),
+		MaxOfferRequested: maxOfferRequested,
+		NumCloser:         numCloser,
+		AccountAddr:       accountAddr,
+		Voucher:           voucher,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateFCRReqMsg(DHTLookupRequestType, nonce, body), nil
+}
+
+// DecodeDHTLookupRequest is used to get the fields from FCRMessage of dhtLookupRequestJson.
+// It returns the nonce, nodeID, pieceCID, maxOfferRequested, numCloser, account address and voucher.
+func DecodeDHTLookupRequest(fcrMsg *FCRReqMsg) (
+	uint64,
+	string,
+	*cid.ContentID,
+	uint32,
+	uint32,
+	string,
+	string,
+	error,
+) {
+	if fcrMsg.Type() != DHTLookupRequestType {
+		return 0, "", nil, 0, 0, "", "", fmt.Errorf("Message type mismatch, expect %v, got %v", DHTLookupRequestType, fcrMsg.Type())
+	}
+	msg := dhtLookupRequestJson{}
+	err := json.Unmarshal(fcrMsg.Body(), &msg)
+	if err != nil {
+		return 0, "", nil, 0, 0, "", "", err
+	}
+	pieceCID, err := cid.NewContentID(msg.PieceCID)
+	if err != nil {
+		return 0, "", nil, 0, 0, "", "", err
+	}
+	return fcrMsg.Nonce(), msg.NodeID, pieceCID, msg.MaxOfferRequested, msg.NumCloser, msg.AccountAddr, msg.Voucher, nil
+}
@@ -0,0 +1,62 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+)
+
+func TestDHTLookupRequest(t *testing.T) {
+	mockNonce := uint64(100)
+	mockNodeID := "mockID"
+	mockCID, err := cid.NewContentID("QmX5Rg8t9zh26JcaTk7VnDXqv5SHH2bT6AfeoTFLSsp4dK")
+	assert.Empty(t, err)
+	mockMaxOfferRequested := uint32(10)
+	mockNumCloser := uint32(3)
+	mockAccountAddr := "mockAddr"
+	mockVoucher := "mockVoucher"
+
+	msg, err := EncodeDHTLookupRequest(mockNonce, mockNodeID, mockCID, mockMaxOfferRequested, mockNumCloser, mockAccountAddr, mockVoucher)
+	assert.Empty(t, err)
+	assert.Equal(t, byte(DHTLookupRequestType), msg.messageType)
+	assert.Equal(t, uint64(100), msg.nonce)
+	assert.Equal(t, "", msg.signature)
+
+	resNonce, resNodeID, resCID, resMaxOfferRequested, resNumCloser, resAcountAddr, resVoucher, err := DecodeDHTLookupRequest(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, mockNonce, resNonce)
+	assert.Equal(t, mockNodeID, resNodeID)
+	assert.Equal(t, mockCID.ToString(), resCID.ToString())
+	assert.Equal(t, mockMaxOfferRequested, resMaxOfferRequested)
+	assert.Equal(t, mockNumCloser, resNumCloser)
+	assert.Equal(t, mockAccountAddr, resAcountAddr)
+	assert.Equal(t, mockVoucher, resVoucher)
+
+	msg.messageType = 100
+	_, _, _, _, _, _, _, err = DecodeDHTLookupRequest(msg)
+	assert.NotEmpty(t, err)
+	msg.messageType = DHTLookupRequestType
+
+	msg.messageBody = []byte{100, 100, 100}
+	_, _, _, _, _, _, _, err = DecodeDHTLookupRequest(msg)
+	assert.NotEmpty(t, err)
+}
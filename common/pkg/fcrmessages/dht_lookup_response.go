@@ -0,0 +1,100 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+)
+
+// dhtLookupResponseJson represents the response to a request of one hop of an iterative DHT lookup.
+type dhtLookupResponseJson struct {
+	Offers        []string `json:"offers"`
+	Closer        []string `json:"closer"`
+	RefundVoucher string   `json:"refund_voucher"`
+}
+
+// EncodeDHTLookupResponse is used to get the FCRMessage of dhtLookupResponseJson.
+func EncodeDHTLookupResponse(
+	nonce uint64,
+	offers []cidoffer.SubCIDOffer,
+	closer []string,
+	refundVoucher string,
+) (*FCRACKMsg, error) {
+	offersStr := make([]string, 0)
+	for _, offer := range offers {
+		data, err := offer.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		offersStr = append(offersStr, hex.EncodeToString(data))
+	}
+	if closer == nil {
+		closer = make([]string, 0)
+	}
+	body, err := json.Marshal(dhtLookupResponseJson{
+		Offers:        offersStr,
+		Closer:        closer,
+		RefundVoucher: refundVoucher,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateFCRACKMsg(nonce, body), nil
+}
+
+// DecodeDHTLookupResponse is used to get the fields from FCRMessage of dhtLookupResponseJson.
+// It returns nonce, a list of offers, a list of IDs of gateways closer to the cid and refund voucher.
+func DecodeDHTLookupResponse(fcrMsg *FCRACKMsg) (
+	uint64,
+	[]cidoffer.SubCIDOffer,
+	[]string,
+	string,
+	error,
+) {
+	if !fcrMsg.ACK() {
+		return 0, nil, nil, "", fmt.Errorf("ACK is false")
+	}
+	msg := dhtLookupResponseJson{}
+	err := json.Unmarshal(fcrMsg.Body(), &msg)
+	if err != nil {
+		return 0, nil, nil, "", err
+	}
+	offers := make([]cidoffer.SubCIDOffer, 0)
+	for _, offerStr := range msg.Offers {
+		data, err := hex.DecodeString(offerStr)
+		if err != nil {
+			return 0, nil, nil, "", err
+		}
+		offer := cidoffer.SubCIDOffer{}
+		err = offer.FromBytes(data)
+		if err != nil {
+			return 0, nil, nil, "", err
+		}
+		offers = append(offers, offer)
+	}
+	closer := msg.Closer
+	if closer == nil {
+		closer = make([]string, 0)
+	}
+	return fcrMsg.Nonce(), offers, closer, msg.RefundVoucher, nil
+}
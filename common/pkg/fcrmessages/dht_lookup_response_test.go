@@ -0,0 +1,71 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+)
+
+func TestDHTLookupResponse(t *testing.T) {
+	mockNonce := uint64(100)
+	mockCID1, err := cid.NewContentID("QmX5Rg8t9zh26JcaTk7VnDXqv5SHH2bT6AfeoTFLSsp4dK")
+	assert.Empty(t, err)
+	mockCID2, err := cid.NewContentID("baga6ea4seaqesauho7j2thfi4g4u5zbnhn2okd74s2igpvc2lsb7rrsfstoy4by")
+	assert.Empty(t, err)
+	mockOffer, err := cidoffer.NewCIDOffer("testprovider", []cid.ContentID{*mockCID1, *mockCID2}, big.NewInt(40), 40, 101)
+	assert.Empty(t, err)
+	mockSubOffer, err := mockOffer.GenerateSubCIDOffer(mockCID1)
+	assert.Empty(t, err)
+	mockCloser := []string{"gw1", "gw2"}
+	mockVoucher := "mockVoucher"
+
+	msg, err := EncodeDHTLookupResponse(mockNonce, []cidoffer.SubCIDOffer{*mockSubOffer}, mockCloser, mockVoucher)
+	assert.Empty(t, err)
+	assert.Equal(t, true, msg.ack)
+	assert.Equal(t, "", msg.signature)
+
+	resNonce, resOffers, resCloser, resVoucher, err := DecodeDHTLookupResponse(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, mockNonce, resNonce)
+	assert.Equal(t, 1, len(resOffers))
+	assert.Equal(t, mockSubOffer.GetMessageDigest(), resOffers[0].GetMessageDigest())
+	assert.Equal(t, mockCloser, resCloser)
+	assert.Equal(t, mockVoucher, resVoucher)
+
+	msg, err = EncodeDHTLookupResponse(mockNonce, nil, nil, "")
+	assert.Empty(t, err)
+	_, resOffers, resCloser, _, err = DecodeDHTLookupResponse(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, 0, len(resOffers))
+	assert.Equal(t, 0, len(resCloser))
+
+	msg.ack = false
+	_, _, _, _, err = DecodeDHTLookupResponse(msg)
+	assert.NotEmpty(t, err)
+	msg.ack = true
+
+	msg.messageBody = []byte{100, 100, 100}
+	_, _, _, _, err = DecodeDHTLookupResponse(msg)
+	assert.NotEmpty(t, err)
+}
@@ -31,17 +31,20 @@ type dhtOfferDiscoveryRequestJson struct {
 	PieceCID                string `json:"piece_cid"`
 	NumDHT                  uint32 `json:"num_dht"`
 	MaxOfferRequestedPerDHT uint32 `json:"max_offer_requested_per_dht"`
+	MaxOfferRequested       uint32 `json:"max_offer_requested"`
 	AccountAddr             string `json:"account_addr"`
 	Voucher                 string `json:"voucher"`
 }
 
 // EncodeDHTOfferDiscoveryRequest is used to get the FCRMessage of dhtOfferDiscoveryRequestJson
+// maxOfferRequested is the maximum number of offers requested from all the DHT gateways in total.
 func EncodeDHTOfferDiscoveryRequest(
 	nonce uint64,
 	NodeID string,
 	pieceCID *cid.ContentID,
 	numDHT uint32,
 	maxOfferRequestedPerDHT uint32,
+	maxOfferRequested uint32,
 	accountAddr string,
 	voucher string,
 ) (*FCRReqMsg, error) {
@@ -50,6 +53,7 @@ func EncodeDHTOfferDiscoveryRequest(
 		PieceCID:                pieceCID.ToString(),
 		NumDHT:                  numDHT,
 		MaxOfferRequestedPerDHT: maxOfferRequestedPerDHT,
+		MaxOfferRequested:       maxOfferRequested,
 		AccountAddr:             accountAddr,
 		Voucher:                 voucher,
 	})
@@ -60,28 +64,29 @@ func EncodeDHTOfferDiscoveryRequest(
 }
 
 // DecodeDHTOfferDiscoveryRequest is used to get the fields from FCRMessage of dhtOfferDiscoveryRequestJson
-// It returns the nonce, nodeID, pieceCID, numDHT, maxOfferRequestedPerDHT, account address and voucher.
+// It returns the nonce, nodeID, pieceCID, numDHT, maxOfferRequestedPerDHT, maxOfferRequested, account address and voucher.
 func DecodeDHTOfferDiscoveryRequest(fcrMsg *FCRReqMsg) (
 	uint64,
 	string,
 	*cid.ContentID,
 	uint32,
 	uint32,
+	uint32,
 	string,
 	string,
 	error,
 ) {
 	if fcrMsg.Type() != DHTOfferDiscoveryRequestType {
-		return 0, "", nil, 0, 0, "", "", fmt.Errorf("Message type mismatch, expect %v, got %v", DHTOfferDiscoveryRequestType, fcrMsg.Type())
+		return 0, "", nil, 0, 0, 0, "", "", fmt.Errorf("Message type mismatch, expect %v, got %v", DHTOfferDiscoveryRequestType, fcrMsg.Type())
 	}
 	msg := dhtOfferDiscoveryRequestJson{}
 	err := json.Unmarshal(fcrMsg.Body(), &msg)
 	if err != nil {
-		return 0, "", nil, 0, 0, "", "", err
+		return 0, "", nil, 0, 0, 0, "", "", err
 	}
 	pieceCID, err := cid.NewContentID(msg.PieceCID)
 	if err != nil {
-		return 0, "", nil, 0, 0, "", "", err
+		return 0, "", nil, 0, 0, 0, "", "", err
 	}
-	return fcrMsg.Nonce(), msg.NodeID, pieceCID, msg.NumDHT, msg.MaxOfferRequestedPerDHT, msg.AccountAddr, msg.Voucher, nil
+	return fcrMsg.Nonce(), msg.NodeID, pieceCID, msg.NumDHT, msg.MaxOfferRequestedPerDHT, msg.MaxOfferRequested, msg.AccountAddr, msg.Voucher, nil
 }
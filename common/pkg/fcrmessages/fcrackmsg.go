@@ -22,25 +22,36 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	"time"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 )
 
 // FCRACKMsg is the response used in communication between filecoin retrieval entities.
 type FCRACKMsg struct {
-	ack         bool
-	nonce       uint64
-	messageBody []byte
-	signature   string
+	ack            bool
+	nonce          uint64
+	code           uint32
+	expectedAmount *big.Int
+	refundVoucher  string
+	retryAfter     int64
+	messageBody    []byte
+	signature      string
 }
 
 // fcrACKMsgJson is used to parse to and from json.
 type fcrACKMsgJson struct {
-	ACK         bool   `json:"ack"`
-	Nonce       uint64 `json:"uint64"`
-	MessageBody string `json:"message_body"`
-	Signature   string `json:"message_signature"`
+	ACK            bool   `json:"ack"`
+	Nonce          uint64 `json:"uint64"`
+	Code           uint32 `json:"code,omitempty"`
+	ExpectedAmount string `json:"expected_amount,omitempty"`
+	RefundVoucher  string `json:"refund_voucher,omitempty"`
+	RetryAfter     int64  `json:"retry_after,omitempty"`
+	MessageBody    string `json:"message_body"`
+	Signature      string `json:"message_signature"`
 }
 
 // CreateFCRACKMsg is used to create an unsigned ack message
@@ -54,13 +65,24 @@ func CreateFCRACKMsg(nonce uint64, msgBody []byte) *FCRACKMsg {
 }
 
 // CreateFCRACKErrorMsg is used to create an unsigned error message
+// If the error is or wraps a FCRError, the message carries its code and fields.
 func CreateFCRACKErrorMsg(nonce uint64, err error) *FCRACKMsg {
-	return &FCRACKMsg{
+	msg := &FCRACKMsg{
 		ack:         false,
 		nonce:       nonce,
 		messageBody: []byte(err.Error()),
 		signature:   "",
 	}
+	var fcrErr *FCRError
+	if errors.As(err, &fcrErr) {
+		msg.code = fcrErr.Code
+		if fcrErr.ExpectedAmount != nil {
+			msg.expectedAmount = big.NewInt(0).Set(fcrErr.ExpectedAmount)
+		}
+		msg.refundVoucher = fcrErr.RefundVoucher
+		msg.retryAfter = int64(fcrErr.RetryAfter / time.Second)
+	}
+	return msg
 }
 
 // Type is used to get the message type of the message.
@@ -78,11 +100,33 @@ func (fcrMsg *FCRACKMsg) Body() []byte {
 	return fcrMsg.messageBody
 }
 
+// Code is used to get the error code, ErrorCodeUnspecified for an ack message.
+func (fcrMsg *FCRACKMsg) Code() uint32 {
+	return fcrMsg.code
+}
+
 // Error is used to get the error.
 func (fcrMsg *FCRACKMsg) Error() string {
 	return string(fcrMsg.Body())
 }
 
+// ErrorDetails is used to get the error with its code and fields, nil for an ack message.
+func (fcrMsg *FCRACKMsg) ErrorDetails() *FCRError {
+	if fcrMsg.ack {
+		return nil
+	}
+	res := &FCRError{
+		Code:          fcrMsg.code,
+		Message:       fcrMsg.Error(),
+		RefundVoucher: fcrMsg.refundVoucher,
+		RetryAfter:    time.Duration(fcrMsg.retryAfter) * time.Second,
+	}
+	if fcrMsg.expectedAmount != nil {
+		res.ExpectedAmount = big.NewInt(0).Set(fcrMsg.expectedAmount)
+	}
+	return res
+}
+
 // Signature is used to get the signature.
 func (fcrMsg *FCRACKMsg) Signature() string {
 	return fcrMsg.signature
@@ -90,15 +134,7 @@ func (fcrMsg *FCRACKMsg) Signature() string {
 
 // Sign is used to sign the message with a given private key and a key version.
 func (fcrMsg *FCRACKMsg) Sign(privKey string, keyVer byte) error {
-	nonce := make([]byte, 8)
-	binary.BigEndian.PutUint64(nonce, fcrMsg.nonce)
-	var data []byte
-	if fcrMsg.ack {
-		data = append([]byte{0}, nonce...)
-	} else {
-		data = append([]byte{1}, nonce...)
-	}
-	data = append(data, fcrMsg.messageBody...)
+	data := fcrMsg.signingData()
 	sig, err := fcrcrypto.Sign(privKey, keyVer, data)
 	if err != nil {
 		return err
@@ -109,29 +145,57 @@ func (fcrMsg *FCRACKMsg) Sign(privKey string, keyVer byte) error {
 
 // Verify is used to verify the offer with a given public key.
 func (fcrMsg *FCRACKMsg) Verify(pubKey string, keyVer byte) error {
+	data := fcrMsg.signingData()
+	err := fcrcrypto.Verify(pubKey, keyVer, fcrMsg.signature, data)
+	if err != nil {
+		return fmt.Errorf("Message fail to verify: %v", err.Error())
+	}
+	return nil
+}
+
+// signingData gets the data to sign, it covers the ack, the nonce, the error code and fields if any and the message body.
+func (fcrMsg *FCRACKMsg) signingData() []byte {
 	nonce := make([]byte, 8)
 	binary.BigEndian.PutUint64(nonce, fcrMsg.nonce)
 	var data []byte
 	if fcrMsg.ack {
 		data = append([]byte{0}, nonce...)
-	} else {
+	} else if fcrMsg.code == ErrorCodeUnspecified && fcrMsg.expectedAmount == nil && fcrMsg.refundVoucher == "" && fcrMsg.retryAfter == 0 {
 		data = append([]byte{1}, nonce...)
+	} else {
+		fields := make([]byte, 12)
+		binary.BigEndian.PutUint32(fields, fcrMsg.code)
+		binary.BigEndian.PutUint64(fields[4:], uint64(fcrMsg.retryAfter))
+		data = append([]byte{2}, nonce...)
+		data = append(data, fields...)
+		expectedAmount := ""
+		if fcrMsg.expectedAmount != nil {
+			expectedAmount = fcrMsg.expectedAmount.String()
+		}
+		// Variable length fields are prefixed with their lengths
+		for _, field := range []string{expectedAmount, fcrMsg.refundVoucher} {
+			length := make([]byte, 4)
+			binary.BigEndian.PutUint32(length, uint32(len(field)))
+			data = append(data, length...)
+			data = append(data, []byte(field)...)
+		}
 	}
-	data = append(data, fcrMsg.messageBody...)
-	err := fcrcrypto.Verify(pubKey, keyVer, fcrMsg.signature, data)
-	if err != nil {
-		return fmt.Errorf("Message fail to verify: %v", err.Error())
-	}
-	return nil
+	return append(data, fcrMsg.messageBody...)
 }
 
 // FCRMsgToBytes converts a FCRMessage to bytes
 func (fcrMsg *FCRACKMsg) ToBytes() ([]byte, error) {
 	fcrMsgJS := &fcrACKMsgJson{
-		ACK:         fcrMsg.ack,
-		Nonce:       fcrMsg.nonce,
-		MessageBody: hex.EncodeToString(fcrMsg.messageBody),
-		Signature:   fcrMsg.signature,
+		ACK:           fcrMsg.ack,
+		Nonce:         fcrMsg.nonce,
+		Code:          fcrMsg.code,
+		RefundVoucher: fcrMsg.refundVoucher,
+		RetryAfter:    fcrMsg.retryAfter,
+		MessageBody:   hex.EncodeToString(fcrMsg.messageBody),
+		Signature:     fcrMsg.signature,
+	}
+	if fcrMsg.expectedAmount != nil {
+		fcrMsgJS.ExpectedAmount = fcrMsg.expectedAmount.String()
 	}
 	return json.Marshal(fcrMsgJS)
 }
@@ -147,8 +211,20 @@ func (fcrMsg *FCRACKMsg) FromBytes(data []byte) error {
 	if err != nil {
 		return err
 	}
+	var expectedAmount *big.Int
+	if res.ExpectedAmount != "" {
+		var ok bool
+		expectedAmount, ok = big.NewInt(0).SetString(res.ExpectedAmount, 10)
+		if !ok {
+			return fmt.Errorf("Invalid expected amount %v", res.ExpectedAmount)
+		}
+	}
 	fcrMsg.ack = res.ACK
 	fcrMsg.nonce = res.Nonce
+	fcrMsg.code = res.Code
+	fcrMsg.expectedAmount = expectedAmount
+	fcrMsg.refundVoucher = res.RefundVoucher
+	fcrMsg.retryAfter = res.RetryAfter
 	fcrMsg.messageBody = msgBody
 	fcrMsg.signature = res.Signature
 	return nil
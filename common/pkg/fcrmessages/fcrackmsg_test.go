@@ -20,7 +20,10 @@ package fcrmessages
 
 import (
 	"errors"
+	"fmt"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -38,7 +41,30 @@ func TestACKGetter(t *testing.T) {
 	assert.Equal(t, false, msg.ACK())
 	assert.Equal(t, uint64(100), msg.Nonce())
 	assert.Equal(t, "Test error", msg.Error())
+	assert.Equal(t, ErrorCodeUnspecified, msg.Code())
 	assert.Equal(t, "testsignature2", msg.Signature())
+
+	assert.Equal(t, &FCRError{Code: ErrorCodeUnspecified, Message: "Test error"}, msg.ErrorDetails())
+
+	fcrErr := &FCRError{
+		Code:           ErrorCodeShortPayment,
+		Message:        "Test error",
+		ExpectedAmount: big.NewInt(10),
+		RefundVoucher:  "testvoucher",
+		RetryAfter:     time.Minute,
+	}
+	msg = CreateFCRACKErrorMsg(100, fmt.Errorf("Wrapped: %w", fcrErr))
+	assert.Equal(t, false, msg.ACK())
+	assert.Equal(t, ErrorCodeShortPayment, msg.Code())
+	assert.Equal(t, "Wrapped: Test error", msg.Error())
+	details := msg.ErrorDetails()
+	assert.Equal(t, ErrorCodeShortPayment, details.Code)
+	assert.Equal(t, "Wrapped: Test error", details.Message)
+	assert.Equal(t, big.NewInt(10), details.ExpectedAmount)
+	assert.Equal(t, "testvoucher", details.RefundVoucher)
+	assert.Equal(t, time.Minute, details.RetryAfter)
+
+	assert.Empty(t, CreateFCRACKMsg(100, []byte{1, 2, 3, 4}).ErrorDetails())
 }
 
 func TestACKParse(t *testing.T) {
@@ -54,6 +80,23 @@ func TestACKParse(t *testing.T) {
 	assert.Equal(t, []byte{1, 2, 3, 4}, msg2.Body())
 	assert.Equal(t, "testsignature", msg2.Signature())
 
+	msg = CreateFCRACKErrorMsg(100, &FCRError{
+		Code:           ErrorCodeShortPayment,
+		Message:        "Test error",
+		ExpectedAmount: big.NewInt(10),
+		RefundVoucher:  "testvoucher",
+		RetryAfter:     time.Minute,
+	})
+	data, err = msg.ToBytes()
+	assert.Empty(t, err)
+	msg2 = FCRACKMsg{}
+	err = msg2.FromBytes(data)
+	assert.Empty(t, err)
+	assert.Equal(t, false, msg2.ACK())
+	assert.Equal(t, ErrorCodeShortPayment, msg2.Code())
+	assert.Equal(t, "Test error", msg2.Error())
+	assert.Equal(t, msg.ErrorDetails(), msg2.ErrorDetails())
+
 	err = msg2.FromBytes([]byte{111, 111, 111})
 	assert.NotEmpty(t, err)
 }
@@ -73,3 +116,37 @@ func TestACKSigning(t *testing.T) {
 	err = msg.Verify(PubKey, 0)
 	assert.Empty(t, err)
 }
+
+func TestACKSigningWithCode(t *testing.T) {
+	msg := CreateFCRACKErrorMsg(100, &FCRError{
+		Code:           ErrorCodeShortPayment,
+		Message:        "Test error",
+		ExpectedAmount: big.NewInt(10),
+		RefundVoucher:  "testvoucher",
+		RetryAfter:     time.Minute,
+	})
+	err := msg.Sign(PrivKey, 0)
+	assert.Empty(t, err)
+	err = msg.Verify(PubKey, 0)
+	assert.Empty(t, err)
+
+	// The code and fields are signed
+	msg.code = ErrorCodeUnspecified
+	err = msg.Verify(PubKey, 0)
+	assert.NotEmpty(t, err)
+	msg.code = ErrorCodeShortPayment
+	msg.expectedAmount = big.NewInt(11)
+	err = msg.Verify(PubKey, 0)
+	assert.NotEmpty(t, err)
+	msg.expectedAmount = big.NewInt(10)
+	msg.refundVoucher = ""
+	err = msg.Verify(PubKey, 0)
+	assert.NotEmpty(t, err)
+	msg.refundVoucher = "testvoucher"
+	msg.retryAfter = 0
+	err = msg.Verify(PubKey, 0)
+	assert.NotEmpty(t, err)
+	msg.retryAfter = 60
+	err = msg.Verify(PubKey, 0)
+	assert.Empty(t, err)
+}
@@ -0,0 +1,77 @@
+/*
+Package fcrmessages - stores all the messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"time"
+)
+
+// Error codes carried by an error message.
+const (
+	// ErrorCodeUnspecified is the code of an error without a specific code.
+	ErrorCodeUnspecified = uint32(0)
+	// ErrorCodeReplayedRequest is the code of a request rejected by the server as stale or replayed.
+	// The error message is sent by the server before any handler, unsigned.
+	ErrorCodeReplayedRequest = uint32(1)
+	// ErrorCodeInvalidRequest is the code of a request that fails to decode or has invalid parameters.
+	ErrorCodeInvalidRequest = uint32(2)
+	// ErrorCodeUnauthorised is the code of a request from an unknown sender or failing to verify.
+	ErrorCodeUnauthorised = uint32(3)
+	// ErrorCodeBlocked is the code of a request from a blocked sender.
+	ErrorCodeBlocked = uint32(4)
+	// ErrorCodeInvalidPayment is the code of a request with a voucher that fails to be received.
+	ErrorCodeInvalidPayment = uint32(5)
+	// ErrorCodeShortPayment is the code of a request paying less than the expected amount.
+	ErrorCodeShortPayment = uint32(6)
+	// ErrorCodeInvalidOffer is the code of a request with an offer that fails to verify.
+	ErrorCodeInvalidOffer = uint32(7)
+	// ErrorCodeOfferExpired is the code of a request with an expired offer.
+	ErrorCodeOfferExpired = uint32(8)
+	// ErrorCodeContentUnavailable is the code of a request for content no longer available.
+	ErrorCodeContentUnavailable = uint32(9)
+	// ErrorCodeInternal is the code of an internal error of the responder.
+	ErrorCodeInternal = uint32(10)
+)
+
+// DefaultRetryAfter is the duration suggested to wait before retrying a request failed by an internal error.
+const DefaultRetryAfter = 30 * time.Second
+
+// FCRError is an error with a code and machine-readable fields, it is carried by an error message.
+type FCRError struct {
+	// Code is the error code.
+	Code uint32
+
+	// Message is the human-readable error.
+	Message string
+
+	// ExpectedAmount is the amount expected to be paid, nil if not applicable.
+	ExpectedAmount *big.Int
+
+	// RefundVoucher is the voucher refunding the payment, empty if nothing is refunded.
+	RefundVoucher string
+
+	// RetryAfter is the duration to wait before retrying, zero if a retry is not expected to succeed.
+	RetryAfter time.Duration
+}
+
+// Error is used to get the human-readable error.
+func (e *FCRError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,36 @@
+/*
+Package fcrmessages - stores all the messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFCRError(t *testing.T) {
+	err := fmt.Errorf("Wrapped: %w", &FCRError{Code: ErrorCodeInternal, Message: "Test error", RetryAfter: DefaultRetryAfter})
+	assert.Equal(t, "Wrapped: Test error", err.Error())
+	var fcrErr *FCRError
+	assert.True(t, errors.As(err, &fcrErr))
+	assert.Equal(t, ErrorCodeInternal, fcrErr.Code)
+	assert.Equal(t, DefaultRetryAfter, fcrErr.RetryAfter)
+}
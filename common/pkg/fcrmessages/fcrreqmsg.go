@@ -24,6 +24,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 )
@@ -32,6 +33,7 @@ import (
 type FCRReqMsg struct {
 	messageType byte
 	nonce       uint64
+	timestamp   int64
 	messageBody []byte
 	signature   string
 }
@@ -40,16 +42,18 @@ type FCRReqMsg struct {
 type fcrReqMsgJson struct {
 	MessageType string `json:"message_type"`
 	Nonce       uint64 `json:"nonce"`
+	Timestamp   int64  `json:"timestamp"`
 	MessageBody string `json:"message_body"`
 	Signature   string `json:"message_signature"`
 }
 
-// CreateFCRReqMsg is used to create an unsigned message
+// CreateFCRReqMsg is used to create an unsigned message, timestamped with the current time
 func CreateFCRReqMsg(msgType byte, nonce uint64, msgBody []byte) *FCRReqMsg {
 	return &FCRReqMsg{
 		messageType: msgType,
 		messageBody: msgBody,
 		nonce:       nonce,
+		timestamp:   time.Now().Unix(),
 		signature:   "",
 	}
 }
@@ -64,6 +68,11 @@ func (fcrMsg *FCRReqMsg) Nonce() uint64 {
 	return fcrMsg.nonce
 }
 
+// Timestamp is used to get the time (in unix seconds) the message is created.
+func (fcrMsg *FCRReqMsg) Timestamp() int64 {
+	return fcrMsg.timestamp
+}
+
 // Body is used to get the message body.
 func (fcrMsg *FCRReqMsg) Body() []byte {
 	return fcrMsg.messageBody
@@ -76,10 +85,7 @@ func (fcrMsg *FCRReqMsg) Signature() string {
 
 // Sign is used to sign the message with a given private key and a key version.
 func (fcrMsg *FCRReqMsg) Sign(privKey string, keyVer byte) error {
-	nonce := make([]byte, 8)
-	binary.BigEndian.PutUint64(nonce, fcrMsg.nonce)
-	data := append([]byte{fcrMsg.messageType}, nonce...)
-	data = append(data, fcrMsg.messageBody...)
+	data := fcrMsg.signingData()
 	sig, err := fcrcrypto.Sign(privKey, keyVer, data)
 	if err != nil {
 		return err
@@ -90,10 +96,7 @@ func (fcrMsg *FCRReqMsg) Sign(privKey string, keyVer byte) error {
 
 // Verify is used to verify the offer with a given public key.
 func (fcrMsg *FCRReqMsg) Verify(pubKey string, keyVer byte) error {
-	nonce := make([]byte, 8)
-	binary.BigEndian.PutUint64(nonce, fcrMsg.nonce)
-	data := append([]byte{fcrMsg.messageType}, nonce...)
-	data = append(data, fcrMsg.messageBody...)
+	data := fcrMsg.signingData()
 	err := fcrcrypto.Verify(pubKey, keyVer, fcrMsg.signature, data)
 	if err != nil {
 		return fmt.Errorf("Message fail to verify: %v", err.Error())
@@ -103,10 +106,7 @@ func (fcrMsg *FCRReqMsg) Verify(pubKey string, keyVer byte) error {
 
 // VerifyByID is used to verify the offer with a given id (hashed public key).
 func (fcrMsg *FCRReqMsg) VerifyByID(id string) error {
-	nonce := make([]byte, 8)
-	binary.BigEndian.PutUint64(nonce, fcrMsg.nonce)
-	data := append([]byte{fcrMsg.messageType}, nonce...)
-	data = append(data, fcrMsg.messageBody...)
+	data := fcrMsg.signingData()
 	err := fcrcrypto.VerifyByID(id, fcrMsg.signature, data)
 	if err != nil {
 		return fmt.Errorf("Message fail to verify: %v", err.Error())
@@ -114,11 +114,28 @@ func (fcrMsg *FCRReqMsg) VerifyByID(id string) error {
 	return nil
 }
 
+// Signer is used to get the public key that signed the message.
+func (fcrMsg *FCRReqMsg) Signer() (string, error) {
+	return fcrcrypto.RecoverPublicKey(fcrMsg.signature, fcrMsg.signingData())
+}
+
+// signingData gets the data to sign, it covers the message type, the nonce, the timestamp and the message body.
+func (fcrMsg *FCRReqMsg) signingData() []byte {
+	nonce := make([]byte, 8)
+	binary.BigEndian.PutUint64(nonce, fcrMsg.nonce)
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(fcrMsg.timestamp))
+	data := append([]byte{fcrMsg.messageType}, nonce...)
+	data = append(data, timestamp...)
+	return append(data, fcrMsg.messageBody...)
+}
+
 // FCRMsgToBytes converts a FCRMessage to bytes
 func (fcrMsg *FCRReqMsg) ToBytes() ([]byte, error) {
 	fcrMsgJS := &fcrReqMsgJson{
 		MessageType: hex.EncodeToString([]byte{fcrMsg.messageType}),
 		Nonce:       fcrMsg.nonce,
+		Timestamp:   fcrMsg.timestamp,
 		MessageBody: hex.EncodeToString(fcrMsg.messageBody),
 		Signature:   fcrMsg.signature,
 	}
@@ -145,6 +162,7 @@ func (fcrMsg *FCRReqMsg) FromBytes(data []byte) error {
 	}
 	fcrMsg.messageType = msgType[0]
 	fcrMsg.nonce = res.Nonce
+	fcrMsg.timestamp = res.Timestamp
 	fcrMsg.messageBody = msgBody
 	fcrMsg.signature = res.Signature
 	return nil
@@ -20,6 +20,7 @@ package fcrmessages
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -35,12 +36,15 @@ func TestReqGetter(t *testing.T) {
 	msg.signature = "testsignature"
 	assert.Equal(t, byte(1), msg.Type())
 	assert.Equal(t, uint64(100), msg.Nonce())
+	assert.LessOrEqual(t, msg.Timestamp(), time.Now().Unix())
+	assert.Greater(t, msg.Timestamp(), time.Now().Add(-time.Minute).Unix())
 	assert.Equal(t, []byte{1, 2, 3, 4}, msg.Body())
 	assert.Equal(t, "testsignature", msg.Signature())
 }
 
 func TestReqParse(t *testing.T) {
 	msg := CreateFCRReqMsg(1, 100, []byte{1, 2, 3, 4})
+	msg.timestamp = 1600000000
 	msg.signature = "testsignature"
 	data, err := msg.ToBytes()
 	assert.Empty(t, err)
@@ -49,6 +53,7 @@ func TestReqParse(t *testing.T) {
 	assert.Empty(t, err)
 	assert.Equal(t, byte(1), msg2.Type())
 	assert.Equal(t, uint64(100), msg2.Nonce())
+	assert.Equal(t, int64(1600000000), msg2.Timestamp())
 	assert.Equal(t, []byte{1, 2, 3, 4}, msg2.Body())
 	assert.Equal(t, "testsignature", msg2.Signature())
 
@@ -58,11 +63,12 @@ func TestReqParse(t *testing.T) {
 
 func TestReqSigning(t *testing.T) {
 	msg := CreateFCRReqMsg(1, 100, []byte{1, 2, 3, 4})
+	msg.timestamp = 1600000000
 	err := msg.Sign("wrongkey", 0)
 	assert.NotEmpty(t, err)
 	err = msg.Sign(PrivKey, 0)
 	assert.Empty(t, err)
-	assert.Equal(t, "00c224806c95a99cd31bce68f0d502c5abc23da173debea0cef3f61d25a6d0649b2dd1940131468b971992be78d51907cb9454cb05b23b42f5fc861255c12b24fb00", msg.Signature())
+	assert.Equal(t, "008aed1912a26fb922a9b3e0748d15fc3a4d0c8ca00004167aed6b05823271ce9936babce868a12649233184d0a5b0acfdc5e3239143eae1224112025d2a463fad01", msg.Signature())
 
 	err = msg.Verify("wrongkey", 0)
 	assert.NotEmpty(t, err)
@@ -77,4 +83,17 @@ func TestReqSigning(t *testing.T) {
 
 	err = msg.VerifyByID(ID)
 	assert.Empty(t, err)
+
+	// Test Signer
+	signer, err := msg.Signer()
+	assert.Empty(t, err)
+	assert.Equal(t, PubKey, signer)
+
+	// The timestamp is signed
+	msg.timestamp++
+	err = msg.Verify(PubKey, 0)
+	assert.NotEmpty(t, err)
+	signer, err = msg.Signer()
+	assert.Empty(t, err)
+	assert.NotEqual(t, PubKey, signer)
 }
@@ -0,0 +1,87 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+)
+
+// offerRevocationRequestJson represents the request from a provider to revoke a published offer.
+type offerRevocationRequestJson struct {
+	NodeID    string `json:"node_id"`
+	Digest    string `json:"digest"`
+	Signature string `json:"signature"`
+}
+
+// EncodeOfferRevocationRequest is used to get the FCRMessage of offerRevocationRequestJson.
+// The revocation is signed by the given offer signing key of the provider, as the offer itself.
+func EncodeOfferRevocationRequest(
+	nonce uint64,
+	nodeID string,
+	digest string,
+	offerSigningKey string,
+) (*FCRReqMsg, error) {
+	sig, err := fcrcrypto.Sign(offerSigningKey, 0, offerRevocationSigningData(nodeID, digest))
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(offerRevocationRequestJson{
+		NodeID:    nodeID,
+		Digest:    digest,
+		Signature: sig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateFCRReqMsg(OfferRevocationRequestType, nonce, body), nil
+}
+
+// DecodeOfferRevocationRequest is used to get the fields from FCRMessage of offerRevocationRequestJson.
+// It returns the nonce, nodeID, digest of the revoked offer and the revocation signature.
+func DecodeOfferRevocationRequest(fcrMsg *FCRReqMsg) (
+	uint64,
+	string,
+	string,
+	string,
+	error,
+) {
+	if fcrMsg.Type() != OfferRevocationRequestType {
+		return 0, "", "", "", fmt.Errorf("Message type mismatch, expect %v, got %v", OfferRevocationRequestType, fcrMsg.Type())
+	}
+	msg := offerRevocationRequestJson{}
+	err := json.Unmarshal(fcrMsg.Body(), &msg)
+	if err != nil {
+		return 0, "", "", "", err
+	}
+	return fcrMsg.Nonce(), msg.NodeID, msg.Digest, msg.Signature, nil
+}
+
+// VerifyOfferRevocation verifies the signature of a revocation of the offer with given digest by a given provider,
+// against the offer signing public key of the provider.
+func VerifyOfferRevocation(nodeID string, digest string, signature string, offerSigningKey string) error {
+	return fcrcrypto.Verify(offerSigningKey, 0, signature, offerRevocationSigningData(nodeID, digest))
+}
+
+// offerRevocationSigningData gets the data signed by a revocation, it differs from any signed offer.
+func offerRevocationSigningData(nodeID string, digest string) []byte {
+	return []byte(fmt.Sprintf("revoke offer %v of %v", digest, nodeID))
+}
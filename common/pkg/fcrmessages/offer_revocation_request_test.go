@@ -0,0 +1,63 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+)
+
+func TestOfferRevocationRequest(t *testing.T) {
+	mockNonce := uint64(100)
+	mockNodeID := "mockID"
+	mockDigest := "mockDigest"
+	privKey, pubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	_, otherPubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+
+	msg, err := EncodeOfferRevocationRequest(mockNonce, mockNodeID, mockDigest, privKey)
+	assert.Empty(t, err)
+	assert.Equal(t, byte(OfferRevocationRequestType), msg.messageType)
+	assert.Equal(t, uint64(100), msg.nonce)
+	assert.Equal(t, "", msg.signature)
+
+	resNonce, resNodeID, resDigest, resSig, err := DecodeOfferRevocationRequest(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, mockNonce, resNonce)
+	assert.Equal(t, mockNodeID, resNodeID)
+	assert.Equal(t, mockDigest, resDigest)
+	assert.Empty(t, VerifyOfferRevocation(resNodeID, resDigest, resSig, pubKey))
+	assert.NotEmpty(t, VerifyOfferRevocation(resNodeID, resDigest, resSig, otherPubKey))
+	assert.NotEmpty(t, VerifyOfferRevocation(resNodeID, "otherDigest", resSig, pubKey))
+
+	_, err = EncodeOfferRevocationRequest(mockNonce, mockNodeID, mockDigest, "invalidKey")
+	assert.NotEmpty(t, err)
+
+	msg.messageType = 100
+	_, _, _, _, err = DecodeOfferRevocationRequest(msg)
+	assert.NotEmpty(t, err)
+	msg.messageType = OfferRevocationRequestType
+
+	msg.messageBody = []byte{100, 100, 100}
+	_, _, _, _, err = DecodeOfferRevocationRequest(msg)
+	assert.NotEmpty(t, err)
+}
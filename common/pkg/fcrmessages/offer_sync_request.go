@@ -0,0 +1,74 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// offerSyncRequestJson represents the request from a gateway to pull a page of the offers within a cid hash range.
+// The page starts after the offer of a given digest, the offers are ordered by digest.
+type offerSyncRequestJson struct {
+	NodeID  string `json:"node_id"`
+	HashMin string `json:"hash_min"`
+	HashMax string `json:"hash_max"`
+	After   string `json:"after"`
+}
+
+// EncodeOfferSyncRequest is used to get the FCRMessage of offerSyncRequestJson.
+func EncodeOfferSyncRequest(
+	nonce uint64,
+	nodeID string,
+	hashMin string,
+	hashMax string,
+	after string,
+) (*FCRReqMsg, error) {
+	body, err := json.Marshal(offerSyncRequestJson{
+		NodeID:  nodeID,
+		HashMin: hashMin,
+		HashMax: hashMax,
+		After:   after,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateFCRReqMsg(OfferSyncRequestType, nonce, body), nil
+}
+
+// DecodeOfferSyncRequest is used to get the fields from FCRMessage of offerSyncRequestJson.
+// It returns the nonce, nodeID, cid min hash, cid max hash and the digest of the offer the page starts after, empty for the first page.
+func DecodeOfferSyncRequest(fcrMsg *FCRReqMsg) (
+	uint64,
+	string,
+	string,
+	string,
+	string,
+	error,
+) {
+	if fcrMsg.Type() != OfferSyncRequestType {
+		return 0, "", "", "", "", fmt.Errorf("Message type mismatch, expect %v, got %v", OfferSyncRequestType, fcrMsg.Type())
+	}
+	msg := offerSyncRequestJson{}
+	err := json.Unmarshal(fcrMsg.Body(), &msg)
+	if err != nil {
+		return 0, "", "", "", "", err
+	}
+	return fcrMsg.Nonce(), msg.NodeID, msg.HashMin, msg.HashMax, msg.After, nil
+}
@@ -0,0 +1,56 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfferSyncRequest(t *testing.T) {
+	mockNonce := uint64(100)
+	mockNodeID := "mockID"
+	mockHashMin := "0000000000000000000000000000000000000000000000000000000000000001"
+	mockHashMax := "0000000000000000000000000000000000000000000000000000000000000011"
+	mockAfter := "mockDigest"
+
+	msg, err := EncodeOfferSyncRequest(mockNonce, mockNodeID, mockHashMin, mockHashMax, mockAfter)
+	assert.Empty(t, err)
+	assert.Equal(t, byte(OfferSyncRequestType), msg.messageType)
+	assert.Equal(t, uint64(100), msg.nonce)
+	assert.Equal(t, "", msg.signature)
+
+	resNonce, resNodeID, resHashMin, resHashMax, resAfter, err := DecodeOfferSyncRequest(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, mockNonce, resNonce)
+	assert.Equal(t, mockNodeID, resNodeID)
+	assert.Equal(t, mockHashMin, resHashMin)
+	assert.Equal(t, mockHashMax, resHashMax)
+	assert.Equal(t, mockAfter, resAfter)
+
+	msg.messageType = 100
+	_, _, _, _, _, err = DecodeOfferSyncRequest(msg)
+	assert.NotEmpty(t, err)
+	msg.messageType = OfferSyncRequestType
+
+	msg.messageBody = []byte{100, 100, 100}
+	_, _, _, _, _, err = DecodeOfferSyncRequest(msg)
+	assert.NotEmpty(t, err)
+}
@@ -0,0 +1,94 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+)
+
+// OfferSyncPageSize is the maximum size in bytes of the offers in a page of offer sync response, unless a single offer is bigger.
+// Offers are hex encoded, so a full page stays well within the maximum message size.
+const OfferSyncPageSize = 1 << 20
+
+// offerSyncResponseJson represents the response to a request of pulling a page of the offers within a cid hash range.
+// Next is the digest of the last offer considered for this page, empty if there is no more page.
+type offerSyncResponseJson struct {
+	Offers []string `json:"offers"`
+	Next   string   `json:"next"`
+}
+
+// EncodeOfferSyncResponse is used to get the FCRMessage of offerSyncResponseJson.
+func EncodeOfferSyncResponse(
+	nonce uint64,
+	offers []cidoffer.CIDOffer,
+	next string,
+) (*FCRACKMsg, error) {
+	offersStr := make([]string, 0)
+	for _, offer := range offers {
+		data, err := offer.ToBytes()
+		if err != nil {
+			return nil, err
+		}
+		offersStr = append(offersStr, hex.EncodeToString(data))
+	}
+	body, err := json.Marshal(offerSyncResponseJson{
+		Offers: offersStr,
+		Next:   next,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return CreateFCRACKMsg(nonce, body), nil
+}
+
+// DecodeOfferSyncResponse is used to get the fields from FCRMessage of offerSyncResponseJson.
+// It returns nonce, a list of offers and the digest to request the next page after, empty if there is no more page.
+func DecodeOfferSyncResponse(fcrMsg *FCRACKMsg) (
+	uint64,
+	[]cidoffer.CIDOffer,
+	string,
+	error,
+) {
+	if !fcrMsg.ACK() {
+		return 0, nil, "", fmt.Errorf("ACK is false")
+	}
+	msg := offerSyncResponseJson{}
+	err := json.Unmarshal(fcrMsg.Body(), &msg)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	offers := make([]cidoffer.CIDOffer, 0)
+	for _, offerStr := range msg.Offers {
+		data, err := hex.DecodeString(offerStr)
+		if err != nil {
+			return 0, nil, "", err
+		}
+		offer := cidoffer.CIDOffer{}
+		err = offer.FromBytes(data)
+		if err != nil {
+			return 0, nil, "", err
+		}
+		offers = append(offers, offer)
+	}
+	return fcrMsg.Nonce(), offers, msg.Next, nil
+}
@@ -0,0 +1,64 @@
+/*
+Package fcrmessages - stores all the p2p messages.
+*/
+package fcrmessages
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+)
+
+func TestOfferSyncResponse(t *testing.T) {
+	mockNonce := uint64(100)
+	mockCID, err := cid.NewContentID("QmX5Rg8t9zh26JcaTk7VnDXqv5SHH2bT6AfeoTFLSsp4dK")
+	assert.Empty(t, err)
+	mockOffer, err := cidoffer.NewCIDOffer("testprovider", []cid.ContentID{*mockCID}, big.NewInt(100), 40, 40)
+	assert.Empty(t, err)
+
+	msg, err := EncodeOfferSyncResponse(mockNonce, []cidoffer.CIDOffer{*mockOffer}, mockOffer.GetMessageDigest())
+	assert.Empty(t, err)
+	assert.Equal(t, true, msg.ack)
+	assert.Equal(t, "", msg.signature)
+
+	resNonce, resOffers, resNext, err := DecodeOfferSyncResponse(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, mockNonce, resNonce)
+	assert.Equal(t, 1, len(resOffers))
+	assert.Equal(t, mockOffer.GetMessageDigest(), resOffers[0].GetMessageDigest())
+	assert.Equal(t, mockOffer.GetMessageDigest(), resNext)
+
+	msg, err = EncodeOfferSyncResponse(mockNonce, nil, "")
+	assert.Empty(t, err)
+	_, resOffers, resNext, err = DecodeOfferSyncResponse(msg)
+	assert.Empty(t, err)
+	assert.Equal(t, 0, len(resOffers))
+	assert.Equal(t, "", resNext)
+
+	msg.ack = false
+	_, _, _, err = DecodeOfferSyncResponse(msg)
+	assert.NotEmpty(t, err)
+	msg.ack = true
+
+	msg.messageBody = []byte{100, 100, 100}
+	_, _, _, err = DecodeOfferSyncResponse(msg)
+	assert.NotEmpty(t, err)
+}
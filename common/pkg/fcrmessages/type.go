@@ -25,4 +25,8 @@ const (
 	EstablishmentRequestType          = byte(3)
 	DataRetrievalRequestType          = byte(4) // Placeholder, TBD
 	PaymentProxyRequestType           = byte(5) // Placeholder, TBD
+	DataRetrievalPaymentType          = byte(6) // Only sent within a data retrieval stream
+	DHTLookupRequestType              = byte(7)
+	OfferSyncRequestType              = byte(8)
+	OfferRevocationRequestType        = byte(9)
 )
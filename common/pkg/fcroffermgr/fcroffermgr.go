@@ -62,6 +62,9 @@ type FCROfferMgr interface {
 	// ListOffers gets a list of offers from given index to given index.
 	ListOffers(from uint, to uint) []cidoffer.CIDOffer
 
+	// ListOffersAfter gets a list of offers ordered by digest, at most a given number, starting after a given digest.
+	ListOffersAfter(after string, limit uint) []cidoffer.CIDOffer
+
 	// GetOfferByDigest
 	GetOfferByDigest(digest string) *cidoffer.CIDOffer
 
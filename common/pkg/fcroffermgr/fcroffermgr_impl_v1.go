@@ -54,6 +54,9 @@ type FCROfferMgrImplV1 struct {
 	// digestOfferMap is a map from digest string -> offer
 	digestOfferMap map[string]*cidoffer.CIDOffer
 
+	// digests are the digests of the offers in ascending order
+	digests []string
+
 	// digestOfferMapS is the digest sub offer map, map from digest string -> sub offer
 	digestOfferMapS map[string]*cidoffer.SubCIDOffer
 
@@ -71,6 +74,7 @@ func NewFCROfferMgrImplV1(tracking bool) FCROfferMgr {
 		cidDigestMap:    make(map[string]map[string]bool),
 		tagDigestMap:    make(map[string]map[string]bool),
 		digestOfferMap:  make(map[string]*cidoffer.CIDOffer),
+		digests:         make([]string, 0),
 		digestOfferMapS: make(map[string]*cidoffer.SubCIDOffer),
 		cidDigestMapS:   make(map[string]map[string]bool),
 	}
@@ -139,6 +143,25 @@ func (mgr *FCROfferMgrImplV1) IncrementCIDAccessCount(cid *cid.ContentID) {
 	}
 }
 
+// setCIDAccessCount sets the access count of a given cid, used when restoring counters.
+func (mgr *FCROfferMgrImplV1) setCIDAccessCount(cidStr string, count int) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	oldCount, ok := mgr.cidCountMap[cidStr]
+	if ok {
+		delete(mgr.countCIDMap[oldCount], cidStr)
+		if len(mgr.countCIDMap[oldCount]) == 0 {
+			delete(mgr.countCIDMap, oldCount)
+		}
+	}
+	mgr.cidCountMap[cidStr] = count
+	_, ok = mgr.countCIDMap[count]
+	if !ok {
+		mgr.countCIDMap[count] = make(map[string]bool)
+	}
+	mgr.countCIDMap[count][cidStr] = true
+}
+
 func (mgr *FCROfferMgrImplV1) GetAccessCountByCID(cid *cid.ContentID) int {
 	mgr.lock.RLock()
 	defer mgr.lock.RUnlock()
@@ -148,6 +171,8 @@ func (mgr *FCROfferMgrImplV1) GetAccessCountByCID(cid *cid.ContentID) int {
 func (mgr *FCROfferMgrImplV1) ListAccessCount(from uint, to uint) ([]string, []int) {
 	resCID := make([]string, 0)
 	resCount := make([]int, 0)
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
 
 	if from >= to || from >= uint(len(mgr.cidCountMap)) {
 		return resCID, resCount
@@ -207,6 +232,9 @@ func (mgr *FCROfferMgrImplV1) AddOffer(offer *cidoffer.CIDOffer) {
 	}
 	// Update digest -> offer map
 	mgr.digestOfferMap[digest] = copy
+	// Update ordered digests
+	index := sort.SearchStrings(mgr.digests, digest)
+	mgr.digests = append(mgr.digests[:index], append([]string{digest}, mgr.digests[index:]...)...)
 
 	for _, cid := range copy.GetCIDs() {
 		cidStr := cid.ToString()
@@ -269,32 +297,41 @@ func (mgr *FCROfferMgrImplV1) ListOffers(from uint, to uint) []cidoffer.CIDOffer
 	res := make([]cidoffer.CIDOffer, 0)
 	mgr.lock.RLock()
 	defer mgr.lock.RUnlock()
-	if from >= to || from >= uint(len(mgr.digestOfferMap)) {
+	if from >= to || from >= uint(len(mgr.digests)) {
 		return res
 	}
-	keys := make([]string, len(mgr.digestOfferMap))
-	i := 0
-	for key := range mgr.digestOfferMap {
-		keys[i] = key
-		i++
+	if to > uint(len(mgr.digests)) {
+		to = uint(len(mgr.digests))
 	}
-	sort.Strings(keys)
+	for _, digest := range mgr.digests[from:to] {
+		copy := mgr.digestOfferMap[digest].Copy()
+		if copy == nil {
+			logging.Error("Fail to obtain a copy of the offer when listing offers.")
+			continue
+		}
+		res = append(res, *copy)
+	}
+	return res
+}
 
-	index := uint(0)
-	for _, key := range keys {
-		if index >= from {
-			if index < to {
-				copy := mgr.digestOfferMap[key].Copy()
-				if copy == nil {
-					logging.Error("Fail to obtain a copy of the offer when listing offers.")
-					continue
-				}
-				res = append(res, *copy)
-			} else {
-				return res
-			}
+func (mgr *FCROfferMgrImplV1) ListOffersAfter(after string, limit uint) []cidoffer.CIDOffer {
+	res := make([]cidoffer.CIDOffer, 0)
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	from := sort.SearchStrings(mgr.digests, after)
+	if from < len(mgr.digests) && mgr.digests[from] == after {
+		from++
+	}
+	for _, digest := range mgr.digests[from:] {
+		if uint(len(res)) >= limit {
+			break
 		}
-		index++
+		copy := mgr.digestOfferMap[digest].Copy()
+		if copy == nil {
+			logging.Error("Fail to obtain a copy of the offer when listing offers.")
+			continue
+		}
+		res = append(res, *copy)
 	}
 	return res
 }
@@ -323,6 +360,8 @@ func (mgr *FCROfferMgrImplV1) RemoveOffer(digest string) {
 	defer mgr.lock.Unlock()
 	cids := mgr.digestOfferMap[digest].GetCIDs()
 	delete(mgr.digestOfferMap, digest)
+	index := sort.SearchStrings(mgr.digests, digest)
+	mgr.digests = append(mgr.digests[:index], mgr.digests[index+1:]...)
 
 	for _, cid := range cids {
 		cidStr := cid.ToString()
@@ -333,7 +372,7 @@ func (mgr *FCROfferMgrImplV1) RemoveOffer(digest string) {
 		}
 		// Update tag map
 		tag := mgr.cidTagMap[cidStr]
-		delete(mgr.tagDigestMap, digest)
+		delete(mgr.tagDigestMap[tag], digest)
 		if len(mgr.tagDigestMap[tag]) == 0 {
 			delete(mgr.tagDigestMap, tag)
 		}
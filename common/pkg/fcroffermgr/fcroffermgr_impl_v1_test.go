@@ -163,6 +163,24 @@ func TestAddOffer(t *testing.T) {
 	assert.Equal(t, "d746ad9bf2a5deafe1f8848eed376e0c68ccd4c600d8b2c9c5d7b832a729ea21", res[2].GetMessageDigest())
 	assert.Equal(t, "fb46952a0a8c2c58d76d3b131099d2cbbfdb0029905efb7a7aad709dd827a9f5", res[3].GetMessageDigest())
 
+	res = mgr.ListOffersAfter("", 2)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, "09aac8229414ad4f42e73cf93e79f922ff65d5a6465c83be6070baaeeca988ff", res[0].GetMessageDigest())
+	assert.Equal(t, "1d8b5afd46676b00a4433b313a83e40719fdf7c3b52131b8b09b304c26ec1e82", res[1].GetMessageDigest())
+
+	res = mgr.ListOffersAfter("1d8b5afd46676b00a4433b313a83e40719fdf7c3b52131b8b09b304c26ec1e82", 2)
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, "697dfe073c9714504b6364e7333feceba4b3bbe64f2104efa5842c1a2331a311", res[0].GetMessageDigest())
+	assert.Equal(t, "9198ee39730bad84b65185b1c306f7b575a0a669ab958f7aba7a35c71f779652", res[1].GetMessageDigest())
+
+	// The digest to start after does not need to exist
+	res = mgr.ListOffersAfter("9", 10)
+	assert.Equal(t, 3, len(res))
+	assert.Equal(t, "9198ee39730bad84b65185b1c306f7b575a0a669ab958f7aba7a35c71f779652", res[0].GetMessageDigest())
+
+	res = mgr.ListOffersAfter("fb46952a0a8c2c58d76d3b131099d2cbbfdb0029905efb7a7aad709dd827a9f5", 10)
+	assert.Equal(t, 0, len(res))
+
 	offer := mgr.GetOfferByDigest("a9aac8229414ad4f42e73cf93e79f922ff65d5a6465c83be6070baaeeca988ff")
 	assert.Empty(t, offer)
 	offer = mgr.GetOfferByDigest("09aac8229414ad4f42e73cf93e79f922ff65d5a6465c83be6070baaeeca988ff")
@@ -171,6 +189,9 @@ func TestAddOffer(t *testing.T) {
 	mgr.RemoveOffer("09aac8229414ad4f42e73cf93e79f922ff65d5a6465c83be6070baaeeca988ff")
 	res = mgr.GetOffers(cid5)
 	assert.Equal(t, 0, len(res))
+	res = mgr.ListOffersAfter("", 1)
+	assert.Equal(t, 1, len(res))
+	assert.Equal(t, "1d8b5afd46676b00a4433b313a83e40719fdf7c3b52131b8b09b304c26ec1e82", res[0].GetMessageDigest())
 }
 
 func TestSubOffer(t *testing.T) {
@@ -0,0 +1,386 @@
+/*
+Package fcroffermgr - offer manager manages all offers stored.
+*/
+package fcroffermgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrdatabase"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// Namespaces used by the persistent offer manager
+const (
+	// cidTagNamespace maps cid string -> tag
+	cidTagNamespace = "cid_tag"
+	// cidCountNamespace maps cid string -> access count
+	cidCountNamespace = "cid_count"
+	// offerNamespace maps digest -> cid offer json
+	offerNamespace = "offer"
+	// subOfferNamespace maps digest -> sub cid offer json
+	subOfferNamespace = "sub_offer"
+)
+
+// FCROfferMgrImplV2 implements FCROfferMgr interface, it is a persistent version.
+// Every change is written to the database before being applied to the in-memory storage,
+// and the in-memory storage with all its indexes is rebuilt from the database at start, expired offers are dropped.
+type FCROfferMgrImplV2 struct {
+	// Boolean indicates if the manager has started
+	start bool
+
+	// Boolean indicates if to track access count
+	tracking bool
+
+	// Path to the database file
+	dbPath string
+
+	// db persists offers, tags and access counts
+	db fcrdatabase.FCRDatabase
+
+	// mem is the in-memory storage serving all reads
+	mem *FCROfferMgrImplV1
+
+	// lock serialises writes so that database and memory are updated in the same order,
+	// reads hold it for read so that they do not race with start and shutdown
+	lock sync.RWMutex
+}
+
+func NewFCROfferMgrImplV2(dbPath string, tracking bool) FCROfferMgr {
+	return &FCROfferMgrImplV2{
+		start:    false,
+		tracking: tracking,
+		dbPath:   dbPath,
+		lock:     sync.RWMutex{},
+	}
+}
+
+func (mgr *FCROfferMgrImplV2) Start() error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if mgr.start {
+		return errors.New("FCROfferManager has already started")
+	}
+	db := fcrdatabase.NewFCRDatabaseImplV1(mgr.dbPath, cidTagNamespace, cidCountNamespace, offerNamespace, subOfferNamespace)
+	err := db.Start()
+	if err != nil {
+		return err
+	}
+	mem := NewFCROfferMgrImplV1(mgr.tracking).(*FCROfferMgrImplV1)
+	expiredOffers := make([][]byte, 0)
+	expiredSubOffers := make([][]byte, 0)
+	// Tags must be loaded before offers so that the tag -> digest index is rebuilt correctly
+	err = db.View(func(snapshot fcrdatabase.Snapshot) error {
+		err := snapshot.ForEach(cidTagNamespace, func(key []byte, value []byte) error {
+			id, err := cid.NewContentID(string(key))
+			if err != nil {
+				return fmt.Errorf("Error in loading cid %v: %v", string(key), err.Error())
+			}
+			mem.AddCIDTag(id, string(value))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = snapshot.ForEach(cidCountNamespace, func(key []byte, value []byte) error {
+			if len(value) != 8 {
+				return fmt.Errorf("Error in loading access count of cid %v", string(key))
+			}
+			mem.setCIDAccessCount(string(key), int(binary.BigEndian.Uint64(value)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = snapshot.ForEach(offerNamespace, func(key []byte, value []byte) error {
+			offer := cidoffer.CIDOffer{}
+			if err := offer.FromBytes(value); err != nil {
+				return fmt.Errorf("Error in loading offer %v: %v", string(key), err.Error())
+			}
+			if offer.HasExpired() {
+				expiredOffers = append(expiredOffers, key)
+				return nil
+			}
+			mem.AddOffer(&offer)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return snapshot.ForEach(subOfferNamespace, func(key []byte, value []byte) error {
+			offer := cidoffer.SubCIDOffer{}
+			if err := offer.FromBytes(value); err != nil {
+				return fmt.Errorf("Error in loading sub offer %v: %v", string(key), err.Error())
+			}
+			if offer.HasExpired() {
+				expiredSubOffers = append(expiredSubOffers, key)
+				return nil
+			}
+			mem.AddSubOffer(&offer)
+			return nil
+		})
+	})
+	if err == nil && len(expiredOffers)+len(expiredSubOffers) > 0 {
+		// Drop expired offers
+		err = db.Update(func(txn fcrdatabase.Txn) error {
+			for _, key := range expiredOffers {
+				if err := txn.Delete(offerNamespace, key); err != nil {
+					return err
+				}
+			}
+			for _, key := range expiredSubOffers {
+				if err := txn.Delete(subOfferNamespace, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		db.Shutdown()
+		return err
+	}
+	if len(expiredOffers)+len(expiredSubOffers) > 0 {
+		logging.Info("FCROfferManager dropped %v expired offers and %v expired sub offers from %v", len(expiredOffers), len(expiredSubOffers), mgr.dbPath)
+	}
+	logging.Info("FCROfferManager loaded %v offers and %v sub offers from %v", len(mem.digestOfferMap), len(mem.digestOfferMapS), mgr.dbPath)
+	mgr.db = db
+	mgr.mem = mem
+	mgr.start = true
+	return nil
+}
+
+func (mgr *FCROfferMgrImplV2) Shutdown() {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	mgr.db.Shutdown()
+	mgr.start = false
+}
+
+func (mgr *FCROfferMgrImplV2) AddCIDTag(cid *cid.ContentID, tag string) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	err := mgr.db.Put(cidTagNamespace, []byte(cid.ToString()), []byte(tag))
+	if err != nil {
+		logging.Error("Error in persisting tag %v for cid %v: %v", tag, cid.ToString(), err.Error())
+		return
+	}
+	mgr.mem.AddCIDTag(cid, tag)
+}
+
+func (mgr *FCROfferMgrImplV2) GetTagByCID(cid *cid.ContentID) string {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return ""
+	}
+	return mgr.mem.GetTagByCID(cid)
+}
+
+func (mgr *FCROfferMgrImplV2) GetCIDByTag(tag string) string {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return ""
+	}
+	return mgr.mem.GetCIDByTag(tag)
+}
+
+func (mgr *FCROfferMgrImplV2) IncrementCIDAccessCount(cid *cid.ContentID) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, uint64(mgr.mem.GetAccessCountByCID(cid)+1))
+	err := mgr.db.Put(cidCountNamespace, []byte(cid.ToString()), count)
+	if err != nil {
+		logging.Error("Error in persisting access count for cid %v: %v", cid.ToString(), err.Error())
+		return
+	}
+	mgr.mem.IncrementCIDAccessCount(cid)
+}
+
+func (mgr *FCROfferMgrImplV2) GetAccessCountByCID(cid *cid.ContentID) int {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return 0
+	}
+	return mgr.mem.GetAccessCountByCID(cid)
+}
+
+func (mgr *FCROfferMgrImplV2) ListAccessCount(from uint, to uint) ([]string, []int) {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return make([]string, 0), make([]int, 0)
+	}
+	return mgr.mem.ListAccessCount(from, to)
+}
+
+func (mgr *FCROfferMgrImplV2) AddOffer(offer *cidoffer.CIDOffer) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	digest := offer.GetMessageDigest()
+	if mgr.mem.GetOfferByDigest(digest) != nil {
+		// Offer existed
+		return
+	}
+	data, err := offer.ToBytes()
+	if err != nil {
+		logging.Error("Error in encoding offer %v: %v", digest, err.Error())
+		return
+	}
+	err = mgr.db.Put(offerNamespace, []byte(digest), data)
+	if err != nil {
+		logging.Error("Error in persisting offer %v: %v", digest, err.Error())
+		return
+	}
+	mgr.mem.AddOffer(offer)
+}
+
+func (mgr *FCROfferMgrImplV2) GetOffers(cID *cid.ContentID) []cidoffer.CIDOffer {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return make([]cidoffer.CIDOffer, 0)
+	}
+	return mgr.mem.GetOffers(cID)
+}
+
+func (mgr *FCROfferMgrImplV2) ListOffers(from uint, to uint) []cidoffer.CIDOffer {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return make([]cidoffer.CIDOffer, 0)
+	}
+	return mgr.mem.ListOffers(from, to)
+}
+
+func (mgr *FCROfferMgrImplV2) ListOffersAfter(after string, limit uint) []cidoffer.CIDOffer {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return make([]cidoffer.CIDOffer, 0)
+	}
+	return mgr.mem.ListOffersAfter(after, limit)
+}
+
+func (mgr *FCROfferMgrImplV2) GetOfferByDigest(digest string) *cidoffer.CIDOffer {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return nil
+	}
+	return mgr.mem.GetOfferByDigest(digest)
+}
+
+func (mgr *FCROfferMgrImplV2) RemoveOffer(digest string) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	err := mgr.db.Delete(offerNamespace, []byte(digest))
+	if err != nil {
+		logging.Error("Error in removing offer %v: %v", digest, err.Error())
+		return
+	}
+	mgr.mem.RemoveOffer(digest)
+}
+
+func (mgr *FCROfferMgrImplV2) AddSubOffer(offer *cidoffer.SubCIDOffer) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	digest := offer.GetMessageDigest()
+	if mgr.mem.GetSubOfferByDigest(digest) != nil {
+		// Offer existed
+		return
+	}
+	data, err := offer.ToBytes()
+	if err != nil {
+		logging.Error("Error in encoding sub offer %v: %v", digest, err.Error())
+		return
+	}
+	err = mgr.db.Put(subOfferNamespace, []byte(digest), data)
+	if err != nil {
+		logging.Error("Error in persisting sub offer %v: %v", digest, err.Error())
+		return
+	}
+	mgr.mem.AddSubOffer(offer)
+}
+
+func (mgr *FCROfferMgrImplV2) GetSubOffers(cID *cid.ContentID) []cidoffer.SubCIDOffer {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return make([]cidoffer.SubCIDOffer, 0)
+	}
+	return mgr.mem.GetSubOffers(cID)
+}
+
+func (mgr *FCROfferMgrImplV2) ListSubOffers(from uint, to uint) []cidoffer.SubCIDOffer {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return make([]cidoffer.SubCIDOffer, 0)
+	}
+	return mgr.mem.ListSubOffers(from, to)
+}
+
+func (mgr *FCROfferMgrImplV2) GetSubOfferByDigest(digest string) *cidoffer.SubCIDOffer {
+	mgr.lock.RLock()
+	defer mgr.lock.RUnlock()
+	if !mgr.start {
+		return nil
+	}
+	return mgr.mem.GetSubOfferByDigest(digest)
+}
+
+func (mgr *FCROfferMgrImplV2) RemoveSubOffer(digest string) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if !mgr.start {
+		return
+	}
+	err := mgr.db.Delete(subOfferNamespace, []byte(digest))
+	if err != nil {
+		logging.Error("Error in removing sub offer %v: %v", digest, err.Error())
+		return
+	}
+	mgr.mem.RemoveSubOffer(digest)
+}
@@ -0,0 +1,130 @@
+/*
+Package fcroffermgr - offer manager manages all offers stored.
+*/
+package fcroffermgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+)
+
+func TestPersistentRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offer.db")
+	mgr := NewFCROfferMgrImplV2(path, true)
+	err := mgr.Start()
+	assert.Empty(t, err)
+	err = mgr.Start()
+	assert.NotEmpty(t, err)
+
+	cid1, err := cid.NewContentID(CID1)
+	assert.Empty(t, err)
+	cid2, err := cid.NewContentID(CID2)
+	assert.Empty(t, err)
+	cid3, err := cid.NewContentID(CID3)
+	assert.Empty(t, err)
+
+	mgr.AddCIDTag(cid1, "tag1")
+	mgr.AddCIDTag(cid2, "tag2")
+	mgr.IncrementCIDAccessCount(cid1)
+	mgr.IncrementCIDAccessCount(cid1)
+	mgr.IncrementCIDAccessCount(cid2)
+
+	expiry := time.Now().Add(time.Hour).Unix()
+	offer0, err := cidoffer.NewCIDOffer("testID", []cid.ContentID{*cid1, *cid2}, big.NewInt(10), expiry, 10)
+	assert.Empty(t, err)
+	offer1, err := cidoffer.NewCIDOffer("testID", []cid.ContentID{*cid2, *cid3}, big.NewInt(20), expiry, 10)
+	assert.Empty(t, err)
+	offer2, err := cidoffer.NewCIDOffer("testID", []cid.ContentID{*cid3}, big.NewInt(30), expiry, 10)
+	assert.Empty(t, err)
+	subOffer0, err := offer0.GenerateSubCIDOffer(cid1)
+	assert.Empty(t, err)
+	subOffer1, err := offer1.GenerateSubCIDOffer(cid3)
+	assert.Empty(t, err)
+
+	mgr.AddOffer(offer0)
+	mgr.AddOffer(offer1)
+	mgr.AddOffer(offer2)
+	mgr.RemoveOffer(offer2.GetMessageDigest())
+	mgr.AddSubOffer(subOffer0)
+	mgr.AddSubOffer(subOffer1)
+	mgr.RemoveSubOffer(subOffer1.GetMessageDigest())
+
+	// Offers that expire before the restart
+	expiring, err := cidoffer.NewCIDOffer("testID", []cid.ContentID{*cid3}, big.NewInt(40), time.Now().Add(time.Second).Unix(), 10)
+	assert.Empty(t, err)
+	expiringSub, err := expiring.GenerateSubCIDOffer(cid3)
+	assert.Empty(t, err)
+	mgr.AddOffer(expiring)
+	mgr.AddSubOffer(expiringSub)
+	assert.Equal(t, 3, len(mgr.ListOffers(0, 10)))
+	assert.Equal(t, 2, len(mgr.ListSubOffers(0, 10)))
+	mgr.Shutdown()
+	time.Sleep(2 * time.Second)
+
+	// Restart
+	mgr = NewFCROfferMgrImplV2(path, true)
+	err = mgr.Start()
+	assert.Empty(t, err)
+	defer mgr.Shutdown()
+
+	assert.Equal(t, "tag1", mgr.GetTagByCID(cid1))
+	assert.Equal(t, CID2, mgr.GetCIDByTag("tag2"))
+	assert.Equal(t, 2, mgr.GetAccessCountByCID(cid1))
+	assert.Equal(t, 1, mgr.GetAccessCountByCID(cid2))
+	cids, counts := mgr.ListAccessCount(0, 10)
+	assert.Equal(t, []string{CID1, CID2}, cids)
+	assert.Equal(t, []int{2, 1}, counts)
+
+	assert.Equal(t, 2, len(mgr.ListOffers(0, 10)))
+	assert.Equal(t, 1, len(mgr.GetOffers(cid1)))
+	assert.Equal(t, 2, len(mgr.GetOffers(cid2)))
+	assert.Equal(t, 1, len(mgr.GetOffers(cid3)))
+	assert.Equal(t, offer1.GetPrice(), mgr.GetOfferByDigest(offer1.GetMessageDigest()).GetPrice())
+	assert.Empty(t, mgr.GetOfferByDigest(offer2.GetMessageDigest()))
+
+	assert.Equal(t, 1, len(mgr.ListSubOffers(0, 10)))
+	assert.Equal(t, 1, len(mgr.GetSubOffers(cid1)))
+	assert.Equal(t, 0, len(mgr.GetSubOffers(cid3)))
+	assert.Equal(t, subOffer0.GetMessageDigest(), mgr.GetSubOfferByDigest(subOffer0.GetMessageDigest()).GetMessageDigest())
+
+	// Tag index is rebuilt
+	v1 := mgr.(*FCROfferMgrImplV2).mem
+	assert.Equal(t, 1, len(v1.GetOffersByTag("tag1")))
+	assert.Equal(t, 2, len(v1.GetOffersByTag("tag2")))
+
+	// Expired offers are dropped from the database too
+	mgr.Shutdown()
+	mgr = NewFCROfferMgrImplV2(path, true)
+	err = mgr.Start()
+	assert.Empty(t, err)
+	defer mgr.Shutdown()
+	assert.Empty(t, mgr.GetOfferByDigest(expiring.GetMessageDigest()))
+	count := 0
+	err = mgr.(*FCROfferMgrImplV2).db.ForEach(offerNamespace, func(key []byte, value []byte) error {
+		count++
+		return nil
+	})
+	assert.Empty(t, err)
+	assert.Equal(t, 2, count)
+}
@@ -56,17 +56,25 @@ type FCRPaymentMgr interface {
 	// RemoveOutboundCh removes the outbound payment channel status by a given recipient addr.
 	RemoveOutboundCh(recipientAddr string) error
 
-	// GetCostToCreate gets the current cost to create a payment channel.
+	// GetCostToCreate gets the current cost to create a payment channel, excluding the amount deposited.
 	GetCostToCreate(recipientAddr string, amt *big.Int) (*big.Int, error)
 
 	// CheckRecipientSettlementValidity checks if it is valid for the recipient to settle a selling payment channel.
+	// It returns false if the channel is not settling. Once the channel is found settling, no more payment is made to it,
+	// and the refunds received after the last payment in a lane are submitted so the lane settles at the net amount.
 	CheckRecipientSettlementValidity(recipientAddr string) (bool, error)
 
 	/* For inbound payment */
 	// Settle settles a payment channel by given sender addr.
+	// It submits the best voucher of every lane and calls the channel to settle, the channel stops receiving payment.
 	Settle(senderAddr string) error
 
+	// Collect collects a settling payment channel by given sender addr, once the settlement period has passed.
+	// The channel is removed after collection. Settling channels are also collected periodically.
+	Collect(senderAddr string) error
+
 	// Receive receives a payment. It returns the amount received and the lane number.
+	// Payments on a channel that has been called to settle, either locally or on chain, are refused.
 	Receive(senderAddr string, voucher string) (*big.Int, uint64, error)
 
 	// Refund creates a voucher used to refund by given sender addr, given lane and given amount.
@@ -79,6 +87,12 @@ type FCRPaymentMgr interface {
 	// It returns the payment channel address, balance and redeemed amount.
 	GetInboundChStatus(senderAddr string) (string, *big.Int, *big.Int, error)
 
+	// GetInboundChSettling gets a boolean indicating if the inbound payment channel by a given sender addr is settling.
+	GetInboundChSettling(senderAddr string) (bool, error)
+
+	// ListInboundChs lists the sender addresses of all inbound payment channels.
+	ListInboundChs() []string
+
 	// GetCostToSettle gets the current cost to settle a payment channel.
 	GetCostToSettle(senderAddr string) (*big.Int, error)
 
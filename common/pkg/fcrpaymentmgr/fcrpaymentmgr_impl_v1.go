@@ -22,15 +22,28 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 )
 
+// collectInterval is the duration to wait between two attempts to collect settling channels.
+const collectInterval = 30 * time.Minute
+
+// settlingCheckInterval is the duration the on-chain state of an inbound channel is cached for.
+// The sender can call the channel to settle at any time, but the vouchers received can still be submitted within the settlement period.
+const settlingCheckInterval = time.Minute
+
 // FCRPaymentMgrImplV1 implements FCRPaymentMgr, it is an in-memory version.
 type FCRPaymentMgrImplV1 struct {
+	// Boolean indicates if the manager has started
+	start bool
+
 	privKey string
 	addr    string
 
@@ -43,6 +56,22 @@ type FCRPaymentMgrImplV1 struct {
 	// map[sender addr] -> channel state
 	inboundChs     map[string]*channelState
 	inboundChsLock sync.RWMutex
+
+	// store persists channel states, nil for the in-memory version.
+	store channelStore
+
+	// Channel to control the collecting routine
+	collectShutdownCh chan bool
+}
+
+// channelStore persists channel states.
+// A channel state is saved after every change and before the change is acknowledged.
+type channelStore interface {
+	// saveChannel saves the state of the given channel.
+	saveChannel(outbound bool, peerAddr string, cs *channelState) error
+
+	// removeChannel removes the state of the given channel.
+	removeChannel(outbound bool, peerAddr string) error
 }
 
 // channelState represents the state of a channel
@@ -52,6 +81,13 @@ type channelState struct {
 	redeemed big.Int
 	lock     sync.RWMutex
 
+	// Boolean indicates if the channel has been called to settle
+	settling bool
+
+	// Time the on-chain state of an inbound channel was last checked, and a boolean indicating if a check is in progress
+	checkedAt time.Time
+	checking  bool
+
 	// Lane States.
 	// map[lane id] -> lane state
 	laneStates map[uint64]*laneState
@@ -66,16 +102,21 @@ type laneState struct {
 
 func NewFCRPaymentMgrImplV1(privKey string, lotusMgr fcrlotusmgr.FCRLotusMgr) FCRPaymentMgr {
 	return &FCRPaymentMgrImplV1{
-		privKey:         privKey,
-		lotusMgr:        lotusMgr,
-		outboundChs:     make(map[string]*channelState),
-		outboundChsLock: sync.RWMutex{},
-		inboundChs:      make(map[string]*channelState),
-		inboundChsLock:  sync.RWMutex{},
+		start:             false,
+		privKey:           privKey,
+		lotusMgr:          lotusMgr,
+		outboundChs:       make(map[string]*channelState),
+		outboundChsLock:   sync.RWMutex{},
+		inboundChs:        make(map[string]*channelState),
+		inboundChsLock:    sync.RWMutex{},
+		collectShutdownCh: make(chan bool),
 	}
 }
 
 func (mgr *FCRPaymentMgrImplV1) Start() error {
+	if mgr.start {
+		return errors.New("FCRPaymentManager has already started")
+	}
 	pubKey, _, err := fcrcrypto.GetPublicKey(mgr.privKey)
 	if err != nil {
 		return err
@@ -85,10 +126,18 @@ func (mgr *FCRPaymentMgrImplV1) Start() error {
 		return err
 	}
 	mgr.addr = addr
+	mgr.start = true
+	go mgr.collectRoutine()
 	return nil
 }
 
 func (mgr *FCRPaymentMgrImplV1) Shutdown() {
+	if !mgr.start {
+		return
+	}
+	mgr.collectShutdownCh <- true
+	<-mgr.collectShutdownCh
+	mgr.start = false
 }
 
 func (mgr *FCRPaymentMgrImplV1) Create(recipientAddr string, amt *big.Int) error {
@@ -105,14 +154,16 @@ func (mgr *FCRPaymentMgrImplV1) Create(recipientAddr string, amt *big.Int) error
 	}
 	mgr.outboundChsLock.Lock()
 	defer mgr.outboundChsLock.Unlock()
-	mgr.outboundChs[recipientAddr] = &channelState{
+	cs := &channelState{
 		addr:       chAddr,
-		balance:    *amt,
+		balance:    *big.NewInt(0).Set(amt),
 		redeemed:   *big.NewInt(0),
 		lock:       sync.RWMutex{},
 		laneStates: make(map[uint64]*laneState),
 	}
-	return nil
+	mgr.outboundChs[recipientAddr] = cs
+	// The channel has been created on chain, keep it in memory even if it fails to be saved
+	return mgr.save(true, recipientAddr, cs, nil)
 }
 
 func (mgr *FCRPaymentMgrImplV1) Topup(recipientAddr string, amt *big.Int) error {
@@ -131,7 +182,8 @@ func (mgr *FCRPaymentMgrImplV1) Topup(recipientAddr string, amt *big.Int) error
 	}
 	// Update channel state
 	cs.balance.Add(&cs.balance, amt)
-	return nil
+	// The topup has been made on chain, keep it in memory even if it fails to be saved
+	return mgr.save(true, recipientAddr, cs, nil)
 }
 
 func (mgr *FCRPaymentMgrImplV1) Pay(recipientAddr string, lane uint64, amt *big.Int) (string, bool, bool, error) {
@@ -147,12 +199,16 @@ func (mgr *FCRPaymentMgrImplV1) Pay(recipientAddr string, lane uint64, amt *big.
 	}
 	cs.lock.Lock()
 	defer cs.lock.Unlock()
+	if cs.settling {
+		return "", false, false, errors.New("Channel is settling")
+	}
 	cNewRedeemed := big.NewInt(0).Add(&cs.redeemed, amt)
 	if cs.balance.Cmp(cNewRedeemed) < 0 {
 		// Balance not enough
 		return "", false, true, nil
 	}
 	// Blanace is enough
+	backup := mgr.backup(cs)
 	// Check lane state
 	ls, ok := cs.laneStates[lane]
 	if !ok {
@@ -168,6 +224,7 @@ func (mgr *FCRPaymentMgrImplV1) Pay(recipientAddr string, lane uint64, amt *big.
 	lNewRedeemed := big.NewInt(0).Add(&ls.redeemed, amt)
 	voucher, err := fcrlotusmgr.GenerateVoucher(mgr.privKey, cs.addr, lane, ls.nonce, lNewRedeemed)
 	if err != nil {
+		cs.restore(backup)
 		return "", false, false, err
 	}
 	// Update lane state
@@ -176,6 +233,9 @@ func (mgr *FCRPaymentMgrImplV1) Pay(recipientAddr string, lane uint64, amt *big.
 	ls.vouchers = append([]string{voucher}, ls.vouchers...)
 	// Update channel state
 	cs.redeemed.Add(&cs.redeemed, amt)
+	if err = mgr.save(true, recipientAddr, cs, backup); err != nil {
+		return "", false, false, err
+	}
 	return voucher, false, false, nil
 }
 
@@ -197,6 +257,7 @@ func (mgr *FCRPaymentMgrImplV1) RevertPay(recipientAddr string, lane uint64) {
 	if len(ls.vouchers) == 0 {
 		return
 	}
+	backup := mgr.backup(cs)
 	_, _, _, _, newRedeemed, _ := fcrlotusmgr.VerifyVoucher(ls.vouchers[0])
 	var oldRedeemed *big.Int
 	if len(ls.vouchers) == 1 {
@@ -210,6 +271,9 @@ func (mgr *FCRPaymentMgrImplV1) RevertPay(recipientAddr string, lane uint64) {
 	}
 	diff := big.NewInt(0).Sub(newRedeemed, oldRedeemed)
 	cs.redeemed.Sub(&cs.redeemed, diff)
+	if err := mgr.save(true, recipientAddr, cs, backup); err != nil {
+		logging.Error("Error in saving reverted payment to %v: %v", recipientAddr, err.Error())
+	}
 }
 
 func (mgr *FCRPaymentMgrImplV1) ReceiveRefund(recipientAddr string, voucher string) (*big.Int, error) {
@@ -245,10 +309,14 @@ func (mgr *FCRPaymentMgrImplV1) ReceiveRefund(recipientAddr string, voucher stri
 		return nil, errors.New("Refund value is not positive")
 	}
 	// Refund is valid, update lane state and channel state
+	backup := mgr.backup(cs)
 	ls.nonce = nonce + 1
 	ls.redeemed.Sub(&ls.redeemed, diff)
 	ls.vouchers = append(ls.vouchers, voucher)
 	cs.redeemed.Sub(&cs.redeemed, diff)
+	if err = mgr.save(true, recipientAddr, cs, backup); err != nil {
+		return nil, err
+	}
 	return diff, nil
 }
 
@@ -275,23 +343,119 @@ func (mgr *FCRPaymentMgrImplV1) RemoveOutboundCh(recipientAddr string) error {
 	}
 	mgr.outboundChsLock.Lock()
 	defer mgr.outboundChsLock.Unlock()
+	if mgr.store != nil {
+		if err := mgr.store.removeChannel(true, recipientAddr); err != nil {
+			return err
+		}
+	}
 	delete(mgr.outboundChs, recipientAddr)
 	return nil
 }
 
 func (mgr *FCRPaymentMgrImplV1) GetCostToCreate(recipientAddr string, amt *big.Int) (*big.Int, error) {
 	recipientAddr = cleanAddress(recipientAddr)
-	return nil, errors.New("No implementation")
+	return mgr.lotusMgr.GetCostToCreate(mgr.privKey, recipientAddr, amt)
 }
 
 func (mgr *FCRPaymentMgrImplV1) CheckRecipientSettlementValidity(recipientAddr string) (bool, error) {
 	recipientAddr = cleanAddress(recipientAddr)
-	return false, errors.New("No implementation")
+	mgr.outboundChsLock.RLock()
+	cs, ok := mgr.outboundChs[recipientAddr]
+	mgr.outboundChsLock.RUnlock()
+	if !ok {
+		return false, errors.New("Channel not found")
+	}
+	cs.lock.RLock()
+	chAddr := cs.addr
+	cs.lock.RUnlock()
+	settling, _, _, err := mgr.lotusMgr.CheckPaymentChannel(chAddr)
+	if err != nil {
+		return false, err
+	}
+	if !settling {
+		return false, nil
+	}
+	cs.lock.RLock()
+	refunds := cs.refundVouchers(recipientAddr)
+	submitted := cs.settling
+	cs.lock.RUnlock()
+	if !submitted && len(refunds) > 0 {
+		// The recipient can only submit vouchers issued before the refunds, submit the refunds to settle the net amount
+		logging.Info("Submitting %v refund vouchers to settling channel %v of %v", len(refunds), chAddr, recipientAddr)
+		if err = mgr.lotusMgr.UpdatePaymentChannel(mgr.privKey, chAddr, refunds); err != nil {
+			return false, err
+		}
+	}
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if !cs.settling {
+		// Stop paying to the channel
+		backup := mgr.backup(cs)
+		cs.settling = true
+		if err = mgr.save(true, recipientAddr, cs, backup); err != nil {
+			return false, err
+		}
+	}
+	// The recipient can only settle with vouchers it has received
+	return cs.redeemed.Cmp(big.NewInt(0)) > 0, nil
 }
 
 func (mgr *FCRPaymentMgrImplV1) Settle(senderAddr string) error {
 	senderAddr = cleanAddress(senderAddr)
-	return errors.New("No implementation")
+	mgr.inboundChsLock.RLock()
+	cs, ok := mgr.inboundChs[senderAddr]
+	mgr.inboundChsLock.RUnlock()
+	if !ok {
+		return errors.New("Channel not found")
+	}
+	cs.lock.Lock()
+	if cs.settling {
+		cs.lock.Unlock()
+		return errors.New("Channel is already settling")
+	}
+	chAddr := cs.addr
+	vouchers := cs.bestVouchers(senderAddr)
+	if len(vouchers) == 0 {
+		cs.lock.Unlock()
+		return errors.New("No voucher to settle")
+	}
+	// Refuse any new voucher while the vouchers are being submitted
+	cs.settling = true
+	cs.lock.Unlock()
+	logging.Info("Settling channel %v from %v with %v vouchers", chAddr, senderAddr, len(vouchers))
+	err := mgr.lotusMgr.SettlePaymentChannel(mgr.privKey, chAddr, vouchers)
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	if err != nil {
+		cs.settling = false
+		return err
+	}
+	// The channel has been settled on chain, keep it in memory even if it fails to be saved
+	return mgr.save(false, senderAddr, cs, nil)
+}
+
+func (mgr *FCRPaymentMgrImplV1) Collect(senderAddr string) error {
+	senderAddr = cleanAddress(senderAddr)
+	mgr.inboundChsLock.RLock()
+	cs, ok := mgr.inboundChs[senderAddr]
+	mgr.inboundChsLock.RUnlock()
+	if !ok {
+		return errors.New("Channel not found")
+	}
+	cs.lock.RLock()
+	settling := cs.settling
+	chAddr := cs.addr
+	cs.lock.RUnlock()
+	if !settling {
+		return errors.New("Channel is not settling")
+	}
+	err := mgr.lotusMgr.CollectPaymentChannel(mgr.privKey, chAddr)
+	if err != nil {
+		return err
+	}
+	logging.Info("Collected channel %v from %v", chAddr, senderAddr)
+	// The channel has been collected, a new voucher from the sender will be on a new channel
+	return mgr.RemoveInboundCh(senderAddr)
 }
 
 func (mgr *FCRPaymentMgrImplV1) Receive(senderAddr string, voucher string) (*big.Int, uint64, error) {
@@ -306,10 +470,13 @@ func (mgr *FCRPaymentMgrImplV1) Receive(senderAddr string, voucher string) (*big
 	}
 	mgr.inboundChsLock.RLock()
 	cs, ok := mgr.inboundChs[senderAddr]
+	mgr.inboundChsLock.RUnlock()
+	// Boolean indicates if the on-chain state has just been checked
+	checked := !ok
 	if !ok {
 		// Need to create a new entry
 		// Get channel address
-		_, balance, recipientAddr, err := mgr.lotusMgr.CheckPaymentChannel(chAddr)
+		settling, balance, recipientAddr, err := mgr.lotusMgr.CheckPaymentChannel(chAddr)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -317,25 +484,41 @@ func (mgr *FCRPaymentMgrImplV1) Receive(senderAddr string, voucher string) (*big
 		if recipientAddr != mgr.addr {
 			return nil, 0, fmt.Errorf("Receive receiver address mismtach expect %v got %v", mgr.addr, recipientAddr)
 		}
-		mgr.inboundChsLock.RUnlock()
+		if settling {
+			return nil, 0, errors.New("Receive on a settling channel")
+		}
 		mgr.inboundChsLock.Lock()
-		cs = &channelState{
-			addr:       chAddr,
-			balance:    *balance,
-			redeemed:   *big.NewInt(0),
-			lock:       sync.RWMutex{},
-			laneStates: make(map[uint64]*laneState),
+		cs, ok = mgr.inboundChs[senderAddr]
+		if !ok {
+			cs = &channelState{
+				addr:       chAddr,
+				balance:    *balance,
+				redeemed:   *big.NewInt(0),
+				lock:       sync.RWMutex{},
+				laneStates: make(map[uint64]*laneState),
+			}
+			mgr.inboundChs[senderAddr] = cs
 		}
-		mgr.inboundChs[senderAddr] = cs
 		mgr.inboundChsLock.Unlock()
-		mgr.inboundChsLock.RLock()
 	}
+	mgr.inboundChsLock.RLock()
 	defer mgr.inboundChsLock.RUnlock()
 	cs.lock.Lock()
 	defer cs.lock.Unlock()
 	if chAddr != cs.addr {
 		return nil, 0, fmt.Errorf("Receive channel address mismatch expect %v got %v", cs.addr, chAddr)
 	}
+	if cs.settling {
+		return nil, 0, errors.New("Receive on a settling channel")
+	}
+	if checked {
+		cs.checkedAt = time.Now()
+	} else if !cs.checking && time.Since(cs.checkedAt) > settlingCheckInterval {
+		// The sender can call the channel to settle at any time, refresh the cached state off the hot path
+		cs.checking = true
+		go mgr.refreshInboundCh(senderAddr, cs)
+	}
+	backup := mgr.backup(cs)
 	ls, ok := cs.laneStates[lane]
 	if !ok {
 		// Need to create a new lane
@@ -348,10 +531,12 @@ func (mgr *FCRPaymentMgrImplV1) Receive(senderAddr string, voucher string) (*big
 	}
 	if ls.nonce > nonce {
 		// Nonce not match
+		cs.restore(backup)
 		return nil, 0, errors.New("Receive nonce is not valid")
 	}
 	paymentValue := big.NewInt(0).Sub(newRedeemed, &ls.redeemed)
 	if paymentValue.Cmp(big.NewInt(0)) <= 0 {
+		cs.restore(backup)
 		return nil, 0, errors.New("Receive has bad amount")
 	}
 	// Verify channel balance
@@ -361,10 +546,12 @@ func (mgr *FCRPaymentMgrImplV1) Receive(senderAddr string, voucher string) (*big
 		// Update one time.
 		_, balance, _, err := mgr.lotusMgr.CheckPaymentChannel(chAddr)
 		if err != nil {
+			cs.restore(backup)
 			return nil, 0, err
 		}
 		cs.balance = *balance
 		if cs.balance.Cmp(csNewRedeemed) < 0 {
+			cs.restore(backup)
 			return nil, 0, errors.New("Receive not enough channel balance")
 		}
 	}
@@ -374,6 +561,9 @@ func (mgr *FCRPaymentMgrImplV1) Receive(senderAddr string, voucher string) (*big
 	ls.vouchers = append(ls.vouchers, voucher)
 	// Update channel state
 	cs.redeemed.Add(&cs.redeemed, paymentValue)
+	if err = mgr.save(false, senderAddr, cs, backup); err != nil {
+		return nil, 0, err
+	}
 	return paymentValue, lane, nil
 }
 
@@ -402,11 +592,15 @@ func (mgr *FCRPaymentMgrImplV1) Refund(senderAddr string, lane uint64, amt *big.
 	if err != nil {
 		return "", err
 	}
+	backup := mgr.backup(cs)
 	// Update lane state
 	ls.nonce++
 	ls.redeemed.Sub(&ls.redeemed, amt)
 	ls.vouchers = append(ls.vouchers, voucher)
 	cs.redeemed.Sub(&cs.redeemed, amt)
+	if err = mgr.save(false, senderAddr, cs, backup); err != nil {
+		return "", err
+	}
 	return voucher, nil
 }
 
@@ -423,6 +617,30 @@ func (mgr *FCRPaymentMgrImplV1) GetInboundChStatus(senderAddr string) (string, *
 	return cs.addr, big.NewInt(0).Set(&cs.balance), big.NewInt(0).Set(&cs.redeemed), nil
 }
 
+func (mgr *FCRPaymentMgrImplV1) GetInboundChSettling(senderAddr string) (bool, error) {
+	senderAddr = cleanAddress(senderAddr)
+	mgr.inboundChsLock.RLock()
+	defer mgr.inboundChsLock.RUnlock()
+	cs, ok := mgr.inboundChs[senderAddr]
+	if !ok {
+		return false, errors.New("Channel not found")
+	}
+	cs.lock.RLock()
+	defer cs.lock.RUnlock()
+	return cs.settling, nil
+}
+
+func (mgr *FCRPaymentMgrImplV1) ListInboundChs() []string {
+	mgr.inboundChsLock.RLock()
+	defer mgr.inboundChsLock.RUnlock()
+	res := make([]string, 0, len(mgr.inboundChs))
+	for senderAddr := range mgr.inboundChs {
+		res = append(res, senderAddr)
+	}
+	sort.Strings(res)
+	return res
+}
+
 func (mgr *FCRPaymentMgrImplV1) RemoveInboundCh(senderAddr string) error {
 	senderAddr = cleanAddress(senderAddr)
 	mgr.inboundChsLock.RLock()
@@ -433,18 +651,240 @@ func (mgr *FCRPaymentMgrImplV1) RemoveInboundCh(senderAddr string) error {
 	}
 	mgr.inboundChsLock.Lock()
 	defer mgr.inboundChsLock.Unlock()
+	if mgr.store != nil {
+		if err := mgr.store.removeChannel(false, senderAddr); err != nil {
+			return err
+		}
+	}
 	delete(mgr.inboundChs, senderAddr)
 	return nil
 }
 
 func (mgr *FCRPaymentMgrImplV1) GetCostToSettle(senderAddr string) (*big.Int, error) {
 	senderAddr = cleanAddress(senderAddr)
-	return nil, errors.New("No implementation")
+	mgr.inboundChsLock.RLock()
+	cs, ok := mgr.inboundChs[senderAddr]
+	mgr.inboundChsLock.RUnlock()
+	if !ok {
+		return nil, errors.New("Channel not found")
+	}
+	cs.lock.RLock()
+	chAddr := cs.addr
+	vouchers := cs.bestVouchers(senderAddr)
+	cs.lock.RUnlock()
+	if len(vouchers) == 0 {
+		return nil, errors.New("No voucher to settle")
+	}
+	return mgr.lotusMgr.GetCostToSettle(mgr.privKey, chAddr, vouchers)
 }
 
 func (mgr *FCRPaymentMgrImplV1) CheckSettlementValidity(senderAddr string) (bool, error) {
 	senderAddr = cleanAddress(senderAddr)
-	return false, errors.New("No implementation")
+	mgr.inboundChsLock.RLock()
+	cs, ok := mgr.inboundChs[senderAddr]
+	mgr.inboundChsLock.RUnlock()
+	if !ok {
+		return false, errors.New("Channel not found")
+	}
+	cs.lock.RLock()
+	chAddr := cs.addr
+	valid := !cs.settling && cs.redeemed.Cmp(big.NewInt(0)) > 0 && len(cs.bestVouchers(senderAddr)) > 0
+	cs.lock.RUnlock()
+	if !valid {
+		return false, nil
+	}
+	// The channel should not have been called to settle by anyone
+	settling, _, _, err := mgr.lotusMgr.CheckPaymentChannel(chAddr)
+	if err != nil {
+		return false, err
+	}
+	return !settling, nil
+}
+
+// refreshInboundCh refreshes the cached on-chain state of a given inbound channel.
+// Once the channel is found settling, any voucher is refused and the channel can be collected once settled.
+func (mgr *FCRPaymentMgrImplV1) refreshInboundCh(senderAddr string, cs *channelState) {
+	cs.lock.RLock()
+	chAddr := cs.addr
+	cs.lock.RUnlock()
+	settling, balance, _, err := mgr.lotusMgr.CheckPaymentChannel(chAddr)
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.checking = false
+	if err != nil {
+		logging.Debug("FCRPaymentManager fail to check channel %v from %v: %v", chAddr, senderAddr, err.Error())
+		return
+	}
+	cs.checkedAt = time.Now()
+	cs.balance = *balance
+	if settling && !cs.settling {
+		// A failure to save is rolled back, the channel is checked again once the cached state expires
+		backup := mgr.backup(cs)
+		cs.settling = true
+		if err = mgr.save(false, senderAddr, cs, backup); err == nil {
+			logging.Info("Channel %v from %v is settling, refuse any voucher", chAddr, senderAddr)
+		}
+	}
+}
+
+// collectRoutine periodically collects the settling inbound channels.
+func (mgr *FCRPaymentMgrImplV1) collectRoutine() {
+	for {
+		afterChan := time.After(collectInterval)
+		select {
+		case <-afterChan:
+			// Need to collect
+		case <-mgr.collectShutdownCh:
+			// Need to shutdown
+			logging.Info("FCRPaymentManager shutdown collecting routine.")
+			mgr.collectShutdownCh <- true
+			return
+		}
+		mgr.outboundChsLock.RLock()
+		recipientAddrs := make([]string, 0, len(mgr.outboundChs))
+		for recipientAddr := range mgr.outboundChs {
+			recipientAddrs = append(recipientAddrs, recipientAddr)
+		}
+		mgr.outboundChsLock.RUnlock()
+		for _, recipientAddr := range recipientAddrs {
+			// Stop paying to a settling channel, and submit the refunds to it
+			if _, err := mgr.CheckRecipientSettlementValidity(recipientAddr); err != nil {
+				logging.Debug("FCRPaymentManager fail to check settlement of channel to %v: %v", recipientAddr, err.Error())
+			}
+		}
+		for _, senderAddr := range mgr.ListInboundChs() {
+			settling, err := mgr.GetInboundChSettling(senderAddr)
+			if err != nil || !settling {
+				continue
+			}
+			if err = mgr.Collect(senderAddr); err != nil {
+				logging.Debug("FCRPaymentManager fail to collect channel from %v: %v", senderAddr, err.Error())
+			}
+		}
+	}
+}
+
+// backup gets a copy of the given channel state, used to roll back a change that fails to be saved.
+// It returns nil for the in-memory version.
+func (mgr *FCRPaymentMgrImplV1) backup(cs *channelState) *channelState {
+	if mgr.store == nil {
+		return nil
+	}
+	return cs.copy()
+}
+
+// save saves the given channel state if there is a store.
+// If it fails and a backup is given, the channel state is rolled back to the backup.
+func (mgr *FCRPaymentMgrImplV1) save(outbound bool, peerAddr string, cs *channelState, backup *channelState) error {
+	if mgr.store == nil {
+		return nil
+	}
+	err := mgr.store.saveChannel(outbound, peerAddr, cs)
+	if err != nil {
+		err = fmt.Errorf("Error in saving channel state for %v: %v", peerAddr, err.Error())
+		logging.Error(err.Error())
+		if backup != nil {
+			cs.restore(backup)
+		}
+	}
+	return err
+}
+
+// bestVouchers gets the voucher with the highest nonce signed by the sender in every lane, sorted by lane.
+// Vouchers are cumulative and the chain keeps the voucher with the highest nonce in a lane, so it is the best voucher to submit.
+// A payment after a refund is cumulative from the net amount redeemed in the lane, so its voucher settles the net amount.
+// A refund voucher is signed by the recipient and has a higher nonce than the sender vouchers before it,
+// so the sender can submit it during the settlement period to settle the net amount of a lane with no payment after the refund.
+func (cs *channelState) bestVouchers(senderAddr string) []string {
+	lanes := make([]uint64, 0, len(cs.laneStates))
+	for lane := range cs.laneStates {
+		lanes = append(lanes, lane)
+	}
+	sort.Slice(lanes, func(i, j int) bool { return lanes[i] < lanes[j] })
+	res := make([]string, 0)
+	for _, lane := range lanes {
+		best := ""
+		var bestNonce uint64
+		for _, voucher := range cs.laneStates[lane].vouchers {
+			signer, _, _, nonce, _, err := fcrlotusmgr.VerifyVoucher(voucher)
+			if err != nil || cleanAddress(signer) != senderAddr {
+				// Refund vouchers are signed by the recipient
+				continue
+			}
+			if best == "" || nonce > bestNonce {
+				best = voucher
+				bestNonce = nonce
+			}
+		}
+		if best != "" {
+			res = append(res, best)
+		}
+	}
+	return res
+}
+
+// refundVouchers gets the refund voucher signed by the recipient in every lane whose latest voucher is a refund, sorted by lane.
+// The refund has a higher nonce than every voucher the recipient holds in the lane, so it settles the lane at the net amount.
+func (cs *channelState) refundVouchers(recipientAddr string) []string {
+	lanes := make([]uint64, 0, len(cs.laneStates))
+	for lane := range cs.laneStates {
+		lanes = append(lanes, lane)
+	}
+	sort.Slice(lanes, func(i, j int) bool { return lanes[i] < lanes[j] })
+	res := make([]string, 0)
+	for _, lane := range lanes {
+		latest := ""
+		var latestNonce uint64
+		var latestSigner string
+		for _, voucher := range cs.laneStates[lane].vouchers {
+			signer, _, _, nonce, _, err := fcrlotusmgr.VerifyVoucher(voucher)
+			if err != nil {
+				continue
+			}
+			if latest == "" || nonce > latestNonce {
+				latest = voucher
+				latestNonce = nonce
+				latestSigner = cleanAddress(signer)
+			}
+		}
+		if latest != "" && latestSigner == recipientAddr {
+			res = append(res, latest)
+		}
+	}
+	return res
+}
+
+// copy returns a deep copy of the channel state, the lock is not copied.
+func (cs *channelState) copy() *channelState {
+	res := &channelState{
+		addr:       cs.addr,
+		balance:    *big.NewInt(0).Set(&cs.balance),
+		redeemed:   *big.NewInt(0).Set(&cs.redeemed),
+		settling:   cs.settling,
+		laneStates: make(map[uint64]*laneState),
+	}
+	for lane, ls := range cs.laneStates {
+		vouchers := make([]string, len(ls.vouchers))
+		copy(vouchers, ls.vouchers)
+		res.laneStates[lane] = &laneState{
+			nonce:    ls.nonce,
+			redeemed: *big.NewInt(0).Set(&ls.redeemed),
+			vouchers: vouchers,
+		}
+	}
+	return res
+}
+
+// restore sets the channel state to a given copy, a nil copy is ignored.
+func (cs *channelState) restore(backup *channelState) {
+	if backup == nil {
+		return
+	}
+	cs.addr = backup.addr
+	cs.balance = backup.balance
+	cs.redeemed = backup.redeemed
+	cs.settling = backup.settling
+	cs.laneStates = backup.laneStates
 }
 
 // cleanAddress enforce the address to start with f
@@ -19,10 +19,13 @@ package fcrpaymentmgr
  */
 
 import (
+	"errors"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
 )
 
 type mockLotusMgr struct {
@@ -32,6 +35,8 @@ type mockLotusMgr struct {
 
 	settlePaymentChannel func(privKey string, chAddr string, vouchers []string) error
 
+	updatePaymentChannel func(privKey string, chAddr string, vouchers []string) error
+
 	collectPaymentChannel func(privKey string, chAddr string) error
 
 	checkPaymentChannel func(chAddr string) (bool, *big.Int, string, error)
@@ -40,6 +45,8 @@ type mockLotusMgr struct {
 
 	getCostToSettle func(privKey string, chAddr string, vouchers []string) (*big.Int, error)
 
+	getCurrentBlock func() (*big.Int, error)
+
 	getPaymentChannelCreationBlock func(chAddr string) (*big.Int, error)
 
 	getPaymentChannelSettlementBlock func(chAddr string) (*big.Int, error)
@@ -57,6 +64,10 @@ func (m *mockLotusMgr) SettlePaymentChannel(privKey string, chAddr string, vouch
 	return m.settlePaymentChannel(privKey, chAddr, vouchers)
 }
 
+func (m *mockLotusMgr) UpdatePaymentChannel(privKey string, chAddr string, vouchers []string) error {
+	return m.updatePaymentChannel(privKey, chAddr, vouchers)
+}
+
 func (m *mockLotusMgr) CollectPaymentChannel(privKey string, chAddr string) error {
 	return m.collectPaymentChannel(privKey, chAddr)
 }
@@ -73,12 +84,16 @@ func (m *mockLotusMgr) GetCostToSettle(privKey string, chAddr string, vouchers [
 	return m.getCostToSettle(privKey, chAddr, vouchers)
 }
 
+func (m *mockLotusMgr) GetCurrentBlock() (*big.Int, error) {
+	return m.getCurrentBlock()
+}
+
 func (m *mockLotusMgr) GetPaymentChannelCreationBlock(chAddr string) (*big.Int, error) {
 	return m.getPaymentChannelCreationBlock(chAddr)
 }
 
 func (m *mockLotusMgr) GetPaymentChannelSettlementBlock(chAddr string) (*big.Int, error) {
-	return m.GetPaymentChannelSettlementBlock(chAddr)
+	return m.getPaymentChannelSettlementBlock(chAddr)
 }
 
 func TestNewPaymentMgr(t *testing.T) {
@@ -260,24 +275,250 @@ func TestPayAndReceive(t *testing.T) {
 	assert.NotEmpty(t, err)
 }
 
-func TestUnimplemented(t *testing.T) {
-	mockLotusMgr := mockLotusMgr{}
-	mgr := NewFCRPaymentMgrImplV1("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0", &mockLotusMgr)
-	err := mgr.Start()
+func TestSettleAndCollect(t *testing.T) {
+	settling := false
+	var settled []string
+	var updated []string
+	mockLotusMgr := mockLotusMgr{
+		createPaymentChannel: func(privKey string, recipientAddr string, amt *big.Int) (string, error) {
+			return "f12yybez3cfe2yb2nsartagpwkk23q5hmmiluqafi", nil
+		},
+		checkPaymentChannel: func(chAddr string) (bool, *big.Int, string, error) {
+			return settling, big.NewInt(100000000), "f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", nil
+		},
+		getCostToSettle: func(privKey string, chAddr string, vouchers []string) (*big.Int, error) {
+			return big.NewInt(int64(len(vouchers)) * 1000), nil
+		},
+		settlePaymentChannel: func(privKey string, chAddr string, vouchers []string) error {
+			settled = vouchers
+			settling = true
+			return nil
+		},
+		updatePaymentChannel: func(privKey string, chAddr string, vouchers []string) error {
+			updated = vouchers
+			return nil
+		},
+		collectPaymentChannel: func(privKey string, chAddr string) error {
+			return errors.New("Channel is not collectable")
+		},
+	}
+	mgr1 := NewFCRPaymentMgrImplV1("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0", &mockLotusMgr)
+	err := mgr1.Start()
+	assert.Empty(t, err)
+	defer mgr1.Shutdown()
+	mgr2 := NewFCRPaymentMgrImplV1("8495f24f3bfab01404671400d876d2887314086d4fd73792e52c46386039ec32", &mockLotusMgr)
+	err = mgr2.Start()
+	assert.Empty(t, err)
+	defer mgr2.Shutdown()
+
+	err = mgr1.Create("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", big.NewInt(100000000))
+	assert.Empty(t, err)
+
+	_, err = mgr2.GetCostToSettle("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.NotEmpty(t, err)
+	err = mgr2.Settle("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.NotEmpty(t, err)
+
+	// Two payments in lane 0 with a refund, one payment in lane 1
+	for _, lane := range []uint64{0, 0, 1} {
+		voucher, _, _, err := mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", lane, big.NewInt(10000000))
+		assert.Empty(t, err)
+		_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+		assert.Empty(t, err)
+	}
+	refund, err := mgr2.Refund("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", 0, big.NewInt(5000000))
+	assert.Empty(t, err)
+	_, err = mgr1.ReceiveRefund("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", refund)
+	assert.Empty(t, err)
+	assert.Equal(t, []string{"f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy"}, mgr2.ListInboundChs())
+
+	// The highest sender voucher is the best in lane 0, the sender holds the refund with a higher nonce
+	cs := mgr2.(*FCRPaymentMgrImplV1).inboundChs["f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy"]
+	best := cs.bestVouchers("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Equal(t, 2, len(best))
+	_, _, _, nonce, redeemed, err := fcrlotusmgr.VerifyVoucher(best[0])
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(1), nonce)
+	assert.Equal(t, "20000000", redeemed.String())
+	refunds := mgr1.(*FCRPaymentMgrImplV1).outboundChs["f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi"].refundVouchers("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi")
+	assert.Equal(t, []string{refund}, refunds)
+
+	// A payment after the refund is cumulative from the net amount redeemed, it supersedes the refund
+	voucher, _, _, err := mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(1000000))
+	assert.Empty(t, err)
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.Empty(t, err)
+	// A refund after the last payment in lane 1
+	refund, err = mgr2.Refund("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", 1, big.NewInt(4000000))
+	assert.Empty(t, err)
+	_, err = mgr1.ReceiveRefund("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", refund)
+	assert.Empty(t, err)
+
+	// Recipient has not settled
+	valid, err := mgr1.CheckRecipientSettlementValidity("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi")
+	assert.Empty(t, err)
+	assert.False(t, valid)
+
+	valid, err = mgr2.CheckSettlementValidity("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.True(t, valid)
+
+	cost, err := mgr2.GetCostToSettle("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.Equal(t, "2000", cost.String())
+
+	err = mgr2.Settle("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	// The sender signed voucher with the highest nonce in every lane is submitted
+	assert.Equal(t, 2, len(settled))
+	expectedNonces := []uint64{3, 0}
+	expectedRedeemed := []string{"16000000", "10000000"}
+	for i, voucher := range settled {
+		sender, _, lane, nonce, redeemed, err := fcrlotusmgr.VerifyVoucher(voucher)
+		assert.Empty(t, err)
+		assert.Equal(t, "f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", sender)
+		assert.Equal(t, uint64(i), lane)
+		assert.Equal(t, expectedNonces[i], nonce)
+		assert.Equal(t, expectedRedeemed[i], redeemed.String())
+	}
+	settling, err = mgr2.GetInboundChSettling("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.True(t, settling)
+
+	err = mgr2.Settle("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.NotEmpty(t, err)
+	valid, err = mgr2.CheckSettlementValidity("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
 	assert.Empty(t, err)
+	assert.False(t, valid)
 
-	_, err = mgr.GetCostToCreate("", nil)
+	// Sender finds the channel settling, submits the refund of lane 1 so the net amount is settled and stops paying
+	valid, err = mgr1.CheckRecipientSettlementValidity("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi")
+	assert.Empty(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, []string{refund}, updated)
+	_, _, lane, nonce, redeemed, err := fcrlotusmgr.VerifyVoucher(updated[0])
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(1), lane)
+	assert.Equal(t, uint64(1), nonce)
+	assert.Equal(t, "6000000", redeemed.String())
+	_, _, redeemedTotal, err := mgr2.GetInboundChStatus("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.Equal(t, "22000000", redeemedTotal.String())
+	// Refunds are submitted once
+	updated = nil
+	valid, err = mgr1.CheckRecipientSettlementValidity("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi")
+	assert.Empty(t, err)
+	assert.True(t, valid)
+	assert.Empty(t, updated)
+	_, _, _, err = mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(10000000))
 	assert.NotEmpty(t, err)
 
-	_, err = mgr.CheckRecipientSettlementValidity("")
+	// Settlement period not passed
+	err = mgr2.Collect("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
 	assert.NotEmpty(t, err)
+	_, _, _, err = mgr2.GetInboundChStatus("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
 
-	err = mgr.Settle("")
+	mockLotusMgr.collectPaymentChannel = func(privKey string, chAddr string) error {
+		return nil
+	}
+	err = mgr2.Collect("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	_, _, _, err = mgr2.GetInboundChStatus("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
 	assert.NotEmpty(t, err)
+	assert.Empty(t, mgr2.ListInboundChs())
+}
+
+func TestReceiveOnSettlingChannel(t *testing.T) {
+	settling := false
+	mockLotusMgr := mockLotusMgr{
+		createPaymentChannel: func(privKey string, recipientAddr string, amt *big.Int) (string, error) {
+			return "f12yybez3cfe2yb2nsartagpwkk23q5hmmiluqafi", nil
+		},
+		checkPaymentChannel: func(chAddr string) (bool, *big.Int, string, error) {
+			return settling, big.NewInt(100000000), "f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", nil
+		},
+	}
+	mgr1 := NewFCRPaymentMgrImplV1("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0", &mockLotusMgr)
+	err := mgr1.Start()
+	assert.Empty(t, err)
+	defer mgr1.Shutdown()
+	mgr2 := NewFCRPaymentMgrImplV1("8495f24f3bfab01404671400d876d2887314086d4fd73792e52c46386039ec32", &mockLotusMgr)
+	err = mgr2.Start()
+	assert.Empty(t, err)
+	defer mgr2.Shutdown()
+
+	err = mgr1.Create("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", big.NewInt(100000000))
+	assert.Empty(t, err)
 
-	_, err = mgr.GetCostToSettle("")
+	// New channel found settling on chain
+	settling = true
+	voucher, _, _, err := mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(10000000))
+	assert.Empty(t, err)
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
 	assert.NotEmpty(t, err)
+	assert.Empty(t, mgr2.ListInboundChs())
+
+	settling = false
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.Empty(t, err)
+
+	// Sender calls the channel to settle, the cached state is used until it expires
+	settling = true
+	voucher, _, _, err = mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(10000000))
+	assert.Empty(t, err)
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.Empty(t, err)
 
-	_, err = mgr.CheckSettlementValidity("")
+	// The cached state expires, the voucher is accepted and the state is refreshed in the background
+	cs := mgr2.(*FCRPaymentMgrImplV1).inboundChs["f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy"]
+	cs.lock.Lock()
+	cs.checkedAt = time.Now().Add(-2 * settlingCheckInterval)
+	cs.lock.Unlock()
+	voucher, _, _, err = mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(10000000))
+	assert.Empty(t, err)
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.Empty(t, err)
+	assert.Eventually(t, func() bool {
+		inboundSettling, err := mgr2.GetInboundChSettling("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+		return err == nil && inboundSettling
+	}, time.Second, 10*time.Millisecond)
+
+	// The next voucher is refused
+	voucher, _, _, err = mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(10000000))
+	assert.Empty(t, err)
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.NotEmpty(t, err)
+	_, _, redeemed, err := mgr2.GetInboundChStatus("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.Equal(t, "30000000", redeemed.String())
+
+	// The channel is marked settling, vouchers are refused without checking the chain again
+	settling = false
+	inboundSettling, err := mgr2.GetInboundChSettling("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.True(t, inboundSettling)
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.NotEmpty(t, err)
+}
+
+func TestGetCostToCreate(t *testing.T) {
+	mockLotusMgr := mockLotusMgr{
+		getCostToCreate: func(privKey string, recipientAddr string, amt *big.Int) (*big.Int, error) {
+			if recipientAddr != "f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy" {
+				return nil, errors.New("Invalid recipient")
+			}
+			return big.NewInt(125000), nil
+		},
+	}
+	mgr := NewFCRPaymentMgrImplV1("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0", &mockLotusMgr)
+	err := mgr.Start()
+	assert.Empty(t, err)
+	defer mgr.Shutdown()
+
+	cost, err := mgr.GetCostToCreate("t1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", big.NewInt(1000))
+	assert.Empty(t, err)
+	assert.Equal(t, "125000", cost.String())
+	_, err = mgr.GetCostToCreate("f1hn3o5excejl2uyea7efs3licozuycghzpdiikjy", big.NewInt(1000))
 	assert.NotEmpty(t, err)
 }
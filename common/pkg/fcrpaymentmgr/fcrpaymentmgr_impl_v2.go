@@ -0,0 +1,210 @@
+/*
+Package fcrpaymentmgr - payment manager manages all payment related functions.
+*/
+package fcrpaymentmgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrdatabase"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// Namespaces used by the persistent payment manager
+const (
+	// outboundNamespace maps recipient addr -> channel state json
+	outboundNamespace = "outbound"
+	// inboundNamespace maps sender addr -> channel state json
+	inboundNamespace = "inbound"
+)
+
+// FCRPaymentMgrImplV2 implements FCRPaymentMgr, it is a persistent version.
+// Every change to a channel is saved to the database before it is acknowledged,
+// and all outbound and inbound channels are restored at start.
+type FCRPaymentMgrImplV2 struct {
+	*FCRPaymentMgrImplV1
+
+	// Path to the database file
+	dbPath string
+
+	// db persists channel states
+	db fcrdatabase.FCRDatabase
+}
+
+// channelStateJson is used to serialise a channel state
+type channelStateJson struct {
+	Addr       string                   `json:"addr"`
+	Balance    string                   `json:"balance"`
+	Redeemed   string                   `json:"redeemed"`
+	Settling   bool                     `json:"settling"`
+	LaneStates map[uint64]laneStateJson `json:"lane_states"`
+}
+
+// laneStateJson is used to serialise a lane state
+type laneStateJson struct {
+	Nonce    uint64   `json:"nonce"`
+	Redeemed string   `json:"redeemed"`
+	Vouchers []string `json:"vouchers"`
+}
+
+func NewFCRPaymentMgrImplV2(privKey string, lotusMgr fcrlotusmgr.FCRLotusMgr, dbPath string) FCRPaymentMgr {
+	mgr := &FCRPaymentMgrImplV2{
+		FCRPaymentMgrImplV1: NewFCRPaymentMgrImplV1(privKey, lotusMgr).(*FCRPaymentMgrImplV1),
+		dbPath:              dbPath,
+		db:                  fcrdatabase.NewFCRDatabaseImplV1(dbPath, outboundNamespace, inboundNamespace),
+	}
+	mgr.store = mgr
+	return mgr
+}
+
+func (mgr *FCRPaymentMgrImplV2) Start() error {
+	err := mgr.FCRPaymentMgrImplV1.Start()
+	if err != nil {
+		return err
+	}
+	err = mgr.db.Start()
+	if err != nil {
+		mgr.FCRPaymentMgrImplV1.Shutdown()
+		return err
+	}
+	outboundChs := make(map[string]*channelState)
+	inboundChs := make(map[string]*channelState)
+	err = mgr.db.View(func(snapshot fcrdatabase.Snapshot) error {
+		err := snapshot.ForEach(outboundNamespace, func(key []byte, value []byte) error {
+			cs, err := decodeChannelState(value)
+			if err != nil {
+				return fmt.Errorf("Error in loading outbound channel to %v: %v", string(key), err.Error())
+			}
+			outboundChs[string(key)] = cs
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return snapshot.ForEach(inboundNamespace, func(key []byte, value []byte) error {
+			cs, err := decodeChannelState(value)
+			if err != nil {
+				return fmt.Errorf("Error in loading inbound channel from %v: %v", string(key), err.Error())
+			}
+			inboundChs[string(key)] = cs
+			return nil
+		})
+	})
+	if err != nil {
+		mgr.FCRPaymentMgrImplV1.Shutdown()
+		mgr.db.Shutdown()
+		return err
+	}
+	mgr.outboundChsLock.Lock()
+	mgr.outboundChs = outboundChs
+	mgr.outboundChsLock.Unlock()
+	mgr.inboundChsLock.Lock()
+	mgr.inboundChs = inboundChs
+	mgr.inboundChsLock.Unlock()
+	logging.Info("FCRPaymentManager restored %v outbound channels and %v inbound channels from %v", len(outboundChs), len(inboundChs), mgr.dbPath)
+	return nil
+}
+
+func (mgr *FCRPaymentMgrImplV2) Shutdown() {
+	mgr.FCRPaymentMgrImplV1.Shutdown()
+	mgr.db.Shutdown()
+}
+
+func (mgr *FCRPaymentMgrImplV2) saveChannel(outbound bool, peerAddr string, cs *channelState) error {
+	data, err := encodeChannelState(cs)
+	if err != nil {
+		return err
+	}
+	namespace := inboundNamespace
+	if outbound {
+		namespace = outboundNamespace
+	}
+	return mgr.db.Put(namespace, []byte(peerAddr), data)
+}
+
+func (mgr *FCRPaymentMgrImplV2) removeChannel(outbound bool, peerAddr string) error {
+	namespace := inboundNamespace
+	if outbound {
+		namespace = outboundNamespace
+	}
+	return mgr.db.Delete(namespace, []byte(peerAddr))
+}
+
+// encodeChannelState encodes a channel state to bytes.
+func encodeChannelState(cs *channelState) ([]byte, error) {
+	res := channelStateJson{
+		Addr:       cs.addr,
+		Balance:    cs.balance.String(),
+		Redeemed:   cs.redeemed.String(),
+		Settling:   cs.settling,
+		LaneStates: make(map[uint64]laneStateJson),
+	}
+	for lane, ls := range cs.laneStates {
+		res.LaneStates[lane] = laneStateJson{
+			Nonce:    ls.nonce,
+			Redeemed: ls.redeemed.String(),
+			Vouchers: ls.vouchers,
+		}
+	}
+	return json.Marshal(res)
+}
+
+// decodeChannelState decodes bytes to a channel state.
+func decodeChannelState(data []byte) (*channelState, error) {
+	csJson := channelStateJson{}
+	err := json.Unmarshal(data, &csJson)
+	if err != nil {
+		return nil, err
+	}
+	balance, ok := big.NewInt(0).SetString(csJson.Balance, 10)
+	if !ok {
+		return nil, fmt.Errorf("Invalid balance %v", csJson.Balance)
+	}
+	redeemed, ok := big.NewInt(0).SetString(csJson.Redeemed, 10)
+	if !ok {
+		return nil, fmt.Errorf("Invalid redeemed %v", csJson.Redeemed)
+	}
+	cs := &channelState{
+		addr:       csJson.Addr,
+		balance:    *balance,
+		redeemed:   *redeemed,
+		lock:       sync.RWMutex{},
+		settling:   csJson.Settling,
+		laneStates: make(map[uint64]*laneState),
+	}
+	for lane, lsJson := range csJson.LaneStates {
+		lRedeemed, ok := big.NewInt(0).SetString(lsJson.Redeemed, 10)
+		if !ok {
+			return nil, fmt.Errorf("Invalid lane %v redeemed %v", lane, lsJson.Redeemed)
+		}
+		vouchers := lsJson.Vouchers
+		if vouchers == nil {
+			vouchers = make([]string, 0)
+		}
+		cs.laneStates[lane] = &laneState{
+			nonce:    lsJson.Nonce,
+			redeemed: *lRedeemed,
+			vouchers: vouchers,
+		}
+	}
+	return cs, nil
+}
@@ -0,0 +1,119 @@
+/*
+Package fcrpaymentmgr - payment manager manages all payment related functions.
+*/
+package fcrpaymentmgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
+)
+
+func TestPersistentRestart(t *testing.T) {
+	mockLotusMgr := mockLotusMgr{
+		createPaymentChannel: func(privKey string, recipientAddr string, amt *big.Int) (string, error) {
+			return "f12yybez3cfe2yb2nsartagpwkk23q5hmmiluqafi", nil
+		},
+		checkPaymentChannel: func(chAddr string) (bool, *big.Int, string, error) {
+			return false, big.NewInt(100000000), "f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", nil
+		},
+		settlePaymentChannel: func(privKey string, chAddr string, vouchers []string) error {
+			return nil
+		},
+	}
+	dir := t.TempDir()
+	path1 := filepath.Join(dir, "payment1.db")
+	path2 := filepath.Join(dir, "payment2.db")
+	mgr1 := NewFCRPaymentMgrImplV2("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0", &mockLotusMgr, path1)
+	err := mgr1.Start()
+	assert.Empty(t, err)
+	mgr2 := NewFCRPaymentMgrImplV2("8495f24f3bfab01404671400d876d2887314086d4fd73792e52c46386039ec32", &mockLotusMgr, path2)
+	err = mgr2.Start()
+	assert.Empty(t, err)
+
+	err = mgr1.Create("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", big.NewInt(100000000))
+	assert.Empty(t, err)
+	voucher, _, _, err := mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(50000000))
+	assert.Empty(t, err)
+	_, _, err = mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.Empty(t, err)
+	_, _, _, err = mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(10000000))
+	assert.Empty(t, err)
+	mgr1.RevertPay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0)
+	voucher, err = mgr2.Refund("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", 0, big.NewInt(10000000))
+	assert.Empty(t, err)
+	_, err = mgr1.ReceiveRefund("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", voucher)
+	assert.Empty(t, err)
+	mgr1.Shutdown()
+	mgr2.Shutdown()
+
+	// Restart both managers
+	mgr1 = NewFCRPaymentMgrImplV2("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0", &mockLotusMgr, path1)
+	err = mgr1.Start()
+	assert.Empty(t, err)
+	defer mgr1.Shutdown()
+	mgr2 = NewFCRPaymentMgrImplV2("8495f24f3bfab01404671400d876d2887314086d4fd73792e52c46386039ec32", &mockLotusMgr, path2)
+	err = mgr2.Start()
+	assert.Empty(t, err)
+	defer mgr2.Shutdown()
+
+	paychAddr, balance, redeemed, err := mgr1.GetOutboundChStatus("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi")
+	assert.Empty(t, err)
+	assert.Equal(t, "f12yybez3cfe2yb2nsartagpwkk23q5hmmiluqafi", paychAddr)
+	assert.Equal(t, "100000000", balance.String())
+	assert.Equal(t, "40000000", redeemed.String())
+	_, _, redeemed, err = mgr2.GetInboundChStatus("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.Equal(t, "40000000", redeemed.String())
+
+	// Lane nonce continues after restart, so the voucher is accepted
+	voucher, _, _, err = mgr1.Pay("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi", 0, big.NewInt(10000000))
+	assert.Empty(t, err)
+	_, _, _, nonce, _, err := fcrlotusmgr.VerifyVoucher(voucher)
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(2), nonce)
+	received, lane, err := mgr2.Receive("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy", voucher)
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(0), lane)
+	assert.Equal(t, "10000000", received.String())
+
+	err = mgr1.RemoveOutboundCh("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi")
+	assert.Empty(t, err)
+	mgr1.Shutdown()
+	mgr1 = NewFCRPaymentMgrImplV2("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0", &mockLotusMgr, path1)
+	err = mgr1.Start()
+	assert.Empty(t, err)
+	defer mgr1.Shutdown()
+	_, _, _, err = mgr1.GetOutboundChStatus("f1wcl5t2jld4iqtthqmj4ef4xvx7jy64eqvyvkchi")
+	assert.NotEmpty(t, err)
+
+	// Settling state survives restart, so the channel is still collected
+	err = mgr2.Settle("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	mgr2.Shutdown()
+	mgr2 = NewFCRPaymentMgrImplV2("8495f24f3bfab01404671400d876d2887314086d4fd73792e52c46386039ec32", &mockLotusMgr, path2)
+	err = mgr2.Start()
+	assert.Empty(t, err)
+	defer mgr2.Shutdown()
+	settling, err := mgr2.GetInboundChSettling("f1qsbhbdqnbzjxqmz3fchnodr5vfae2twuwstoxuy")
+	assert.Empty(t, err)
+	assert.True(t, settling)
+}
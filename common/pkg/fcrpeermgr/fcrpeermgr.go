@@ -18,6 +18,11 @@ package fcrpeermgr
  * SPDX-License-Identifier: Apache-2.0
  */
 
+import "time"
+
+// MsgKeyGracePeriod is the duration for which the previous msg signing key of a peer is still accepted after the key is updated.
+const MsgKeyGracePeriod = time.Hour
+
 // FCRPeerMgr represents the manager that manages all peers.
 type FCRPeerMgr interface {
 	// Start starts the manager's routine.
@@ -48,6 +53,7 @@ type FCRPeerMgr interface {
 	GetGWSNearCIDHash(hash string, numDHT int, except string) []Peer
 
 	// GetCurrentCIDHashRange gets the cid min hash and cid max hash that a gateway should store based on current network. Called only by gateways.
+	// The range spans the closest gateways to this gateway, as many as the replication factor, so each cid is stored by about that many gateways.
 	GetCurrentCIDHashRange() (string, string)
 }
 
@@ -68,6 +74,15 @@ type Peer struct {
 	// MsgSigningKeyVer is the message signing public key version.
 	MsgSigningKeyVer byte
 
+	// PrevMsgSigningKey is the previous message signing public key, empty if the key has never been updated.
+	PrevMsgSigningKey string
+
+	// PrevMsgSigningKeyVer is the previous message signing public key version.
+	PrevMsgSigningKeyVer byte
+
+	// PrevMsgSigningKeyExpiry is the time (in unix seconds) after which the previous key is no longer accepted.
+	PrevMsgSigningKeyExpiry int64
+
 	// OfferSigningKey is the offer signing public key.
 	// It is a 32 bytes hex string. Empty for gateway peer.
 	OfferSigningKey string
@@ -84,3 +99,13 @@ type Peer struct {
 	// DeregisteringHeight is the height of the block which contains the deregistering transaction.
 	DeregisteringHeight uint64
 }
+
+// VerifyMsg verifies a message signed by this peer with a given verify function, for example FCRReqMsg.Verify.
+// A message signed with the previous msg signing key is accepted until the previous key expires.
+func (p *Peer) VerifyMsg(verify func(pubKey string, keyVer byte) error) error {
+	err := verify(p.MsgSigningKey, p.MsgSigningKeyVer)
+	if err == nil || p.PrevMsgSigningKey == "" || time.Now().Unix() > p.PrevMsgSigningKeyExpiry {
+		return err
+	}
+	return verify(p.PrevMsgSigningKey, p.PrevMsgSigningKeyVer)
+}
@@ -51,6 +51,9 @@ type FCRPeerMgrImplV1 struct {
 	// trackCIDRange indicates if track current cid range
 	trackCIDRange bool
 
+	// replicationFactor is the number of closest gateways whose range covers the tracked cid range
+	replicationFactor int
+
 	// Channels to control the threads
 	gatewayShutdownCh  chan bool
 	providerShutdownCh chan bool
@@ -74,7 +77,7 @@ type FCRPeerMgrImplV1 struct {
 	rangeLock sync.RWMutex
 }
 
-func NewFCRPeerMgrImplV1(registerMgr fcrregistermgr.FCRRegisterMgr, reputationMgr fcrreputationmgr.FCRReputationMgr, gatewayDiscv bool, providerDiscv bool, trackCIDRange bool, trackAnchor string, refreshDuration time.Duration) FCRPeerMgr {
+func NewFCRPeerMgrImplV1(registerMgr fcrregistermgr.FCRRegisterMgr, reputationMgr fcrreputationmgr.FCRReputationMgr, gatewayDiscv bool, providerDiscv bool, trackCIDRange bool, trackAnchor string, replicationFactor int, refreshDuration time.Duration) FCRPeerMgr {
 	return &FCRPeerMgrImplV1{
 		start:                  false,
 		registerMgr:            registerMgr,
@@ -83,6 +86,7 @@ func NewFCRPeerMgrImplV1(registerMgr fcrregistermgr.FCRRegisterMgr, reputationMg
 		gatewayDiscv:           gatewayDiscv,
 		providerDiscv:          providerDiscv,
 		trackCIDRange:          trackCIDRange,
+		replicationFactor:      replicationFactor,
 		gatewayShutdownCh:      make(chan bool),
 		providerShutdownCh:     make(chan bool),
 		gatewayRefreshCh:       make(chan bool),
@@ -169,6 +173,9 @@ func (mgr *FCRPeerMgrImplV1) SyncGW(gwID string) *Peer {
 		mgr.closestGatewaysIDs.Insert(gwID)
 	}
 	// Mostly used to updating msg key
+	if ok {
+		gwPeer.PrevMsgSigningKey, gwPeer.PrevMsgSigningKeyVer, gwPeer.PrevMsgSigningKeyExpiry = prevMsgSigningKey(gwPeer, gwReg.MsgSigningKey, gwReg.MsgSigningKeyVer)
+	}
 	gwPeer.RootKey = gwReg.RootKey
 	gwPeer.MsgSigningKey = gwReg.MsgSigningKey
 	gwPeer.MsgSigningKeyVer = gwReg.MsgSigningKeyVer
@@ -181,14 +188,17 @@ func (mgr *FCRPeerMgrImplV1) SyncGW(gwID string) *Peer {
 		mgr.reputationMgr.PendPeer(gwID)
 	}
 	return &Peer{
-		RootKey:             gwPeer.RootKey,
-		NodeID:              gwPeer.NodeID,
-		MsgSigningKey:       gwPeer.MsgSigningKey,
-		MsgSigningKeyVer:    gwPeer.MsgSigningKeyVer,
-		RegionCode:          gwPeer.RegionCode,
-		NetworkAddr:         gwPeer.NetworkAddr,
-		Deregistering:       gwPeer.Deregistering,
-		DeregisteringHeight: gwPeer.DeregisteringHeight,
+		RootKey:                 gwPeer.RootKey,
+		NodeID:                  gwPeer.NodeID,
+		MsgSigningKey:           gwPeer.MsgSigningKey,
+		MsgSigningKeyVer:        gwPeer.MsgSigningKeyVer,
+		PrevMsgSigningKey:       gwPeer.PrevMsgSigningKey,
+		PrevMsgSigningKeyVer:    gwPeer.PrevMsgSigningKeyVer,
+		PrevMsgSigningKeyExpiry: gwPeer.PrevMsgSigningKeyExpiry,
+		RegionCode:              gwPeer.RegionCode,
+		NetworkAddr:             gwPeer.NetworkAddr,
+		Deregistering:           gwPeer.Deregistering,
+		DeregisteringHeight:     gwPeer.DeregisteringHeight,
 	}
 }
 
@@ -212,6 +222,9 @@ func (mgr *FCRPeerMgrImplV1) SyncPVD(pvdID string) *Peer {
 		mgr.discoveredPVDS[pvdID] = pvdPeer
 	}
 	// Mostly used to updating msg key
+	if ok {
+		pvdPeer.PrevMsgSigningKey, pvdPeer.PrevMsgSigningKeyVer, pvdPeer.PrevMsgSigningKeyExpiry = prevMsgSigningKey(pvdPeer, pvdReg.MsgSigningKey, pvdReg.MsgSigningKeyVer)
+	}
 	pvdPeer.RootKey = pvdReg.RootKey
 	pvdPeer.MsgSigningKey = pvdReg.MsgSigningKey
 	pvdPeer.MsgSigningKeyVer = pvdReg.MsgSigningKeyVer
@@ -226,15 +239,18 @@ func (mgr *FCRPeerMgrImplV1) SyncPVD(pvdID string) *Peer {
 	}
 	// Return copy
 	return &Peer{
-		RootKey:             pvdPeer.RootKey,
-		NodeID:              pvdPeer.NodeID,
-		MsgSigningKey:       pvdPeer.MsgSigningKey,
-		MsgSigningKeyVer:    pvdPeer.MsgSigningKeyVer,
-		OfferSigningKey:     pvdPeer.OfferSigningKey,
-		RegionCode:          pvdPeer.RegionCode,
-		NetworkAddr:         pvdPeer.NetworkAddr,
-		Deregistering:       pvdPeer.Deregistering,
-		DeregisteringHeight: pvdPeer.DeregisteringHeight,
+		RootKey:                 pvdPeer.RootKey,
+		NodeID:                  pvdPeer.NodeID,
+		MsgSigningKey:           pvdPeer.MsgSigningKey,
+		MsgSigningKeyVer:        pvdPeer.MsgSigningKeyVer,
+		PrevMsgSigningKey:       pvdPeer.PrevMsgSigningKey,
+		PrevMsgSigningKeyVer:    pvdPeer.PrevMsgSigningKeyVer,
+		PrevMsgSigningKeyExpiry: pvdPeer.PrevMsgSigningKeyExpiry,
+		OfferSigningKey:         pvdPeer.OfferSigningKey,
+		RegionCode:              pvdPeer.RegionCode,
+		NetworkAddr:             pvdPeer.NetworkAddr,
+		Deregistering:           pvdPeer.Deregistering,
+		DeregisteringHeight:     pvdPeer.DeregisteringHeight,
 	}
 }
 
@@ -250,14 +266,17 @@ func (mgr *FCRPeerMgrImplV1) GetGWInfo(gwID string) *Peer {
 		return nil
 	}
 	return &Peer{
-		RootKey:             peer.RootKey,
-		NodeID:              peer.NodeID,
-		MsgSigningKey:       peer.MsgSigningKey,
-		MsgSigningKeyVer:    peer.MsgSigningKeyVer,
-		RegionCode:          peer.RegionCode,
-		NetworkAddr:         peer.NetworkAddr,
-		Deregistering:       peer.Deregistering,
-		DeregisteringHeight: peer.DeregisteringHeight,
+		RootKey:                 peer.RootKey,
+		NodeID:                  peer.NodeID,
+		MsgSigningKey:           peer.MsgSigningKey,
+		MsgSigningKeyVer:        peer.MsgSigningKeyVer,
+		PrevMsgSigningKey:       peer.PrevMsgSigningKey,
+		PrevMsgSigningKeyVer:    peer.PrevMsgSigningKeyVer,
+		PrevMsgSigningKeyExpiry: peer.PrevMsgSigningKeyExpiry,
+		RegionCode:              peer.RegionCode,
+		NetworkAddr:             peer.NetworkAddr,
+		Deregistering:           peer.Deregistering,
+		DeregisteringHeight:     peer.DeregisteringHeight,
 	}
 }
 
@@ -273,15 +292,18 @@ func (mgr *FCRPeerMgrImplV1) GetPVDInfo(pvdID string) *Peer {
 		return nil
 	}
 	return &Peer{
-		RootKey:             peer.RootKey,
-		NodeID:              peer.NodeID,
-		MsgSigningKey:       peer.MsgSigningKey,
-		MsgSigningKeyVer:    peer.MsgSigningKeyVer,
-		OfferSigningKey:     peer.OfferSigningKey,
-		RegionCode:          peer.RegionCode,
-		NetworkAddr:         peer.NetworkAddr,
-		Deregistering:       peer.Deregistering,
-		DeregisteringHeight: peer.DeregisteringHeight,
+		RootKey:                 peer.RootKey,
+		NodeID:                  peer.NodeID,
+		MsgSigningKey:           peer.MsgSigningKey,
+		MsgSigningKeyVer:        peer.MsgSigningKeyVer,
+		PrevMsgSigningKey:       peer.PrevMsgSigningKey,
+		PrevMsgSigningKeyVer:    peer.PrevMsgSigningKeyVer,
+		PrevMsgSigningKeyExpiry: peer.PrevMsgSigningKeyExpiry,
+		OfferSigningKey:         peer.OfferSigningKey,
+		RegionCode:              peer.RegionCode,
+		NetworkAddr:             peer.NetworkAddr,
+		Deregistering:           peer.Deregistering,
+		DeregisteringHeight:     peer.DeregisteringHeight,
 	}
 }
 
@@ -302,14 +324,17 @@ func (mgr *FCRPeerMgrImplV1) GetGWSNearCIDHash(hash string, numDHT int, except s
 	for _, id := range ids {
 		peer := mgr.discoveredGWS[id]
 		res = append(res, Peer{
-			RootKey:             peer.RootKey,
-			NodeID:              peer.NodeID,
-			MsgSigningKey:       peer.MsgSigningKey,
-			MsgSigningKeyVer:    peer.MsgSigningKeyVer,
-			RegionCode:          peer.RegionCode,
-			NetworkAddr:         peer.NetworkAddr,
-			Deregistering:       peer.Deregistering,
-			DeregisteringHeight: peer.DeregisteringHeight,
+			RootKey:                 peer.RootKey,
+			NodeID:                  peer.NodeID,
+			MsgSigningKey:           peer.MsgSigningKey,
+			MsgSigningKeyVer:        peer.MsgSigningKeyVer,
+			PrevMsgSigningKey:       peer.PrevMsgSigningKey,
+			PrevMsgSigningKeyVer:    peer.PrevMsgSigningKeyVer,
+			PrevMsgSigningKeyExpiry: peer.PrevMsgSigningKeyExpiry,
+			RegionCode:              peer.RegionCode,
+			NetworkAddr:             peer.NetworkAddr,
+			Deregistering:           peer.Deregistering,
+			DeregisteringHeight:     peer.DeregisteringHeight,
 		})
 	}
 	return res
@@ -325,14 +350,17 @@ func (mgr *FCRPeerMgrImplV1) ListGWS() []Peer {
 	// return copies
 	for _, peer := range mgr.discoveredGWS {
 		res = append(res, Peer{
-			RootKey:             peer.RootKey,
-			NodeID:              peer.NodeID,
-			MsgSigningKey:       peer.MsgSigningKey,
-			MsgSigningKeyVer:    peer.MsgSigningKeyVer,
-			RegionCode:          peer.RegionCode,
-			NetworkAddr:         peer.NetworkAddr,
-			Deregistering:       peer.Deregistering,
-			DeregisteringHeight: peer.DeregisteringHeight,
+			RootKey:                 peer.RootKey,
+			NodeID:                  peer.NodeID,
+			MsgSigningKey:           peer.MsgSigningKey,
+			MsgSigningKeyVer:        peer.MsgSigningKeyVer,
+			PrevMsgSigningKey:       peer.PrevMsgSigningKey,
+			PrevMsgSigningKeyVer:    peer.PrevMsgSigningKeyVer,
+			PrevMsgSigningKeyExpiry: peer.PrevMsgSigningKeyExpiry,
+			RegionCode:              peer.RegionCode,
+			NetworkAddr:             peer.NetworkAddr,
+			Deregistering:           peer.Deregistering,
+			DeregisteringHeight:     peer.DeregisteringHeight,
 		})
 	}
 	return res
@@ -407,18 +435,27 @@ func (mgr *FCRPeerMgrImplV1) gwSyncRoutine() {
 						update = true
 					}
 				}
+				var prevKey string
+				var prevKeyVer byte
+				var prevKeyExpiry int64
+				if update && ok {
+					prevKey, prevKeyVer, prevKeyExpiry = prevMsgSigningKey(storedInfo, gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer)
+				}
 				mgr.discoveredGWSLock.RUnlock()
 				if update {
 					mgr.discoveredGWSLock.Lock()
 					mgr.discoveredGWS[gwInfo.NodeID] = &Peer{
-						RootKey:             gwInfo.RootKey,
-						NodeID:              gwInfo.NodeID,
-						MsgSigningKey:       gwInfo.MsgSigningKey,
-						MsgSigningKeyVer:    gwInfo.MsgSigningKeyVer,
-						RegionCode:          gwInfo.RegionCode,
-						NetworkAddr:         gwInfo.NetworkAddr,
-						Deregistering:       gwInfo.Deregistering,
-						DeregisteringHeight: gwInfo.DeregisteringHeight,
+						RootKey:                 gwInfo.RootKey,
+						NodeID:                  gwInfo.NodeID,
+						MsgSigningKey:           gwInfo.MsgSigningKey,
+						MsgSigningKeyVer:        gwInfo.MsgSigningKeyVer,
+						PrevMsgSigningKey:       prevKey,
+						PrevMsgSigningKeyVer:    prevKeyVer,
+						PrevMsgSigningKeyExpiry: prevKeyExpiry,
+						RegionCode:              gwInfo.RegionCode,
+						NetworkAddr:             gwInfo.NetworkAddr,
+						Deregistering:           gwInfo.Deregistering,
+						DeregisteringHeight:     gwInfo.DeregisteringHeight,
 					}
 					mgr.discoveredGWSLock.Unlock()
 					if gwInfo.Deregistering && mgr.reputationMgr != nil {
@@ -506,19 +543,28 @@ func (mgr *FCRPeerMgrImplV1) pvdSyncRoutine() {
 						update = true
 					}
 				}
+				var prevKey string
+				var prevKeyVer byte
+				var prevKeyExpiry int64
+				if update && ok {
+					prevKey, prevKeyVer, prevKeyExpiry = prevMsgSigningKey(storedInfo, pvdInfo.MsgSigningKey, pvdInfo.MsgSigningKeyVer)
+				}
 				mgr.discoveredPVDSLock.RUnlock()
 				if update {
 					mgr.discoveredPVDSLock.Lock()
 					mgr.discoveredPVDS[pvdInfo.NodeID] = &Peer{
-						RootKey:             pvdInfo.RootKey,
-						NodeID:              pvdInfo.NodeID,
-						MsgSigningKey:       pvdInfo.MsgSigningKey,
-						MsgSigningKeyVer:    pvdInfo.MsgSigningKeyVer,
-						OfferSigningKey:     pvdInfo.OfferSigningKey,
-						RegionCode:          pvdInfo.RegionCode,
-						NetworkAddr:         pvdInfo.NetworkAddr,
-						Deregistering:       pvdInfo.Deregistering,
-						DeregisteringHeight: pvdInfo.DeregisteringHeight,
+						RootKey:                 pvdInfo.RootKey,
+						NodeID:                  pvdInfo.NodeID,
+						MsgSigningKey:           pvdInfo.MsgSigningKey,
+						MsgSigningKeyVer:        pvdInfo.MsgSigningKeyVer,
+						PrevMsgSigningKey:       prevKey,
+						PrevMsgSigningKeyVer:    prevKeyVer,
+						PrevMsgSigningKeyExpiry: prevKeyExpiry,
+						OfferSigningKey:         pvdInfo.OfferSigningKey,
+						RegionCode:              pvdInfo.RegionCode,
+						NetworkAddr:             pvdInfo.NetworkAddr,
+						Deregistering:           pvdInfo.Deregistering,
+						DeregisteringHeight:     pvdInfo.DeregisteringHeight,
 					}
 					mgr.discoveredPVDSLock.Unlock()
 					if pvdInfo.Deregistering && mgr.reputationMgr != nil {
@@ -540,15 +586,27 @@ func (mgr *FCRPeerMgrImplV1) pvdSyncRoutine() {
 	}
 }
 
+// prevMsgSigningKey gets the previous msg signing key, key version and expiry of a stored peer after updating its msg signing key.
+func prevMsgSigningKey(stored *Peer, msgKey string, msgKeyVer byte) (string, byte, int64) {
+	if stored.MsgSigningKey == msgKey && stored.MsgSigningKeyVer == msgKeyVer {
+		// Not rotated, keep the current previous key
+		return stored.PrevMsgSigningKey, stored.PrevMsgSigningKeyVer, stored.PrevMsgSigningKeyExpiry
+	}
+	return stored.MsgSigningKey, stored.MsgSigningKeyVer, time.Now().Add(MsgKeyGracePeriod).Unix()
+}
+
 func (mgr *FCRPeerMgrImplV1) updateCIDHashRange() {
 	mgr.closestGatewaysIDsLock.RLock()
 	defer mgr.closestGatewaysIDsLock.RUnlock()
 	mgr.rangeLock.Lock()
 	defer mgr.rangeLock.Unlock()
-	res := mgr.closestGatewaysIDs.GetClosest(mgr.anchor, 16, mgr.anchor)
-	if len(res) < 16 {
+	res := mgr.closestGatewaysIDs.GetClosest(mgr.anchor, mgr.replicationFactor, mgr.anchor)
+	if mgr.replicationFactor <= 0 || len(res) < mgr.replicationFactor {
+		// Not enough gateways in the network, every cid is in range
+		mgr.hashMin = "0000000000000000000000000000000000000000000000000000000000000000"
+		mgr.hashMax = "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF"
 		return
 	}
 	mgr.hashMin = res[0]
-	mgr.hashMax = res[15]
+	mgr.hashMax = res[len(res)-1]
 }
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/register"
 )
@@ -379,7 +380,7 @@ func TestAutoSync(t *testing.T) {
 	err := mockReputationMgr.Start()
 	assert.Empty(t, err)
 	defer mockReputationMgr.Shutdown()
-	peerMgr := NewFCRPeerMgrImplV1(mockRegisterMgr, mockReputationMgr, true, true, true, "0000000000000000000000000000000000000000000000000000000000000009", time.Second)
+	peerMgr := NewFCRPeerMgrImplV1(mockRegisterMgr, mockReputationMgr, true, true, true, "0000000000000000000000000000000000000000000000000000000000000009", 16, time.Second)
 	// No effect before starting manager routine
 	peerMgr.Sync()
 	peerMgr.SyncGW("0000000000000000000000000000000000000000000000000000000000000000")
@@ -424,7 +425,7 @@ func TestSyncUpgrade(t *testing.T) {
 	err := mockReputationMgr.Start()
 	assert.Empty(t, err)
 	defer mockReputationMgr.Shutdown()
-	peerMgr := NewFCRPeerMgrImplV1(mockRegisterMgr, mockReputationMgr, true, true, true, "0000000000000000000000000000000000000000000000000000000000000009", time.Second)
+	peerMgr := NewFCRPeerMgrImplV1(mockRegisterMgr, mockReputationMgr, true, true, true, "0000000000000000000000000000000000000000000000000000000000000009", 16, time.Second)
 	err = peerMgr.Start()
 	assert.Empty(t, err)
 	defer peerMgr.Shutdown()
@@ -457,6 +458,9 @@ func TestSyncUpgrade(t *testing.T) {
 	peerMgr.Sync()
 	peer = peerMgr.GetGWInfo("0000000000000000000000000000000000000000000000000000000000000002")
 	assert.Equal(t, byte(1), peer.MsgSigningKeyVer)
+	assert.Equal(t, mockRegisterMgr.gws[0][2].MsgSigningKey, peer.PrevMsgSigningKey)
+	assert.Equal(t, byte(4), peer.PrevMsgSigningKeyVer)
+	assert.Less(t, time.Now().Unix(), peer.PrevMsgSigningKeyExpiry)
 	// Test remove gw entry
 	mockRegisterMgr.gws[0] = append(mockRegisterMgr.gws[0][:2], mockRegisterMgr.gws[0][3:]...)
 	peerMgr.Sync()
@@ -473,6 +477,7 @@ func TestSyncUpgrade(t *testing.T) {
 	peerMgr.Sync()
 	peer = peerMgr.GetPVDInfo("0000000000000000000000000000000000000000000000000000000000000014")
 	assert.Equal(t, byte(1), peer.MsgSigningKeyVer)
+	assert.Equal(t, byte(22), peer.PrevMsgSigningKeyVer)
 	// Test remove pvd entry
 	mockRegisterMgr.pvds[0] = mockRegisterMgr.pvds[0][1:]
 	peerMgr.Sync()
@@ -486,7 +491,7 @@ func TestSyncSingle(t *testing.T) {
 	err := mockReputationMgr.Start()
 	assert.Empty(t, err)
 	defer mockReputationMgr.Shutdown()
-	peerMgr := NewFCRPeerMgrImplV1(mockRegisterMgr, mockReputationMgr, true, true, true, "0000000000000000000000000000000000000000000000000000000000000009", time.Second)
+	peerMgr := NewFCRPeerMgrImplV1(mockRegisterMgr, mockReputationMgr, true, true, true, "0000000000000000000000000000000000000000000000000000000000000009", 16, time.Second)
 	err = peerMgr.Start()
 	assert.Empty(t, err)
 	defer peerMgr.Shutdown()
@@ -497,6 +502,10 @@ func TestSyncSingle(t *testing.T) {
 	peerMgr.SyncGW("0000000000000000000000000000000000000000000000000000000000000002")
 	peer = peerMgr.GetGWInfo("0000000000000000000000000000000000000000000000000000000000000002")
 	assert.Equal(t, byte(1), peer.MsgSigningKeyVer)
+	assert.Equal(t, byte(4), peer.PrevMsgSigningKeyVer)
+	// Sync again without an update keeps the previous key
+	peer = peerMgr.SyncGW("0000000000000000000000000000000000000000000000000000000000000002")
+	assert.Equal(t, byte(4), peer.PrevMsgSigningKeyVer)
 	// Test remove gw entry
 	tempgw := mockRegisterMgr.gws[0][2]
 	mockRegisterMgr.gws[0] = append(mockRegisterMgr.gws[0][:2], mockRegisterMgr.gws[0][3:]...)
@@ -513,6 +522,7 @@ func TestSyncSingle(t *testing.T) {
 	mockRegisterMgr.pvds[0][0].MsgSigningKeyVer = 1
 	peer = peerMgr.SyncPVD("0000000000000000000000000000000000000000000000000000000000000014")
 	assert.Equal(t, byte(1), peer.MsgSigningKeyVer)
+	assert.Equal(t, byte(22), peer.PrevMsgSigningKeyVer)
 	// Test remove pvd entry
 	temppvd := mockRegisterMgr.pvds[0][0]
 	mockRegisterMgr.pvds[0] = mockRegisterMgr.pvds[0][1:]
@@ -527,3 +537,58 @@ func TestSyncSingle(t *testing.T) {
 	peers := peerMgr.ListGWS()
 	assert.Equal(t, 20, len(peers))
 }
+
+func TestVerifyMsg(t *testing.T) {
+	prevPrivKey, prevPubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	privKey, pubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	peer := &Peer{
+		MsgSigningKey:           pubKey,
+		MsgSigningKeyVer:        2,
+		PrevMsgSigningKey:       prevPubKey,
+		PrevMsgSigningKeyVer:    1,
+		PrevMsgSigningKeyExpiry: time.Now().Add(time.Minute).Unix(),
+	}
+	data := []byte("test message")
+	verify := func(sig string) func(string, byte) error {
+		return func(pubKey string, keyVer byte) error {
+			return fcrcrypto.Verify(pubKey, keyVer, sig, data)
+		}
+	}
+	sig, err := fcrcrypto.Sign(privKey, 2, data)
+	assert.Empty(t, err)
+	assert.Empty(t, peer.VerifyMsg(verify(sig)))
+	prevSig, err := fcrcrypto.Sign(prevPrivKey, 1, data)
+	assert.Empty(t, err)
+	assert.Empty(t, peer.VerifyMsg(verify(prevSig)))
+	wrongSig, err := fcrcrypto.Sign(prevPrivKey, 2, data)
+	assert.Empty(t, err)
+	assert.NotEmpty(t, peer.VerifyMsg(verify(wrongSig)))
+	// The previous key expires
+	peer.PrevMsgSigningKeyExpiry = time.Now().Add(-time.Minute).Unix()
+	assert.NotEmpty(t, peer.VerifyMsg(verify(prevSig)))
+	assert.Empty(t, peer.VerifyMsg(verify(sig)))
+}
+
+func TestReplicationFactor(t *testing.T) {
+	mockRegisterMgr := newMockRegister()
+	peerMgr := NewFCRPeerMgrImplV1(mockRegisterMgr, nil, true, false, true, "0000000000000000000000000000000000000000000000000000000000000009", 4, time.Second)
+	err := peerMgr.Start()
+	assert.Empty(t, err)
+	defer peerMgr.Shutdown()
+	peerMgr.Sync()
+	min, max := peerMgr.GetCurrentCIDHashRange()
+	assert.Equal(t, "0000000000000000000000000000000000000000000000000000000000000007", min)
+	assert.Equal(t, "000000000000000000000000000000000000000000000000000000000000000b", max)
+
+	// Not enough gateways to replicate, every cid is in range
+	peerMgr = NewFCRPeerMgrImplV1(mockRegisterMgr, nil, true, false, true, "0000000000000000000000000000000000000000000000000000000000000009", 100, time.Second)
+	err = peerMgr.Start()
+	assert.Empty(t, err)
+	defer peerMgr.Shutdown()
+	peerMgr.Sync()
+	min, max = peerMgr.GetCurrentCIDHashRange()
+	assert.Equal(t, "0000000000000000000000000000000000000000000000000000000000000000", min)
+	assert.Equal(t, "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", max)
+}
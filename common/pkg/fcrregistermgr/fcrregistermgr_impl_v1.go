@@ -22,7 +22,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -63,8 +66,14 @@ func (mgr *FCRRegisterMgrImplV1) RegisterGateway(id string, gwInfo *register.Gat
 }
 
 func (mgr *FCRRegisterMgrImplV1) UpdateGateway(id string, gwInfo *register.GatewayRegisteredInfo) error {
-	// Not implemented
-	return errors.New("No implementation")
+	if gwInfo == nil || gwInfo.NodeID != id {
+		return fmt.Errorf("Gateway information does not match gateway %v", id)
+	}
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	// Registering an existing gateway overwrites its entry
+	url := mgr.registerAPI + "/registers/gateway"
+	return SendJSON(url, mgr.client, gwInfo)
 }
 
 func (mgr *FCRRegisterMgrImplV1) RequestDeregisterGateway(id string) error {
@@ -84,9 +93,15 @@ func (mgr *FCRRegisterMgrImplV1) RegisterProvider(id string, pvdInfo *register.P
 	return SendJSON(url, mgr.client, pvdInfo)
 }
 
-func (mgr *FCRRegisterMgrImplV1) UpdateProvider(id string, gwInfo *register.ProviderRegisteredInfo) error {
-	// Not implemented
-	return errors.New("No implementation")
+func (mgr *FCRRegisterMgrImplV1) UpdateProvider(id string, pvdInfo *register.ProviderRegisteredInfo) error {
+	if pvdInfo == nil || pvdInfo.NodeID != id {
+		return fmt.Errorf("Provider information does not match provider %v", id)
+	}
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	// Registering an existing provider overwrites its entry
+	url := mgr.registerAPI + "/registers/provider"
+	return SendJSON(url, mgr.client, pvdInfo)
 }
 
 func (mgr *FCRRegisterMgrImplV1) RequestDeregisterProvider(id string) error {
@@ -148,21 +163,85 @@ func (mgr *FCRRegisterMgrImplV1) GetRegisteredProviderByID(id string) (*register
 }
 
 func (mgr *FCRRegisterMgrImplV1) GetGWMaxPageByRegion(height uint64, region string) (uint64, error) {
-	return 0, nil
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	// Only the count is needed, request the first page to keep the response small
+	page := uint64(0)
+	total, err := getTotalCount(mgr.registerAPI+"/registers/gateway?"+regionQuery(region, &page), mgr.client)
+	if err != nil {
+		return 0, err
+	}
+	return maxPage(total), nil
 }
 
 func (mgr *FCRRegisterMgrImplV1) GetPVDMaxPageByRegion(height uint64, region string) (uint64, error) {
-	return 0, nil
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	// Only the count is needed, request the first page to keep the response small
+	page := uint64(0)
+	total, err := getTotalCount(mgr.registerAPI+"/registers/provider?"+regionQuery(region, &page), mgr.client)
+	if err != nil {
+		return 0, err
+	}
+	return maxPage(total), nil
 }
 
 func (mgr *FCRRegisterMgrImplV1) GetRegisteredGatewaysByRegion(height uint64, region string, page uint64) ([]register.GatewayRegisteredInfo, error) {
-	// Not implemented
-	return nil, errors.New("No implementation")
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	url := mgr.registerAPI + "/registers/gateway?" + regionQuery(region, &page)
+	var gateways []register.GatewayRegisteredInfo
+	err := GetJSON(url, mgr.client, &gateways)
+	if err != nil {
+		return gateways, err
+	}
+	return gateways, nil
 }
 
 func (mgr *FCRRegisterMgrImplV1) GetRegisteredProvidersByRegion(height uint64, region string, page uint64) ([]register.ProviderRegisteredInfo, error) {
-	// Not implemented
-	return nil, errors.New("No implementation")
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	url := mgr.registerAPI + "/registers/provider?" + regionQuery(region, &page)
+	var providers []register.ProviderRegisteredInfo
+	err := GetJSON(url, mgr.client, &providers)
+	if err != nil {
+		return providers, err
+	}
+	return providers, nil
+}
+
+// regionQuery gets the query string of a register query for a given region, at a given page if not nil.
+func regionQuery(region string, page *uint64) string {
+	query := url.Values{}
+	query.Set("region", region)
+	if page != nil {
+		query.Set("page", strconv.FormatUint(*page, 10))
+	}
+	return query.Encode()
+}
+
+// maxPage gets the maximum page of a register list with a given number of entries.
+func maxPage(total int) uint64 {
+	if total == 0 {
+		return 0
+	}
+	return uint64((total - 1) / register.PageSize)
+}
+
+// getTotalCount gets the number of entries across all pages of a register list, from the header of a given register query.
+func getTotalCount(url string, client *http.Client) (int, error) {
+	r, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	if closeErr := r.Body.Close(); closeErr != nil {
+		return 0, closeErr
+	}
+	total, err := strconv.Atoi(r.Header.Get(register.TotalCountHeader))
+	if err != nil {
+		return 0, fmt.Errorf("Error in parsing total count header: %v", err.Error())
+	}
+	return total, nil
 }
 
 // GetJSON request Get JSON
@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
@@ -89,6 +90,66 @@ func TestRegisterProvider(t *testing.T) {
 	assert.Empty(t, err)
 }
 
+func TestUpdateGateway(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/registers/gateway", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+		target := register.GatewayRegisteredInfo{}
+		err := json.NewDecoder(r.Body).Decode(&target)
+		assert.Empty(t, err)
+		assert.Equal(t, "256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11", target.NodeID)
+		assert.Equal(t, "04b0b3c4ad6f8e27d3fb3dfd2f0c0e4dd5cd2d7c8bb6fa4f6ab3c6f7e13fb7e5d8d7ab6e51a8d8b4d4e5e2e0c5c0f7fa2b8dd9fd8a1c73ae2d2b8e6e4d5c8b7a6f", target.MsgSigningKey)
+		assert.Equal(t, byte(2), target.MsgSigningKeyVer)
+	}))
+	defer ts.Close()
+	// Initialise a manager
+	mgr := NewFCRRegisterMgrImplV1(ts.URL, &http.Client{Timeout: 180 * time.Second})
+
+	gwInfo := &register.GatewayRegisteredInfo{
+		RootKey:          "0496a1a3c388b63a577d7c6661cf615ede5c1d7c5545dbd9f3745ef81e8dbfbdb63139b78ecdc2d44982782f00bdaa1f77463a052debe93c86947f81d59bff2d16",
+		NodeID:           "256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11",
+		MsgSigningKey:    "04b0b3c4ad6f8e27d3fb3dfd2f0c0e4dd5cd2d7c8bb6fa4f6ab3c6f7e13fb7e5d8d7ab6e51a8d8b4d4e5e2e0c5c0f7fa2b8dd9fd8a1c73ae2d2b8e6e4d5c8b7a6f",
+		MsgSigningKeyVer: 2,
+		RegionCode:       "au",
+		NetworkAddr:      "testaddr",
+	}
+	err := mgr.UpdateGateway("256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11", gwInfo)
+	assert.Empty(t, err)
+	err = mgr.UpdateGateway("test", gwInfo)
+	assert.NotEmpty(t, err)
+}
+
+func TestUpdateProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/registers/provider", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+		target := register.ProviderRegisteredInfo{}
+		err := json.NewDecoder(r.Body).Decode(&target)
+		assert.Empty(t, err)
+		assert.Equal(t, "256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11", target.NodeID)
+		assert.Equal(t, "04b0b3c4ad6f8e27d3fb3dfd2f0c0e4dd5cd2d7c8bb6fa4f6ab3c6f7e13fb7e5d8d7ab6e51a8d8b4d4e5e2e0c5c0f7fa2b8dd9fd8a1c73ae2d2b8e6e4d5c8b7a6f", target.MsgSigningKey)
+		assert.Equal(t, byte(2), target.MsgSigningKeyVer)
+		assert.Equal(t, "0496a1a3c388b63a577d7c6661cf615ede5c1d7c5545dbd9f3745ef81e8dbfbdb63139b78ecdc2d44982782f00bdaa1f77463a052debe93c86947f81d59bff2d16", target.OfferSigningKey)
+	}))
+	defer ts.Close()
+	// Initialise a manager
+	mgr := NewFCRRegisterMgrImplV1(ts.URL, &http.Client{Timeout: 180 * time.Second})
+
+	pvdInfo := &register.ProviderRegisteredInfo{
+		RootKey:          "0496a1a3c388b63a577d7c6661cf615ede5c1d7c5545dbd9f3745ef81e8dbfbdb63139b78ecdc2d44982782f00bdaa1f77463a052debe93c86947f81d59bff2d16",
+		NodeID:           "256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11",
+		MsgSigningKey:    "04b0b3c4ad6f8e27d3fb3dfd2f0c0e4dd5cd2d7c8bb6fa4f6ab3c6f7e13fb7e5d8d7ab6e51a8d8b4d4e5e2e0c5c0f7fa2b8dd9fd8a1c73ae2d2b8e6e4d5c8b7a6f",
+		MsgSigningKeyVer: 2,
+		OfferSigningKey:  "0496a1a3c388b63a577d7c6661cf615ede5c1d7c5545dbd9f3745ef81e8dbfbdb63139b78ecdc2d44982782f00bdaa1f77463a052debe93c86947f81d59bff2d16",
+		RegionCode:       "au",
+		NetworkAddr:      "testaddr",
+	}
+	err := mgr.UpdateProvider("256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11", pvdInfo)
+	assert.Empty(t, err)
+	err = mgr.UpdateProvider("test", pvdInfo)
+	assert.NotEmpty(t, err)
+}
+
 func TestGetAllRegisteredGateway(t *testing.T) {
 	gwInfo0 := &register.GatewayRegisteredInfo{
 		RootKey:          "0496a1a3c388b63a577d7c6661cf615ede5c1d7c5545dbd9f3745ef81e8dbfbdb63139b78ecdc2d44982782f00bdaa1f77463a052debe93c86947f81d59bff2d16",
@@ -258,20 +319,93 @@ func TestUnimplemented(t *testing.T) {
 	maxPage, err = mgr.GetPVDMaxPage(height)
 	assert.Empty(t, err)
 	assert.Equal(t, uint64(0), maxPage)
-	maxPage, err = mgr.GetGWMaxPageByRegion(height, "au")
-	assert.Empty(t, err)
-	assert.Equal(t, uint64(0), maxPage)
-	maxPage, err = mgr.GetPVDMaxPageByRegion(height, "au")
-	assert.Empty(t, err)
-	assert.Equal(t, uint64(0), maxPage)
 	assert.NotEmpty(t, mgr.UpdateGateway("test", nil))
 	assert.NotEmpty(t, mgr.RequestDeregisterGateway("test"))
 	assert.NotEmpty(t, mgr.DeregisterGateway("test"))
 	assert.NotEmpty(t, mgr.UpdateProvider("test", nil))
 	assert.NotEmpty(t, mgr.RequestDeregisterProvider("test"))
 	assert.NotEmpty(t, mgr.DeregisterProvider("test"))
-	_, err = mgr.GetRegisteredGatewaysByRegion(0, "test", 0)
-	assert.NotEmpty(t, err)
-	_, err = mgr.GetRegisteredProvidersByRegion(0, "test", 0)
-	assert.NotEmpty(t, err)
+}
+
+func TestGetRegisteredGatewaysByRegion(t *testing.T) {
+	gwInfo := register.GatewayRegisteredInfo{
+		NodeID:      "256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11",
+		RegionCode:  "au",
+		NetworkAddr: "testaddr0",
+	}
+	gws := make([]register.GatewayRegisteredInfo, register.PageSize+1)
+	for i := range gws {
+		gws[i] = gwInfo
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/registers/gateway", r.URL.Path)
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "au", r.URL.Query().Get("region"))
+		var res []register.GatewayRegisteredInfo
+		switch r.URL.Query().Get("page") {
+		case "":
+			t.Errorf("Full register list requested")
+		case "0":
+			res = gws[:register.PageSize]
+		case "1":
+			res = gws[register.PageSize:]
+		default:
+			res = []register.GatewayRegisteredInfo{}
+		}
+		w.Header().Set(register.TotalCountHeader, strconv.Itoa(len(gws)))
+		data, err := json.Marshal(res)
+		assert.Empty(t, err)
+		_, err = w.Write(data)
+		assert.Empty(t, err)
+	}))
+	defer ts.Close()
+	// Initialise a manager
+	mgr := NewFCRRegisterMgrImplV1(ts.URL, &http.Client{Timeout: 180 * time.Second})
+
+	maxPage, err := mgr.GetGWMaxPageByRegion(0, "au")
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(1), maxPage)
+	res, err := mgr.GetRegisteredGatewaysByRegion(0, "au", 1)
+	assert.Empty(t, err)
+	assert.Equal(t, []register.GatewayRegisteredInfo{gwInfo}, res)
+	res, err = mgr.GetRegisteredGatewaysByRegion(0, "au", 2)
+	assert.Empty(t, err)
+	assert.Equal(t, 0, len(res))
+}
+
+func TestGetRegisteredProvidersByRegion(t *testing.T) {
+	pvdInfo := register.ProviderRegisteredInfo{
+		NodeID:      "256a237ce1f8abac72728ac8f2edbe4a436ff1f898cd2e8ff869899e9bd92d11",
+		RegionCode:  "us",
+		NetworkAddr: "testaddr0",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/registers/provider", r.URL.Path)
+		assert.Equal(t, "GET", r.Method)
+		res := []register.ProviderRegisteredInfo{}
+		if r.URL.Query().Get("region") == "us" {
+			res = append(res, pvdInfo)
+		}
+		w.Header().Set(register.TotalCountHeader, strconv.Itoa(len(res)))
+		data, err := json.Marshal(res)
+		assert.Empty(t, err)
+		_, err = w.Write(data)
+		assert.Empty(t, err)
+	}))
+	defer ts.Close()
+	// Initialise a manager
+	mgr := NewFCRRegisterMgrImplV1(ts.URL, &http.Client{Timeout: 180 * time.Second})
+
+	maxPage, err := mgr.GetPVDMaxPageByRegion(0, "au")
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(0), maxPage)
+	maxPage, err = mgr.GetPVDMaxPageByRegion(0, "us")
+	assert.Empty(t, err)
+	assert.Equal(t, uint64(0), maxPage)
+	res, err := mgr.GetRegisteredProvidersByRegion(0, "us", 0)
+	assert.Empty(t, err)
+	assert.Equal(t, []register.ProviderRegisteredInfo{pvdInfo}, res)
+	res, err = mgr.GetRegisteredProvidersByRegion(0, "au", 0)
+	assert.Empty(t, err)
+	assert.Equal(t, 0, len(res))
 }
@@ -0,0 +1,76 @@
+/*
+Package fcrrenewmgr - renew manager renews the published offers of a provider before they expire and republishes them to gateways.
+*/
+package fcrrenewmgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+)
+
+// FCRRenewMgr represents the manager that tracks the published offers of a provider, renews them before they expire
+// and republishes them to gateways newly joined the DHT range of their cids.
+// A renewed offer is published to the gateways closest to its cids, and the superseded offer is revoked from the gateways holding it.
+type FCRRenewMgr interface {
+	// Start starts the manager's routine.
+	Start() error
+
+	// Shutdown ends the manager's routine safely.
+	Shutdown()
+
+	// Check forces the manager to check the tracked offers against their policies.
+	Check()
+
+	// Track tracks a published offer with a given renewal policy and the IDs of the gateways it has been published to.
+	// It returns error if the policy is invalid.
+	Track(offer *cidoffer.CIDOffer, policy RenewPolicy, publishedTo []string) error
+
+	// Untrack stops tracking the offer with a given digest.
+	Untrack(digest string)
+
+	// SetPolicy sets the renewal policy of the tracked offer with a given digest.
+	// It returns error if the offer is not tracked or the policy is invalid.
+	SetPolicy(digest string, policy RenewPolicy) error
+
+	// GetPolicy gets the renewal policy of the tracked offer with a given digest, nil if the offer is not tracked.
+	GetPolicy(digest string) *RenewPolicy
+}
+
+// Publisher publishes a given offer to a given gateway.
+type Publisher func(offer *cidoffer.CIDOffer, gw fcrpeermgr.Peer) error
+
+// Revoker revokes the offer with a given digest from a given gateway.
+type Revoker func(digest string, gw fcrpeermgr.Peer) error
+
+// RenewPolicy represents how an offer is renewed.
+type RenewPolicy struct {
+	// Renew indicates whether the offer is renewed before it expires.
+	Renew bool
+
+	// RenewBefore is the duration before expiry within which the offer is renewed.
+	RenewBefore time.Duration
+
+	// Period is the duration the renewed offer is valid for, it must be longer than RenewBefore.
+	Period time.Duration
+
+	// Price is the price of the renewed offer, nil to keep the current price.
+	Price *big.Int
+}
@@ -0,0 +1,404 @@
+/*
+Package fcrrenewmgr - renew manager renews the published offers of a provider before they expire and republishes them to gateways.
+*/
+package fcrrenewmgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// FCRRenewMgrImplV1 implements FCRRenewMgr, it is an in-memory version.
+type FCRRenewMgrImplV1 struct {
+	// Boolean indicates if the manager has started
+	start bool
+
+	// Node ID and offer signing key of this provider
+	nodeID          string
+	offerSigningKey string
+
+	offerMgr  fcroffermgr.FCROfferMgr
+	peerMgr   fcrpeermgr.FCRPeerMgr
+	publisher Publisher
+	revoker   Revoker
+
+	// Number of gateways closest to a cid that an offer is published to
+	replicationFactor int
+
+	// Duration to wait between two checks
+	checkDuration time.Duration
+
+	// Channels to control the thread
+	shutdownCh chan bool
+	checkCh    chan bool
+
+	// Tracked offers, it is not locked while offers are published or revoked
+	tracked     map[string]*trackedOffer
+	trackedLock sync.Mutex
+
+	// store persists the tracked offers, nil if they are only kept in memory
+	store trackedStore
+}
+
+// trackedOffer is a published offer being tracked.
+type trackedOffer struct {
+	offer  *cidoffer.CIDOffer
+	policy RenewPolicy
+
+	// IDs of gateways the offer has been published to
+	publishedTo map[string]bool
+}
+
+// trackedStore persists the tracked offers, it is called with the tracked lock held.
+type trackedStore interface {
+	// saveTracked saves a tracked offer with a given digest.
+	saveTracked(digest string, tracked *trackedOffer)
+
+	// removeTracked removes the tracked offer with a given digest.
+	removeTracked(digest string)
+}
+
+func NewFCRRenewMgrImplV1(nodeID string, offerSigningKey string, offerMgr fcroffermgr.FCROfferMgr, peerMgr fcrpeermgr.FCRPeerMgr, publisher Publisher, revoker Revoker, replicationFactor int, checkDuration time.Duration) FCRRenewMgr {
+	return &FCRRenewMgrImplV1{
+		start:             false,
+		nodeID:            nodeID,
+		offerSigningKey:   offerSigningKey,
+		offerMgr:          offerMgr,
+		peerMgr:           peerMgr,
+		publisher:         publisher,
+		revoker:           revoker,
+		replicationFactor: replicationFactor,
+		checkDuration:     checkDuration,
+		shutdownCh:        make(chan bool),
+		checkCh:           make(chan bool),
+		tracked:           make(map[string]*trackedOffer),
+		trackedLock:       sync.Mutex{},
+		store:             nil,
+	}
+}
+
+func (mgr *FCRRenewMgrImplV1) Start() error {
+	if mgr.start {
+		return errors.New("FCRRenewManager has already started")
+	}
+	mgr.start = true
+	go mgr.checkRoutine()
+	return nil
+}
+
+func (mgr *FCRRenewMgrImplV1) Shutdown() {
+	if !mgr.start {
+		return
+	}
+	mgr.shutdownCh <- true
+	<-mgr.shutdownCh
+	mgr.start = false
+}
+
+func (mgr *FCRRenewMgrImplV1) Check() {
+	if !mgr.start {
+		return
+	}
+	mgr.checkCh <- true
+	<-mgr.checkCh
+}
+
+func (mgr *FCRRenewMgrImplV1) Track(offer *cidoffer.CIDOffer, policy RenewPolicy, publishedTo []string) error {
+	if err := validatePolicy(policy); err != nil {
+		return err
+	}
+	tracked := &trackedOffer{
+		offer:       offer,
+		policy:      copyPolicy(policy),
+		publishedTo: make(map[string]bool),
+	}
+	for _, gwID := range publishedTo {
+		tracked.publishedTo[gwID] = true
+	}
+	mgr.trackedLock.Lock()
+	defer mgr.trackedLock.Unlock()
+	mgr.tracked[offer.GetMessageDigest()] = tracked
+	mgr.save(offer.GetMessageDigest(), tracked)
+	return nil
+}
+
+func (mgr *FCRRenewMgrImplV1) Untrack(digest string) {
+	mgr.trackedLock.Lock()
+	defer mgr.trackedLock.Unlock()
+	delete(mgr.tracked, digest)
+	mgr.remove(digest)
+}
+
+func (mgr *FCRRenewMgrImplV1) SetPolicy(digest string, policy RenewPolicy) error {
+	if err := validatePolicy(policy); err != nil {
+		return err
+	}
+	mgr.trackedLock.Lock()
+	defer mgr.trackedLock.Unlock()
+	tracked, ok := mgr.tracked[digest]
+	if !ok {
+		return fmt.Errorf("Offer %v is not tracked", digest)
+	}
+	tracked.policy = copyPolicy(policy)
+	mgr.save(digest, tracked)
+	return nil
+}
+
+func (mgr *FCRRenewMgrImplV1) GetPolicy(digest string) *RenewPolicy {
+	mgr.trackedLock.Lock()
+	defer mgr.trackedLock.Unlock()
+	tracked, ok := mgr.tracked[digest]
+	if !ok {
+		return nil
+	}
+	policy := copyPolicy(tracked.policy)
+	return &policy
+}
+
+// checkRoutine checks the tracked offers periodically or when forced.
+func (mgr *FCRRenewMgrImplV1) checkRoutine() {
+	for {
+		forced := false
+		afterChan := time.After(mgr.checkDuration)
+		select {
+		case <-mgr.checkCh:
+			// Need to check
+			logging.Info("FCRRenewManager force check.")
+			forced = true
+		case <-afterChan:
+			// Need to check
+		case <-mgr.shutdownCh:
+			// Need to shutdown
+			logging.Info("FCRRenewManager shutdown checking routine.")
+			mgr.shutdownCh <- true
+			return
+		}
+		mgr.checkAll()
+		if forced {
+			mgr.checkCh <- true
+		}
+	}
+}
+
+// checkAll renews the tracked offers about to expire, and republishes the others to the gateways not yet holding them.
+// The tracked offers are checked on a snapshot, so that the lock is not held while offers are published or revoked.
+func (mgr *FCRRenewMgrImplV1) checkAll() {
+	mgr.trackedLock.Lock()
+	snapshot := make(map[string]*trackedOffer)
+	for digest, tracked := range mgr.tracked {
+		// An expired offer is renewed if it has a renewal policy, otherwise it is dropped
+		if !tracked.policy.Renew && tracked.offer.HasExpired() {
+			logging.Info("FCRRenewManager stop tracking expired offer %v", digest)
+			delete(mgr.tracked, digest)
+			mgr.remove(digest)
+			continue
+		}
+		snapshot[digest] = copyTracked(tracked)
+	}
+	mgr.trackedLock.Unlock()
+
+	for digest, tracked := range snapshot {
+		if tracked.policy.Renew && time.Until(time.Unix(tracked.offer.GetExpiry(), 0)) <= tracked.policy.RenewBefore {
+			renewed, err := mgr.renew(tracked)
+			if err != nil {
+				logging.Error("FCRRenewManager fail to renew offer %v: %v", digest, err.Error())
+				continue
+			}
+			if !mgr.replace(digest, renewed) {
+				// The offer has been untracked while the renewed offer was published
+				logging.Info("FCRRenewManager drop renewed offer %v of untracked offer %v", renewed.offer.GetMessageDigest(), digest)
+				mgr.revoke(renewed.offer.GetMessageDigest(), renewed.publishedTo)
+				continue
+			}
+			logging.Info("FCRRenewManager renewed offer %v to %v, published to %v gateways", digest, renewed.offer.GetMessageDigest(), len(renewed.publishedTo))
+			// The gateways no longer serve the superseded offer
+			mgr.revoke(digest, tracked.publishedTo)
+			continue
+		}
+		mgr.republish(digest, tracked)
+	}
+}
+
+// renew creates and signs a renewed offer of a tracked offer by its policy, then publishes it to the gateways
+// closest to its cids, as many as the replication factor.
+func (mgr *FCRRenewMgrImplV1) renew(tracked *trackedOffer) (*trackedOffer, error) {
+	price := tracked.policy.Price
+	if price == nil {
+		price = tracked.offer.GetPrice()
+	}
+	offer, err := cidoffer.NewCIDOffer(mgr.nodeID, tracked.offer.GetCIDs(), price, time.Now().Add(tracked.policy.Period).Unix(), tracked.offer.GetQoS())
+	if err != nil {
+		return nil, fmt.Errorf("Error creating offer: %v", err.Error())
+	}
+	err = offer.Sign(mgr.offerSigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error signing offer: %v", err.Error())
+	}
+	renewed := &trackedOffer{
+		offer:       offer,
+		policy:      tracked.policy,
+		publishedTo: make(map[string]bool),
+	}
+	for gwID, gw := range mgr.nearGWS(offer, renewed.publishedTo) {
+		err = mgr.publisher(offer, gw)
+		if err != nil {
+			logging.Warn("FCRRenewManager fail to publish renewed offer %v to gateway %v: %v", offer.GetMessageDigest(), gwID, err.Error())
+			continue
+		}
+		renewed.publishedTo[gwID] = true
+	}
+	return renewed, nil
+}
+
+// replace replaces the tracked offer with a given digest by a renewed offer, in the tracked offers and in the offer manager.
+// It returns false if the offer is no longer tracked. The renewed offer keeps the current policy of the offer.
+func (mgr *FCRRenewMgrImplV1) replace(digest string, renewed *trackedOffer) bool {
+	mgr.trackedLock.Lock()
+	defer mgr.trackedLock.Unlock()
+	tracked, ok := mgr.tracked[digest]
+	if !ok {
+		return false
+	}
+	renewed.policy = tracked.policy
+	delete(mgr.tracked, digest)
+	mgr.remove(digest)
+	mgr.tracked[renewed.offer.GetMessageDigest()] = renewed
+	mgr.save(renewed.offer.GetMessageDigest(), renewed)
+	mgr.offerMgr.AddOffer(renewed.offer)
+	mgr.offerMgr.RemoveOffer(digest)
+	return true
+}
+
+// revoke revokes the offer with a given digest from the gateways with given IDs.
+func (mgr *FCRRenewMgrImplV1) revoke(digest string, gwIDs map[string]bool) {
+	for gwID := range gwIDs {
+		gw := mgr.peerMgr.GetGWInfo(gwID)
+		if gw == nil {
+			continue
+		}
+		err := mgr.revoker(digest, *gw)
+		if err != nil {
+			logging.Warn("FCRRenewManager fail to revoke offer %v from gateway %v: %v", digest, gwID, err.Error())
+		}
+	}
+}
+
+// republish publishes a tracked offer to the gateways closest to its cids that it has not been published to,
+// as many as the replication factor.
+func (mgr *FCRRenewMgrImplV1) republish(digest string, tracked *trackedOffer) {
+	publishedTo := make([]string, 0)
+	for gwID, gw := range mgr.nearGWS(tracked.offer, tracked.publishedTo) {
+		err := mgr.publisher(tracked.offer, gw)
+		if err != nil {
+			logging.Warn("FCRRenewManager fail to republish offer %v to gateway %v: %v", digest, gwID, err.Error())
+			continue
+		}
+		publishedTo = append(publishedTo, gwID)
+		logging.Info("FCRRenewManager republished offer %v to gateway %v", digest, gwID)
+	}
+	if len(publishedTo) == 0 {
+		return
+	}
+	mgr.trackedLock.Lock()
+	defer mgr.trackedLock.Unlock()
+	current, ok := mgr.tracked[digest]
+	if !ok {
+		return
+	}
+	for _, gwID := range publishedTo {
+		current.publishedTo[gwID] = true
+	}
+	mgr.save(digest, current)
+}
+
+// nearGWS gets the gateways closest to the cids of a given offer, as many as the replication factor for each cid,
+// except the gateways with given IDs.
+func (mgr *FCRRenewMgrImplV1) nearGWS(offer *cidoffer.CIDOffer, except map[string]bool) map[string]fcrpeermgr.Peer {
+	gws := make(map[string]fcrpeermgr.Peer)
+	for _, id := range offer.GetCIDs() {
+		cidHash, err := id.CalculateHash()
+		if err != nil {
+			logging.Error("FCRRenewManager fail to get cid hash for %v: %v", id.ToString(), err.Error())
+			continue
+		}
+		for _, gw := range mgr.peerMgr.GetGWSNearCIDHash(hex.EncodeToString(cidHash), mgr.replicationFactor, "") {
+			if !except[gw.NodeID] {
+				gws[gw.NodeID] = gw
+			}
+		}
+	}
+	return gws
+}
+
+// save saves a tracked offer to the store, if there is one.
+func (mgr *FCRRenewMgrImplV1) save(digest string, tracked *trackedOffer) {
+	if mgr.store != nil {
+		mgr.store.saveTracked(digest, tracked)
+	}
+}
+
+// remove removes a tracked offer from the store, if there is one.
+func (mgr *FCRRenewMgrImplV1) remove(digest string) {
+	if mgr.store != nil {
+		mgr.store.removeTracked(digest)
+	}
+}
+
+// validatePolicy checks if a given policy renews an offer for longer than the duration before expiry it is renewed.
+func validatePolicy(policy RenewPolicy) error {
+	if !policy.Renew {
+		return nil
+	}
+	if policy.Period <= policy.RenewBefore {
+		return fmt.Errorf("Renewal period %v is not longer than %v", policy.Period, policy.RenewBefore)
+	}
+	if policy.Price != nil && policy.Price.Sign() < 0 {
+		return fmt.Errorf("Renewal price %v is negative", policy.Price.String())
+	}
+	return nil
+}
+
+// copyPolicy gets a copy of a given policy.
+func copyPolicy(policy RenewPolicy) RenewPolicy {
+	if policy.Price != nil {
+		policy.Price = big.NewInt(0).Set(policy.Price)
+	}
+	return policy
+}
+
+// copyTracked gets a copy of a given tracked offer.
+func copyTracked(tracked *trackedOffer) *trackedOffer {
+	res := &trackedOffer{
+		offer:       tracked.offer,
+		policy:      copyPolicy(tracked.policy),
+		publishedTo: make(map[string]bool),
+	}
+	for gwID := range tracked.publishedTo {
+		res.publishedTo[gwID] = true
+	}
+	return res
+}
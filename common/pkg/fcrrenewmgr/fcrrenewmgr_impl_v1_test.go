@@ -0,0 +1,196 @@
+/*
+Package fcrrenewmgr - renew manager renews the published offers of a provider before they expire and republishes them to gateways.
+*/
+package fcrrenewmgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+)
+
+const (
+	CID1   = "QmWJi2BHLpKpCnD3sA3jcSWv5M51D6Zf1WY4rN8BrQtCgi"
+	CID2   = "QmVPhUbiWEoFJ26p4uZveuMhnZvVuFx9Drras6FyD8aw22"
+	NodeID = "self"
+)
+
+// mockPeerMgr only implements the functions used by the renew manager
+type mockPeerMgr struct {
+	fcrpeermgr.FCRPeerMgr
+
+	gws  []fcrpeermgr.Peer
+	near []fcrpeermgr.Peer
+}
+
+func (m *mockPeerMgr) ListGWS() []fcrpeermgr.Peer {
+	return m.gws
+}
+
+func (m *mockPeerMgr) GetGWInfo(gwID string) *fcrpeermgr.Peer {
+	for _, gw := range m.gws {
+		if gw.NodeID == gwID {
+			return &gw
+		}
+	}
+	return nil
+}
+
+func (m *mockPeerMgr) GetGWSNearCIDHash(hash string, numDHT int, except string) []fcrpeermgr.Peer {
+	if numDHT < len(m.near) {
+		return m.near[:numDHT]
+	}
+	return m.near
+}
+
+func newOffer(t *testing.T, key string, cidStr string, price int64, validity time.Duration) *cidoffer.CIDOffer {
+	id, err := cid.NewContentID(cidStr)
+	assert.Empty(t, err)
+	offer, err := cidoffer.NewCIDOffer(NodeID, []cid.ContentID{*id}, big.NewInt(price), time.Now().Add(validity).Unix(), 10)
+	assert.Empty(t, err)
+	assert.Empty(t, offer.Sign(key))
+	return offer
+}
+
+func TestRenewMgr(t *testing.T) {
+	key, pubKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	offerMgr := fcroffermgr.NewFCROfferMgrImplV1(true)
+	assert.Empty(t, offerMgr.Start())
+	defer offerMgr.Shutdown()
+	gw1 := fcrpeermgr.Peer{NodeID: "gw1"}
+	gw2 := fcrpeermgr.Peer{NodeID: "gw2"}
+	gw3 := fcrpeermgr.Peer{NodeID: "gw3"}
+	peerMgr := &mockPeerMgr{gws: []fcrpeermgr.Peer{gw1, gw2}, near: []fcrpeermgr.Peer{gw1}}
+
+	published := make(map[string][]string)
+	failed := map[string]bool{"gw2": true}
+	publisher := func(offer *cidoffer.CIDOffer, gw fcrpeermgr.Peer) error {
+		if failed[gw.NodeID] {
+			return errors.New("Test error")
+		}
+		published[offer.GetMessageDigest()] = append(published[offer.GetMessageDigest()], gw.NodeID)
+		return nil
+	}
+	revoked := make(map[string][]string)
+	revoker := func(digest string, gw fcrpeermgr.Peer) error {
+		revoked[digest] = append(revoked[digest], gw.NodeID)
+		return nil
+	}
+	mgr := NewFCRRenewMgrImplV1(NodeID, key, offerMgr, peerMgr, publisher, revoker, 2, time.Hour)
+
+	// Offer about to expire
+	expiring := newOffer(t, key, CID1, 10, time.Hour)
+	offerMgr.AddOffer(expiring)
+	policy := RenewPolicy{Renew: true, RenewBefore: 2 * time.Hour, Period: 24 * time.Hour, Price: big.NewInt(20)}
+	assert.Empty(t, mgr.Track(expiring, policy, []string{"gw1"}))
+	// Offer not renewed
+	stable := newOffer(t, key, CID2, 5, time.Hour)
+	offerMgr.AddOffer(stable)
+	assert.Empty(t, mgr.Track(stable, RenewPolicy{}, []string{"gw1"}))
+	// Invalid policies
+	assert.NotEmpty(t, mgr.Track(stable, RenewPolicy{Renew: true, RenewBefore: time.Hour, Period: time.Hour}, nil))
+	assert.NotEmpty(t, mgr.SetPolicy("unknown", RenewPolicy{}))
+	assert.Empty(t, mgr.GetPolicy("unknown"))
+
+	// Not started
+	mgr.Check()
+	assert.Empty(t, published)
+
+	assert.Empty(t, mgr.Start())
+	defer mgr.Shutdown()
+	assert.NotEmpty(t, mgr.Start())
+
+	// The expiring offer is renewed and published to the nearby gateway, the other is already published to it
+	mgr.Check()
+	assert.Empty(t, offerMgr.GetOfferByDigest(expiring.GetMessageDigest()))
+	assert.Empty(t, mgr.GetPolicy(expiring.GetMessageDigest()))
+	id, err := cid.NewContentID(CID1)
+	assert.Empty(t, err)
+	offers := offerMgr.GetOffers(id)
+	assert.Equal(t, 1, len(offers))
+	renewed := &offers[0]
+	assert.Empty(t, renewed.Verify(pubKey))
+	assert.Equal(t, big.NewInt(20), renewed.GetPrice())
+	assert.True(t, renewed.GetExpiry() > time.Now().Add(23*time.Hour).Unix())
+	assert.Equal(t, []string{"gw1"}, published[renewed.GetMessageDigest()])
+	assert.Equal(t, policy, *mgr.GetPolicy(renewed.GetMessageDigest()))
+	assert.Empty(t, published[stable.GetMessageDigest()])
+	// The expiring offer is revoked from the gateways holding it
+	assert.Equal(t, []string{"gw1"}, revoked[expiring.GetMessageDigest()])
+	assert.Empty(t, revoked[renewed.GetMessageDigest()])
+
+	// Offers are republished to gateways newly joined the range, until they succeed
+	peerMgr.near = []fcrpeermgr.Peer{gw1, gw2, gw3}
+	mgr.Check()
+	assert.Equal(t, []string{"gw1"}, published[renewed.GetMessageDigest()])
+	assert.Empty(t, published[stable.GetMessageDigest()])
+	delete(failed, "gw2")
+	mgr.Check()
+	assert.Equal(t, []string{"gw1", "gw2"}, published[renewed.GetMessageDigest()])
+	assert.Equal(t, []string{"gw2"}, published[stable.GetMessageDigest()])
+	mgr.Check()
+	assert.Equal(t, []string{"gw2"}, published[stable.GetMessageDigest()])
+
+	// Untracked and expired offers are no longer published
+	mgr.Untrack(renewed.GetMessageDigest())
+	assert.Empty(t, mgr.SetPolicy(stable.GetMessageDigest(), RenewPolicy{Renew: true, RenewBefore: time.Hour, Period: 2 * time.Hour}))
+	peerMgr.gws = []fcrpeermgr.Peer{gw1, gw2, gw3}
+	peerMgr.near = []fcrpeermgr.Peer{gw1, gw2, gw3}
+	mgr.Check()
+	assert.Equal(t, []string{"gw1", "gw2"}, published[renewed.GetMessageDigest()])
+	// The stable offer expires within an hour under the new policy, so it is renewed with the current price
+	assert.Empty(t, offerMgr.GetOfferByDigest(stable.GetMessageDigest()))
+	id, err = cid.NewContentID(CID2)
+	assert.Empty(t, err)
+	offers = offerMgr.GetOffers(id)
+	assert.Equal(t, 1, len(offers))
+	assert.Equal(t, big.NewInt(5), offers[0].GetPrice())
+	// It is published to the nearby gateways as many as the replication factor, and the stable offer is revoked
+	assert.ElementsMatch(t, []string{"gw1", "gw2"}, published[offers[0].GetMessageDigest()])
+	assert.ElementsMatch(t, []string{"gw1", "gw2"}, revoked[stable.GetMessageDigest()])
+
+	// An offer untracked while its renewed offer is published, the renewed offer is revoked and dropped
+	renewedStable := &offers[0]
+	assert.Empty(t, mgr.SetPolicy(renewedStable.GetMessageDigest(), RenewPolicy{Renew: true, RenewBefore: 48 * time.Hour, Period: 72 * time.Hour}))
+	publisher = func(offer *cidoffer.CIDOffer, gw fcrpeermgr.Peer) error {
+		// The lock is not held while publishing
+		mgr.Untrack(renewedStable.GetMessageDigest())
+		published[offer.GetMessageDigest()] = append(published[offer.GetMessageDigest()], gw.NodeID)
+		return nil
+	}
+	mgr.(*FCRRenewMgrImplV1).publisher = publisher
+	mgr.Check()
+	assert.Empty(t, mgr.GetPolicy(renewedStable.GetMessageDigest()))
+	offers = offerMgr.GetOffers(id)
+	assert.Equal(t, 1, len(offers))
+	assert.Equal(t, renewedStable.GetMessageDigest(), offers[0].GetMessageDigest())
+	for digest, gwIDs := range published {
+		if digest != renewedStable.GetMessageDigest() && digest != renewed.GetMessageDigest() && digest != stable.GetMessageDigest() {
+			assert.ElementsMatch(t, gwIDs, revoked[digest])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Package fcrrenewmgr - renew manager renews the published offers of a provider before they expire and republishes them to gateways.
+*/
+package fcrrenewmgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrdatabase"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// trackedNamespace maps digest -> tracked offer json
+const trackedNamespace = "tracked"
+
+// FCRRenewMgrImplV2 implements FCRRenewMgr, it is a persistent version.
+// The policy and the gateways of every tracked offer are written to the database, and at start
+// the offers still held by the offer manager are tracked again, so the offer manager must be started before.
+type FCRRenewMgrImplV2 struct {
+	*FCRRenewMgrImplV1
+
+	// Path to the database file
+	dbPath string
+
+	// db persists tracked offers, it is guarded by the tracked lock
+	db fcrdatabase.FCRDatabase
+}
+
+// storedTrackedOffer is the stored form of a tracked offer.
+type storedTrackedOffer struct {
+	Renew       bool          `json:"renew"`
+	RenewBefore time.Duration `json:"renew_before"`
+	Period      time.Duration `json:"period"`
+	// Price is empty to keep the current price
+	Price       string   `json:"price"`
+	PublishedTo []string `json:"published_to"`
+}
+
+func NewFCRRenewMgrImplV2(nodeID string, offerSigningKey string, offerMgr fcroffermgr.FCROfferMgr, peerMgr fcrpeermgr.FCRPeerMgr, publisher Publisher, revoker Revoker, replicationFactor int, checkDuration time.Duration, dbPath string) FCRRenewMgr {
+	mgr := &FCRRenewMgrImplV2{
+		FCRRenewMgrImplV1: NewFCRRenewMgrImplV1(nodeID, offerSigningKey, offerMgr, peerMgr, publisher, revoker, replicationFactor, checkDuration).(*FCRRenewMgrImplV1),
+		dbPath:            dbPath,
+	}
+	mgr.store = mgr
+	return mgr
+}
+
+func (mgr *FCRRenewMgrImplV2) Start() error {
+	if mgr.start {
+		return errors.New("FCRRenewManager has already started")
+	}
+	db := fcrdatabase.NewFCRDatabaseImplV1(mgr.dbPath, trackedNamespace)
+	err := db.Start()
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]*trackedOffer)
+	dropped := make([][]byte, 0)
+	err = db.ForEach(trackedNamespace, func(key []byte, value []byte) error {
+		stored := storedTrackedOffer{}
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return fmt.Errorf("Error in loading tracked offer %v: %v", string(key), err.Error())
+		}
+		offer := mgr.offerMgr.GetOfferByDigest(string(key))
+		if offer == nil || (!stored.Renew && offer.HasExpired()) {
+			dropped = append(dropped, key)
+			return nil
+		}
+		tracked := &trackedOffer{
+			offer: offer,
+			policy: RenewPolicy{
+				Renew:       stored.Renew,
+				RenewBefore: stored.RenewBefore,
+				Period:      stored.Period,
+			},
+			publishedTo: make(map[string]bool),
+		}
+		if stored.Price != "" {
+			price, ok := big.NewInt(0).SetString(stored.Price, 10)
+			if !ok {
+				return fmt.Errorf("Error in loading price of tracked offer %v", string(key))
+			}
+			tracked.policy.Price = price
+		}
+		for _, gwID := range stored.PublishedTo {
+			tracked.publishedTo[gwID] = true
+		}
+		loaded[string(key)] = tracked
+		return nil
+	})
+	if err == nil && len(dropped) > 0 {
+		// Drop offers no longer held by the offer manager
+		err = db.Update(func(txn fcrdatabase.Txn) error {
+			for _, key := range dropped {
+				if err := txn.Delete(trackedNamespace, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		db.Shutdown()
+		return err
+	}
+	logging.Info("FCRRenewManager loaded %v tracked offers and dropped %v from %v", len(loaded), len(dropped), mgr.dbPath)
+	mgr.trackedLock.Lock()
+	mgr.db = db
+	for digest, tracked := range loaded {
+		mgr.tracked[digest] = tracked
+	}
+	mgr.trackedLock.Unlock()
+	return mgr.FCRRenewMgrImplV1.Start()
+}
+
+func (mgr *FCRRenewMgrImplV2) Shutdown() {
+	if !mgr.start {
+		return
+	}
+	mgr.FCRRenewMgrImplV1.Shutdown()
+	mgr.trackedLock.Lock()
+	defer mgr.trackedLock.Unlock()
+	mgr.db.Shutdown()
+	mgr.db = nil
+}
+
+func (mgr *FCRRenewMgrImplV2) Track(offer *cidoffer.CIDOffer, policy RenewPolicy, publishedTo []string) error {
+	if !mgr.start {
+		return errors.New("FCRRenewManager has not started")
+	}
+	return mgr.FCRRenewMgrImplV1.Track(offer, policy, publishedTo)
+}
+
+func (mgr *FCRRenewMgrImplV2) saveTracked(digest string, tracked *trackedOffer) {
+	if mgr.db == nil {
+		return
+	}
+	stored := storedTrackedOffer{
+		Renew:       tracked.policy.Renew,
+		RenewBefore: tracked.policy.RenewBefore,
+		Period:      tracked.policy.Period,
+		PublishedTo: make([]string, 0),
+	}
+	if tracked.policy.Price != nil {
+		stored.Price = tracked.policy.Price.String()
+	}
+	for gwID := range tracked.publishedTo {
+		stored.PublishedTo = append(stored.PublishedTo, gwID)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		logging.Error("Error in encoding tracked offer %v: %v", digest, err.Error())
+		return
+	}
+	err = mgr.db.Put(trackedNamespace, []byte(digest), data)
+	if err != nil {
+		logging.Error("Error in persisting tracked offer %v: %v", digest, err.Error())
+	}
+}
+
+func (mgr *FCRRenewMgrImplV2) removeTracked(digest string) {
+	if mgr.db == nil {
+		return
+	}
+	err := mgr.db.Delete(trackedNamespace, []byte(digest))
+	if err != nil {
+		logging.Error("Error in removing tracked offer %v: %v", digest, err.Error())
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Package fcrrenewmgr - renew manager renews the published offers of a provider before they expire and republishes them to gateways.
+*/
+package fcrrenewmgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+)
+
+func TestPersistentRestart(t *testing.T) {
+	key, _, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	assert.Empty(t, err)
+	offerMgr := fcroffermgr.NewFCROfferMgrImplV1(true)
+	assert.Empty(t, offerMgr.Start())
+	defer offerMgr.Shutdown()
+	gw1 := fcrpeermgr.Peer{NodeID: "gw1"}
+	peerMgr := &mockPeerMgr{gws: []fcrpeermgr.Peer{gw1}, near: []fcrpeermgr.Peer{gw1}}
+	publisher := func(offer *cidoffer.CIDOffer, gw fcrpeermgr.Peer) error {
+		return nil
+	}
+	revoker := func(digest string, gw fcrpeermgr.Peer) error {
+		return nil
+	}
+	path := filepath.Join(t.TempDir(), "renew.db")
+	mgr := NewFCRRenewMgrImplV2(NodeID, key, offerMgr, peerMgr, publisher, revoker, 1, time.Hour, path)
+
+	offer1 := newOffer(t, key, CID1, 10, 48*time.Hour)
+	offerMgr.AddOffer(offer1)
+	offer2 := newOffer(t, key, CID2, 5, 48*time.Hour)
+	offerMgr.AddOffer(offer2)
+	// Not started
+	assert.NotEmpty(t, mgr.Track(offer1, RenewPolicy{}, nil))
+
+	assert.Empty(t, mgr.Start())
+	assert.NotEmpty(t, mgr.Start())
+	policy := RenewPolicy{Renew: true, RenewBefore: 2 * time.Hour, Period: 24 * time.Hour, Price: big.NewInt(20)}
+	assert.Empty(t, mgr.Track(offer1, RenewPolicy{}, []string{"gw2"}))
+	assert.Empty(t, mgr.SetPolicy(offer1.GetMessageDigest(), policy))
+	assert.Empty(t, mgr.Track(offer2, RenewPolicy{}, nil))
+	// The first offer is republished to the nearby gateway
+	mgr.Check()
+	mgr.Shutdown()
+
+	// The policies are restored, the offer no longer held by the offer manager is dropped
+	offerMgr.RemoveOffer(offer2.GetMessageDigest())
+	mgr = NewFCRRenewMgrImplV2(NodeID, key, offerMgr, peerMgr, publisher, revoker, 1, time.Hour, path)
+	assert.Empty(t, mgr.Start())
+	assert.Equal(t, policy, *mgr.GetPolicy(offer1.GetMessageDigest()))
+	assert.Empty(t, mgr.GetPolicy(offer2.GetMessageDigest()))
+	tracked := mgr.(*FCRRenewMgrImplV2).tracked[offer1.GetMessageDigest()]
+	assert.Equal(t, map[string]bool{"gw1": true, "gw2": true}, tracked.publishedTo)
+	mgr.Untrack(offer1.GetMessageDigest())
+	mgr.Shutdown()
+
+	// Untracked offers are not restored
+	mgr = NewFCRRenewMgrImplV2(NodeID, key, offerMgr, peerMgr, publisher, revoker, 1, time.Hour, path)
+	assert.Empty(t, mgr.Start())
+	defer mgr.Shutdown()
+	assert.Empty(t, mgr.GetPolicy(offer1.GetMessageDigest()))
+}
@@ -26,7 +26,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
@@ -47,6 +49,37 @@ const (
 	GracePeriod = time.Hour
 )
 
+// MaxMessageSize is the maximum size in bytes of a single message on the wire.
+// Any larger content must be streamed in multiple messages, see fcrmessages.DataChunkSize.
+const MaxMessageSize = 4 << 20
+
+const (
+	// ReplayWindow is how old the timestamp of a request can be before it is rejected as stale.
+	ReplayWindow = 5 * time.Minute
+	// ReplayClockSkew is how far the timestamp of a request can be ahead of now, to allow for clock differences between peers.
+	ReplayClockSkew = 30 * time.Second
+	// ReplayCacheSize is the maximum number of requests remembered within the replay window.
+	ReplayCacheSize = 100000
+	// ReplayCacheSizePerSender is the maximum number of requests of a single sender remembered within the replay window.
+	ReplayCacheSizePerSender = 10000
+)
+
+// ErrReplayedRequest is returned when a request is rejected as stale or replayed.
+var ErrReplayedRequest = errors.New("Request is replayed")
+
+// nonceSource draws request nonces, it is seeded once per process so that a restarted node does not repeat the nonces of its last run.
+var nonceSource = struct {
+	rand *rand.Rand
+	lock sync.Mutex
+}{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// NewNonce returns a new random nonce for a request.
+func NewNonce() uint64 {
+	nonceSource.lock.Lock()
+	defer nonceSource.lock.Unlock()
+	return uint64(nonceSource.rand.Int63())
+}
+
 // FCRServerImplV1 implements FCRServer, it is built on top of libp2p.
 type FCRServerImplV1 struct {
 	privKeyStr string
@@ -57,6 +90,9 @@ type FCRServerImplV1 struct {
 	handlers   map[byte]func(reader FCRServerRequestReader, writer FCRServerResponseWriter, request *fcrmessages.FCRReqMsg) error
 	requesters map[byte]func(reader FCRServerResponseReader, writer FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error)
 
+	// replays remembers the requests seen recently to reject replays
+	replays *replayCache
+
 	shutdown chan bool
 	host     host.Host
 	cancel   context.CancelFunc
@@ -70,6 +106,7 @@ func NewFCRServerImplV1(privKeyStr string, port uint, timeout time.Duration) FCR
 		timeout:    timeout,
 		handlers:   make(map[byte]func(reader FCRServerRequestReader, writer FCRServerResponseWriter, request *fcrmessages.FCRReqMsg) error),
 		requesters: make(map[byte]func(reader FCRServerResponseReader, writer FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error)),
+		replays:    newReplayCache(ReplayWindow, ReplayClockSkew, ReplayCacheSize, ReplayCacheSizePerSender),
 		shutdown:   make(chan bool),
 	}
 }
@@ -196,6 +233,20 @@ func (s *FCRServerImplV1) handleIncomingConnection(conn network.Stream) {
 		logging.Error("P2P Server has error reading message from %s: %s - Connection dropped", conn.ID(), err.Error())
 		return
 	}
+	// The sender is recovered from the signature, as a replayed request may come from any peer.
+	sender, err := request.Signer()
+	if err == nil {
+		err = s.replays.check(sender, request.Nonce(), request.Timestamp())
+	}
+	if err != nil {
+		logging.Error("P2P Server rejected message from %s: %s - Connection dropped", conn.ID(), err.Error())
+		// The rejection is sent before any handler so it is not signed, the stream is authenticated by libp2p.
+		data, err := fcrmessages.CreateFCRACKErrorMsg(request.Nonce(), &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeReplayedRequest, Message: err.Error()}).ToBytes()
+		if err == nil {
+			write(conn, data, s.timeout)
+		}
+		return
+	}
 	handler := s.handlers[request.Type()]
 	if handler != nil {
 		// Call handler to handle the request
@@ -214,26 +265,29 @@ func (s *FCRServerImplV1) handleIncomingConnection(conn network.Stream) {
 }
 
 // read read a message bytes from a given connection.
+// It reads directly from the stream so that consecutive messages on the same stream are not lost in a read-ahead buffer.
 func read(conn network.Stream, timeout time.Duration) ([]byte, error) {
-	// Initialise a reader
-	reader := bufio.NewReader(conn)
 	// Read the length
 	length := make([]byte, 4)
 	// Set timeout
 	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
 		panic(err)
 	}
-	_, err := io.ReadFull(reader, length)
+	_, err := io.ReadFull(conn, length)
 	if err != nil {
 		return nil, err
 	}
+	size := binary.BigEndian.Uint32(length)
+	if size > MaxMessageSize {
+		return nil, fmt.Errorf("Message size %v exceeds maximum size %v", size, MaxMessageSize)
+	}
 	// Read the data
-	data := make([]byte, int(binary.BigEndian.Uint32(length)))
+	data := make([]byte, int(size))
 	// Set timeout
 	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
 		panic(err)
 	}
-	_, err = io.ReadFull(reader, data)
+	_, err = io.ReadFull(conn, data)
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +296,9 @@ func read(conn network.Stream, timeout time.Duration) ([]byte, error) {
 
 // write writes a message bytes array to a given connection.
 func write(conn network.Stream, data []byte, timeout time.Duration) error {
-	// Get data
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("Message size %v exceeds maximum size %v", len(data), MaxMessageSize)
+	}
 	// Initialise a writer
 	writer := bufio.NewWriter(conn)
 	length := make([]byte, 4)
@@ -287,6 +343,9 @@ func (r *FCRServerResponseReaderImplV1) Read(timeout time.Duration) (*fcrmessage
 	if err == nil {
 		err = res.FromBytes(data)
 	}
+	if err == nil && !res.ACK() && res.Code() == fcrmessages.ErrorCodeReplayedRequest {
+		err = fmt.Errorf("%w: %v", ErrReplayedRequest, res.Error())
+	}
 	return res, err
 }
 
@@ -328,6 +387,11 @@ func IsTimeoutError(err error) bool {
 	return ok && neterr.Timeout()
 }
 
+// IsReplayedRequestError checks if the given error is a replayed request error
+func IsReplayedRequestError(err error) bool {
+	return errors.Is(err, ErrReplayedRequest)
+}
+
 // GetMultiAddr returns the supposed multiaddr string from given private key, ip address and port.
 func GetMultiAddr(privKeyStr string, ip string, port uint) (string, error) {
 	privKeyBytes, err := hex.DecodeString(privKeyStr)
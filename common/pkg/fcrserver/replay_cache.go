@@ -0,0 +1,111 @@
+/*
+Package fcrserver - provides an interface to do networking.
+*/
+package fcrserver
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// replayKey identifies a request by its sender and nonce.
+type replayKey struct {
+	sender string
+	nonce  uint64
+}
+
+// replayEntry is a seen request, it can be forgotten after expiry as its timestamp is stale by then.
+type replayEntry struct {
+	key    replayKey
+	expiry int64
+}
+
+// replayCache remembers the requests seen within the replay window, with a bounded memory.
+// The sender of a request is recovered from its signature, so anyone can send requests as many senders. To keep a flood of
+// requests from blocking other peers, the entries of a sender are capped, and the oldest entry is evicted when the cache is full.
+type replayCache struct {
+	window       int64
+	skew         int64
+	maxSize      int
+	maxPerSender int
+
+	// seen maps key -> element in order
+	seen map[replayKey]*list.Element
+	// order keeps entries in the order they are seen
+	order *list.List
+	// perSender maps sender -> number of entries
+	perSender map[string]int
+
+	lock sync.Mutex
+}
+
+func newReplayCache(window time.Duration, skew time.Duration, maxSize int, maxPerSender int) *replayCache {
+	return &replayCache{
+		window:       int64(window / time.Second),
+		skew:         int64(skew / time.Second),
+		maxSize:      maxSize,
+		maxPerSender: maxPerSender,
+		seen:         make(map[replayKey]*list.Element),
+		order:        list.New(),
+		perSender:    make(map[string]int),
+		lock:         sync.Mutex{},
+	}
+}
+
+// check checks a request of given sender, nonce and timestamp.
+// It returns error if the timestamp is older than the replay window or ahead of now by more than the clock skew,
+// if the request has been seen, or if the sender has too many requests within the window, otherwise it records the request.
+// When the cache is full, the oldest entry is evicted, its request could be replayed until its timestamp goes stale.
+func (c *replayCache) check(sender string, nonce uint64, timestamp int64) error {
+	now := time.Now().Unix()
+	if timestamp < now-c.window || timestamp > now+c.skew {
+		return fmt.Errorf("Request timestamp %v is outside the replay window", timestamp)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Forget expired entries
+	for front := c.order.Front(); front != nil && front.Value.(*replayEntry).expiry < now; front = c.order.Front() {
+		c.remove(front)
+	}
+	key := replayKey{sender: sender, nonce: nonce}
+	if _, ok := c.seen[key]; ok {
+		return fmt.Errorf("Request nonce %v has been used", nonce)
+	}
+	if c.perSender[sender] >= c.maxPerSender {
+		return fmt.Errorf("Sender %v has more than %v requests within the replay window, request nonce %v cannot be recorded", sender, c.maxPerSender, nonce)
+	}
+	if c.order.Len() >= c.maxSize {
+		c.remove(c.order.Front())
+	}
+	c.seen[key] = c.order.PushBack(&replayEntry{key: key, expiry: timestamp + c.window})
+	c.perSender[sender]++
+	return nil
+}
+
+// remove removes a given entry.
+func (c *replayCache) remove(e *list.Element) {
+	key := e.Value.(*replayEntry).key
+	delete(c.seen, key)
+	c.order.Remove(e)
+	c.perSender[key.sender]--
+	if c.perSender[key.sender] <= 0 {
+		delete(c.perSender, key.sender)
+	}
+}
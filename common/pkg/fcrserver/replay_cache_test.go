@@ -0,0 +1,94 @@
+/*
+Package fcrserver - provides an interface to do networking.
+*/
+package fcrserver
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayCache(t *testing.T) {
+	cache := newReplayCache(time.Minute, 10*time.Second, 3, 2)
+	now := time.Now().Unix()
+
+	// Stale timestamps, or future timestamps beyond the clock skew
+	assert.NotEmpty(t, cache.check("sender1", 1, now-120))
+	assert.NotEmpty(t, cache.check("sender1", 1, now+30))
+
+	assert.Empty(t, cache.check("sender1", 1, now))
+	assert.NotEmpty(t, cache.check("sender1", 1, now))
+	// Nonces are scoped by sender
+	assert.Empty(t, cache.check("sender2", 1, now+5))
+	assert.Empty(t, cache.check("sender1", 2, now-30))
+	assert.Equal(t, 3, cache.order.Len())
+
+	// A sender is capped, without blocking other senders
+	assert.NotEmpty(t, cache.check("sender1", 3, now))
+	assert.Equal(t, 3, cache.order.Len())
+
+	// The oldest entry is evicted when full
+	assert.Empty(t, cache.check("sender3", 1, now))
+	assert.Equal(t, 3, cache.order.Len())
+	assert.Equal(t, 3, len(cache.seen))
+	assert.Equal(t, map[string]int{"sender1": 1, "sender2": 1, "sender3": 1}, cache.perSender)
+	assert.NotEmpty(t, cache.check("sender1", 2, now))
+	assert.NotEmpty(t, cache.check("sender2", 1, now))
+	assert.NotEmpty(t, cache.check("sender3", 1, now))
+	// The sender of the evicted entry can send again
+	assert.Empty(t, cache.check("sender1", 3, now))
+
+	// A flood of senders does not block other requests
+	cache = newReplayCache(time.Minute, 10*time.Second, 100, 10)
+	now = time.Now().Unix()
+	for i := 0; i < 1000; i++ {
+		assert.Empty(t, cache.check(fmt.Sprintf("flood%v", i), 1, now))
+	}
+	assert.Equal(t, 100, cache.order.Len())
+	assert.Empty(t, cache.check("sender1", 1, now))
+	assert.NotEmpty(t, cache.check("sender1", 1, now))
+
+	// Expired entries are forgotten
+	cache = newReplayCache(time.Second, time.Second, 3, 3)
+	now = time.Now().Unix()
+	assert.Empty(t, cache.check("sender1", 1, now))
+	time.Sleep(2100 * time.Millisecond)
+	assert.Empty(t, cache.check("sender1", 2, time.Now().Unix()))
+	assert.Equal(t, 1, cache.order.Len())
+	assert.Equal(t, 1, len(cache.seen))
+	assert.Equal(t, 1, cache.perSender["sender1"])
+}
+
+func TestNewNonce(t *testing.T) {
+	seen := make(map[uint64]bool)
+	for i := 0; i < 1000; i++ {
+		nonce := NewNonce()
+		assert.False(t, seen[nonce])
+		seen[nonce] = true
+	}
+}
+
+func TestIsReplayedRequestError(t *testing.T) {
+	assert.True(t, IsReplayedRequestError(ErrReplayedRequest))
+	assert.True(t, IsReplayedRequestError(fmt.Errorf("%w: test", ErrReplayedRequest)))
+	assert.False(t, IsReplayedRequestError(errors.New("Test error")))
+}
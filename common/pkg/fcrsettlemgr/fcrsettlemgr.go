@@ -0,0 +1,78 @@
+/*
+Package fcrsettlemgr - settlement manager settles inbound payment channels automatically based on a policy.
+*/
+package fcrsettlemgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "math/big"
+
+// FCRSettleMgr represents the manager that watches all inbound payment channels and settles them by policy.
+type FCRSettleMgr interface {
+	// Start starts the manager's routine.
+	Start() error
+
+	// Shutdown ends the manager's routine safely.
+	Shutdown()
+
+	// Check forces the manager to check all inbound payment channels against the policy.
+	Check()
+
+	// ListDecisions lists the settlement decisions made, the most recent first.
+	ListDecisions(from uint, to uint) []Decision
+}
+
+// SettlePolicy represents the thresholds, any of which hit will trigger the settlement of a channel.
+type SettlePolicy struct {
+	// MinRedeemed settles a channel once the unredeemed amount reaches it, nil or zero to disable.
+	MinRedeemed *big.Int
+
+	// MaxAge settles a channel once it is older than the given number of blocks, zero to disable.
+	MaxAge uint64
+
+	// Deregistering settles a channel once the sender is deregistering.
+	Deregistering bool
+
+	// MaxCostRatio settles a channel once the cost to settle is below the given fraction of the unredeemed amount, zero to disable.
+	MaxCostRatio float64
+}
+
+// Decision represents a settlement decision made for a channel.
+type Decision struct {
+	// Time is the unix time at which the decision is made.
+	Time int64
+
+	// SenderAddr is the address of the sender of the channel.
+	SenderAddr string
+
+	// ChAddr is the address of the channel.
+	ChAddr string
+
+	// Redeemed is the unredeemed amount of the channel.
+	Redeemed *big.Int
+
+	// Cost is the estimated cost to settle the channel, nil if not estimated.
+	Cost *big.Int
+
+	// Reason is the thresholds that have been hit.
+	Reason string
+
+	// Settled indicates whether or not the channel has been called to settle.
+	Settled bool
+
+	// Outcome is the outcome of the settlement.
+	Outcome string
+}
@@ -0,0 +1,274 @@
+/*
+Package fcrsettlemgr - settlement manager settles inbound payment channels automatically based on a policy.
+*/
+package fcrsettlemgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// maxDecisions is the maximum number of decisions kept.
+const maxDecisions = 1000
+
+// FCRSettleMgrImplV1 implements FCRSettleMgr, it is an in-memory version.
+type FCRSettleMgrImplV1 struct {
+	// Boolean indicates if the manager has started
+	start bool
+
+	paymentMgr fcrpaymentmgr.FCRPaymentMgr
+	lotusMgr   fcrlotusmgr.FCRLotusMgr
+	peerMgr    fcrpeermgr.FCRPeerMgr
+
+	policy SettlePolicy
+
+	// Duration to wait between two checks
+	checkDuration time.Duration
+
+	// Channels to control the thread
+	shutdownCh chan bool
+	checkCh    chan bool
+
+	// Decisions made, the most recent first
+	decisions     []Decision
+	decisionsLock sync.RWMutex
+
+	// Creation blocks of channels, looking up the creation block is expensive and it never changes
+	createdBlocks     map[string]*big.Int
+	createdBlocksLock sync.Mutex
+}
+
+func NewFCRSettleMgrImplV1(paymentMgr fcrpaymentmgr.FCRPaymentMgr, lotusMgr fcrlotusmgr.FCRLotusMgr, peerMgr fcrpeermgr.FCRPeerMgr, policy SettlePolicy, checkDuration time.Duration) FCRSettleMgr {
+	return &FCRSettleMgrImplV1{
+		start:         false,
+		paymentMgr:    paymentMgr,
+		lotusMgr:      lotusMgr,
+		peerMgr:       peerMgr,
+		policy:        policy,
+		checkDuration: checkDuration,
+		shutdownCh:    make(chan bool),
+		checkCh:       make(chan bool),
+		decisions:     make([]Decision, 0),
+		decisionsLock: sync.RWMutex{},
+		createdBlocks: make(map[string]*big.Int),
+	}
+}
+
+func (mgr *FCRSettleMgrImplV1) Start() error {
+	if mgr.start {
+		return errors.New("FCRSettleManager has already started")
+	}
+	mgr.start = true
+	go mgr.checkRoutine()
+	return nil
+}
+
+func (mgr *FCRSettleMgrImplV1) Shutdown() {
+	if !mgr.start {
+		return
+	}
+	mgr.shutdownCh <- true
+	<-mgr.shutdownCh
+	mgr.start = false
+}
+
+func (mgr *FCRSettleMgrImplV1) Check() {
+	if !mgr.start {
+		return
+	}
+	mgr.checkCh <- true
+	<-mgr.checkCh
+}
+
+func (mgr *FCRSettleMgrImplV1) ListDecisions(from uint, to uint) []Decision {
+	mgr.decisionsLock.RLock()
+	defer mgr.decisionsLock.RUnlock()
+	if from >= to || from >= uint(len(mgr.decisions)) {
+		return make([]Decision, 0)
+	}
+	if to > uint(len(mgr.decisions)) {
+		to = uint(len(mgr.decisions))
+	}
+	res := make([]Decision, to-from)
+	copy(res, mgr.decisions[from:to])
+	return res
+}
+
+// checkRoutine checks all inbound channels periodically or when forced.
+func (mgr *FCRSettleMgrImplV1) checkRoutine() {
+	for {
+		forced := false
+		afterChan := time.After(mgr.checkDuration)
+		select {
+		case <-mgr.checkCh:
+			// Need to check
+			logging.Info("FCRSettleManager force check.")
+			forced = true
+		case <-afterChan:
+			// Need to check
+		case <-mgr.shutdownCh:
+			// Need to shutdown
+			logging.Info("FCRSettleManager shutdown checking routine.")
+			mgr.shutdownCh <- true
+			return
+		}
+		mgr.checkAll()
+		if forced {
+			mgr.checkCh <- true
+		}
+	}
+}
+
+// checkAll checks all inbound channels against the policy and settles the channels hitting any threshold.
+func (mgr *FCRSettleMgrImplV1) checkAll() {
+	deregistering := make(map[string]bool)
+	if mgr.policy.Deregistering && mgr.peerMgr != nil {
+		for _, peer := range mgr.peerMgr.ListGWS() {
+			if !peer.Deregistering {
+				continue
+			}
+			addr, err := fcrcrypto.GetWalletAddress(peer.RootKey)
+			if err != nil {
+				logging.Warn("FCRSettleManager fail to get wallet address of gateway %v: %v", peer.NodeID, err.Error())
+				continue
+			}
+			deregistering[addr] = true
+		}
+	}
+	var current *big.Int
+	if mgr.policy.MaxAge > 0 {
+		var err error
+		current, err = mgr.lotusMgr.GetCurrentBlock()
+		if err != nil {
+			logging.Warn("FCRSettleManager fail to get current block: %v", err.Error())
+		}
+	}
+	for _, senderAddr := range mgr.paymentMgr.ListInboundChs() {
+		mgr.checkChannel(senderAddr, current, deregistering[senderAddr])
+	}
+}
+
+// checkChannel checks the inbound channel from a given sender, a nil current block skips the age threshold.
+func (mgr *FCRSettleMgrImplV1) checkChannel(senderAddr string, current *big.Int, deregistering bool) {
+	settling, err := mgr.paymentMgr.GetInboundChSettling(senderAddr)
+	if err != nil || settling {
+		return
+	}
+	chAddr, _, redeemed, err := mgr.paymentMgr.GetInboundChStatus(senderAddr)
+	if err != nil || redeemed.Cmp(big.NewInt(0)) <= 0 {
+		return
+	}
+	reasons := make([]string, 0)
+	if mgr.policy.MinRedeemed != nil && mgr.policy.MinRedeemed.Cmp(big.NewInt(0)) > 0 && redeemed.Cmp(mgr.policy.MinRedeemed) >= 0 {
+		reasons = append(reasons, fmt.Sprintf("Unredeemed amount %v reaches %v", redeemed.String(), mgr.policy.MinRedeemed.String()))
+	}
+	if mgr.policy.MaxAge > 0 && current != nil {
+		created, err := mgr.getCreationBlock(chAddr)
+		if err != nil {
+			logging.Debug("FCRSettleManager fail to get creation block of channel %v: %v", chAddr, err.Error())
+		} else if age := big.NewInt(0).Sub(current, created); age.Cmp(big.NewInt(0).SetUint64(mgr.policy.MaxAge)) > 0 {
+			reasons = append(reasons, fmt.Sprintf("Channel age %v blocks exceeds %v", age.String(), mgr.policy.MaxAge))
+		}
+	}
+	if mgr.policy.Deregistering && deregistering {
+		reasons = append(reasons, "Sender is deregistering")
+	}
+	var cost *big.Int
+	if mgr.policy.MaxCostRatio > 0 {
+		// The cost is only needed by the cost trigger, other triggers settle without it
+		cost, err = mgr.paymentMgr.GetCostToSettle(senderAddr)
+		if err != nil {
+			logging.Debug("FCRSettleManager fail to estimate cost to settle channel %v: %v", chAddr, err.Error())
+			cost = nil
+		} else {
+			limit, _ := big.NewFloat(0).Mul(big.NewFloat(mgr.policy.MaxCostRatio), big.NewFloat(0).SetInt(redeemed)).Int(nil)
+			if cost.Cmp(limit) < 0 {
+				reasons = append(reasons, fmt.Sprintf("Cost to settle %v is below %v of unredeemed amount", cost.String(), mgr.policy.MaxCostRatio))
+			}
+		}
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	valid, err := mgr.paymentMgr.CheckSettlementValidity(senderAddr)
+	if err != nil {
+		mgr.record(senderAddr, chAddr, redeemed, cost, reasons, false, fmt.Sprintf("Error in checking settlement validity: %v", err.Error()))
+		return
+	}
+	if !valid {
+		mgr.record(senderAddr, chAddr, redeemed, cost, reasons, false, "Channel is not valid to settle")
+		return
+	}
+	err = mgr.paymentMgr.Settle(senderAddr)
+	if err != nil {
+		mgr.record(senderAddr, chAddr, redeemed, cost, reasons, false, fmt.Sprintf("Error in settling: %v", err.Error()))
+		return
+	}
+	mgr.record(senderAddr, chAddr, redeemed, cost, reasons, true, "Channel has been called to settle")
+}
+
+// record logs and stores a decision.
+func (mgr *FCRSettleMgrImplV1) record(senderAddr string, chAddr string, redeemed *big.Int, cost *big.Int, reasons []string, settled bool, outcome string) {
+	reason := strings.Join(reasons, "; ")
+	if settled {
+		logging.Info("FCRSettleManager settles channel %v from %v (%v): %v", chAddr, senderAddr, reason, outcome)
+	} else {
+		logging.Error("FCRSettleManager fails to settle channel %v from %v (%v): %v", chAddr, senderAddr, reason, outcome)
+	}
+	mgr.decisionsLock.Lock()
+	defer mgr.decisionsLock.Unlock()
+	mgr.decisions = append([]Decision{{
+		Time:       time.Now().Unix(),
+		SenderAddr: senderAddr,
+		ChAddr:     chAddr,
+		Redeemed:   redeemed,
+		Cost:       cost,
+		Reason:     reason,
+		Settled:    settled,
+		Outcome:    outcome,
+	}}, mgr.decisions...)
+	if len(mgr.decisions) > maxDecisions {
+		mgr.decisions = mgr.decisions[:maxDecisions]
+	}
+}
+
+// getCreationBlock gets the creation block of a given channel, from the cache if it has been looked up.
+func (mgr *FCRSettleMgrImplV1) getCreationBlock(chAddr string) (*big.Int, error) {
+	mgr.createdBlocksLock.Lock()
+	defer mgr.createdBlocksLock.Unlock()
+	created, ok := mgr.createdBlocks[chAddr]
+	if ok {
+		return created, nil
+	}
+	created, err := mgr.lotusMgr.GetPaymentChannelCreationBlock(chAddr)
+	if err != nil {
+		return nil, err
+	}
+	mgr.createdBlocks[chAddr] = created
+	return created, nil
+}
@@ -0,0 +1,239 @@
+/*
+Package fcrsettlemgr - settlement manager settles inbound payment channels automatically based on a policy.
+*/
+package fcrsettlemgr
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+)
+
+type mockChannel struct {
+	chAddr   string
+	redeemed *big.Int
+	settling bool
+	created  *big.Int
+}
+
+// mockPaymentMgr only implements the functions used by the settle manager
+type mockPaymentMgr struct {
+	fcrpaymentmgr.FCRPaymentMgr
+
+	chs    map[string]*mockChannel
+	order  []string
+	failed map[string]bool
+
+	costErr   bool
+	costCalls int
+}
+
+func (m *mockPaymentMgr) ListInboundChs() []string {
+	return m.order
+}
+
+func (m *mockPaymentMgr) GetInboundChSettling(senderAddr string) (bool, error) {
+	return m.chs[senderAddr].settling, nil
+}
+
+func (m *mockPaymentMgr) GetInboundChStatus(senderAddr string) (string, *big.Int, *big.Int, error) {
+	ch := m.chs[senderAddr]
+	return ch.chAddr, big.NewInt(1000000), ch.redeemed, nil
+}
+
+func (m *mockPaymentMgr) GetCostToSettle(senderAddr string) (*big.Int, error) {
+	m.costCalls++
+	if m.costErr {
+		return nil, errors.New("Test error")
+	}
+	return big.NewInt(10), nil
+}
+
+func (m *mockPaymentMgr) CheckSettlementValidity(senderAddr string) (bool, error) {
+	return !m.chs[senderAddr].settling, nil
+}
+
+func (m *mockPaymentMgr) Settle(senderAddr string) error {
+	if m.failed[senderAddr] {
+		return errors.New("Test error")
+	}
+	m.chs[senderAddr].settling = true
+	return nil
+}
+
+// mockLotusMgr only implements the functions used by the settle manager
+type mockLotusMgr struct {
+	fcrlotusmgr.FCRLotusMgr
+
+	chs map[string]*mockChannel
+
+	lookups int
+}
+
+func (m *mockLotusMgr) GetCurrentBlock() (*big.Int, error) {
+	return big.NewInt(200), nil
+}
+
+func (m *mockLotusMgr) GetPaymentChannelCreationBlock(chAddr string) (*big.Int, error) {
+	m.lookups++
+	for _, ch := range m.chs {
+		if ch.chAddr == chAddr {
+			return ch.created, nil
+		}
+	}
+	return nil, errors.New("Channel not found")
+}
+
+// mockPeerMgr only implements the functions used by the settle manager
+type mockPeerMgr struct {
+	fcrpeermgr.FCRPeerMgr
+
+	gws []fcrpeermgr.Peer
+}
+
+func (m *mockPeerMgr) ListGWS() []fcrpeermgr.Peer {
+	return m.gws
+}
+
+func TestSettlePolicy(t *testing.T) {
+	rootKey, nodeID, err := fcrcrypto.GetPublicKey("933dfc0be9ca2d783446fa3fa9ea27bd9cc553ec5131256dd6fddcde3302b9e0")
+	assert.Empty(t, err)
+	deregisteringAddr, err := fcrcrypto.GetWalletAddress(rootKey)
+	assert.Empty(t, err)
+
+	chs := map[string]*mockChannel{
+		// Hits unredeemed amount
+		"sender1": {chAddr: "ch1", redeemed: big.NewInt(1000), created: big.NewInt(150)},
+		// Hits nothing
+		"sender2": {chAddr: "ch2", redeemed: big.NewInt(100), created: big.NewInt(150)},
+		// Hits channel age but fails to settle
+		"sender3": {chAddr: "ch3", redeemed: big.NewInt(100), created: big.NewInt(50)},
+		// Already settling
+		"sender4": {chAddr: "ch4", redeemed: big.NewInt(1000), created: big.NewInt(50), settling: true},
+		// Hits deregistering
+		deregisteringAddr: {chAddr: "ch5", redeemed: big.NewInt(100), created: big.NewInt(150)},
+	}
+	paymentMgr := &mockPaymentMgr{
+		chs:    chs,
+		order:  []string{"sender1", "sender2", "sender3", "sender4", deregisteringAddr},
+		failed: map[string]bool{"sender3": true},
+	}
+	lotusMgr := &mockLotusMgr{chs: chs}
+	peerMgr := &mockPeerMgr{gws: []fcrpeermgr.Peer{{RootKey: rootKey, NodeID: nodeID, Deregistering: true}}}
+
+	mgr := NewFCRSettleMgrImplV1(paymentMgr, lotusMgr, peerMgr, SettlePolicy{
+		MinRedeemed:   big.NewInt(500),
+		MaxAge:        100,
+		Deregistering: true,
+		MaxCostRatio:  0.01,
+	}, time.Hour)
+	// Not started
+	mgr.Check()
+	assert.Empty(t, mgr.ListDecisions(0, 10))
+
+	err = mgr.Start()
+	assert.Empty(t, err)
+	defer mgr.Shutdown()
+	err = mgr.Start()
+	assert.NotEmpty(t, err)
+
+	mgr.Check()
+	decisions := mgr.ListDecisions(0, 10)
+	assert.Equal(t, 3, len(decisions))
+	assert.Equal(t, "ch5", decisions[0].ChAddr)
+	assert.True(t, decisions[0].Settled)
+	assert.Equal(t, "Sender is deregistering", decisions[0].Reason)
+	assert.Equal(t, "ch3", decisions[1].ChAddr)
+	assert.False(t, decisions[1].Settled)
+	assert.Equal(t, "Channel age 150 blocks exceeds 100", decisions[1].Reason)
+	assert.Equal(t, "ch1", decisions[2].ChAddr)
+	assert.True(t, decisions[2].Settled)
+	assert.Equal(t, "Unredeemed amount 1000 reaches 500", decisions[2].Reason)
+	assert.Equal(t, big.NewInt(10), decisions[2].Cost)
+	assert.True(t, chs["sender1"].settling)
+	assert.False(t, chs["sender2"].settling)
+
+	// Settled channels are not checked again, the failed one is retried
+	mgr.Check()
+	decisions = mgr.ListDecisions(0, 10)
+	assert.Equal(t, 4, len(decisions))
+	assert.Equal(t, "ch3", decisions[0].ChAddr)
+	assert.Equal(t, 1, len(mgr.ListDecisions(3, 10)))
+	assert.Empty(t, mgr.ListDecisions(4, 10))
+	// Creation blocks are only looked up once
+	assert.Equal(t, 4, lotusMgr.lookups)
+
+	// Cost below ratio
+	chs["sender3"].settling = true
+	chs["sender2"].redeemed = big.NewInt(100000)
+	mgr.Check()
+	decisions = mgr.ListDecisions(0, 1)
+	assert.Equal(t, "ch2", decisions[0].ChAddr)
+	assert.True(t, decisions[0].Settled)
+	assert.Equal(t, "Unredeemed amount 100000 reaches 500; Cost to settle 10 is below 0.01 of unredeemed amount", decisions[0].Reason)
+}
+
+func TestSettleWithoutCost(t *testing.T) {
+	chs := map[string]*mockChannel{
+		"sender1": {chAddr: "ch1", redeemed: big.NewInt(1000), created: big.NewInt(150)},
+		"sender2": {chAddr: "ch2", redeemed: big.NewInt(1000), created: big.NewInt(150)},
+	}
+	paymentMgr := &mockPaymentMgr{
+		chs:    chs,
+		order:  []string{"sender1"},
+		failed: map[string]bool{},
+	}
+	lotusMgr := &mockLotusMgr{chs: chs}
+	peerMgr := &mockPeerMgr{}
+
+	// Cost trigger disabled, the cost is not estimated
+	mgr := NewFCRSettleMgrImplV1(paymentMgr, lotusMgr, peerMgr, SettlePolicy{MinRedeemed: big.NewInt(500)}, time.Hour)
+	err := mgr.Start()
+	assert.Empty(t, err)
+	mgr.Check()
+	mgr.Shutdown()
+	decisions := mgr.ListDecisions(0, 10)
+	assert.Equal(t, 1, len(decisions))
+	assert.True(t, decisions[0].Settled)
+	assert.Empty(t, decisions[0].Cost)
+	assert.Equal(t, 0, paymentMgr.costCalls)
+
+	// Cost estimation fails, other triggers still settle
+	paymentMgr.order = []string{"sender2"}
+	paymentMgr.costErr = true
+	mgr = NewFCRSettleMgrImplV1(paymentMgr, lotusMgr, peerMgr, SettlePolicy{MinRedeemed: big.NewInt(500), MaxCostRatio: 0.01}, time.Hour)
+	err = mgr.Start()
+	assert.Empty(t, err)
+	defer mgr.Shutdown()
+	mgr.Check()
+	decisions = mgr.ListDecisions(0, 10)
+	assert.Equal(t, 1, len(decisions))
+	assert.Equal(t, "ch2", decisions[0].ChAddr)
+	assert.True(t, decisions[0].Settled)
+	assert.Equal(t, "Unredeemed amount 1000 reaches 500", decisions[0].Reason)
+	assert.Empty(t, decisions[0].Cost)
+	assert.Equal(t, 1, paymentMgr.costCalls)
+	assert.True(t, chs["sender2"].settling)
+}
@@ -29,6 +29,12 @@ const (
 	validKeyLen    = 65
 )
 
+// PageSize is the number of registered entries in a page of a paged register query, entries are ordered by node ID.
+const PageSize = 100
+
+// TotalCountHeader is the header of a register list response holding the number of entries across all pages.
+const TotalCountHeader = "X-Total-Count"
+
 // ValidateGatewayInfo check if a given gateway info is valid.
 func ValidateGatewayInfo(gwInfo *GatewayRegisteredInfo) bool {
 	rootKey, err := hex.DecodeString(gwInfo.RootKey)
@@ -99,3 +99,15 @@ var InvalidRefund = Record{
 	point:     -50,
 	violation: true,
 }
+
+var PaymentStoppedDuringRetrieval = Record{
+	reason:    "Stopped paying during an incremental retrieval",
+	point:     -10,
+	violation: true,
+}
+
+var InvalidPaymentDuringRetrieval = Record{
+	reason:    "Sent an invalid payment during an incremental retrieval",
+	point:     -50,
+	violation: true,
+}
@@ -25,6 +25,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/c-bata/go-prompt"
 	"github.com/wcgcyx/fc-retrieval/gateway-admin/pkg/gatewayadmin"
@@ -72,6 +73,11 @@ func completer(d prompt.Document) []prompt.Suggest {
 		{Text: "list-cids", Description: "List the cid access frequency of the default gateway"},
 		{Text: "get-offers", Description: "Get offers by given cid from the default gateway"},
 		{Text: "cache-content", Description: "Cache offer by given offer digest and and a given sub cid using the default gateway"},
+		{Text: "ls-channels", Description: "List inbound payment channels of the default gateway"},
+		{Text: "settle-channel", Description: "Estimate the cost to settle an inbound payment channel of the default gateway, settle it with --confirm"},
+		{Text: "collect-channel", Description: "Collect a settling inbound payment channel of the default gateway"},
+		{Text: "ls-settlements", Description: "List automatic settlement decisions of the default gateway"},
+		{Text: "cache-stats", Description: "Show cache statistics and cached content of the default gateway"},
 		{Text: "exit", Description: "Exit the program"},
 	}
 	return prompt.FilterHasPrefix(s, d.GetWordBeforeCursor(), true)
@@ -254,6 +260,86 @@ func (c *GatewayAdminCLI) executor(in string) {
 			return
 		}
 		fmt.Println("Content cached")
+	case "ls-channels":
+		senders, chAddrs, balances, redeemed, settling, err := c.admin.ListInboundChs(c.defaultGW)
+		if err != nil {
+			fmt.Printf("Error in listing inbound channels for given gateway: %v\n", err.Error())
+			return
+		}
+		fmt.Println("Inbound channels:")
+		for i, sender := range senders {
+			fmt.Printf("%v:\tsender-%v\tchannel-%v\tbalance-%v\tredeemed-%v\tsettling-%t\n", i, sender, chAddrs[i], balances[i], redeemed[i], settling[i])
+		}
+	case "settle-channel":
+		if len(blocks) != 2 && !(len(blocks) == 3 && blocks[2] == "--confirm") {
+			fmt.Println("Usage: settle-channel ${senderAddr} [--confirm]")
+			return
+		}
+		if len(blocks) == 2 {
+			cost, err := c.admin.EstimateSettleCh(c.defaultGW, blocks[1])
+			if err != nil {
+				fmt.Printf("Error in estimating cost to settle channel for given gateway: %v\n", err.Error())
+				return
+			}
+			fmt.Printf("Estimated cost to settle: %v, run again with --confirm to settle\n", cost.String())
+			return
+		}
+		cost, err := c.admin.SettleCh(c.defaultGW, blocks[1])
+		if err != nil {
+			fmt.Printf("Error in settling channel for given gateway: %v\n", err.Error())
+			return
+		}
+		fmt.Printf("Settlement submitted with estimated cost %v, channel can be collected after the settlement period\n", cost.String())
+	case "collect-channel":
+		if len(blocks) != 2 {
+			fmt.Println("Usage: collect-channel ${senderAddr}")
+			return
+		}
+		ok, msg, err := c.admin.CollectCh(c.defaultGW, blocks[1])
+		if err != nil {
+			fmt.Printf("Error in collecting channel for given gateway: %v\n", err.Error())
+			return
+		}
+		if !ok {
+			fmt.Printf("Fail to collect channel for given gateway: %v\n", msg)
+			return
+		}
+		fmt.Println("Done")
+	case "ls-settlements":
+		if len(blocks) != 2 {
+			fmt.Println("Usage: ls-settlements ${page}")
+			return
+		}
+		page, err := strconv.ParseUint(blocks[1], 10, 32)
+		if err != nil {
+			fmt.Printf("Error parsing unit int %v: %v\n", blocks[1], err.Error())
+			return
+		}
+		times, senders, chAddrs, redeemed, costs, reasons, settled, outcomes, err := c.admin.ListSettleDecisions(c.defaultGW, uint(page))
+		if err != nil {
+			fmt.Printf("Error in listing settlement decisions for given gateway: %v\n", err.Error())
+			return
+		}
+		fmt.Println("Settlement decisions:")
+		for i, sender := range senders {
+			fmt.Printf("%v:\ttime-%v\tsender-%v\tchannel-%v\tredeemed-%v\tcost-%v\tsettled-%t\treason-%v\toutcome-%v\n", i, time.Unix(times[i], 0).Format(time.RFC3339), sender, chAddrs[i], redeemed[i], costs[i], settled[i], reasons[i], outcomes[i])
+		}
+	case "cache-stats":
+		autoCache, hits, misses, fetched, evicted, spent, spendLimit, size, quota, cids, sizes, cidHits, lastAccess, err := c.admin.GetCacheStats(c.defaultGW)
+		if err != nil {
+			fmt.Printf("Error in getting cache stats for given gateway: %v\n", err.Error())
+			return
+		}
+		fmt.Printf("Cache size: %v bytes in %v content\n", size, len(cids))
+		if autoCache {
+			fmt.Printf("Automatic caching: quota-%v\thits-%v\tmisses-%v\tfetched-%v\tevicted-%v\tspent-%v/%v\n", quota, hits, misses, fetched, evicted, spent, spendLimit)
+		} else {
+			fmt.Println("Automatic caching: disabled")
+		}
+		fmt.Println("Cached content:")
+		for i, cid := range cids {
+			fmt.Printf("%v:	cid-%v	size-%v	hits-%v	last-access-%v\n", i, cid, sizes[i], cidHits[i], time.Unix(lastAccess[i], 0).Format(time.RFC3339))
+		}
 	case "exit":
 		fmt.Println("Shutdown gateway admin...")
 		fmt.Println("Bye!")
@@ -0,0 +1,60 @@
+/*
+Package adminapi - contains the the adminapi code.
+*/
+package adminapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// RequestGetCacheStats gets the cache statistics and the cached content of a given gateway.
+func RequestGetCacheStats(adminURL string, adminKey string) (
+	bool, // auto cache
+	uint64, // hits
+	uint64, // misses
+	uint64, // fetched
+	uint64, // evicted
+	string, // spent
+	string, // spend limit
+	int64, // size
+	int64, // quota
+	[]string, // cids
+	[]int64, // sizes
+	[]uint64, // cid hits
+	[]int64, // last access
+	error, // error
+) {
+	respType, respData, err := fcradminserver.Request(adminURL, adminKey, fcradminmsg.GetCacheStatsRequestType, []byte{0})
+	if err != nil {
+		err = fmt.Errorf("Error in sending request: %v", err.Error())
+		logging.Error(err.Error())
+		return false, 0, 0, 0, 0, "", "", 0, 0, nil, nil, nil, nil, err
+	}
+
+	if respType != fcradminmsg.GetCacheStatsResponseType {
+		err = fmt.Errorf("Getting response of wrong type expect %v, got %v", fcradminmsg.GetCacheStatsResponseType, respType)
+		logging.Error(err.Error())
+		return false, 0, 0, 0, 0, "", "", 0, 0, nil, nil, nil, nil, err
+	}
+
+	return fcradminmsg.DecodeGetCacheStatsResponse(respData)
+}
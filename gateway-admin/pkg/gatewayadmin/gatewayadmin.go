@@ -22,6 +22,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"sync"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
@@ -318,3 +319,130 @@ func (a *FilecoinRetrievalGatewayAdmin) ForceSync(targetID string) error {
 	}
 	return nil
 }
+
+// ListInboundChs lists the inbound payment channels of a managed gateway
+func (a *FilecoinRetrievalGatewayAdmin) ListInboundChs(targetID string) (
+	[]string, // senders
+	[]string, // channel addresses
+	[]string, // balances
+	[]string, // redeemed
+	[]bool, // settling
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	g, ok := a.activeGateways[targetID]
+	if !ok {
+		err := fmt.Errorf("Gateway %v is not in active gateways", targetID)
+		logging.Error(err.Error())
+		return nil, nil, nil, nil, nil, err
+	}
+	return adminapi.RequestListInboundChs(g.adminURL, g.adminKey)
+}
+
+// EstimateSettleCh gets the estimated cost for a managed gateway to settle the inbound payment channel from a given sender
+func (a *FilecoinRetrievalGatewayAdmin) EstimateSettleCh(targetID string, senderAddr string) (*big.Int, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	g, ok := a.activeGateways[targetID]
+	if !ok {
+		err := fmt.Errorf("Gateway %v is not in active gateways", targetID)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	cost, _, err := adminapi.RequestSettleCh(g.adminURL, g.adminKey, senderAddr, true)
+	return cost, err
+}
+
+// SettleCh asks a managed gateway to settle the inbound payment channel from a given sender
+// It returns the estimated cost of the settlement submitted
+func (a *FilecoinRetrievalGatewayAdmin) SettleCh(targetID string, senderAddr string) (*big.Int, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	g, ok := a.activeGateways[targetID]
+	if !ok {
+		err := fmt.Errorf("Gateway %v is not in active gateways", targetID)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	cost, submitted, err := adminapi.RequestSettleCh(g.adminURL, g.adminKey, senderAddr, false)
+	if err != nil {
+		return nil, err
+	}
+	if !submitted {
+		err = fmt.Errorf("Settlement of channel from %v is not submitted", senderAddr)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	return cost, nil
+}
+
+// CollectCh asks a managed gateway to collect the settling inbound payment channel from a given sender
+func (a *FilecoinRetrievalGatewayAdmin) CollectCh(targetID string, senderAddr string) (
+	bool, // Success
+	string, // Information
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	g, ok := a.activeGateways[targetID]
+	if !ok {
+		err := fmt.Errorf("Gateway %v is not in active gateways", targetID)
+		logging.Error(err.Error())
+		return false, "", err
+	}
+	return adminapi.RequestCollectCh(g.adminURL, g.adminKey, senderAddr)
+}
+
+// ListSettleDecisions lists the automatic settlement decisions from a managed gateway, most recent first
+func (a *FilecoinRetrievalGatewayAdmin) ListSettleDecisions(targetID string, page uint) (
+	[]int64, // times
+	[]string, // senders
+	[]string, // channel addresses
+	[]string, // redeemed
+	[]string, // costs
+	[]string, // reasons
+	[]bool, // settled
+	[]string, // outcomes
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	g, ok := a.activeGateways[targetID]
+	if !ok {
+		err := fmt.Errorf("Gateway %v is not in active gateways", targetID)
+		logging.Error(err.Error())
+		return nil, nil, nil, nil, nil, nil, nil, nil, err
+	}
+	// page 0 is from 0 to 10
+	// page 1 is from 10 to 20...
+	return adminapi.RequestListSettleDecisions(g.adminURL, g.adminKey, 10*page, 10*(page+1))
+}
+
+// GetCacheStats gets the cache statistics and the cached content of a managed gateway
+func (a *FilecoinRetrievalGatewayAdmin) GetCacheStats(targetID string) (
+	bool, // auto cache
+	uint64, // hits
+	uint64, // misses
+	uint64, // fetched
+	uint64, // evicted
+	string, // spent
+	string, // spend limit
+	int64, // size
+	int64, // quota
+	[]string, // cids
+	[]int64, // sizes
+	[]uint64, // cid hits
+	[]int64, // last access
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	g, ok := a.activeGateways[targetID]
+	if !ok {
+		err := fmt.Errorf("Gateway %v is not in active gateways", targetID)
+		logging.Error(err.Error())
+		return false, 0, 0, 0, 0, "", "", 0, 0, nil, nil, nil, nil, err
+	}
+	return adminapi.RequestGetCacheStats(g.adminURL, g.adminKey)
+}
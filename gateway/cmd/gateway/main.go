@@ -38,6 +38,8 @@ import (
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachectrl"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
@@ -47,6 +49,7 @@ import (
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrregistermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrsettlemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/api/adminapi"
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/api/p2papi"
@@ -111,7 +114,12 @@ func main() {
 		AddHandler(fcradminmsg.InspectPeerRequestType, adminapi.InspectPeerHandler).
 		AddHandler(fcradminmsg.ListCIDFrequencyRequestType, adminapi.ListCIDFrequencyHandler).
 		AddHandler(fcradminmsg.ListPeersRequestType, adminapi.ListPeersHandler).
-		AddHandler(fcradminmsg.ForceSyncRequestType, adminapi.ForceSyncHandler)
+		AddHandler(fcradminmsg.ForceSyncRequestType, adminapi.ForceSyncHandler).
+		AddHandler(fcradminmsg.ListInboundChsRequestType, adminapi.ListInboundChsHandler).
+		AddHandler(fcradminmsg.SettleChRequestType, adminapi.SettleChHandler).
+		AddHandler(fcradminmsg.CollectChRequestType, adminapi.CollectChHandler).
+		AddHandler(fcradminmsg.ListSettleDecisionsRequestType, adminapi.ListSettleDecisionsHandler).
+		AddHandler(fcradminmsg.GetCacheStatsRequestType, adminapi.GetCacheStatsHandler)
 
 	err = c.AdminServer.Start()
 	if err != nil {
@@ -171,12 +179,39 @@ func main() {
 		c.MsgSigningKeyVer = byte(msgSigningKeyVer)
 		c.P2PServer = fcrserver.NewFCRServerImplV1(p2pPrivKey, uint(p2pPort), c.Settings.TCPInactivityTimeout)
 		c.ReputationMgr = fcrreputationmgr.NewFCRReputationMgrImpV1()
-		registerMgr := fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
+		c.RegisterMgr = fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
 		c.StoreFullOffer = c.Settings.StoreFullOffer
-		c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(registerMgr, c.ReputationMgr, true, true, !c.StoreFullOffer, nodeID, c.Settings.SyncDuration)
+		c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(c.RegisterMgr, c.ReputationMgr, true, true, !c.StoreFullOffer, nodeID, int(c.Settings.ReplicationFactor), c.Settings.SyncDuration)
 		lotusMgr := fcrlotusmgr.NewFCRLotusMgrImplV1(lotusAPIAddr, lotusAuthToken, nil)
-		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
-		c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+		if c.Settings.PersistPayment {
+			c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV2(rootPrivKey, lotusMgr, c.Settings.PaymentDBFile)
+		} else {
+			c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
+		}
+		if c.Settings.AutoSettle {
+			c.SettleMgr = fcrsettlemgr.NewFCRSettleMgrImplV1(c.PaymentMgr, lotusMgr, c.PeerMgr, fcrsettlemgr.SettlePolicy{
+				MinRedeemed:   c.Settings.SettleMinRedeemed,
+				MaxAge:        c.Settings.SettleMaxAge,
+				Deregistering: c.Settings.SettleDeregistering,
+				MaxCostRatio:  c.Settings.SettleMaxCostRatio,
+			}, c.Settings.SettleCheckDuration)
+		}
+		if c.Settings.PersistOffer {
+			c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV2(c.Settings.OfferDBFile, true)
+		} else {
+			c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+		}
+		c.CacheMgr = fcrcachemgr.NewFCRCacheMgrImplV1(c.Settings.RetrievalDir)
+		if c.Settings.AutoCache {
+			c.CacheCtrl = fcrcachectrl.NewFCRCacheCtrlImplV1(c.NodeID, c.CacheMgr, c.OfferMgr, c.FetchContent, fcrcachectrl.CachePolicy{
+				Eviction:  c.Settings.CacheEviction,
+				MaxSize:   c.Settings.CacheMaxSize,
+				MaxSpend:  c.Settings.CacheMaxSpend,
+				MaxPrice:  c.Settings.CacheMaxPrice,
+				TopN:      c.Settings.CacheTopN,
+				MinAccess: c.Settings.CacheMinAccess,
+			}, c.Settings.CacheCheckDuration)
+		}
 		c.Ready <- true
 		if !<-c.Ready {
 			return
@@ -195,11 +230,17 @@ func main() {
 		AddHandler(fcrmessages.EstablishmentRequestType, p2papi.EstablishmentHandler).
 		AddHandler(fcrmessages.StandardOfferDiscoveryRequestType, p2papi.OfferQueryHandler).
 		AddHandler(fcrmessages.DHTOfferDiscoveryRequestType, p2papi.DHTOfferQueryHandler).
+		AddHandler(fcrmessages.DHTLookupRequestType, p2papi.DHTLookupHandler).
 		AddHandler(fcrmessages.OfferPublishRequestType, p2papi.OfferPublishHandler).
+		AddHandler(fcrmessages.OfferSyncRequestType, p2papi.OfferSyncHandler).
+		AddHandler(fcrmessages.OfferRevocationRequestType, p2papi.OfferRevocationHandler).
+		AddHandler(fcrmessages.DataRetrievalRequestType, p2papi.DataRetrievalHandler).
 		// Requesters
 		AddRequester(fcrmessages.StandardOfferDiscoveryRequestType, p2papi.OfferQueryRequester).
 		AddRequester(fcrmessages.EstablishmentRequestType, p2papi.EstablishmentRequester).
-		AddRequester(fcrmessages.DataRetrievalRequestType, p2papi.DataRetrievalRequester)
+		AddRequester(fcrmessages.DataRetrievalRequestType, p2papi.DataRetrievalRequester).
+		AddRequester(fcrmessages.OfferPublishRequestType, p2papi.OfferPublishRequester).
+		AddRequester(fcrmessages.OfferSyncRequestType, p2papi.OfferSyncRequester)
 
 	err = c.P2PServer.Start()
 	if err != nil {
@@ -225,6 +266,16 @@ func main() {
 		return
 	}
 
+	if c.SettleMgr != nil {
+		err = c.SettleMgr.Start()
+		if err != nil {
+			logging.Error("Error in starting Settle Manager: %v", err)
+			c.Ready <- false
+			gracefulExit()
+			return
+		}
+	}
+
 	err = c.OfferMgr.Start()
 	if err != nil {
 		logging.Error("Error in starting Offer Manager: %v", err)
@@ -241,6 +292,24 @@ func main() {
 		return
 	}
 
+	err = c.CacheMgr.Start()
+	if err != nil {
+		logging.Error("Error in starting Cache Manager: %v", err)
+		c.Ready <- false
+		gracefulExit()
+		return
+	}
+
+	if c.CacheCtrl != nil {
+		err = c.CacheCtrl.Start()
+		if err != nil {
+			logging.Error("Error in starting Cache Controller: %v", err)
+			c.Ready <- false
+			gracefulExit()
+			return
+		}
+	}
+
 	// Everything has been started.
 	c.Ready <- true
 	// Wait for this gateway to be registered.
@@ -254,9 +323,40 @@ func main() {
 	logging.Info("Filecoin Gateway Start-up Complete")
 	c.PeerMgr.Sync()
 
-	// Wait forever
-	// TODO: Start message signing key update routine.
-	select {}
+	// Start offer sync routine if this gateway stores offers within a cid hash range
+	if !c.StoreFullOffer {
+		go offerSyncRoutine(c)
+	}
+
+	// Start message signing key update routine, it runs forever
+	msgKeyUpdateRoutine(c)
+}
+
+// offerSyncRoutine hands off and pulls offers whenever the cid hash range of this gateway changes,
+// as gateways join or leave the ring.
+func offerSyncRoutine(c *core.Core) {
+	lastMin, lastMax := "", ""
+	for {
+		minStr, maxStr := c.PeerMgr.GetCurrentCIDHashRange()
+		if minStr != lastMin || maxStr != lastMax {
+			logging.Info("CID hash range changed from [%v, %v] to [%v, %v], start offer sync", lastMin, lastMax, minStr, maxStr)
+			c.HandOffOffers()
+			c.PullOffers()
+			lastMin, lastMax = minStr, maxStr
+		}
+		time.Sleep(c.Settings.SyncDuration)
+	}
+}
+
+// msgKeyUpdateRoutine updates the message signing key periodically
+func msgKeyUpdateRoutine(c *core.Core) {
+	for {
+		time.Sleep(c.Settings.MsgKeyUpdateDuration)
+		err := c.UpdateMsgSigningKey()
+		if err != nil {
+			logging.Error("Error in updating message signing key: %v", err.Error())
+		}
+	}
 }
 
 // gracefulExit handles exit
@@ -275,6 +375,9 @@ func gracefulExit() {
 	if c.PeerMgr != nil {
 		c.PeerMgr.Shutdown()
 	}
+	if c.SettleMgr != nil {
+		c.SettleMgr.Shutdown()
+	}
 	if c.PaymentMgr != nil {
 		c.PaymentMgr.Shutdown()
 	}
@@ -284,6 +387,12 @@ func gracefulExit() {
 	if c.ReputationMgr != nil {
 		c.ReputationMgr.Shutdown()
 	}
+	if c.CacheCtrl != nil {
+		c.CacheCtrl.Shutdown()
+	}
+	if c.CacheMgr != nil {
+		c.CacheMgr.Shutdown()
+	}
 
 	logging.Info("Filecoin Gateway Shutdown: Completed")
 }
@@ -24,7 +24,6 @@ import (
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
-	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
 )
@@ -54,6 +53,11 @@ func CacheOfferByDigestHandler(data []byte) (byte, []byte, error) {
 		ack := fcradminmsg.EncodeACK(false, err.Error())
 		return fcradminmsg.ACKType, ack, err
 	}
+	if c.CacheMgr.Get(cid) != nil {
+		err = fmt.Errorf("Content %v has already been cached", cidStr)
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
 	offer := c.OfferMgr.GetOfferByDigest(digest)
 	if offer == nil {
 		err = fmt.Errorf("Cannot find offer with digest: %v", digest)
@@ -67,21 +71,9 @@ func CacheOfferByDigestHandler(data []byte) (byte, []byte, error) {
 		return fcradminmsg.ACKType, ack, err
 	}
 
-	// Get provider information
-	pvdInfo := c.PeerMgr.GetPVDInfo(suboffer.GetProviderID())
-	if pvdInfo == nil {
-		// Not found, try sync once
-		pvdInfo = c.PeerMgr.SyncPVD(suboffer.GetProviderID())
-		if pvdInfo == nil {
-			err = fmt.Errorf("Cannot find provider %v that supplied the offer", suboffer.GetProviderID())
-			ack := fcradminmsg.EncodeACK(false, err.Error())
-			return fcradminmsg.ACKType, ack, err
-		}
-	}
 	// Do caching
-	_, err = c.P2PServer.Request(pvdInfo.NetworkAddr, fcrmessages.DataRetrievalRequestType, pvdInfo.NodeID, suboffer)
+	err = c.FetchContent(suboffer)
 	if err != nil {
-		err = fmt.Errorf("Error in data retrieval: %v", err.Error())
 		ack := fcradminmsg.EncodeACK(false, err.Error())
 		return fcradminmsg.ACKType, ack, err
 	}
@@ -0,0 +1,71 @@
+/*
+Package adminapi contains the API code for the admin client - gateway communication.
+*/
+package adminapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
+)
+
+// GetCacheStatsHandler handles get cache statistics request
+func GetCacheStatsHandler(data []byte) (byte, []byte, error) {
+	logging.Debug("Handle get cache stats from admin")
+	// Get core
+	c := core.GetSingleInstance()
+	if !c.Initialised {
+		// Not initialised.
+		err := errors.New("Not initialised")
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	// Statistics are only collected when automatic caching is enabled
+	var hits, misses, fetched, evicted uint64
+	spent, spendLimit := "0", "0"
+	quota := int64(0)
+	if c.CacheCtrl != nil {
+		stats := c.CacheCtrl.GetStats()
+		hits, misses, fetched, evicted = stats.Hits, stats.Misses, stats.Fetched, stats.Evicted
+		spent, spendLimit = stats.Spent.String(), stats.SpendLimit.String()
+		quota = stats.Quota
+	}
+	cids := make([]string, 0)
+	sizes := make([]int64, 0)
+	cidHits := make([]uint64, 0)
+	lastAccess := make([]int64, 0)
+	for _, entry := range c.CacheMgr.List() {
+		cids = append(cids, entry.CID)
+		sizes = append(sizes, entry.Size)
+		cidHits = append(cidHits, entry.Hits)
+		lastAccess = append(lastAccess, entry.LastAccess)
+	}
+
+	// Succeed
+	response, err := fcradminmsg.EncodeGetCacheStatsResponse(c.CacheCtrl != nil, hits, misses, fetched, evicted, spent, spendLimit, c.CacheMgr.Size(), quota, cids, sizes, cidHits, lastAccess)
+	if err != nil {
+		err = fmt.Errorf("Error in encoding response: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+	return fcradminmsg.GetCacheStatsResponseType, response, nil
+}
@@ -26,6 +26,8 @@ import (
 	"time"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachectrl"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrlotusmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
@@ -34,6 +36,7 @@ import (
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrregistermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrsettlemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/register"
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
@@ -90,16 +93,47 @@ func InitialisationHandler(data []byte) (byte, []byte, error) {
 	c.ReputationMgr = fcrreputationmgr.NewFCRReputationMgrImpV1()
 
 	// Initialise peer manager
-	registerMgr := fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
+	c.RegisterMgr = fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
 	c.StoreFullOffer = c.Settings.StoreFullOffer
-	c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(registerMgr, c.ReputationMgr, true, true, !c.StoreFullOffer, nodeID, c.Settings.SyncDuration)
+	c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(c.RegisterMgr, c.ReputationMgr, true, true, !c.StoreFullOffer, nodeID, int(c.Settings.ReplicationFactor), c.Settings.SyncDuration)
 
 	// Initialise payment manager
 	lotusMgr := fcrlotusmgr.NewFCRLotusMgrImplV1(lotusAPIAddr, lotusAuthToken, nil)
-	c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
+	if c.Settings.PersistPayment {
+		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV2(rootPrivKey, lotusMgr, c.Settings.PaymentDBFile)
+	} else {
+		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
+	}
+
+	// Initialise settle manager
+	if c.Settings.AutoSettle {
+		c.SettleMgr = fcrsettlemgr.NewFCRSettleMgrImplV1(c.PaymentMgr, lotusMgr, c.PeerMgr, fcrsettlemgr.SettlePolicy{
+			MinRedeemed:   c.Settings.SettleMinRedeemed,
+			MaxAge:        c.Settings.SettleMaxAge,
+			Deregistering: c.Settings.SettleDeregistering,
+			MaxCostRatio:  c.Settings.SettleMaxCostRatio,
+		}, c.Settings.SettleCheckDuration)
+	}
 
 	// Initialise offer manager
-	c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+	if c.Settings.PersistOffer {
+		c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV2(c.Settings.OfferDBFile, true)
+	} else {
+		c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+	}
+
+	// Initialise cache manager
+	c.CacheMgr = fcrcachemgr.NewFCRCacheMgrImplV1(c.Settings.RetrievalDir)
+	if c.Settings.AutoCache {
+		c.CacheCtrl = fcrcachectrl.NewFCRCacheCtrlImplV1(c.NodeID, c.CacheMgr, c.OfferMgr, c.FetchContent, fcrcachectrl.CachePolicy{
+			Eviction:  c.Settings.CacheEviction,
+			MaxSize:   c.Settings.CacheMaxSize,
+			MaxSpend:  c.Settings.CacheMaxSpend,
+			MaxPrice:  c.Settings.CacheMaxPrice,
+			TopN:      c.Settings.CacheTopN,
+			MinAccess: c.Settings.CacheMinAccess,
+		}, c.Settings.CacheCheckDuration)
+	}
 
 	// Ask the server to start
 	c.Ready <- true
@@ -111,7 +145,7 @@ func InitialisationHandler(data []byte) (byte, []byte, error) {
 	}
 
 	// Initialisation succeed. Start register this gateway.
-	err = registerMgr.RegisterGateway(nodeID, &register.GatewayRegisteredInfo{
+	err = c.RegisterMgr.RegisterGateway(nodeID, &register.GatewayRegisteredInfo{
 		RootKey:             rootKey,
 		NodeID:              nodeID,
 		MsgSigningKey:       msgSigningKey,
@@ -0,0 +1,90 @@
+/*
+Package adminapi contains the API code for the admin client - gateway communication.
+*/
+package adminapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
+)
+
+// SettleChHandler handles settle inbound payment channel request
+func SettleChHandler(data []byte) (byte, []byte, error) {
+	logging.Debug("Handle settle channel from admin")
+	// Get core
+	c := core.GetSingleInstance()
+	if !c.Initialised {
+		// Not initialised.
+		err := errors.New("Not initialised")
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	// Decode payload
+	senderAddr, estimateOnly, err := fcradminmsg.DecodeSettleChRequest(data)
+	if err != nil {
+		err = fmt.Errorf("Error in decoding payload: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	if !estimateOnly {
+		valid, err := c.PaymentMgr.CheckSettlementValidity(senderAddr)
+		if err != nil {
+			err = fmt.Errorf("Error in checking settlement validity: %v", err.Error())
+			ack := fcradminmsg.EncodeACK(false, err.Error())
+			return fcradminmsg.ACKType, ack, err
+		}
+		if !valid {
+			err = fmt.Errorf("Channel from %v is not valid to settle", senderAddr)
+			ack := fcradminmsg.EncodeACK(false, err.Error())
+			return fcradminmsg.ACKType, ack, err
+		}
+	}
+
+	cost, err := c.PaymentMgr.GetCostToSettle(senderAddr)
+	if err != nil {
+		err = fmt.Errorf("Error in estimating cost to settle: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	if !estimateOnly {
+		// Settlement waits for every message to be executed on chain, it is done in background
+		go func() {
+			if err := c.PaymentMgr.Settle(senderAddr); err != nil {
+				logging.Error("Error in settling channel from %v: %v", senderAddr, err.Error())
+				return
+			}
+			logging.Info("Channel from %v has been called to settle", senderAddr)
+		}()
+	}
+
+	// Succeed
+	response, err := fcradminmsg.EncodeSettleChResponse(cost, !estimateOnly)
+	if err != nil {
+		err = fmt.Errorf("Error in encoding response: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+	return fcradminmsg.SettleChResponseType, response, nil
+}
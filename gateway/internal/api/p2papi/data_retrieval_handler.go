@@ -0,0 +1,60 @@
+/*
+Package p2papi contains the API code for the p2p communication.
+*/
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrdataretrieval"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
+)
+
+// DataRetrievalHandler handles data retrieval request, it serves content from the cache of this gateway.
+func DataRetrievalHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver.FCRServerResponseWriter, request *fcrmessages.FCRReqMsg) error {
+	logging.Debug("Handle data retrieval")
+	// Get core structure
+	c := core.GetSingleInstance()
+
+	handler := fcrdataretrieval.Handler{
+		PeerMgr:       c.PeerMgr,
+		PaymentMgr:    c.PaymentMgr,
+		ReputationMgr: c.ReputationMgr,
+		SearchPrice:   c.Settings.SearchPrice,
+		Timeout:       c.Settings.TCPInactivityTimeout,
+		// Only hold the msg signing key lock when signing a response, not for the whole stream
+		Write: func(writer fcrserver.FCRServerResponseWriter, response *fcrmessages.FCRACKMsg) error {
+			msgKey, msgKeyVer := c.GetMsgSigningKey()
+			return writer.Write(response, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+		},
+		// Only resale offers of this gateway can be served, they are signed by the msg signing key
+		VerifyOffer: c.VerifyResaleOffer,
+		GetContent: func(id *cid.ContentID) *fcrdataretrieval.Content {
+			entry := c.CacheMgr.Get(id)
+			if entry == nil {
+				// Content has been evicted
+				return nil
+			}
+			return &fcrdataretrieval.Content{Tag: entry.CID, Path: entry.Path, DAGParams: entry.DAG}
+		},
+		RecordAccess: c.CacheMgr.RecordAccess,
+	}
+	return handler.Handle(reader, writer, request)
+}
@@ -21,9 +21,8 @@ package p2papi
 import (
 	"fmt"
 	"math/big"
-	"math/rand"
 	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
@@ -59,11 +58,10 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 
 	// Get core structure
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
 
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
 	// Get provider information
 	pvdInfo := c.PeerMgr.GetPVDInfo(targetID)
@@ -154,7 +152,7 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 
 	// Now we have got a voucher
 	// Encode request
-	request, err := fcrmessages.EncodeDataRetrievalRequest(nonce, c.NodeID, offer, c.WalletAddr, voucher)
+	request, err := fcrmessages.EncodeDataRetrievalRequest(nonce, c.NodeID, offer, c.WalletAddr, voucher, 0, nil)
 	if err != nil {
 		c.PaymentMgr.RevertPay(recipientAddr, 0)
 		err = fmt.Errorf("Internal error in encoding response: %v", err.Error())
@@ -163,7 +161,7 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 	}
 
 	// Write request
-	err = writer.Write(request, c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+	err = writer.Write(request, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	if err != nil {
 		err = fmt.Errorf("Error in sending request to %v: %v", targetID, err.Error())
 		logging.Error(err.Error())
@@ -185,11 +183,11 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 	}
 
 	// Verify the response
-	if response.Verify(pvdInfo.MsgSigningKey, pvdInfo.MsgSigningKeyVer) != nil {
+	if pvdInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
 		pvdInfo = c.PeerMgr.SyncPVD(targetID)
-		if pvdInfo == nil || response.Verify(pvdInfo.MsgSigningKey, pvdInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		if pvdInfo == nil || pvdInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			// Pend PVD
 			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
@@ -208,8 +206,8 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		return nil, err
 	}
 
-	// Decode response
-	nonceRecv, tag, data, err := fcrmessages.DecodeDataRetrievalResponse(response)
+	// Decode response header
+	nonceRecv, _, size, chunks, dagParams, _, err := fcrmessages.DecodeDataRetrievalResponse(response, nil)
 	if err != nil {
 		err = fmt.Errorf("Error in decoding response from %v: %v", targetID, err.Error())
 		logging.Error(err.Error())
@@ -228,42 +226,101 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		return nil, err
 	}
 
-	// Save file
-	if _, err := os.Stat(filepath.Join(c.Settings.RetrievalDir, tag)); os.IsNotExist(err) {
-		// Not exist, save
-		f, err := os.Create(filepath.Join(c.Settings.RetrievalDir, tag))
+	// Receive into a temporary file of the cache
+	f, err := c.CacheMgr.NewTempFile()
+	if err != nil {
+		err = fmt.Errorf("Error saving file: %v", err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	filename := f.Name()
+
+	// Receive chunks and write them to disk as they arrive
+	received := uint64(0)
+	for index := uint64(0); index < chunks; index++ {
+		chunk, err := reader.Read(c.Settings.TCPInactivityTimeout)
+		if err != nil {
+			f.Close()
+			os.Remove(filename)
+			err = fmt.Errorf("Error in receiving chunk %v from %v: %v", index, targetID, err.Error())
+			logging.Error(err.Error())
+			// Pend PVD
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.NetworkErrorAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return nil, err
+		}
+		// Every chunk is verified on its own
+		if pvdInfo.VerifyMsg(chunk.Verify) != nil {
+			f.Close()
+			os.Remove(filename)
+			err = fmt.Errorf("Error in verifying chunk %v from %v", index, targetID)
+			logging.Error(err.Error())
+			// Pend PVD
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return nil, err
+		}
+		nonceRecv, indexRecv, data, err := fcrmessages.DecodeDataChunkResponse(chunk)
 		if err == nil {
-			_, err = f.Write(data)
+			if nonceRecv != nonce {
+				err = fmt.Errorf("Nonce mismatch: expected %v got %v", nonce, nonceRecv)
+			} else if indexRecv != index {
+				err = fmt.Errorf("Chunk index mismatch: expected %v got %v", index, indexRecv)
+			} else if received+uint64(len(data)) > size {
+				err = fmt.Errorf("Chunk exceeds declared size %v", size)
+			}
+		}
+		if err != nil {
 			f.Close()
+			os.Remove(filename)
+			err = fmt.Errorf("Error in decoding chunk %v from %v: %v", index, targetID, err.Error())
+			logging.Error(err.Error())
+			// Pend PVD
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return nil, err
 		}
+		_, err = f.Write(data)
 		if err != nil {
+			f.Close()
+			os.Remove(filename)
 			err = fmt.Errorf("Error saving file: %v", err.Error())
 			logging.Error(err.Error())
 			return nil, err
 		}
-	} else {
-		// Exist
-		err = fmt.Errorf("Filename already existed %v", tag)
+		received += uint64(len(data))
+	}
+	err = f.Close()
+	if err == nil && received != size {
+		err = fmt.Errorf("Received %v bytes, expected %v", received, size)
+	}
+	if err != nil {
+		os.Remove(filename)
+		err = fmt.Errorf("Error saving file: %v", err.Error())
 		logging.Error(err.Error())
 		return nil, err
 	}
 
 	// Read file
-	fileReader, err := os.Open(filepath.Join(c.Settings.RetrievalDir, tag))
+	fileReader, err := os.Open(filename)
 	if err != nil {
-		err = fmt.Errorf("Fail to open file for cid calculation %v: %v", tag, err.Error())
+		os.Remove(filename)
+		err = fmt.Errorf("Fail to open file for cid calculation: %v", err.Error())
 		logging.Error(err.Error())
 		return nil, err
 	}
-	cid, err := cid.NewContentIDFromFile(fileReader)
+	id, err := cid.NewContentIDFromFileWithParams(fileReader, dagParams)
+	fileReader.Close()
 	if err != nil {
+		os.Remove(filename)
 		err = fmt.Errorf("Invalid CID: %v", err.Error())
 		logging.Error(err.Error())
 		return nil, err
 	}
 	// Check file cid
-	if cid.ToString() != offer.GetSubCID().ToString() {
-		err = fmt.Errorf("Received data with wrong cid expected: %v got: %v", offer.GetSubCID().ToString(), cid.ToString())
+	if id.ToString() != offer.GetSubCID().ToString() {
+		os.Remove(filename)
+		err = fmt.Errorf("Received data with wrong cid expected: %v got: %v", offer.GetSubCID().ToString(), id.ToString())
 		logging.Error(err.Error())
 		// Pend PVD
 		c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
@@ -271,7 +328,34 @@ func DataRetrievalRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 		return nil, err
 	}
 
-	// Succeed
 	c.ReputationMgr.UpdatePeerRecord(targetID, reputation.ContentRetrieved.Copy(), 0)
+
+	// Add to cache
+	_, err = c.CacheMgr.Add(id, filename, dagParams)
+	if err != nil {
+		os.Remove(filename)
+		err = fmt.Errorf("Error in caching content %v: %v", id.ToString(), err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Advertise the cached content, priced on what has been paid for it
+	price, _ := new(big.Float).Mul(new(big.Float).SetInt(offer.GetPrice()), big.NewFloat(c.Settings.CachePriceRatio)).Int(nil)
+	expiry := time.Now().Add(c.Settings.CacheOfferDuration).Unix()
+	resale, err := cidoffer.NewCIDOffer(c.NodeID, []cid.ContentID{*id}, price, expiry, offer.GetQoS())
+	if err == nil {
+		// Sign with the latest key, the key may have been updated during the retrieval
+		resaleKey, _ := c.GetMsgSigningKey()
+		err = resale.Sign(resaleKey)
+	}
+	if err != nil {
+		// Content is cached, but cannot be advertised
+		err = fmt.Errorf("Error in creating resale offer for cached content %v: %v", id.ToString(), err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	c.OfferMgr.AddOffer(resale)
+
+	// Succeed
 	return response, nil
 }
@@ -21,10 +21,12 @@ package p2papi
 import (
 	"encoding/hex"
 	"fmt"
-
 	"math/big"
+	"time"
 
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
@@ -35,43 +37,46 @@ func DHTOfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 	logging.Debug("Handle dht offer query")
 	// Get core structure
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	// Only hold the msg signing key lock when signing a response, so a key update is never blocked by a request
+	respond := func(response *fcrmessages.FCRACKMsg) error {
+		msgKey, msgKeyVer := c.GetMsgSigningKey()
+		return writer.Write(response, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	}
 
 	// Message decoding
-	nonce, senderID, pieceCID, numDHT, maxOfferRequestedPerDHT, accountAddr, voucher, err := fcrmessages.DecodeDHTOfferDiscoveryRequest(request)
+	nonce, senderID, pieceCID, numDHT, maxOfferRequestedPerDHT, maxOfferRequested, accountAddr, voucher, err := fcrmessages.DecodeDHTOfferDiscoveryRequest(request)
 	if err != nil {
-		err = fmt.Errorf("Error in decoding payload: %v", err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payload: %v", err.Error())}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
 	// Verify signature
 	if request.VerifyByID(senderID) != nil {
-		err = fmt.Errorf("Error in verifying request from %v: %v", senderID, err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from %v", senderID)}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
 	// Check numDHT
 	if numDHT > 16 {
-		err = fmt.Errorf("Error exceeding maximum numDHT 16 from %v, got %v", senderID, numDHT)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error exceeding maximum numDHT 16 from %v, got %v", senderID, numDHT)}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
 	// Check payment
 	refundVoucher := ""
 	received, lane, err := c.PaymentMgr.Receive(accountAddr, voucher)
 	if err != nil {
-		err = fmt.Errorf("Error in receiving voucher %v:", err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidPayment, Message: fmt.Sprintf("Error in receiving voucher %v:", err.Error())}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 	if lane != 0 {
-		err = fmt.Errorf("Not correct lane received expect 0 got %v:", lane)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidPayment, Message: fmt.Sprintf("Not correct lane received expect 0 got %v:", lane)}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 	// expected is 1 * search price + numDHT * (search price + max offer per DHT * offer price)
 	expected := big.NewInt(0).Add(c.Settings.SearchPrice, big.NewInt(0).Mul(big.NewInt(0).Add(c.Settings.SearchPrice, big.NewInt(0).Mul(c.Settings.OfferPrice, big.NewInt(int64(maxOfferRequestedPerDHT)))), big.NewInt(int64(numDHT))))
@@ -87,13 +92,16 @@ func DHTOfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 				logging.Error("Error in refunding: %v", err.Error())
 			}
 		}
-		err = fmt.Errorf("Short payment received, expect %v got %v, refund voucher %v", expected.String(), received.String(), refundVoucher)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeShortPayment, Message: fmt.Sprintf("Short payment received, expect %v got %v, refund voucher %v", expected.String(), received.String(), refundVoucher), ExpectedAmount: expected, RefundVoucher: refundVoucher}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
 	// Payment is fine, search.
 	c.OfferMgr.IncrementCIDAccessCount(pieceCID)
+	if c.CacheCtrl != nil {
+		c.CacheCtrl.RecordLookup(pieceCID)
+	}
 	cidHash, err := pieceCID.CalculateHash()
 	if err != nil {
 		// Internal error in calculating cid hash
@@ -103,13 +111,13 @@ func DHTOfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 			// This should never happen
 			logging.Error("Error in refunding %v", ierr.Error())
 		}
-		err = fmt.Errorf("Error in calculating cid hash: %v, refund voucher: %v", err.Error(), refundVoucher)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Error in calculating cid hash: %v, refund voucher: %v", err.Error(), refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
-	// Get gateways
-	gws := c.PeerMgr.GetGWSNearCIDHash(hex.EncodeToString(cidHash), int(numDHT), c.NodeID)
+	// Get gateways, the ones beyond numDHT replace those failing to respond
+	gws := c.PeerMgr.GetGWSNearCIDHash(hex.EncodeToString(cidHash), int(numDHT)*2, c.NodeID)
 	if err != nil {
 		// Internal error in getting near gateways
 		var ierr error
@@ -118,26 +126,16 @@ func DHTOfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 			// This should never happen
 			logging.Error("Error in refunding %v", ierr.Error())
 		}
-		err = fmt.Errorf("Internal error in getting near gateways to requested cid: %v with hash: %v, refund voucher: %v", pieceCID.ToString(), hex.EncodeToString(cidHash), refundVoucher)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in getting near gateways to requested cid: %v with hash: %v, refund voucher: %v", pieceCID.ToString(), hex.EncodeToString(cidHash), refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
-	// TODO: Concurrency
+	// Query the gateways concurrently, only the responses collected before the query stops are charged
+	contacted, found := queryNearGateways(c, gws, pieceCID, numDHT, maxOfferRequestedPerDHT, maxOfferRequested)
 	supposed := big.NewInt(0).Set(c.Settings.SearchPrice)
-	contacted := make(map[string]*fcrmessages.FCRACKMsg)
-	for _, gw := range gws {
-		resp, err := c.P2PServer.Request(gw.NetworkAddr, fcrmessages.StandardOfferDiscoveryRequestType, gw.NodeID, pieceCID, maxOfferRequestedPerDHT)
-		if err != nil {
-			continue
-		}
-		found := int64(maxOfferRequestedPerDHT)
-		_, offers, _, _ := fcrmessages.DecodeStandardOfferDiscoveryResponse(resp)
-		if len(offers) < int(maxOfferRequestedPerDHT) {
-			found = int64(len(offers))
-		}
-		supposed.Add(c.Settings.SearchPrice, big.NewInt(0).Mul(c.Settings.OfferPrice, big.NewInt(found)))
-		contacted[gw.NodeID] = resp
+	for _, n := range found {
+		supposed.Add(supposed, big.NewInt(0).Add(c.Settings.SearchPrice, big.NewInt(0).Mul(c.Settings.OfferPrice, big.NewInt(n))))
 	}
 	if supposed.Cmp(expected) < 0 {
 		var ierr error
@@ -158,10 +156,74 @@ func DHTOfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 			// This should never happen
 			logging.Error("Error in refunding %v", ierr.Error())
 		}
-		err = fmt.Errorf("Internal error in encoding response: %v, refund voucher %v", err.Error(), refundVoucher)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in encoding response: %v, refund voucher %v", err.Error(), refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
+	}
+
+	return respond(response)
+}
+
+// queryNearGateways queries given gateways for offers of a given cid, closest first, with a bounded number of concurrent requests.
+// A gateway failing to respond is replaced by the next one. It stops once numDHT gateways have responded, maxOfferRequested offers
+// are collected, the gateways are exhausted or the deadline is reached, responses arriving after it stops are ignored.
+// It returns the responses and the number of offers found, by gateway ID.
+func queryNearGateways(c *core.Core, gws []fcrpeermgr.Peer, pieceCID *cid.ContentID, numDHT uint32, maxOfferRequestedPerDHT uint32, maxOfferRequested uint32) (map[string]*fcrmessages.FCRACKMsg, map[string]int64) {
+	contacted := make(map[string]*fcrmessages.FCRACKMsg)
+	found := make(map[string]int64)
+	workers := int(c.Settings.DHTFanOutWorkers)
+	if workers <= 0 {
+		workers = 1
+	}
+	// At most maxOfferRequestedPerDHT offers are counted per gateway, a maxOfferRequested of 0 sets no lower target
+	target := int64(numDHT) * int64(maxOfferRequestedPerDHT)
+	if maxOfferRequested > 0 && int64(maxOfferRequested) < target {
+		target = int64(maxOfferRequested)
+	}
+	collected := int64(0)
+	// Buffered so that requests finishing after the query stops never block
+	results := make(chan dhtQueryResult, len(gws))
+	deadline := time.After(c.Settings.DHTFanOutTimeout)
+	next := 0
+	pending := 0
+	for {
+		// Send requests while there is a free worker and more responses are needed
+		for next < len(gws) && pending < workers && len(contacted)+pending < int(numDHT) {
+			go func(gw fcrpeermgr.Peer) {
+				resp, err := c.P2PServer.Request(gw.NetworkAddr, fcrmessages.StandardOfferDiscoveryRequestType, gw.NodeID, pieceCID, maxOfferRequestedPerDHT)
+				results <- dhtQueryResult{nodeID: gw.NodeID, resp: resp, err: err}
+			}(gws[next])
+			next++
+			pending++
+		}
+		if pending == 0 || len(contacted) >= int(numDHT) || collected >= target {
+			return contacted, found
+		}
+		select {
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				logging.Warn("Error in querying gateway %v for dht offer query: %v", res.nodeID, res.err.Error())
+				continue
+			}
+			_, offers, _, _ := fcrmessages.DecodeStandardOfferDiscoveryResponse(res.resp)
+			n := int64(len(offers))
+			if n > int64(maxOfferRequestedPerDHT) {
+				n = int64(maxOfferRequestedPerDHT)
+			}
+			contacted[res.nodeID] = res.resp
+			found[res.nodeID] = n
+			collected += n
+		case <-deadline:
+			logging.Warn("DHT offer query for %v reached deadline with %v gateways pending", pieceCID.ToString(), pending)
+			return contacted, found
+		}
 	}
+}
 
-	return writer.Write(response, c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+// dhtQueryResult is the result of querying a gateway in a dht offer query.
+type dhtQueryResult struct {
+	nodeID string
+	resp   *fcrmessages.FCRACKMsg
+	err    error
 }
@@ -0,0 +1,187 @@
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/settings"
+)
+
+// mockGateway is how a gateway responds to an offer query.
+type mockGateway struct {
+	delay  time.Duration
+	offers int
+	fail   bool
+}
+
+// mockServer sends offer queries to the mock gateways by network address.
+type mockServer struct {
+	fcrserver.FCRServer
+	gws    map[string]mockGateway
+	offer  *cidoffer.SubCIDOffer
+	lock   sync.Mutex
+	sent   []string
+	active int
+	// maxActive is the maximum number of requests in progress at the same time
+	maxActive int
+}
+
+func (s *mockServer) Request(multiaddrStr string, msgType byte, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+	s.lock.Lock()
+	s.sent = append(s.sent, multiaddrStr)
+	s.active++
+	if s.active > s.maxActive {
+		s.maxActive = s.active
+	}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.active--
+		s.lock.Unlock()
+	}()
+	gw := s.gws[multiaddrStr]
+	time.Sleep(gw.delay)
+	if gw.fail {
+		return nil, errors.New("Test error")
+	}
+	offers := make([]cidoffer.SubCIDOffer, 0)
+	for i := 0; i < gw.offers; i++ {
+		offers = append(offers, *s.offer)
+	}
+	return fcrmessages.EncodeStandardOfferDiscoveryResponse(1, offers, "")
+}
+
+func TestQueryNearGateways(t *testing.T) {
+	pieceCID, err := cid.NewContentID("QmYb36f6SPpEN8oeznyxD5qSwztygQrK4jn3JEaCvwxUBx")
+	assert.Empty(t, err)
+	offer, err := cidoffer.NewCIDOffer("provider", []cid.ContentID{*pieceCID}, big.NewInt(1), time.Now().Add(time.Hour).Unix(), 0)
+	assert.Empty(t, err)
+	subOffer, err := offer.GenerateSubCIDOffer(pieceCID)
+	assert.Empty(t, err)
+
+	tests := []struct {
+		name                    string
+		gws                     []mockGateway
+		workers                 uint
+		timeout                 time.Duration
+		numDHT                  uint32
+		maxOfferRequestedPerDHT uint32
+		maxOfferRequested       uint32
+		contacted               []string
+		sent                    int
+		maxActive               int
+	}{
+		{
+			name:                    "bounded concurrency",
+			gws:                     []mockGateway{{delay: 20 * time.Millisecond, offers: 1}, {delay: 20 * time.Millisecond, offers: 1}, {delay: 20 * time.Millisecond, offers: 1}, {delay: 20 * time.Millisecond, offers: 1}, {delay: 20 * time.Millisecond, offers: 1}, {delay: 20 * time.Millisecond, offers: 1}},
+			workers:                 2,
+			timeout:                 time.Second,
+			numDHT:                  6,
+			maxOfferRequestedPerDHT: 1,
+			contacted:               []string{"gw0", "gw1", "gw2", "gw3", "gw4", "gw5"},
+			sent:                    6,
+			maxActive:               2,
+		},
+		{
+			name:                    "failing gateways are replaced",
+			gws:                     []mockGateway{{fail: true}, {offers: 1}, {fail: true}, {offers: 1}, {offers: 1}, {offers: 1}},
+			workers:                 1,
+			timeout:                 time.Second,
+			numDHT:                  3,
+			maxOfferRequestedPerDHT: 1,
+			contacted:               []string{"gw1", "gw3", "gw4"},
+			sent:                    5,
+			maxActive:               1,
+		},
+		{
+			name:                    "stops once enough offers are collected",
+			gws:                     []mockGateway{{offers: 2}, {offers: 2}, {offers: 2}, {offers: 2}},
+			workers:                 1,
+			timeout:                 time.Second,
+			numDHT:                  4,
+			maxOfferRequestedPerDHT: 2,
+			maxOfferRequested:       3,
+			contacted:               []string{"gw0", "gw1"},
+			sent:                    2,
+			maxActive:               1,
+		},
+		{
+			name:                    "offers beyond the limit per gateway are not counted",
+			gws:                     []mockGateway{{offers: 5}, {offers: 5}, {offers: 5}},
+			workers:                 1,
+			timeout:                 time.Second,
+			numDHT:                  3,
+			maxOfferRequestedPerDHT: 1,
+			maxOfferRequested:       2,
+			contacted:               []string{"gw0", "gw1"},
+			sent:                    2,
+			maxActive:               1,
+		},
+		{
+			name:                    "stops at the deadline",
+			gws:                     []mockGateway{{delay: 50 * time.Millisecond, offers: 1}, {delay: time.Second, offers: 1}, {delay: 50 * time.Millisecond, offers: 1}, {delay: time.Second, offers: 1}},
+			workers:                 4,
+			timeout:                 200 * time.Millisecond,
+			numDHT:                  4,
+			maxOfferRequestedPerDHT: 1,
+			contacted:               []string{"gw0", "gw2"},
+			sent:                    4,
+			maxActive:               4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &mockServer{gws: make(map[string]mockGateway), offer: subOffer}
+			gws := make([]fcrpeermgr.Peer, 0)
+			for i, gw := range test.gws {
+				id := fmt.Sprintf("gw%v", i)
+				server.gws[id] = gw
+				gws = append(gws, fcrpeermgr.Peer{NodeID: id, NetworkAddr: id})
+			}
+			c := &core.Core{
+				Settings:  &settings.AppSettings{DHTFanOutWorkers: test.workers, DHTFanOutTimeout: test.timeout},
+				P2PServer: server,
+			}
+			start := time.Now()
+			contacted, found := queryNearGateways(c, gws, pieceCID, test.numDHT, test.maxOfferRequestedPerDHT, test.maxOfferRequested)
+			assert.Less(t, int64(time.Since(start)), int64(test.timeout+100*time.Millisecond))
+			for _, id := range test.contacted {
+				_, ok := contacted[id]
+				assert.True(t, ok)
+				assert.LessOrEqual(t, found[id], int64(test.maxOfferRequestedPerDHT))
+			}
+			assert.Equal(t, len(test.contacted), len(contacted))
+			assert.Equal(t, len(test.contacted), len(found))
+			server.lock.Lock()
+			defer server.lock.Unlock()
+			assert.Equal(t, test.sent, len(server.sent))
+			assert.Equal(t, test.maxActive, server.maxActive)
+		})
+	}
+}
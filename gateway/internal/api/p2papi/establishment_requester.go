@@ -19,9 +19,9 @@ package p2papi
  */
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
@@ -54,11 +54,10 @@ func EstablishmentRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 
 	// Get core structure
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
 
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
 	challengeBytes := make([]byte, 32)
 	rand.Read(challengeBytes)
@@ -71,7 +70,7 @@ func EstablishmentRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 	}
 
 	// Write request
-	err = writer.Write(request, c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+	err = writer.Write(request, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	if err != nil {
 		err = fmt.Errorf("Error in sending request to %v: %v", targetID, err.Error())
 		logging.Error(err.Error())
@@ -111,15 +110,15 @@ func EstablishmentRequester(reader fcrserver.FCRServerResponseReader, writer fcr
 			}
 		}
 	}
-	if response.Verify(nodeInfo.MsgSigningKey, nodeInfo.MsgSigningKeyVer) != nil {
+	if nodeInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
 		if gateway {
 			nodeInfo = c.PeerMgr.SyncGW(targetID)
 		} else {
 			nodeInfo = c.PeerMgr.SyncPVD(targetID)
 		}
-		if nodeInfo == nil || response.Verify(nodeInfo.MsgSigningKey, nodeInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		if nodeInfo == nil || nodeInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			return nil, err
 		}
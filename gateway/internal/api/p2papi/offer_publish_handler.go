@@ -19,9 +19,7 @@ package p2papi
  */
 
 import (
-	"encoding/hex"
 	"fmt"
-	"math/big"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
@@ -29,79 +27,77 @@ import (
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
 )
 
-// OfferPublishHandler handles offer publication.
+// OfferPublishHandler handles offer publication, from a provider or from a gateway handing off an offer.
 func OfferPublishHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver.FCRServerResponseWriter, request *fcrmessages.FCRReqMsg) error {
 	logging.Debug("Handle offer publish")
 	// Get core response
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
 
 	// Message decoding
 	nonce, senderID, offer, err := fcrmessages.DecodeOfferPublishRequest(request)
 	if err != nil {
-		err = fmt.Errorf("Error in decoding payload: %v", err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payload: %v", err.Error())}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	}
 
-	// Verify the signature
-	pvdInfo := c.PeerMgr.GetPVDInfo(senderID)
+	// Verify the signature, the sender is either the provider of the offer or a gateway handing off the offer
+	if senderID != offer.GetProviderID() {
+		gwInfo := c.PeerMgr.GetGWInfo(senderID)
+		if gwInfo == nil {
+			// Not found, try sync once
+			gwInfo = c.PeerMgr.SyncGW(senderID)
+			if gwInfo == nil {
+				err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in obtaining information for gateway %v", senderID)}
+				logging.Error(err.Error())
+				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+			}
+		}
+		if gwInfo.VerifyMsg(request.Verify) != nil {
+			// Try update
+			gwInfo = c.PeerMgr.SyncGW(senderID)
+			if gwInfo == nil || gwInfo.VerifyMsg(request.Verify) != nil {
+				err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from gateway %v", senderID)}
+				logging.Error(err.Error())
+				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+			}
+		}
+	}
+	pvdInfo := c.PeerMgr.GetPVDInfo(offer.GetProviderID())
 	if pvdInfo == nil {
 		// Not found, try sync once
-		pvdInfo = c.PeerMgr.SyncPVD(senderID)
+		pvdInfo = c.PeerMgr.SyncPVD(offer.GetProviderID())
 		if pvdInfo == nil {
-			err = fmt.Errorf("Error in obtaining information for provider %v", senderID)
+			err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in obtaining information for provider %v", offer.GetProviderID())}
 			logging.Error(err.Error())
-			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 		}
 	}
-	if request.Verify(pvdInfo.MsgSigningKey, pvdInfo.MsgSigningKeyVer) != nil {
+	if senderID == offer.GetProviderID() && pvdInfo.VerifyMsg(request.Verify) != nil {
 		// Try update
-		pvdInfo = c.PeerMgr.SyncGW(senderID)
-		if pvdInfo == nil || request.Verify(pvdInfo.MsgSigningKey, pvdInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying request from provider %v: %v", senderID, err.Error())
+		pvdInfo = c.PeerMgr.SyncPVD(senderID)
+		if pvdInfo == nil || pvdInfo.VerifyMsg(request.Verify) != nil {
+			err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from provider %v", senderID)}
 			logging.Error(err.Error())
-			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 		}
 	}
 
 	// Check offer signature
 	if offer.Verify(pvdInfo.OfferSigningKey) != nil {
-		err = fmt.Errorf("Received offer fails to verify against signature of provider %v", senderID)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidOffer, Message: fmt.Sprintf("Received offer fails to verify against signature of provider %v", offer.GetProviderID())}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	}
 
 	// Offer verified, add to storage
-	if !c.StoreFullOffer {
+	if c.IsRevoked(offer) {
+		logging.Debug("Offer %v has been revoked by provider %v, ignore", offer.GetMessageDigest(), offer.GetProviderID())
+	} else if !c.StoreFullOffer {
 		minStr, maxStr := c.PeerMgr.GetCurrentCIDHashRange()
-		min, _ := big.NewInt(0).SetString(minStr, 16)
-		max, _ := big.NewInt(0).SetString(maxStr, 16)
-		store := false
-		for _, cid := range offer.GetCIDs() {
-			cidHash, err := cid.CalculateHash()
-			if err != nil {
-				err = fmt.Errorf("Error getting cid hash for %v: %v", cid.ToString(), err.Error())
-				logging.Error(err.Error())
-				continue
-			}
-			cidVal, _ := big.NewInt(0).SetString(hex.EncodeToString(cidHash), 16)
-			if max.Cmp(min) > 0 {
-				if cidVal.Cmp(min) >= 0 && cidVal.Cmp(max) <= 0 {
-					logging.Debug("Offer contains cid %v, within range [%v, %v], added to storage", hex.EncodeToString(cidHash), minStr, maxStr)
-					store = true
-					break
-				}
-			} else {
-				if cidVal.Cmp(min) >= 0 || cidVal.Cmp(max) <= 0 {
-					store = true
-					logging.Debug("Offer contains cid %v, within range [%v, %v], added to storage", hex.EncodeToString(cidHash), minStr, maxStr)
-					break
-				}
-			}
-		}
-		if store {
+		if core.InCIDHashRange(offer, minStr, maxStr) {
+			logging.Debug("Offer contains cid within range [%v, %v], added to storage", minStr, maxStr)
 			c.OfferMgr.AddOffer(offer)
 		} else {
 			logging.Debug("Offer does not contain cid within range [%v, %v], ignore", minStr, maxStr)
@@ -110,5 +106,5 @@ func OfferPublishHandler(reader fcrserver.FCRServerRequestReader, writer fcrserv
 		logging.Debug("Gateway stores every offer, added to storage")
 		c.OfferMgr.AddOffer(offer)
 	}
-	return writer.Write(fcrmessages.CreateFCRACKMsg(nonce, []byte{0}), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+	return writer.Write(fcrmessages.CreateFCRACKMsg(nonce, []byte{0}), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 }
@@ -36,15 +36,18 @@ func OfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver
 	logging.Debug("Handle offer query")
 	// Get core structure
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	// Only hold the msg signing key lock when signing a response, so a key update is never blocked by a request
+	respond := func(response *fcrmessages.FCRACKMsg) error {
+		msgKey, msgKeyVer := c.GetMsgSigningKey()
+		return writer.Write(response, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	}
 
 	// Message decoding
 	nonce, senderID, pieceCID, maxOfferRequested, accountAddr, voucher, err := fcrmessages.DecodeStandardOfferDiscoveryRequest(request)
 	if err != nil {
-		err = fmt.Errorf("Error in decoding payload: %v", err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payload: %v", err.Error())}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
 	// Verify signature
@@ -55,18 +58,18 @@ func OfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver
 			// Not found, try sync once
 			gwInfo = c.PeerMgr.SyncGW(senderID)
 			if gwInfo == nil {
-				err = fmt.Errorf("Error in obtaining information for gateway %v", senderID)
+				err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in obtaining information for gateway %v", senderID)}
 				logging.Error(err.Error())
-				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+				return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 			}
 		}
-		if request.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
+		if gwInfo.VerifyMsg(request.Verify) != nil {
 			// Try update
 			gwInfo = c.PeerMgr.SyncGW(senderID)
-			if gwInfo == nil || request.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
-				err = fmt.Errorf("Error in verifying request from gateway %v: %v", senderID, err.Error())
+			if gwInfo == nil || gwInfo.VerifyMsg(request.Verify) != nil {
+				err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from gateway %v", senderID)}
 				logging.Error(err.Error())
-				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+				return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 			}
 		}
 	}
@@ -75,14 +78,14 @@ func OfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver
 	refundVoucher := ""
 	received, lane, err := c.PaymentMgr.Receive(accountAddr, voucher)
 	if err != nil {
-		err = fmt.Errorf("Error in receiving voucher %v:", err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidPayment, Message: fmt.Sprintf("Error in receiving voucher %v:", err.Error())}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 	if lane != 0 {
-		err = fmt.Errorf("Not correct lane received expect 0 got %v:", lane)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidPayment, Message: fmt.Sprintf("Not correct lane received expect 0 got %v:", lane)}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 	expected := big.NewInt(0).Add(c.Settings.SearchPrice, big.NewInt(0).Mul(c.Settings.OfferPrice, big.NewInt(int64(maxOfferRequested))))
 	if received.Cmp(expected) < 0 {
@@ -98,13 +101,16 @@ func OfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver
 				logging.Error("Error in refunding: %v", ierr.Error())
 			}
 		}
-		err = fmt.Errorf("Short payment received, expect %v got %v, refund voucher %v", expected.String(), received.String(), refundVoucher)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeShortPayment, Message: fmt.Sprintf("Short payment received, expect %v got %v, refund voucher %v", expected.String(), received.String(), refundVoucher), ExpectedAmount: expected, RefundVoucher: refundVoucher}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
 	// Payment is fine, search.
 	c.OfferMgr.IncrementCIDAccessCount(pieceCID)
+	if c.CacheCtrl != nil {
+		c.CacheCtrl.RecordLookup(pieceCID)
+	}
 	offers := c.OfferMgr.GetOffers(pieceCID)
 
 	// Generating sub CID offers
@@ -130,9 +136,9 @@ func OfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver
 				// This should never happen
 				logging.Error("Error in refunding: %v", ierr.Error())
 			}
-			err = fmt.Errorf("Internal error in generating sub cid offer: %v, refund voucher %v", err.Error(), refundVoucher)
+			err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in generating sub cid offer: %v, refund voucher %v", err.Error(), refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter}
 			logging.Error(err.Error())
-			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+			return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 		}
 		res = append(res, *subOffer)
 		remain--
@@ -156,10 +162,10 @@ func OfferQueryHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver
 			// This should never happen
 			logging.Error("Error in refunding %v", ierr.Error())
 		}
-		err = fmt.Errorf("Internal error in encoding response: %v, refund voucher %v", err.Error(), refundVoucher)
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in encoding response: %v, refund voucher %v", err.Error(), refundVoucher), RefundVoucher: refundVoucher, RetryAfter: fcrmessages.DefaultRetryAfter}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return respond(fcrmessages.CreateFCRACKErrorMsg(nonce, err))
 	}
 
-	return writer.Write(response, c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+	return respond(response)
 }
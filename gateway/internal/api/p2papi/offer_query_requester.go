@@ -21,7 +21,6 @@ package p2papi
 import (
 	"fmt"
 	"math/big"
-	"math/rand"
 	"time"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
@@ -63,11 +62,10 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 
 	// Get core structure
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
 
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
 	// Get gateway information
 	gwInfo := c.PeerMgr.GetGWInfo(targetID)
@@ -167,7 +165,7 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 	}
 
 	// Write request
-	err = writer.Write(request, c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+	err = writer.Write(request, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	if err != nil {
 		err = fmt.Errorf("Error in sending request to %v: %v", targetID, err.Error())
 		logging.Error(err.Error())
@@ -189,11 +187,11 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 	}
 
 	// Verify the response
-	if response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
+	if gwInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
 		gwInfo = c.PeerMgr.SyncGW(targetID)
-		if gwInfo == nil || response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		if gwInfo == nil || gwInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			// Pend GW
 			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
@@ -239,18 +237,13 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 		// Verify offer one by one
 		// Get offer signing key
 		pvdID := offer.GetProviderID()
-		pvdInfo := c.PeerMgr.GetPVDInfo(pvdID)
-		if err == nil {
-			// Not found, try again
-			pvdInfo = c.PeerMgr.SyncPVD(pvdID)
-			if pvdInfo == nil {
-				// Not found, return error
-				err = fmt.Errorf("Error in obtaining information for provider %v", pvdID)
-				logging.Error(err.Error())
-				c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
-				c.ReputationMgr.PendPeer(targetID)
-				return nil, err
-			}
+		offerSigningKey, err := getOfferSigningKey(c, pvdID)
+		if err != nil {
+			// Not found, return error
+			logging.Error(err.Error())
+			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
+			c.ReputationMgr.PendPeer(targetID)
+			return nil, err
 		}
 		// Verify sub cid.
 		if offer.GetSubCID().ToString() != pieceCID.ToString() {
@@ -261,8 +254,8 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 			return nil, err
 		}
 		// Verify offer signature
-		if offer.Verify(pvdInfo.OfferSigningKey) != nil {
-			err = fmt.Errorf("Received offer fails to verify against signature of provider %v", pvdID)
+		if offer.Verify(offerSigningKey) != nil {
+			err = fmt.Errorf("Received offer fails to verify against signature of %v", pvdID)
 			logging.Error(err.Error())
 			c.ReputationMgr.UpdatePeerRecord(targetID, reputation.InvalidResponseAfterPayment.Copy(), 0)
 			c.ReputationMgr.PendPeer(targetID)
@@ -320,3 +313,25 @@ func OfferQueryRequester(reader fcrserver.FCRServerResponseReader, writer fcrser
 	// Return response
 	return response, nil
 }
+
+// getOfferSigningKey gets the public key to verify offers supplied by a given peer.
+// Providers sign offers with their offer signing key, gateways sign resale offers of cached content with their msg signing key.
+func getOfferSigningKey(c *core.Core, peerID string) (string, error) {
+	pvdInfo := c.PeerMgr.GetPVDInfo(peerID)
+	if pvdInfo == nil {
+		// Not found, try sync once
+		pvdInfo = c.PeerMgr.SyncPVD(peerID)
+	}
+	if pvdInfo != nil {
+		return pvdInfo.OfferSigningKey, nil
+	}
+	gwInfo := c.PeerMgr.GetGWInfo(peerID)
+	if gwInfo == nil {
+		// Not found, try sync once
+		gwInfo = c.PeerMgr.SyncGW(peerID)
+	}
+	if gwInfo != nil {
+		return gwInfo.MsgSigningKey, nil
+	}
+	return "", fmt.Errorf("Error in obtaining information for provider %v", peerID)
+}
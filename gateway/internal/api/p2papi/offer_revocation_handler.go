@@ -0,0 +1,89 @@
+/*
+Package p2papi contains the API code for the p2p communication.
+*/
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
+)
+
+// OfferRevocationHandler handles offer revocation from the provider of the offer.
+// The revocation is kept until the offer expires, so the offer is not stored again from a publication or a sync.
+func OfferRevocationHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver.FCRServerResponseWriter, request *fcrmessages.FCRReqMsg) error {
+	logging.Debug("Handle offer revocation")
+	// Get core response
+	c := core.GetSingleInstance()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
+
+	// Message decoding
+	nonce, senderID, digest, signature, err := fcrmessages.DecodeOfferRevocationRequest(request)
+	if err != nil {
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payload: %v", err.Error())}
+		logging.Error(err.Error())
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	}
+
+	// Verify the signature, only a provider can revoke offers
+	pvdInfo := c.PeerMgr.GetPVDInfo(senderID)
+	if pvdInfo == nil {
+		// Not found, try sync once
+		pvdInfo = c.PeerMgr.SyncPVD(senderID)
+		if pvdInfo == nil {
+			err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in obtaining information for provider %v", senderID)}
+			logging.Error(err.Error())
+			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+		}
+	}
+	if pvdInfo.VerifyMsg(request.Verify) != nil {
+		// Try update
+		pvdInfo = c.PeerMgr.SyncPVD(senderID)
+		if pvdInfo == nil || pvdInfo.VerifyMsg(request.Verify) != nil {
+			err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from provider %v", senderID)}
+			logging.Error(err.Error())
+			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+		}
+	}
+
+	// Check revocation signature
+	if fcrmessages.VerifyOfferRevocation(senderID, digest, signature, pvdInfo.OfferSigningKey) != nil {
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Received revocation fails to verify against offer signing key of provider %v", senderID)}
+		logging.Error(err.Error())
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	}
+
+	// Revocation verified, remove from storage
+	offer := c.OfferMgr.GetOfferByDigest(digest)
+	if offer == nil {
+		logging.Debug("Offer %v is not in storage, record revocation", digest)
+		c.RecordRevocation(senderID, digest, nil)
+	} else if offer.GetProviderID() != senderID {
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Offer %v is not supplied by provider %v", digest, senderID)}
+		logging.Error(err.Error())
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	} else {
+		logging.Debug("Offer %v revoked by provider %v, removed from storage", digest, senderID)
+		c.RecordRevocation(senderID, digest, offer)
+		c.OfferMgr.RemoveOffer(digest)
+	}
+	return writer.Write(fcrmessages.CreateFCRACKMsg(nonce, []byte{0}), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+}
@@ -0,0 +1,113 @@
+/*
+Package p2papi contains the API code for the p2p communication.
+*/
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
+)
+
+// offerSyncListSize is the number of offers listed for a page of offer sync.
+const offerSyncListSize = 1000
+
+// OfferSyncHandler handles offer sync, it responds with a page of the stored offers within the requested cid hash range,
+// ordered by digest and starting after the requested digest. Only the offers of one page are listed from the offer manager. Resale offers of cached content are not synced as the content
+// is only cached by this gateway.
+func OfferSyncHandler(reader fcrserver.FCRServerRequestReader, writer fcrserver.FCRServerResponseWriter, request *fcrmessages.FCRReqMsg) error {
+	logging.Debug("Handle offer sync")
+	// Get core structure
+	c := core.GetSingleInstance()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
+
+	// Message decoding
+	nonce, senderID, hashMin, hashMax, after, err := fcrmessages.DecodeOfferSyncRequest(request)
+	if err != nil {
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payload: %v", err.Error())}
+		logging.Error(err.Error())
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	}
+
+	// Verify signature, only gateways sync offers
+	gwInfo := c.PeerMgr.GetGWInfo(senderID)
+	if gwInfo == nil {
+		// Not found, try sync once
+		gwInfo = c.PeerMgr.SyncGW(senderID)
+		if gwInfo == nil {
+			err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in obtaining information for gateway %v", senderID)}
+			logging.Error(err.Error())
+			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+		}
+	}
+	if gwInfo.VerifyMsg(request.Verify) != nil {
+		// Try update
+		gwInfo = c.PeerMgr.SyncGW(senderID)
+		if gwInfo == nil || gwInfo.VerifyMsg(request.Verify) != nil {
+			err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from gateway %v", senderID)}
+			logging.Error(err.Error())
+			return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+		}
+	}
+	if !core.ValidCIDHashRange(hashMin, hashMax) {
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Invalid cid hash range [%v, %v]", hashMin, hashMax)}
+		logging.Error(err.Error())
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	}
+
+	// Collect a page of offers in range from the offers listed after the requested digest, the offers listed are ordered by digest.
+	// If the listed offers run out before the page is full, the page ends with the last offer listed.
+	res := make([]cidoffer.CIDOffer, 0)
+	size := 0
+	next := ""
+	offers := c.OfferMgr.ListOffersAfter(after, offerSyncListSize)
+	for _, offer := range offers {
+		if offer.GetProviderID() == c.NodeID || offer.HasExpired() || !core.InCIDHashRange(&offer, hashMin, hashMax) {
+			continue
+		}
+		data, err := offer.ToBytes()
+		if err != nil {
+			logging.Error("Error in encoding offer %v: %v", offer.GetMessageDigest(), err.Error())
+			continue
+		}
+		if len(res) > 0 && size+len(data) > fcrmessages.OfferSyncPageSize {
+			// Page is full, the next page starts after the last offer of this page
+			next = res[len(res)-1].GetMessageDigest()
+			break
+		}
+		res = append(res, offer)
+		size += len(data)
+	}
+	if next == "" && len(offers) == offerSyncListSize {
+		next = offers[len(offers)-1].GetMessageDigest()
+	}
+	logging.Debug("Sync %v offers within range [%v, %v] after %v to gateway %v", len(res), hashMin, hashMax, after, senderID)
+
+	// Respond
+	response, err := fcrmessages.EncodeOfferSyncResponse(nonce, res, next)
+	if err != nil {
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in encoding response: %v", err.Error()), RetryAfter: fcrmessages.DefaultRetryAfter}
+		logging.Error(err.Error())
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	}
+	return writer.Write(response, msgKey, msgKeyVer, c.Settings.TCPLongInactivityTimeout)
+}
@@ -0,0 +1,139 @@
+/*
+Package p2papi contains the API code for the p2p communication.
+*/
+package p2papi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/gateway/internal/core"
+)
+
+// OfferSyncRequester sends an offer sync request, it pulls a page of the offers within a cid hash range from a gateway,
+// starting after the offer of a given digest, empty for the first page.
+func OfferSyncRequester(reader fcrserver.FCRServerResponseReader, writer fcrserver.FCRServerRequestWriter, args ...interface{}) (*fcrmessages.FCRACKMsg, error) {
+	logging.Debug("Request offer sync")
+	// Get parameters
+	if len(args) != 4 {
+		err := fmt.Errorf("Wrong arguments, expect length 4, got length %v", len(args))
+		logging.Error(err.Error())
+		return nil, err
+	}
+	targetID, ok := args[0].(string)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a target ID in string")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	hashMin, ok := args[1].(string)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a cid min hash in string")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	hashMax, ok := args[2].(string)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect a cid max hash in string")
+		logging.Error(err.Error())
+		return nil, err
+	}
+	after, ok := args[3].(string)
+	if !ok {
+		err := fmt.Errorf("Wrong arguments, expect an offer digest in string")
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Get core structure
+	c := core.GetSingleInstance()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
+
+	// Generate random nonce
+	nonce := fcrserver.NewNonce()
+
+	// Get gateway information
+	gwInfo := c.PeerMgr.GetGWInfo(targetID)
+	if gwInfo == nil {
+		// Not found, try sync once
+		gwInfo = c.PeerMgr.SyncGW(targetID)
+		if gwInfo == nil {
+			err := fmt.Errorf("Error in obtaining information for gateway %v", targetID)
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	request, err := fcrmessages.EncodeOfferSyncRequest(nonce, c.NodeID, hashMin, hashMax, after)
+	if err != nil {
+		err = fmt.Errorf("Internal error in encoding request: %v", err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Write request
+	err = writer.Write(request, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
+	if err != nil {
+		err = fmt.Errorf("Error in sending request to %v: %v", targetID, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Get a response
+	response, err := reader.Read(c.Settings.TCPLongInactivityTimeout)
+	if err != nil {
+		err = fmt.Errorf("Error in receiving response from %v: %v", targetID, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Verify the response
+	if gwInfo.VerifyMsg(response.Verify) != nil {
+		// Try update
+		gwInfo = c.PeerMgr.SyncGW(targetID)
+		if gwInfo == nil || gwInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
+			logging.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	// Check response
+	if !response.ACK() {
+		err = fmt.Errorf("Reponse contains an error: %w", response.ErrorDetails())
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	// Check nonce
+	nonceRecv, _, _, err := fcrmessages.DecodeOfferSyncResponse(response)
+	if err != nil {
+		err = fmt.Errorf("Error in decoding response from %v: %v", targetID, err.Error())
+		logging.Error(err.Error())
+		return nil, err
+	}
+	if nonceRecv != nonce {
+		err = fmt.Errorf("Nonce mismatch: expected %v got %v", nonce, nonceRecv)
+		logging.Error(err.Error())
+		return nil, err
+	}
+
+	return response, nil
+}
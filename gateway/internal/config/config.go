@@ -22,10 +22,13 @@ import (
 	"flag"
 	"fmt"
 	"math/big"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachectrl"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/settings"
 )
@@ -59,6 +62,74 @@ func Map(conf *viper.Viper) settings.AppSettings {
 		tcpLongInactivityTimeout = settings.DefaultLongTCPInactivityTimeout
 	}
 
+	offerDBFile := conf.GetString("OFFER_DB_FILE")
+	if offerDBFile == "" {
+		offerDBFile = filepath.Join(conf.GetString("SYSTEM_DIR"), settings.DefaultOfferDBFile)
+	}
+	paymentDBFile := conf.GetString("PAYMENT_DB_FILE")
+	if paymentDBFile == "" {
+		paymentDBFile = filepath.Join(conf.GetString("SYSTEM_DIR"), settings.DefaultPaymentDBFile)
+	}
+
+	settleCheckDuration, err := time.ParseDuration(conf.GetString("SETTLE_CHECK_DURATION"))
+	if err != nil {
+		settleCheckDuration = settings.DefaultSettleCheckDuration
+	}
+	settleMinRedeemed := new(big.Int)
+	_, err = fmt.Sscan(conf.GetString("SETTLE_MIN_REDEEMED"), settleMinRedeemed)
+	if err != nil {
+		// Disabled by default
+		settleMinRedeemed = big.NewInt(0)
+	}
+
+	cachePriceRatio := conf.GetFloat64("CACHE_PRICE_RATIO")
+	if cachePriceRatio <= 0 {
+		cachePriceRatio = settings.DefaultCachePriceRatio
+	}
+	cacheOfferDuration, err := time.ParseDuration(conf.GetString("CACHE_OFFER_DURATION"))
+	if err != nil || cacheOfferDuration <= 0 {
+		cacheOfferDuration = settings.DefaultCacheOfferDuration
+	}
+
+	cacheCheckDuration, err := time.ParseDuration(conf.GetString("CACHE_CHECK_DURATION"))
+	if err != nil {
+		cacheCheckDuration = settings.DefaultCacheCheckDuration
+	}
+	cacheEviction := strings.ToLower(conf.GetString("CACHE_EVICTION"))
+	if cacheEviction != fcrcachectrl.EvictLFU {
+		cacheEviction = fcrcachectrl.EvictLRU
+	}
+	cacheMaxSpend := new(big.Int)
+	_, err = fmt.Sscan(conf.GetString("CACHE_MAX_SPEND"), cacheMaxSpend)
+	if err != nil {
+		// Nothing to spend by default
+		cacheMaxSpend = big.NewInt(0)
+	}
+	cacheMaxPrice := new(big.Int)
+	_, err = fmt.Sscan(conf.GetString("CACHE_MAX_PRICE"), cacheMaxPrice)
+	if err != nil {
+		// Disabled by default
+		cacheMaxPrice = big.NewInt(0)
+	}
+	cacheTopN := conf.GetUint("CACHE_TOP_N")
+	if cacheTopN == 0 {
+		cacheTopN = settings.DefaultCacheTopN
+	}
+
+	dhtFanOutWorkers := conf.GetUint("DHT_FAN_OUT_WORKERS")
+	if dhtFanOutWorkers == 0 {
+		dhtFanOutWorkers = settings.DefaultDHTFanOutWorkers
+	}
+	dhtFanOutTimeout, err := time.ParseDuration(conf.GetString("DHT_FAN_OUT_TIMEOUT"))
+	if err != nil || dhtFanOutTimeout <= 0 {
+		dhtFanOutTimeout = settings.DefaultDHTFanOutTimeout
+	}
+
+	replicationFactor := conf.GetUint("REPLICATION_FACTOR")
+	if replicationFactor == 0 {
+		replicationFactor = settings.DefaultReplicationFactor
+	}
+
 	defaultSearchPrice := new(big.Int)
 	_, err = fmt.Sscan(conf.GetString("SEARCH_PRICE"), defaultSearchPrice)
 	if err != nil {
@@ -98,6 +169,10 @@ func Map(conf *viper.Viper) settings.AppSettings {
 		AdminKeyFile:   conf.GetString("ADMIN_KEY_FILE"),
 		ConfigFile:     conf.GetString("CONFIG_FILE"),
 		StoreFullOffer: conf.GetBool("STORE_FULL_OFFER"),
+		PersistOffer:   conf.GetBool("PERSIST_OFFER"),
+		OfferDBFile:    offerDBFile,
+		PersistPayment: conf.GetBool("PERSIST_PAYMENT"),
+		PaymentDBFile:  paymentDBFile,
 
 		SyncDuration:             syncDuration,
 		MsgKeyUpdateDuration:     msgKeyUpdateDuration,
@@ -107,6 +182,29 @@ func Map(conf *viper.Viper) settings.AppSettings {
 		SearchPrice: defaultSearchPrice,
 		OfferPrice:  defaultOfferPrice,
 		TopupAmount: defaultTopUpAmount,
+
+		AutoSettle:          conf.GetBool("AUTO_SETTLE"),
+		SettleCheckDuration: settleCheckDuration,
+		SettleMinRedeemed:   settleMinRedeemed,
+		SettleMaxAge:        conf.GetUint64("SETTLE_MAX_AGE"),
+		SettleDeregistering: conf.GetBool("SETTLE_DEREGISTERING"),
+		SettleMaxCostRatio:  conf.GetFloat64("SETTLE_MAX_COST_RATIO"),
+
+		CachePriceRatio:    cachePriceRatio,
+		CacheOfferDuration: cacheOfferDuration,
+		AutoCache:          conf.GetBool("AUTO_CACHE"),
+		CacheCheckDuration: cacheCheckDuration,
+		CacheEviction:      cacheEviction,
+		CacheMaxSize:       conf.GetInt64("CACHE_MAX_SIZE"),
+		CacheMaxSpend:      cacheMaxSpend,
+		CacheMaxPrice:      cacheMaxPrice,
+		CacheTopN:          cacheTopN,
+		CacheMinAccess:     conf.GetInt("CACHE_MIN_ACCESS"),
+
+		DHTFanOutWorkers: dhtFanOutWorkers,
+		DHTFanOutTimeout: dhtFanOutTimeout,
+
+		ReplicationFactor: replicationFactor,
 	}
 }
 
@@ -20,14 +20,27 @@ package core
  */
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachectrl"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcachemgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrregistermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrsettlemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/gateway/internal/settings"
 )
@@ -49,6 +62,12 @@ type Core struct {
 	MsgSigningKey     string
 	MsgSigningKeyVer  byte
 	MsgSigningKeyLock sync.RWMutex
+	// Lock serialising the updates of the message signing key
+	msgSigningKeyUpdateLock sync.Mutex
+
+	// Previous message signing key, resale offers signed by it are still accepted until it expires
+	PrevMsgSigningKey       string
+	PrevMsgSigningKeyExpiry int64
 
 	// Boolean indicates whether this gateway stores full offer
 	StoreFullOffer bool
@@ -59,17 +78,33 @@ type Core struct {
 	// The P2P Server
 	P2PServer fcrserver.FCRServer
 
+	// The Register Manager
+	RegisterMgr fcrregistermgr.FCRRegisterMgr
+
 	// The Peer Manager
 	PeerMgr fcrpeermgr.FCRPeerMgr
 
 	// The Payment Manager
 	PaymentMgr fcrpaymentmgr.FCRPaymentMgr
 
+	// The Settle Manager, nil if automatic settlement is disabled
+	SettleMgr fcrsettlemgr.FCRSettleMgr
+
 	// The Offer Manager
 	OfferMgr fcroffermgr.FCROfferMgr
 
 	// The Reputation Manager
 	ReputationMgr fcrreputationmgr.FCRReputationMgr
+
+	// The Cache Manager, storing content retrieved by this gateway
+	CacheMgr fcrcachemgr.FCRCacheMgr
+
+	// The Cache Controller, nil if automatic caching is disabled
+	CacheCtrl fcrcachectrl.FCRCacheCtrl
+
+	// Revocations of offers by digest and a lock protecting the access
+	revokedOffers     map[string]*revocation
+	revokedOffersLock sync.Mutex
 }
 
 // Single instance of the gateway
@@ -94,14 +129,151 @@ func GetSingleInstance(confs ...*settings.AppSettings) *Core {
 			MsgSigningKey:     "",
 			MsgSigningKeyVer:  0,
 			MsgSigningKeyLock: sync.RWMutex{},
+			PrevMsgSigningKey: "",
 			StoreFullOffer:    false,
 			AdminServer:       nil,
 			P2PServer:         nil,
+			RegisterMgr:       nil,
 			OfferMgr:          nil,
 			ReputationMgr:     nil,
 			PeerMgr:           nil,
 			PaymentMgr:        nil,
+			SettleMgr:         nil,
+			CacheMgr:          nil,
+			CacheCtrl:         nil,
+			revokedOffers:     make(map[string]*revocation),
+			revokedOffersLock: sync.Mutex{},
 		}
 	})
 	return instance
 }
+
+// FetchContent retrieves the content of a given sub cid offer from the provider into the cache.
+func (c *Core) FetchContent(offer *cidoffer.SubCIDOffer) error {
+	// Get provider information
+	pvdInfo := c.PeerMgr.GetPVDInfo(offer.GetProviderID())
+	if pvdInfo == nil {
+		// Not found, try sync once
+		pvdInfo = c.PeerMgr.SyncPVD(offer.GetProviderID())
+		if pvdInfo == nil {
+			return fmt.Errorf("Cannot find provider %v that supplied the offer", offer.GetProviderID())
+		}
+	}
+	_, err := c.P2PServer.Request(pvdInfo.NetworkAddr, fcrmessages.DataRetrievalRequestType, pvdInfo.NodeID, offer)
+	if err != nil {
+		return fmt.Errorf("Error in data retrieval: %v", err.Error())
+	}
+	return nil
+}
+
+// VerifyResaleOffer verifies a resale offer of this gateway, signed by the msg signing key or the previous msg signing key if not expired.
+// It takes the msg signing key lock, the caller must not hold it.
+func (c *Core) VerifyResaleOffer(offer *cidoffer.SubCIDOffer) error {
+	if offer.GetProviderID() != c.NodeID {
+		return fmt.Errorf("Offer is supplied by %v, not this gateway", offer.GetProviderID())
+	}
+	c.MsgSigningKeyLock.RLock()
+	defer c.MsgSigningKeyLock.RUnlock()
+	pubKey, _, err := fcrcrypto.GetPublicKey(c.MsgSigningKey)
+	if err != nil {
+		return err
+	}
+	err = offer.Verify(pubKey)
+	if err == nil || c.PrevMsgSigningKey == "" || time.Now().Unix() > c.PrevMsgSigningKeyExpiry {
+		return err
+	}
+	pubKey, _, err = fcrcrypto.GetPublicKey(c.PrevMsgSigningKey)
+	if err != nil {
+		return err
+	}
+	return offer.Verify(pubKey)
+}
+
+// GetMsgSigningKey returns the current msg signing key and key version.
+func (c *Core) GetMsgSigningKey() (string, byte) {
+	c.MsgSigningKeyLock.RLock()
+	defer c.MsgSigningKeyLock.RUnlock()
+	return c.MsgSigningKey, c.MsgSigningKeyVer
+}
+
+// UpdateMsgSigningKey generates a new msg signing key with the next key version, publishes it to the register,
+// saves it to the config file and re-signs the resale offers of this gateway.
+func (c *Core) UpdateMsgSigningKey() error {
+	msgKey, msgSigningKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	if err != nil {
+		return fmt.Errorf("Error in generating message signing key: %v", err.Error())
+	}
+	gwInfo, err := c.RegisterMgr.GetRegisteredGatewayByID(c.NodeID)
+	if err != nil {
+		return fmt.Errorf("Error in getting registered information of this gateway: %v", err.Error())
+	}
+	// The register is updated without holding the msg signing key lock, so messages are still signed meanwhile
+	c.msgSigningKeyUpdateLock.Lock()
+	defer c.msgSigningKeyUpdateLock.Unlock()
+	_, msgKeyVer := c.GetMsgSigningKey()
+	msgKeyVer++
+	gwInfo.MsgSigningKey = msgSigningKey
+	gwInfo.MsgSigningKeyVer = msgKeyVer
+	err = c.RegisterMgr.UpdateGateway(c.NodeID, gwInfo)
+	if err != nil {
+		return fmt.Errorf("Error in updating message signing key in the register: %v", err.Error())
+	}
+	// The register has the new key, it must be used from now on
+	c.MsgSigningKeyLock.Lock()
+	c.PrevMsgSigningKey = c.MsgSigningKey
+	c.PrevMsgSigningKeyExpiry = time.Now().Add(fcrpeermgr.MsgKeyGracePeriod).Unix()
+	c.MsgSigningKey = msgKey
+	c.MsgSigningKeyVer = msgKeyVer
+	c.MsgSigningKeyLock.Unlock()
+	logging.Info("Message signing key updated to version %v", msgKeyVer)
+	for _, offer := range c.OfferMgr.ListOffers(0, math.MaxUint32) {
+		if offer.GetProviderID() != c.NodeID {
+			continue
+		}
+		err = offer.Sign(msgKey)
+		if err != nil {
+			logging.Error("Error in re-signing resale offer %v: %v", offer.GetMessageDigest(), err.Error())
+			continue
+		}
+		c.OfferMgr.RemoveOffer(offer.GetMessageDigest())
+		c.OfferMgr.AddOffer(&offer)
+	}
+	return c.saveMsgSigningKey(msgKey, msgKeyVer)
+}
+
+// saveMsgSigningKey saves the given msg signing key and key version to the config file.
+func (c *Core) saveMsgSigningKey(msgKey string, msgKeyVer byte) error {
+	data, err := ioutil.ReadFile(c.Settings.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("Error in reading config file: %v", err.Error())
+	}
+	config := strings.Split(string(data), ";")
+	if len(config) != 10 {
+		return fmt.Errorf("Error in parsing config file, expect 10 fields got %v", len(config))
+	}
+	config[8] = msgKey
+	config[9] = fmt.Sprintf("%v", msgKeyVer)
+	// Write to a temporary file and rename it, so a crash never leaves a truncated config file behind
+	tmpFile := c.Settings.ConfigFile + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Error in creating temporary config file: %v", err.Error())
+	}
+	_, err = f.Write([]byte(strings.Join(config, ";")))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("Error in writing temporary config file: %v", err.Error())
+	}
+	err = os.Rename(tmpFile, c.Settings.ConfigFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("Error in saving config file: %v", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+Package core - structure representing a Gateway's current state, including setting, configuration, references to
+all running Gateway APIs of this instance.
+*/
+package core
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"math/big"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// ValidCIDHashRange checks if a given cid min hash and cid max hash are 32 bytes hex strings.
+func ValidCIDHashRange(minStr string, maxStr string) bool {
+	for _, hash := range []string{minStr, maxStr} {
+		data, err := hex.DecodeString(hash)
+		if err != nil || len(data) != 32 {
+			return false
+		}
+	}
+	return true
+}
+
+// InCIDHashRange checks if a given offer contains a cid whose hash is within a given cid hash range.
+// The range wraps around the max hash if the min hash is bigger than the max hash.
+func InCIDHashRange(offer *cidoffer.CIDOffer, minStr string, maxStr string) bool {
+	min, _ := big.NewInt(0).SetString(minStr, 16)
+	max, _ := big.NewInt(0).SetString(maxStr, 16)
+	if min == nil || max == nil {
+		return false
+	}
+	for _, id := range offer.GetCIDs() {
+		cidHash, err := id.CalculateHash()
+		if err != nil {
+			logging.Error("Error getting cid hash for %v: %v", id.ToString(), err.Error())
+			continue
+		}
+		cidVal := big.NewInt(0).SetBytes(cidHash)
+		if max.Cmp(min) > 0 {
+			if cidVal.Cmp(min) >= 0 && cidVal.Cmp(max) <= 0 {
+				return true
+			}
+		} else {
+			if cidVal.Cmp(min) >= 0 || cidVal.Cmp(max) <= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// offerListPageSize is the number of offers listed at a time when going through the stored offers.
+const offerListPageSize = 1000
+
+// HandOffOffers pushes the stored offers no longer within the cid hash range of this gateway to the gateways closest
+// to their cids, as many as the replication factor. An offer is removed once handed off to at least one gateway.
+// The stored offers are gone through page by page in digest order, so removed offers do not shift the pages.
+func (c *Core) HandOffOffers() {
+	minStr, maxStr := c.PeerMgr.GetCurrentCIDHashRange()
+	after := ""
+	for {
+		offers := c.OfferMgr.ListOffersAfter(after, offerListPageSize)
+		if len(offers) == 0 {
+			return
+		}
+		for i := range offers {
+			c.handOffOffer(&offers[i], minStr, maxStr)
+		}
+		after = offers[len(offers)-1].GetMessageDigest()
+	}
+}
+
+// handOffOffer hands off a given offer if it is no longer within a given cid hash range, or removes it if expired.
+func (c *Core) handOffOffer(offer *cidoffer.CIDOffer, minStr string, maxStr string) {
+	if offer.GetProviderID() == c.NodeID || InCIDHashRange(offer, minStr, maxStr) {
+		// Resale offers of cached content stay with this gateway
+		return
+	}
+	if offer.HasExpired() {
+		c.OfferMgr.RemoveOffer(offer.GetMessageDigest())
+		return
+	}
+	targets := make(map[string]string)
+	for _, id := range offer.GetCIDs() {
+		cidHash, err := id.CalculateHash()
+		if err != nil {
+			logging.Error("Error getting cid hash for %v: %v", id.ToString(), err.Error())
+			continue
+		}
+		for _, gw := range c.PeerMgr.GetGWSNearCIDHash(hex.EncodeToString(cidHash), int(c.Settings.ReplicationFactor), c.NodeID) {
+			targets[gw.NodeID] = gw.NetworkAddr
+		}
+	}
+	handedOff := false
+	for gwID, networkAddr := range targets {
+		_, err := c.P2PServer.Request(networkAddr, fcrmessages.OfferPublishRequestType, gwID, offer)
+		if err != nil {
+			logging.Warn("Error in handing off offer %v to gateway %v: %v", offer.GetMessageDigest(), gwID, err.Error())
+			continue
+		}
+		handedOff = true
+	}
+	if handedOff {
+		c.OfferMgr.RemoveOffer(offer.GetMessageDigest())
+		logging.Info("Handed off offer %v to %v gateways", offer.GetMessageDigest(), len(targets))
+	}
+}
+
+// PullOffers pulls the offers within the cid hash range of this gateway from the gateways closest to this gateway,
+// as many as the replication factor, whose ranges overlap with the range of this gateway. Offers are pulled page by page.
+// Offers that are stored already, expired, or fail to verify against the signature of the provider are ignored.
+func (c *Core) PullOffers() {
+	minStr, maxStr := c.PeerMgr.GetCurrentCIDHashRange()
+	for _, gw := range c.PeerMgr.GetGWSNearCIDHash(c.NodeID, int(c.Settings.ReplicationFactor), c.NodeID) {
+		pulled := 0
+		after := ""
+		for {
+			response, err := c.P2PServer.Request(gw.NetworkAddr, fcrmessages.OfferSyncRequestType, gw.NodeID, minStr, maxStr, after)
+			if err != nil {
+				logging.Warn("Error in pulling offers from gateway %v: %v", gw.NodeID, err.Error())
+				break
+			}
+			_, offers, next, _ := fcrmessages.DecodeOfferSyncResponse(response)
+			for i := range offers {
+				if c.importOffer(&offers[i], minStr, maxStr) {
+					pulled++
+				}
+			}
+			// The last page has no next digest, a next digest not moving forward also ends the pull
+			if next <= after {
+				break
+			}
+			after = next
+		}
+		logging.Info("Pulled %v offers from gateway %v", pulled, gw.NodeID)
+	}
+}
+
+// importOffer stores a given offer pulled from another gateway, if it is within a given cid hash range and not revoked.
+// It returns true if the offer is stored.
+func (c *Core) importOffer(offer *cidoffer.CIDOffer, minStr string, maxStr string) bool {
+	if offer.HasExpired() || !InCIDHashRange(offer, minStr, maxStr) || c.OfferMgr.GetOfferByDigest(offer.GetMessageDigest()) != nil {
+		return false
+	}
+	if c.IsRevoked(offer) {
+		logging.Debug("Pulled offer %v has been revoked, ignore", offer.GetMessageDigest())
+		return false
+	}
+	pvdInfo := c.PeerMgr.GetPVDInfo(offer.GetProviderID())
+	if pvdInfo == nil {
+		// Not found, try sync once
+		pvdInfo = c.PeerMgr.SyncPVD(offer.GetProviderID())
+		if pvdInfo == nil {
+			logging.Warn("Error in obtaining information for provider %v of pulled offer", offer.GetProviderID())
+			return false
+		}
+	}
+	if offer.Verify(pvdInfo.OfferSigningKey) != nil {
+		logging.Warn("Pulled offer %v fails to verify against signature of provider %v", offer.GetMessageDigest(), offer.GetProviderID())
+		return false
+	}
+	c.OfferMgr.AddOffer(offer)
+	return true
+}
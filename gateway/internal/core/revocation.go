@@ -0,0 +1,71 @@
+/*
+Package core - structure representing a Gateway's current state, including setting, configuration, references to
+all running Gateway APIs of this instance.
+*/
+package core
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
+)
+
+// RevokedOfferRetention is how long a revocation is kept when the revoked offer is not stored, and so its expiry is unknown.
+const RevokedOfferRetention = 24 * time.Hour
+
+// revocation is a revocation of an offer by its provider, kept until a given time (in unix seconds).
+type revocation struct {
+	providerID string
+	until      int64
+}
+
+// RecordRevocation records the revocation of the offer of a given digest by a given provider, with the stored offer if any.
+// The revocation is kept until the offer expires, so that a copy of the offer held by another gateway cannot be stored again.
+func (c *Core) RecordRevocation(providerID string, digest string, offer *cidoffer.CIDOffer) {
+	until := time.Now().Add(RevokedOfferRetention).Unix()
+	if offer != nil {
+		until = offer.GetExpiry()
+	}
+	c.revokedOffersLock.Lock()
+	defer c.revokedOffersLock.Unlock()
+	// Forget the revocations of expired offers
+	now := time.Now().Unix()
+	for revoked, r := range c.revokedOffers {
+		if r.until < now {
+			delete(c.revokedOffers, revoked)
+		}
+	}
+	if r, ok := c.revokedOffers[digest]; !ok || r.providerID != providerID || r.until < until {
+		c.revokedOffers[digest] = &revocation{providerID: providerID, until: until}
+	}
+}
+
+// IsRevoked checks if a given offer has been revoked.
+// If so, the revocation is kept until the offer expires, as its expiry may not be known when it is revoked.
+func (c *Core) IsRevoked(offer *cidoffer.CIDOffer) bool {
+	c.revokedOffersLock.Lock()
+	defer c.revokedOffersLock.Unlock()
+	r, ok := c.revokedOffers[offer.GetMessageDigest()]
+	if !ok || r.providerID != offer.GetProviderID() || r.until < time.Now().Unix() {
+		return false
+	}
+	if offer.GetExpiry() > r.until {
+		r.until = offer.GetExpiry()
+	}
+	return true
+}
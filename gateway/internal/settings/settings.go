@@ -35,6 +35,36 @@ const DefaultTCPInactivityTimeout = 5000 * time.Millisecond
 // DefaultLongTCPInactivityTimeout is the default timeout for long TCP inactivity. This timeout should never be ignored.
 const DefaultLongTCPInactivityTimeout = 300000 * time.Millisecond
 
+// DefaultOfferDBFile is the default offer database file name, relative to the system dir
+const DefaultOfferDBFile = "offer.db"
+
+// DefaultPaymentDBFile is the default payment database file name, relative to the system dir
+const DefaultPaymentDBFile = "payment.db"
+
+// DefaultSettleCheckDuration is the default duration between two automatic settlement checks
+const DefaultSettleCheckDuration = 1 * time.Hour
+
+// DefaultCachePriceRatio is the default ratio of the resale price of cached content to the price paid for it
+const DefaultCachePriceRatio = 1.1
+
+// DefaultCacheOfferDuration is the default duration for which a resale offer of cached content is valid
+const DefaultCacheOfferDuration = 24 * time.Hour
+
+// DefaultCacheCheckDuration is the default duration between two automatic caching checks
+const DefaultCacheCheckDuration = 1 * time.Hour
+
+// DefaultCacheTopN is the default number of the most accessed cids to consider for automatic caching
+const DefaultCacheTopN = 10
+
+// DefaultDHTFanOutWorkers is the default number of gateways queried concurrently in a dht offer query
+const DefaultDHTFanOutWorkers = 4
+
+// DefaultDHTFanOutTimeout is the default deadline for querying gateways in a dht offer query, it must be below the long TCP inactivity timeout of clients
+const DefaultDHTFanOutTimeout = 60 * time.Second
+
+// DefaultReplicationFactor is the default number of gateways storing the offers of a cid
+const DefaultReplicationFactor = 16
+
 // AppSettings defines the server configuraiton
 type AppSettings struct {
 	// Logging related settings
@@ -52,10 +82,14 @@ type AppSettings struct {
 	// Admin related
 	BindAdminAPI   int    `mapstructure:"BIND_ADMIN_API"`   // Port number to bind to for admin secured HTTP connection
 	SystemDir      string `mapstructure:"SYSTEM_DIR"`       // // Dir storing all data of this gateway
-	RetrievalDir   string `mapstructure:"RETRIEVAL_DIR"`    // Retrieval Dir: /var/.fc-retrieval/gateway/files
+	RetrievalDir   string `mapstructure:"RETRIEVAL_DIR"`    // Dir managed as the content cache: /var/.fc-retrieval/gateway/files
 	AdminKeyFile   string `mapstructure:"ADMIN_KEY_FILE"`   // File storing the admin access key file
 	ConfigFile     string `mapstructure:"CONFIG_FILE"`      // File storing the gateway config
 	StoreFullOffer bool   `mapstructure:"STORE_FULL_OFFER"` // Boolean indicates whether this gateway stores full offer
+	PersistOffer   bool   `mapstructure:"PERSIST_OFFER"`    // Boolean indicates whether offers are persisted on disk
+	OfferDBFile    string `mapstructure:"OFFER_DB_FILE"`    // File storing the offer database
+	PersistPayment bool   `mapstructure:"PERSIST_PAYMENT"`  // Boolean indicates whether payment channels are persisted on disk
+	PaymentDBFile  string `mapstructure:"PAYMENT_DB_FILE"`  // File storing the payment database
 
 	// Duration
 	SyncDuration             time.Duration `mapstructure:"SYNC_DURATION"`               // Sync duration
@@ -67,4 +101,31 @@ type AppSettings struct {
 	SearchPrice *big.Int `mapstructure:"SEARCH_PRICE"` // Search price
 	OfferPrice  *big.Int `mapstructure:"OFFER_PRICE"`  // Offer price
 	TopupAmount *big.Int `mapstructure:"TOPUP_AMOUNT"` // Topup amount
+
+	// Automatic settlement related
+	AutoSettle          bool          `mapstructure:"AUTO_SETTLE"`           // Boolean indicates whether inbound channels are settled automatically
+	SettleCheckDuration time.Duration `mapstructure:"SETTLE_CHECK_DURATION"` // Duration between two automatic settlement checks
+	SettleMinRedeemed   *big.Int      `mapstructure:"SETTLE_MIN_REDEEMED"`   // Settle when unredeemed amount reaches this value, 0 to disable
+	SettleMaxAge        uint64        `mapstructure:"SETTLE_MAX_AGE"`        // Settle when channel age in blocks exceeds this value, 0 to disable
+	SettleDeregistering bool          `mapstructure:"SETTLE_DEREGISTERING"`  // Boolean indicates whether to settle when sender is deregistering
+	SettleMaxCostRatio  float64       `mapstructure:"SETTLE_MAX_COST_RATIO"` // Settle when cost to settle is below this fraction of unredeemed amount, 0 to disable
+
+	// Cache related
+	CachePriceRatio    float64       `mapstructure:"CACHE_PRICE_RATIO"`    // Ratio of the resale price of cached content to the price paid for it
+	CacheOfferDuration time.Duration `mapstructure:"CACHE_OFFER_DURATION"` // Duration for which a resale offer of cached content is valid
+	AutoCache          bool          `mapstructure:"AUTO_CACHE"`           // Boolean indicates whether popular content is cached automatically
+	CacheCheckDuration time.Duration `mapstructure:"CACHE_CHECK_DURATION"` // Duration between two automatic caching checks
+	CacheEviction      string        `mapstructure:"CACHE_EVICTION"`       // Eviction policy: lru, lfu
+	CacheMaxSize       int64         `mapstructure:"CACHE_MAX_SIZE"`       // Disk quota of the cache in bytes, 0 to disable
+	CacheMaxSpend      *big.Int      `mapstructure:"CACHE_MAX_SPEND"`      // Total amount to spend on automatic caching
+	CacheMaxPrice      *big.Int      `mapstructure:"CACHE_MAX_PRICE"`      // Maximum price to pay for a single content, 0 to disable
+	CacheTopN          uint          `mapstructure:"CACHE_TOP_N"`          // Number of the most accessed cids to consider at every check
+	CacheMinAccess     int           `mapstructure:"CACHE_MIN_ACCESS"`     // Minimum access count of a cid to be cached
+
+	// DHT related
+	DHTFanOutWorkers uint          `mapstructure:"DHT_FAN_OUT_WORKERS"` // Number of gateways queried concurrently in a dht offer query
+	DHTFanOutTimeout time.Duration `mapstructure:"DHT_FAN_OUT_TIMEOUT"` // Deadline for querying gateways in a dht offer query
+
+	// Offer replication related
+	ReplicationFactor uint `mapstructure:"REPLICATION_FACTOR"` // Number of gateways storing the offers of a cid, offers are handed off and pulled as the ring changes
 }
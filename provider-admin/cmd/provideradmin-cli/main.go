@@ -71,6 +71,12 @@ func completer(d prompt.Document) []prompt.Suggest {
 		{Text: "upload", Description: "Upload a file to the default provider (max 25MB)"},
 		{Text: "publish-offer", Description: "Ask the default provider to publish an offer"},
 		{Text: "fast-publish-offer", Description: "Upload a given file to the default provider and ask it to publish an offer"},
+		{Text: "withdraw-offer", Description: "Ask the default provider to withdraw an offer by digest"},
+		{Text: "set-offer-renewal", Description: "Set how the default provider renews an offer, or stop renewing it with off"},
+		{Text: "ls-channels", Description: "List inbound payment channels of the default provider"},
+		{Text: "settle-channel", Description: "Estimate the cost to settle an inbound payment channel of the default provider, settle it with --confirm"},
+		{Text: "collect-channel", Description: "Collect a settling inbound payment channel of the default provider"},
+		{Text: "ls-settlements", Description: "List automatic settlement decisions of the default provider"},
 		{Text: "exit", Description: "Exit the program"},
 	}
 	return prompt.FilterHasPrefix(s, d.GetWordBeforeCursor(), true)
@@ -249,6 +255,131 @@ func (c *ProviderAdminCLI) executor(in string) {
 			return
 		}
 		fmt.Println("Done")
+	case "withdraw-offer":
+		if len(blocks) != 2 {
+			fmt.Println("Usage: withdraw-offer ${digest}")
+			return
+		}
+		ok, msg, err := c.admin.WithdrawOffer(c.defaultPVD, blocks[1])
+		if err != nil {
+			fmt.Printf("Error in withdrawing offer from provider: %v\n", err.Error())
+			return
+		}
+		if !ok {
+			fmt.Printf("Fail to withdraw offer from provider: %v\n", msg)
+			return
+		}
+		fmt.Println(msg)
+	case "set-offer-renewal":
+		if len(blocks) == 3 && blocks[2] == "off" {
+			ok, msg, err := c.admin.SetOfferRenewal(c.defaultPVD, blocks[1], false, 0, 0, nil)
+			if err != nil {
+				fmt.Printf("Error in setting offer renewal for given provider: %v\n", err.Error())
+				return
+			}
+			if !ok {
+				fmt.Printf("Fail to set offer renewal for given provider: %v\n", msg)
+				return
+			}
+			fmt.Println("Done")
+			return
+		}
+		if len(blocks) != 3 && len(blocks) != 4 {
+			fmt.Println("Usage: set-offer-renewal ${digest} ${period} [${price}], or set-offer-renewal ${digest} off")
+			return
+		}
+		period, err := time.ParseDuration(blocks[2])
+		if err != nil {
+			fmt.Printf("Error parsing period: %v\n", err.Error())
+			return
+		}
+		if period <= time.Hour*12 {
+			fmt.Printf("Too short period: %v, need to be at least 12 hours\n", period)
+			return
+		}
+		var price *big.Int
+		if len(blocks) == 4 {
+			var ok bool
+			price, ok = big.NewInt(0).SetString(blocks[3], 10)
+			if !ok {
+				fmt.Println("Error parsing price")
+				return
+			}
+		}
+		ok, msg, err := c.admin.SetOfferRenewal(c.defaultPVD, blocks[1], true, 0, period, price)
+		if err != nil {
+			fmt.Printf("Error in setting offer renewal for given provider: %v\n", err.Error())
+			return
+		}
+		if !ok {
+			fmt.Printf("Fail to set offer renewal for given provider: %v\n", msg)
+			return
+		}
+		fmt.Println("Done")
+	case "ls-channels":
+		senders, chAddrs, balances, redeemed, settling, err := c.admin.ListInboundChs(c.defaultPVD)
+		if err != nil {
+			fmt.Printf("Error in listing inbound channels for given provider: %v\n", err.Error())
+			return
+		}
+		fmt.Println("Inbound channels:")
+		for i, sender := range senders {
+			fmt.Printf("%v:\tsender-%v\tchannel-%v\tbalance-%v\tredeemed-%v\tsettling-%t\n", i, sender, chAddrs[i], balances[i], redeemed[i], settling[i])
+		}
+	case "settle-channel":
+		if len(blocks) != 2 && !(len(blocks) == 3 && blocks[2] == "--confirm") {
+			fmt.Println("Usage: settle-channel ${senderAddr} [--confirm]")
+			return
+		}
+		if len(blocks) == 2 {
+			cost, err := c.admin.EstimateSettleCh(c.defaultPVD, blocks[1])
+			if err != nil {
+				fmt.Printf("Error in estimating cost to settle channel for given provider: %v\n", err.Error())
+				return
+			}
+			fmt.Printf("Estimated cost to settle: %v, run again with --confirm to settle\n", cost.String())
+			return
+		}
+		cost, err := c.admin.SettleCh(c.defaultPVD, blocks[1])
+		if err != nil {
+			fmt.Printf("Error in settling channel for given provider: %v\n", err.Error())
+			return
+		}
+		fmt.Printf("Settlement submitted with estimated cost %v, channel can be collected after the settlement period\n", cost.String())
+	case "collect-channel":
+		if len(blocks) != 2 {
+			fmt.Println("Usage: collect-channel ${senderAddr}")
+			return
+		}
+		ok, msg, err := c.admin.CollectCh(c.defaultPVD, blocks[1])
+		if err != nil {
+			fmt.Printf("Error in collecting channel for given provider: %v\n", err.Error())
+			return
+		}
+		if !ok {
+			fmt.Printf("Fail to collect channel for given provider: %v\n", msg)
+			return
+		}
+		fmt.Println("Done")
+	case "ls-settlements":
+		if len(blocks) != 2 {
+			fmt.Println("Usage: ls-settlements ${page}")
+			return
+		}
+		page, err := strconv.ParseUint(blocks[1], 10, 32)
+		if err != nil {
+			fmt.Printf("Error parsing unit int %v: %v\n", blocks[1], err.Error())
+			return
+		}
+		times, senders, chAddrs, redeemed, costs, reasons, settled, outcomes, err := c.admin.ListSettleDecisions(c.defaultPVD, uint(page))
+		if err != nil {
+			fmt.Printf("Error in listing settlement decisions for given provider: %v\n", err.Error())
+			return
+		}
+		fmt.Println("Settlement decisions:")
+		for i, sender := range senders {
+			fmt.Printf("%v:\ttime-%v\tsender-%v\tchannel-%v\tredeemed-%v\tcost-%v\tsettled-%t\treason-%v\toutcome-%v\n", i, time.Unix(times[i], 0).Format(time.RFC3339), sender, chAddrs[i], redeemed[i], costs[i], settled[i], reasons[i], outcomes[i])
+		}
 	case "exit":
 		fmt.Println("Shutdown provider admin...")
 		fmt.Println("Bye!")
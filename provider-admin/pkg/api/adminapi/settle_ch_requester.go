@@ -0,0 +1,68 @@
+/*
+Package adminapi - contains the the adminapi code.
+*/
+package adminapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+)
+
+// RequestSettleCh requests a given provider to settle the inbound payment channel from a given sender.
+// If estimate only, the channel is not settled and only the estimated cost is returned.
+func RequestSettleCh(adminURL string, adminKey string, senderAddr string, estimateOnly bool) (
+	*big.Int, // estimated cost
+	bool, // submitted
+	error, // error
+) {
+	request, err := fcradminmsg.EncodeSettleChRequest(senderAddr, estimateOnly)
+	if err != nil {
+		err = fmt.Errorf("Error in encoding request: %v", err.Error())
+		logging.Error(err.Error())
+		return nil, false, err
+	}
+
+	respType, respData, err := fcradminserver.Request(adminURL, adminKey, fcradminmsg.SettleChRequestType, request)
+	if err != nil {
+		err = fmt.Errorf("Error in sending request: %v", err.Error())
+		logging.Error(err.Error())
+		return nil, false, err
+	}
+
+	if respType == fcradminmsg.ACKType {
+		// Request is rejected
+		_, msg, err := fcradminmsg.DecodeACK(respData)
+		if err == nil {
+			err = fmt.Errorf("Request rejected: %v", msg)
+		}
+		logging.Error(err.Error())
+		return nil, false, err
+	}
+
+	if respType != fcradminmsg.SettleChResponseType {
+		err = fmt.Errorf("Getting response of wrong type expect %v, got %v", fcradminmsg.SettleChResponseType, respType)
+		logging.Error(err.Error())
+		return nil, false, err
+	}
+
+	return fcradminmsg.DecodeSettleChResponse(respData)
+}
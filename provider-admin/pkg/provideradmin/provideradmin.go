@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"time"
 
 	crypto "github.com/libp2p/go-libp2p-crypto"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
@@ -219,6 +220,40 @@ func (a *FilecoinRetrievalProviderAdmin) PublishOffer(targetID string, files []s
 	return adminapi.RequestPublishOffer(p.adminURL, p.adminKey, files, price, expiry, qos)
 }
 
+// WithdrawOffer asks a managed provider to withdraw the offer with a given digest and revoke it from every gateway
+func (a *FilecoinRetrievalProviderAdmin) WithdrawOffer(targetID string, digest string) (
+	bool, // Success
+	string, // Information
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	p, ok := a.activeProviders[targetID]
+	if !ok {
+		err := fmt.Errorf("Provider %v is not in active providers", targetID)
+		logging.Error(err.Error())
+		return false, "", err
+	}
+	return adminapi.RequestWithdrawOffer(p.adminURL, p.adminKey, digest)
+}
+
+// SetOfferRenewal asks a managed provider to set the renewal policy of the offer with a given digest
+func (a *FilecoinRetrievalProviderAdmin) SetOfferRenewal(targetID string, digest string, renew bool, renewBefore time.Duration, period time.Duration, price *big.Int) (
+	bool, // Success
+	string, // Information
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	p, ok := a.activeProviders[targetID]
+	if !ok {
+		err := fmt.Errorf("Provider %v is not in active providers", targetID)
+		logging.Error(err.Error())
+		return false, "", err
+	}
+	return adminapi.RequestSetOfferRenewal(p.adminURL, p.adminKey, digest, renew, renewBefore, period, price)
+}
+
 // FastPublishOffer uploads a file to given provider then asks it to publish the offer
 func (a *FilecoinRetrievalProviderAdmin) FastPublishOffer(targetID string, filename string, tag string, price *big.Int, expiry int64, qos uint64) error {
 	a.lock.RLock()
@@ -280,3 +315,102 @@ func (a *FilecoinRetrievalProviderAdmin) ForceSync(targetID string) error {
 	}
 	return nil
 }
+
+// ListInboundChs lists the inbound payment channels of a managed provider
+func (a *FilecoinRetrievalProviderAdmin) ListInboundChs(targetID string) (
+	[]string, // senders
+	[]string, // channel addresses
+	[]string, // balances
+	[]string, // redeemed
+	[]bool, // settling
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	p, ok := a.activeProviders[targetID]
+	if !ok {
+		err := fmt.Errorf("Provider %v is not in active providers", targetID)
+		logging.Error(err.Error())
+		return nil, nil, nil, nil, nil, err
+	}
+	return adminapi.RequestListInboundChs(p.adminURL, p.adminKey)
+}
+
+// EstimateSettleCh gets the estimated cost for a managed provider to settle the inbound payment channel from a given sender
+func (a *FilecoinRetrievalProviderAdmin) EstimateSettleCh(targetID string, senderAddr string) (*big.Int, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	p, ok := a.activeProviders[targetID]
+	if !ok {
+		err := fmt.Errorf("Provider %v is not in active providers", targetID)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	cost, _, err := adminapi.RequestSettleCh(p.adminURL, p.adminKey, senderAddr, true)
+	return cost, err
+}
+
+// SettleCh asks a managed provider to settle the inbound payment channel from a given sender
+// It returns the estimated cost of the settlement submitted
+func (a *FilecoinRetrievalProviderAdmin) SettleCh(targetID string, senderAddr string) (*big.Int, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	p, ok := a.activeProviders[targetID]
+	if !ok {
+		err := fmt.Errorf("Provider %v is not in active providers", targetID)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	cost, submitted, err := adminapi.RequestSettleCh(p.adminURL, p.adminKey, senderAddr, false)
+	if err != nil {
+		return nil, err
+	}
+	if !submitted {
+		err = fmt.Errorf("Settlement of channel from %v is not submitted", senderAddr)
+		logging.Error(err.Error())
+		return nil, err
+	}
+	return cost, nil
+}
+
+// CollectCh asks a managed provider to collect the settling inbound payment channel from a given sender
+func (a *FilecoinRetrievalProviderAdmin) CollectCh(targetID string, senderAddr string) (
+	bool, // Success
+	string, // Information
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	p, ok := a.activeProviders[targetID]
+	if !ok {
+		err := fmt.Errorf("Provider %v is not in active providers", targetID)
+		logging.Error(err.Error())
+		return false, "", err
+	}
+	return adminapi.RequestCollectCh(p.adminURL, p.adminKey, senderAddr)
+}
+
+// ListSettleDecisions lists the automatic settlement decisions from a managed provider, most recent first
+func (a *FilecoinRetrievalProviderAdmin) ListSettleDecisions(targetID string, page uint) (
+	[]int64, // times
+	[]string, // senders
+	[]string, // channel addresses
+	[]string, // redeemed
+	[]string, // costs
+	[]string, // reasons
+	[]bool, // settled
+	[]string, // outcomes
+	error, // error
+) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	p, ok := a.activeProviders[targetID]
+	if !ok {
+		err := fmt.Errorf("Provider %v is not in active providers", targetID)
+		logging.Error(err.Error())
+		return nil, nil, nil, nil, nil, nil, nil, nil, err
+	}
+	// page 0 is from 0 to 10
+	// page 1 is from 10 to 20...
+	return adminapi.RequestListSettleDecisions(p.adminURL, p.adminKey, 10*page, 10*(page+1))
+}
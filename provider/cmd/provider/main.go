@@ -29,6 +29,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -47,7 +48,10 @@ import (
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrregistermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrrenewmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrsettlemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/provider/internal/api/adminapi"
 	"github.com/wcgcyx/fc-retrieval/provider/internal/api/p2papi"
@@ -110,7 +114,13 @@ func main() {
 		AddHandler(fcradminmsg.ListFilesRequestType, adminapi.ListFilesHandler).
 		AddHandler(fcradminmsg.PublishOfferRequestType, adminapi.OfferPublishHandler).
 		AddHandler(fcradminmsg.UploadFileRequestType, adminapi.UploadFileHandler).
-		AddHandler(fcradminmsg.ForceSyncRequestType, adminapi.ForceSyncHandler)
+		AddHandler(fcradminmsg.ForceSyncRequestType, adminapi.ForceSyncHandler).
+		AddHandler(fcradminmsg.ListInboundChsRequestType, adminapi.ListInboundChsHandler).
+		AddHandler(fcradminmsg.SettleChRequestType, adminapi.SettleChHandler).
+		AddHandler(fcradminmsg.CollectChRequestType, adminapi.CollectChHandler).
+		AddHandler(fcradminmsg.ListSettleDecisionsRequestType, adminapi.ListSettleDecisionsHandler).
+		AddHandler(fcradminmsg.WithdrawOfferRequestType, adminapi.OfferWithdrawHandler).
+		AddHandler(fcradminmsg.SetOfferRenewalRequestType, adminapi.SetOfferRenewalHandler)
 
 	err = c.AdminServer.Start()
 	if err != nil {
@@ -178,11 +188,33 @@ func main() {
 		c.MsgSigningKey = msgSigningKey
 		c.MsgSigningKeyVer = byte(msgSigningKeyVer)
 		c.P2PServer = fcrserver.NewFCRServerImplV1(p2pPrivKey, uint(p2pPort), c.Settings.TCPInactivityTimeout)
-		registerMgr := fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
-		c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(registerMgr, nil, true, false, false, nodeID, c.Settings.SyncDuration)
+		c.RegisterMgr = fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
+		c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(c.RegisterMgr, nil, true, false, false, nodeID, 0, c.Settings.SyncDuration)
 		lotusMgr := fcrlotusmgr.NewFCRLotusMgrImplV1(lotusAPIAddr, lotusAuthToken, nil)
-		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
-		c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+		if c.Settings.PersistPayment {
+			c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV2(rootPrivKey, lotusMgr, c.Settings.PaymentDBFile)
+		} else {
+			c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
+		}
+		if c.Settings.AutoSettle {
+			c.SettleMgr = fcrsettlemgr.NewFCRSettleMgrImplV1(c.PaymentMgr, lotusMgr, c.PeerMgr, fcrsettlemgr.SettlePolicy{
+				MinRedeemed:   c.Settings.SettleMinRedeemed,
+				MaxAge:        c.Settings.SettleMaxAge,
+				Deregistering: c.Settings.SettleDeregistering,
+				MaxCostRatio:  c.Settings.SettleMaxCostRatio,
+			}, c.Settings.SettleCheckDuration)
+		}
+		if c.Settings.PersistOffer {
+			c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV2(c.Settings.OfferDBFile, true)
+		} else {
+			c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+		}
+		c.ReputationMgr = fcrreputationmgr.NewFCRReputationMgrImpV1()
+		if c.Settings.PersistOffer {
+			c.RenewMgr = fcrrenewmgr.NewFCRRenewMgrImplV2(nodeID, offerSigningKey, c.OfferMgr, c.PeerMgr, c.PublishOffer, c.RevokeOffer, int(c.Settings.ReplicationFactor), c.Settings.RenewCheckDuration, c.Settings.RenewDBFile)
+		} else {
+			c.RenewMgr = fcrrenewmgr.NewFCRRenewMgrImplV1(nodeID, offerSigningKey, c.OfferMgr, c.PeerMgr, c.PublishOffer, c.RevokeOffer, int(c.Settings.ReplicationFactor), c.Settings.RenewCheckDuration)
+		}
 		c.Ready <- true
 		if !<-c.Ready {
 			return
@@ -201,7 +233,8 @@ func main() {
 		AddHandler(fcrmessages.EstablishmentRequestType, p2papi.EstablishmentHandler).
 		AddHandler(fcrmessages.DataRetrievalRequestType, p2papi.DataRetrievalHandler).
 		// Requesters
-		AddRequester(fcrmessages.OfferPublishRequestType, p2papi.OfferPublishRequester)
+		AddRequester(fcrmessages.OfferPublishRequestType, p2papi.OfferPublishRequester).
+		AddRequester(fcrmessages.OfferRevocationRequestType, p2papi.OfferRevocationRequester)
 
 	err = c.P2PServer.Start()
 	if err != nil {
@@ -227,6 +260,16 @@ func main() {
 		return
 	}
 
+	if c.SettleMgr != nil {
+		err = c.SettleMgr.Start()
+		if err != nil {
+			logging.Error("Error in starting Settle Manager: %v", err)
+			c.Ready <- false
+			gracefulExit()
+			return
+		}
+	}
+
 	err = c.OfferMgr.Start()
 	if err != nil {
 		logging.Error("Error in starting Offer Manager: %v", err)
@@ -235,6 +278,35 @@ func main() {
 		return
 	}
 
+	err = c.ReputationMgr.Start()
+	if err != nil {
+		logging.Error("Error in starting Reputation Manager: %v", err)
+		c.Ready <- false
+		gracefulExit()
+		return
+	}
+
+	// The persistent renew manager tracks the offers it tracked before start-up again
+	err = c.RenewMgr.Start()
+	if err != nil {
+		logging.Error("Error in starting Renew Manager: %v", err)
+		c.Ready <- false
+		gracefulExit()
+		return
+	}
+
+	// Track the other offers published before start-up, they are republished to the gateways near their cids
+	for _, offer := range c.OfferMgr.ListOffers(0, math.MaxUint32) {
+		offer := offer
+		if offer.HasExpired() || c.RenewMgr.GetPolicy(offer.GetMessageDigest()) != nil {
+			continue
+		}
+		err = c.RenewMgr.Track(&offer, c.DefaultRenewPolicy(c.Settings.OfferRenewPeriod), nil)
+		if err != nil {
+			logging.Warn("Error in tracking offer %v: %v", offer.GetMessageDigest(), err.Error())
+		}
+	}
+
 	// Everything has been started.
 	c.Ready <- true
 	// Wait for this provider to be registered.
@@ -247,9 +319,19 @@ func main() {
 	logging.Info("Filecoin Provider Start-up Complete")
 	c.PeerMgr.Sync()
 
-	// Wait forever
-	// TODO: Start message signing key update routine.
-	select {}
+	// Start message signing key update routine, it runs forever
+	msgKeyUpdateRoutine(c)
+}
+
+// msgKeyUpdateRoutine updates the message signing key periodically
+func msgKeyUpdateRoutine(c *core.Core) {
+	for {
+		time.Sleep(c.Settings.MsgKeyUpdateDuration)
+		err := c.UpdateMsgSigningKey()
+		if err != nil {
+			logging.Error("Error in updating message signing key: %v", err.Error())
+		}
+	}
 }
 
 // gracefulExit handles exit
@@ -268,12 +350,21 @@ func gracefulExit() {
 	if c.PeerMgr != nil {
 		c.PeerMgr.Shutdown()
 	}
+	if c.SettleMgr != nil {
+		c.SettleMgr.Shutdown()
+	}
 	if c.PaymentMgr != nil {
 		c.PaymentMgr.Shutdown()
 	}
 	if c.OfferMgr != nil {
 		c.OfferMgr.Shutdown()
 	}
+	if c.RenewMgr != nil {
+		c.RenewMgr.Shutdown()
+	}
+	if c.ReputationMgr != nil {
+		c.ReputationMgr.Shutdown()
+	}
 
 	logging.Info("Filecoin Provider Shutdown: Completed")
 }
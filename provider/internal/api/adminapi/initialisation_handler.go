@@ -32,7 +32,10 @@ import (
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrregistermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrrenewmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrsettlemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/register"
 	"github.com/wcgcyx/fc-retrieval/provider/internal/core"
@@ -95,15 +98,43 @@ func InitialisationHandler(data []byte) (byte, []byte, error) {
 	c.P2PServer = fcrserver.NewFCRServerImplV1(p2pPrivKey, uint(p2pPort), c.Settings.TCPInactivityTimeout)
 
 	// Initialise peer manager
-	registerMgr := fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
-	c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(registerMgr, nil, true, false, false, nodeID, c.Settings.SyncDuration)
+	c.RegisterMgr = fcrregistermgr.NewFCRRegisterMgrImplV1(registerAPIAddr, &http.Client{Timeout: 180 * time.Second})
+	c.PeerMgr = fcrpeermgr.NewFCRPeerMgrImplV1(c.RegisterMgr, nil, true, false, false, nodeID, 0, c.Settings.SyncDuration)
 
 	// Initialise payment manager
 	lotusMgr := fcrlotusmgr.NewFCRLotusMgrImplV1(lotusAPIAddr, lotusAuthToken, nil)
-	c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
+	if c.Settings.PersistPayment {
+		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV2(rootPrivKey, lotusMgr, c.Settings.PaymentDBFile)
+	} else {
+		c.PaymentMgr = fcrpaymentmgr.NewFCRPaymentMgrImplV1(rootPrivKey, lotusMgr)
+	}
+
+	// Initialise settle manager
+	if c.Settings.AutoSettle {
+		c.SettleMgr = fcrsettlemgr.NewFCRSettleMgrImplV1(c.PaymentMgr, lotusMgr, c.PeerMgr, fcrsettlemgr.SettlePolicy{
+			MinRedeemed:   c.Settings.SettleMinRedeemed,
+			MaxAge:        c.Settings.SettleMaxAge,
+			Deregistering: c.Settings.SettleDeregistering,
+			MaxCostRatio:  c.Settings.SettleMaxCostRatio,
+		}, c.Settings.SettleCheckDuration)
+	}
 
 	// Initialise offer manager
-	c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+	if c.Settings.PersistOffer {
+		c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV2(c.Settings.OfferDBFile, true)
+	} else {
+		c.OfferMgr = fcroffermgr.NewFCROfferMgrImplV1(true)
+	}
+
+	// Initialise renew manager
+	if c.Settings.PersistOffer {
+		c.RenewMgr = fcrrenewmgr.NewFCRRenewMgrImplV2(nodeID, offerKey, c.OfferMgr, c.PeerMgr, c.PublishOffer, c.RevokeOffer, int(c.Settings.ReplicationFactor), c.Settings.RenewCheckDuration, c.Settings.RenewDBFile)
+	} else {
+		c.RenewMgr = fcrrenewmgr.NewFCRRenewMgrImplV1(nodeID, offerKey, c.OfferMgr, c.PeerMgr, c.PublishOffer, c.RevokeOffer, int(c.Settings.ReplicationFactor), c.Settings.RenewCheckDuration)
+	}
+
+	// Initialise reputation manager
+	c.ReputationMgr = fcrreputationmgr.NewFCRReputationMgrImpV1()
 
 	// Ask the server to start
 	c.Ready <- true
@@ -115,7 +146,7 @@ func InitialisationHandler(data []byte) (byte, []byte, error) {
 	}
 
 	// Initialisation succeed. Start register this provider.
-	err = registerMgr.RegisterProvider(nodeID, &register.ProviderRegisteredInfo{
+	err = c.RegisterMgr.RegisterProvider(nodeID, &register.ProviderRegisteredInfo{
 		RootKey:             rootKey,
 		NodeID:              nodeID,
 		MsgSigningKey:       msgSigningKey,
@@ -0,0 +1,72 @@
+/*
+Package adminapi contains the API code for the admin client - gateway communication.
+*/
+package adminapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/provider/internal/core"
+)
+
+// ListInboundChsHandler handles list inbound payment channels request
+func ListInboundChsHandler(data []byte) (byte, []byte, error) {
+	logging.Debug("Handle list inbound channels from admin")
+	// Get core
+	c := core.GetSingleInstance()
+	if !c.Initialised {
+		// Not initialised.
+		err := errors.New("Not initialised")
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	senders := make([]string, 0)
+	chAddrs := make([]string, 0)
+	balances := make([]string, 0)
+	redeemed := make([]string, 0)
+	settling := make([]bool, 0)
+	for _, sender := range c.PaymentMgr.ListInboundChs() {
+		chAddr, balance, chRedeemed, err := c.PaymentMgr.GetInboundChStatus(sender)
+		if err != nil {
+			// Channel has been removed
+			continue
+		}
+		chSettling, err := c.PaymentMgr.GetInboundChSettling(sender)
+		if err != nil {
+			continue
+		}
+		senders = append(senders, sender)
+		chAddrs = append(chAddrs, chAddr)
+		balances = append(balances, balance.String())
+		redeemed = append(redeemed, chRedeemed.String())
+		settling = append(settling, chSettling)
+	}
+
+	// Succeed
+	response, err := fcradminmsg.EncodeListInboundChsResponse(senders, chAddrs, balances, redeemed, settling)
+	if err != nil {
+		err = fmt.Errorf("Error in encoding response: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+	return fcradminmsg.ListInboundChsResponseType, response, nil
+}
@@ -0,0 +1,86 @@
+/*
+Package adminapi contains the API code for the admin client - gateway communication.
+*/
+package adminapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/provider/internal/core"
+)
+
+// ListSettleDecisionsHandler handles list automatic settlement decisions request
+func ListSettleDecisionsHandler(data []byte) (byte, []byte, error) {
+	logging.Debug("Handle list settle decisions from admin")
+	// Get core
+	c := core.GetSingleInstance()
+	if !c.Initialised {
+		// Not initialised.
+		err := errors.New("Not initialised")
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+	if c.SettleMgr == nil {
+		err := errors.New("Auto settlement is not enabled")
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	// Decode payload
+	from, to, err := fcradminmsg.DecodeListSettleDecisionsRequest(data)
+	if err != nil {
+		err = fmt.Errorf("Error in decoding payload: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+	decisions := c.SettleMgr.ListDecisions(from, to)
+	times := make([]int64, 0)
+	senders := make([]string, 0)
+	chAddrs := make([]string, 0)
+	redeemed := make([]string, 0)
+	costs := make([]string, 0)
+	reasons := make([]string, 0)
+	settled := make([]bool, 0)
+	outcomes := make([]string, 0)
+	for _, decision := range decisions {
+		cost := ""
+		if decision.Cost != nil {
+			cost = decision.Cost.String()
+		}
+		times = append(times, decision.Time)
+		senders = append(senders, decision.SenderAddr)
+		chAddrs = append(chAddrs, decision.ChAddr)
+		redeemed = append(redeemed, decision.Redeemed.String())
+		costs = append(costs, cost)
+		reasons = append(reasons, decision.Reason)
+		settled = append(settled, decision.Settled)
+		outcomes = append(outcomes, decision.Outcome)
+	}
+
+	// Succeed
+	response, err := fcradminmsg.EncodeListSettleDecisionsResponse(times, senders, chAddrs, redeemed, costs, reasons, settled, outcomes)
+	if err != nil {
+		err = fmt.Errorf("Error in encoding response: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+	return fcradminmsg.ListSettleDecisionsResponseType, response, nil
+}
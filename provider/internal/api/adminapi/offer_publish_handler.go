@@ -23,11 +23,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
-	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/provider/internal/core"
 )
@@ -59,7 +59,7 @@ func OfferPublishHandler(data []byte) (byte, []byte, error) {
 			ack := fcradminmsg.EncodeACK(false, err.Error())
 			return fcradminmsg.ACKType, ack, err
 		}
-		cid, err := cid.NewContentIDFromFile(reader)
+		cid, err := cid.NewContentIDFromFileWithParams(reader, c.Settings.DAGParams)
 		if err != nil {
 			err = fmt.Errorf("Invalid CID: %v", err.Error())
 			ack := fcradminmsg.EncodeACK(false, err.Error())
@@ -88,13 +88,22 @@ func OfferPublishHandler(data []byte) (byte, []byte, error) {
 	// Send offer
 	// TODO, concurrency and memory (too many gateways)
 	gws := c.PeerMgr.ListGWS()
+	publishedTo := make([]string, 0)
 	for _, gw := range gws {
-		c.P2PServer.Request(gw.NetworkAddr, fcrmessages.OfferPublishRequestType, gw.NodeID, offer)
+		if c.PublishOffer(offer, gw) == nil {
+			publishedTo = append(publishedTo, gw.NodeID)
+		}
 	}
 
 	// Add offer
 	c.OfferMgr.AddOffer(offer)
 
+	// Track offer, the renewed offer is valid for as long as this offer by default
+	err = c.RenewMgr.Track(offer, c.DefaultRenewPolicy(time.Until(time.Unix(expiry, 0))), publishedTo)
+	if err != nil {
+		logging.Warn("Error in tracking offer %v: %v", offer.GetMessageDigest(), err.Error())
+	}
+
 	// Succeed
 	ack := fcradminmsg.EncodeACK(true, "Succeed")
 	return fcradminmsg.ACKType, ack, nil
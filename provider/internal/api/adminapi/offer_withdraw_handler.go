@@ -0,0 +1,79 @@
+/*
+Package adminapi contains the API code for the admin client - gateway communication.
+*/
+package adminapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminmsg"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
+	"github.com/wcgcyx/fc-retrieval/provider/internal/core"
+)
+
+// OfferWithdrawHandler handles offer withdrawal, it revokes the offer from every gateway.
+func OfferWithdrawHandler(data []byte) (byte, []byte, error) {
+	logging.Debug("Handle offer withdraw from admin")
+	// Get core
+	c := core.GetSingleInstance()
+	if !c.Initialised {
+		// Not initialised.
+		err := errors.New("Not initialised")
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	digest, err := fcradminmsg.DecodeWithdrawOfferRequest(data)
+	if err != nil {
+		err = fmt.Errorf("Error in decoding payload: %v", err.Error())
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	if c.OfferMgr.GetOfferByDigest(digest) == nil {
+		err = fmt.Errorf("Offer %v not found", digest)
+		ack := fcradminmsg.EncodeACK(false, err.Error())
+		return fcradminmsg.ACKType, ack, err
+	}
+
+	// Stop renewal before the revocation, so the offer is never renewed after it is revoked
+	c.RenewMgr.Untrack(digest)
+
+	// Send revocation
+	// TODO, concurrency and memory (too many gateways)
+	gws := c.PeerMgr.ListGWS()
+	failed := 0
+	for _, gw := range gws {
+		err = c.RevokeOffer(digest, gw)
+		if err != nil {
+			failed++
+		}
+	}
+
+	// Remove offer
+	c.OfferMgr.RemoveOffer(digest)
+
+	// Succeed
+	msg := "Succeed"
+	if failed > 0 {
+		msg = fmt.Sprintf("Offer withdrawn, %v of %v gateways fail to receive the revocation", failed, len(gws))
+	}
+	ack := fcradminmsg.EncodeACK(true, msg)
+	return fcradminmsg.ACKType, ack, nil
+}
@@ -68,7 +68,7 @@ func UploadFileHandler(data []byte) (byte, []byte, error) {
 			ack := fcradminmsg.EncodeACK(false, err.Error())
 			return fcradminmsg.ACKType, ack, err
 		}
-		cid, err := cid.NewContentIDFromFile(reader)
+		cid, err := cid.NewContentIDFromFileWithParams(reader, c.Settings.DAGParams)
 		if err != nil {
 			err = fmt.Errorf("Invalid CID: %v", err.Error())
 			ack := fcradminmsg.EncodeACK(false, err.Error())
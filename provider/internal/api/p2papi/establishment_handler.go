@@ -32,15 +32,14 @@ func EstablishmentHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 	logging.Debug("Handle establishment")
 	// Get core structure
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
 
 	// Message decoding
 	nonce, senderID, challenge, err := fcrmessages.DecodeEstablishmentRequest(request)
 	if err != nil {
-		err = fmt.Errorf("Error in decoding payload: %v", err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInvalidRequest, Message: fmt.Sprintf("Error in decoding payload: %v", err.Error())}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	}
 
 	// Verify signature
@@ -51,18 +50,18 @@ func EstablishmentHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 			// Not found, try sync once
 			gwInfo = c.PeerMgr.SyncGW(senderID)
 			if gwInfo == nil {
-				err = fmt.Errorf("Error in obtaining information for gateway %v", senderID)
+				err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in obtaining information for gateway %v", senderID)}
 				logging.Error(err.Error())
-				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 			}
 		}
-		if request.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
+		if gwInfo.VerifyMsg(request.Verify) != nil {
 			// Try update
 			gwInfo = c.PeerMgr.SyncGW(senderID)
-			if gwInfo == nil || request.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
-				err = fmt.Errorf("Error in verifying request from gateway %v: %v", senderID, err.Error())
+			if gwInfo == nil || gwInfo.VerifyMsg(request.Verify) != nil {
+				err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeUnauthorised, Message: fmt.Sprintf("Error in verifying request from gateway %v", senderID)}
 				logging.Error(err.Error())
-				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+				return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 			}
 		}
 	}
@@ -70,10 +69,10 @@ func EstablishmentHandler(reader fcrserver.FCRServerRequestReader, writer fcrser
 	// Respond
 	response, err := fcrmessages.EncodeEstablishmentResponse(nonce, challenge)
 	if err != nil {
-		err = fmt.Errorf("Internal error in encoding response: %v", err.Error())
+		err = &fcrmessages.FCRError{Code: fcrmessages.ErrorCodeInternal, Message: fmt.Sprintf("Internal error in encoding response: %v", err.Error()), RetryAfter: fcrmessages.DefaultRetryAfter}
 		logging.Error(err.Error())
-		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+		return writer.Write(fcrmessages.CreateFCRACKErrorMsg(nonce, err), msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	}
 
-	return writer.Write(response, c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+	return writer.Write(response, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 }
@@ -20,7 +20,6 @@ package p2papi
 
 import (
 	"fmt"
-	"math/rand"
 
 	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
@@ -53,11 +52,10 @@ func OfferPublishRequester(reader fcrserver.FCRServerResponseReader, writer fcrs
 
 	// Get core structure
 	c := core.GetSingleInstance()
-	c.MsgSigningKeyLock.RLock()
-	defer c.MsgSigningKeyLock.RUnlock()
+	msgKey, msgKeyVer := c.GetMsgSigningKey()
 
 	// Generate random nonce
-	nonce := uint64(rand.Int63())
+	nonce := fcrserver.NewNonce()
 
 	request, err := fcrmessages.EncodeOfferPublishRequest(nonce, c.NodeID, offer)
 	if err != nil {
@@ -66,14 +64,14 @@ func OfferPublishRequester(reader fcrserver.FCRServerResponseReader, writer fcrs
 		return nil, err
 	}
 
-	err = request.Sign(c.MsgSigningKey, c.MsgSigningKeyVer)
+	err = request.Sign(msgKey, msgKeyVer)
 	if err != nil {
 		// Error in signing
 		return nil, err
 	}
 
 	// Write request
-	err = writer.Write(request, c.MsgSigningKey, c.MsgSigningKeyVer, c.Settings.TCPInactivityTimeout)
+	err = writer.Write(request, msgKey, msgKeyVer, c.Settings.TCPInactivityTimeout)
 	if err != nil {
 		err = fmt.Errorf("Error in sending request to %v: %v", targetID, err.Error())
 		logging.Error(err.Error())
@@ -99,11 +97,11 @@ func OfferPublishRequester(reader fcrserver.FCRServerResponseReader, writer fcrs
 			return nil, err
 		}
 	}
-	if response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
+	if gwInfo.VerifyMsg(response.Verify) != nil {
 		// Try update
 		gwInfo = c.PeerMgr.SyncGW(targetID)
-		if gwInfo == nil || response.Verify(gwInfo.MsgSigningKey, gwInfo.MsgSigningKeyVer) != nil {
-			err = fmt.Errorf("Error in verifying response from %v: %v", targetID, err.Error())
+		if gwInfo == nil || gwInfo.VerifyMsg(response.Verify) != nil {
+			err = fmt.Errorf("Error in verifying response from %v", targetID)
 			logging.Error(err.Error())
 			return nil, err
 		}
@@ -22,10 +22,12 @@ import (
 	"flag"
 	"fmt"
 	"math/big"
+	"path/filepath"
 	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/provider/internal/settings"
 )
@@ -59,6 +61,59 @@ func Map(conf *viper.Viper) settings.AppSettings {
 		tcpLongInactivityTimeout = settings.DefaultLongTCPInactivityTimeout
 	}
 
+	offerDBFile := conf.GetString("OFFER_DB_FILE")
+	if offerDBFile == "" {
+		offerDBFile = filepath.Join(conf.GetString("SYSTEM_DIR"), settings.DefaultOfferDBFile)
+	}
+	renewDBFile := conf.GetString("RENEW_DB_FILE")
+	if renewDBFile == "" {
+		renewDBFile = filepath.Join(conf.GetString("SYSTEM_DIR"), settings.DefaultRenewDBFile)
+	}
+	paymentDBFile := conf.GetString("PAYMENT_DB_FILE")
+	if paymentDBFile == "" {
+		paymentDBFile = filepath.Join(conf.GetString("SYSTEM_DIR"), settings.DefaultPaymentDBFile)
+	}
+
+	settleCheckDuration, err := time.ParseDuration(conf.GetString("SETTLE_CHECK_DURATION"))
+	if err != nil {
+		settleCheckDuration = settings.DefaultSettleCheckDuration
+	}
+	settleMinRedeemed := new(big.Int)
+	_, err = fmt.Sscan(conf.GetString("SETTLE_MIN_REDEEMED"), settleMinRedeemed)
+	if err != nil {
+		// Disabled by default
+		settleMinRedeemed = big.NewInt(0)
+	}
+
+	renewCheckDuration, err := time.ParseDuration(conf.GetString("RENEW_CHECK_DURATION"))
+	if err != nil {
+		renewCheckDuration = settings.DefaultRenewCheckDuration
+	}
+	offerRenewBefore, err := time.ParseDuration(conf.GetString("OFFER_RENEW_BEFORE"))
+	if err != nil {
+		offerRenewBefore = settings.DefaultOfferRenewBefore
+	}
+	offerRenewPeriod, err := time.ParseDuration(conf.GetString("OFFER_RENEW_PERIOD"))
+	if err != nil || offerRenewPeriod <= offerRenewBefore {
+		offerRenewPeriod = settings.DefaultOfferRenewPeriod
+	}
+	replicationFactor := conf.GetUint("REPLICATION_FACTOR")
+	if replicationFactor == 0 {
+		replicationFactor = settings.DefaultReplicationFactor
+	}
+
+	dagParams := cid.DAGParams{
+		ChunkSize:  conf.GetInt64("CID_CHUNK_SIZE"),
+		CIDVersion: conf.GetUint64("CID_VERSION"),
+		RawLeaves:  conf.GetBool("CID_RAW_LEAVES"),
+	}
+	if dagParams.ChunkSize == 0 {
+		dagParams.ChunkSize = cid.DefaultDAGParams.ChunkSize
+	}
+	if dagParams.Validate() != nil {
+		dagParams = cid.DefaultDAGParams
+	}
+
 	defaultSearchPrice := new(big.Int)
 	_, err = fmt.Sscan(conf.GetString("SEARCH_PRICE"), defaultSearchPrice)
 	if err != nil {
@@ -78,11 +133,16 @@ func Map(conf *viper.Viper) settings.AppSettings {
 		LogCompress:    conf.GetBool("LOG_COMPRESS"),
 		LogTimeFormat:  conf.GetString("LOG_TIME_FORMAT"),
 
-		BindAdminAPI: conf.GetInt("BIND_ADMIN_API"),
-		SystemDir:    conf.GetString("SYSTEM_DIR"),
-		RetrievalDir: conf.GetString("RETRIEVAL_DIR"),
-		AdminKeyFile: conf.GetString("ADMIN_KEY_FILE"),
-		ConfigFile:   conf.GetString("CONFIG_FILE"),
+		BindAdminAPI:   conf.GetInt("BIND_ADMIN_API"),
+		SystemDir:      conf.GetString("SYSTEM_DIR"),
+		RetrievalDir:   conf.GetString("RETRIEVAL_DIR"),
+		AdminKeyFile:   conf.GetString("ADMIN_KEY_FILE"),
+		ConfigFile:     conf.GetString("CONFIG_FILE"),
+		PersistOffer:   conf.GetBool("PERSIST_OFFER"),
+		OfferDBFile:    offerDBFile,
+		RenewDBFile:    renewDBFile,
+		PersistPayment: conf.GetBool("PERSIST_PAYMENT"),
+		PaymentDBFile:  paymentDBFile,
 
 		SyncDuration:             syncDuration,
 		MsgKeyUpdateDuration:     msgKeyUpdateDuration,
@@ -90,6 +150,21 @@ func Map(conf *viper.Viper) settings.AppSettings {
 		TCPLongInactivityTimeout: tcpLongInactivityTimeout,
 
 		SearchPrice: defaultSearchPrice,
+
+		AutoSettle:          conf.GetBool("AUTO_SETTLE"),
+		SettleCheckDuration: settleCheckDuration,
+		SettleMinRedeemed:   settleMinRedeemed,
+		SettleMaxAge:        conf.GetUint64("SETTLE_MAX_AGE"),
+		SettleDeregistering: conf.GetBool("SETTLE_DEREGISTERING"),
+		SettleMaxCostRatio:  conf.GetFloat64("SETTLE_MAX_COST_RATIO"),
+
+		AutoRenewOffer:     conf.GetBool("AUTO_RENEW_OFFER"),
+		RenewCheckDuration: renewCheckDuration,
+		OfferRenewBefore:   offerRenewBefore,
+		OfferRenewPeriod:   offerRenewPeriod,
+		ReplicationFactor:  replicationFactor,
+
+		DAGParams: dagParams,
 	}
 }
 
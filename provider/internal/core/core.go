@@ -20,13 +20,25 @@ package core
  */
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cidoffer"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcradminserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrcrypto"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrmessages"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcroffermgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpaymentmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrpeermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrregistermgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrrenewmgr"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrreputationmgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrserver"
+	"github.com/wcgcyx/fc-retrieval/common/pkg/fcrsettlemgr"
 	"github.com/wcgcyx/fc-retrieval/common/pkg/logging"
 	"github.com/wcgcyx/fc-retrieval/provider/internal/settings"
 )
@@ -48,6 +60,8 @@ type Core struct {
 	MsgSigningKey     string
 	MsgSigningKeyVer  byte
 	MsgSigningKeyLock sync.RWMutex
+	// Lock serialising the updates of the message signing key
+	msgSigningKeyUpdateLock sync.Mutex
 
 	OfferSigningKey    string
 	OfferSigningPubKey string
@@ -58,14 +72,26 @@ type Core struct {
 	// The P2P Server
 	P2PServer fcrserver.FCRServer
 
+	// The Register Manager
+	RegisterMgr fcrregistermgr.FCRRegisterMgr
+
 	// The Peer Manager
 	PeerMgr fcrpeermgr.FCRPeerMgr
 
 	// The Payment Manager
 	PaymentMgr fcrpaymentmgr.FCRPaymentMgr
 
+	// The Settle Manager, nil if automatic settlement is disabled
+	SettleMgr fcrsettlemgr.FCRSettleMgr
+
 	// The Offer Manager
 	OfferMgr fcroffermgr.FCROfferMgr
+
+	// The Reputation Manager, tracks peers retrieving content from this provider
+	ReputationMgr fcrreputationmgr.FCRReputationMgr
+
+	// The Renew Manager, tracks published offers to renew and republish them
+	RenewMgr fcrrenewmgr.FCRRenewMgr
 }
 
 // Single instance of the provider
@@ -93,10 +119,111 @@ func GetSingleInstance(confs ...*settings.AppSettings) *Core {
 			OfferSigningKey:   "",
 			AdminServer:       nil,
 			P2PServer:         nil,
+			RegisterMgr:       nil,
 			OfferMgr:          nil,
 			PeerMgr:           nil,
 			PaymentMgr:        nil,
+			SettleMgr:         nil,
+			ReputationMgr:     nil,
+			RenewMgr:          nil,
 		}
 	})
 	return instance
 }
+
+// PublishOffer publishes a given offer to a given gateway.
+func (c *Core) PublishOffer(offer *cidoffer.CIDOffer, gw fcrpeermgr.Peer) error {
+	_, err := c.P2PServer.Request(gw.NetworkAddr, fcrmessages.OfferPublishRequestType, gw.NodeID, offer)
+	return err
+}
+
+// RevokeOffer revokes the offer with a given digest from a given gateway.
+func (c *Core) RevokeOffer(digest string, gw fcrpeermgr.Peer) error {
+	_, err := c.P2PServer.Request(gw.NetworkAddr, fcrmessages.OfferRevocationRequestType, gw.NodeID, digest)
+	return err
+}
+
+// DefaultRenewPolicy gets the renewal policy of an offer from the settings, the renewed offer is valid for a given period.
+func (c *Core) DefaultRenewPolicy(period time.Duration) fcrrenewmgr.RenewPolicy {
+	return fcrrenewmgr.RenewPolicy{
+		Renew:       c.Settings.AutoRenewOffer && period > c.Settings.OfferRenewBefore,
+		RenewBefore: c.Settings.OfferRenewBefore,
+		Period:      period,
+		Price:       nil,
+	}
+}
+
+// GetMsgSigningKey returns the current msg signing key and key version.
+func (c *Core) GetMsgSigningKey() (string, byte) {
+	c.MsgSigningKeyLock.RLock()
+	defer c.MsgSigningKeyLock.RUnlock()
+	return c.MsgSigningKey, c.MsgSigningKeyVer
+}
+
+// UpdateMsgSigningKey generates a new msg signing key with the next key version, publishes it to the register
+// and saves it to the config file.
+func (c *Core) UpdateMsgSigningKey() error {
+	msgKey, msgSigningKey, _, err := fcrcrypto.GenerateRetrievalKeyPair()
+	if err != nil {
+		return fmt.Errorf("Error in generating message signing key: %v", err.Error())
+	}
+	pvdInfo, err := c.RegisterMgr.GetRegisteredProviderByID(c.NodeID)
+	if err != nil {
+		return fmt.Errorf("Error in getting registered information of this provider: %v", err.Error())
+	}
+	// The register is updated without holding the msg signing key lock, so messages are still signed meanwhile
+	c.msgSigningKeyUpdateLock.Lock()
+	defer c.msgSigningKeyUpdateLock.Unlock()
+	_, msgKeyVer := c.GetMsgSigningKey()
+	msgKeyVer++
+	pvdInfo.MsgSigningKey = msgSigningKey
+	pvdInfo.MsgSigningKeyVer = msgKeyVer
+	err = c.RegisterMgr.UpdateProvider(c.NodeID, pvdInfo)
+	if err != nil {
+		return fmt.Errorf("Error in updating message signing key in the register: %v", err.Error())
+	}
+	// The register has the new key, it must be used from now on
+	c.MsgSigningKeyLock.Lock()
+	c.MsgSigningKey = msgKey
+	c.MsgSigningKeyVer = msgKeyVer
+	c.MsgSigningKeyLock.Unlock()
+	logging.Info("Message signing key updated to version %v", msgKeyVer)
+	return c.saveMsgSigningKey(msgKey, msgKeyVer)
+}
+
+// saveMsgSigningKey saves the given msg signing key and key version to the config file.
+func (c *Core) saveMsgSigningKey(msgKey string, msgKeyVer byte) error {
+	data, err := ioutil.ReadFile(c.Settings.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("Error in reading config file: %v", err.Error())
+	}
+	config := strings.Split(string(data), ";")
+	if len(config) != 11 {
+		return fmt.Errorf("Error in parsing config file, expect 11 fields got %v", len(config))
+	}
+	config[9] = msgKey
+	config[10] = fmt.Sprintf("%v", msgKeyVer)
+	// Write to a temporary file and rename it, so a crash never leaves a truncated config file behind
+	tmpFile := c.Settings.ConfigFile + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Error in creating temporary config file: %v", err.Error())
+	}
+	_, err = f.Write([]byte(strings.Join(config, ";")))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("Error in writing temporary config file: %v", err.Error())
+	}
+	err = os.Rename(tmpFile, c.Settings.ConfigFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("Error in saving config file: %v", err.Error())
+	}
+	return nil
+}
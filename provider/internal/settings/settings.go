@@ -21,6 +21,8 @@ package settings
 import (
 	"math/big"
 	"time"
+
+	"github.com/wcgcyx/fc-retrieval/common/pkg/cid"
 )
 
 // DefaultMsgKeyUpdateDuration is the default msg signing key update duration
@@ -35,6 +37,31 @@ const DefaultTCPInactivityTimeout = 5000 * time.Millisecond
 // DefaultLongTCPInactivityTimeout is the default timeout for long TCP inactivity. This timeout should never be ignored.
 const DefaultLongTCPInactivityTimeout = 300000 * time.Millisecond
 
+// DefaultOfferDBFile is the default offer database file name, relative to the system dir
+const DefaultOfferDBFile = "offer.db"
+
+// DefaultPaymentDBFile is the default payment database file name, relative to the system dir
+const DefaultPaymentDBFile = "payment.db"
+
+// DefaultRenewDBFile is the default offer renewal database file name, relative to the system dir
+const DefaultRenewDBFile = "renew.db"
+
+// DefaultSettleCheckDuration is the default duration between two automatic settlement checks
+const DefaultSettleCheckDuration = 1 * time.Hour
+
+// DefaultRenewCheckDuration is the default duration between two offer renewal checks
+const DefaultRenewCheckDuration = 10 * time.Minute
+
+// DefaultOfferRenewBefore is the default duration before expiry within which an offer is renewed.
+// Gateways drop offers within two hours of expiry, so it needs to be longer than that.
+const DefaultOfferRenewBefore = 6 * time.Hour
+
+// DefaultOfferRenewPeriod is the default duration a renewed offer is valid for
+const DefaultOfferRenewPeriod = 7 * 24 * time.Hour
+
+// DefaultReplicationFactor is the default number of gateways closest to a cid that an offer is republished to
+const DefaultReplicationFactor = 16
+
 // AppSettings defines the server configuraiton
 type AppSettings struct {
 	// Logging related settings
@@ -50,11 +77,16 @@ type AppSettings struct {
 	LogTimeFormat  string `mapstructure:"LOG_TIME_FORMAT"`  // Log time format: RFC3339
 
 	// Admin related
-	BindAdminAPI int    `mapstructure:"BIND_ADMIN_API"` // Port number to bind to for admin secured HTTP connection
-	SystemDir    string `mapstructure:"SYSTEM_DIR"`     // Dir storing all data of this provider
-	RetrievalDir string `mapstructure:"RETRIEVAL_DIR"`  // Retrieval Dir: /var/.fc-retrieval/provider/files
-	AdminKeyFile string `mapstructure:"ADMIN_KEY_FILE"` // File storing the admin access key file
-	ConfigFile   string `mapstructure:"CONFIG_FILE"`    // File storing the provider config
+	BindAdminAPI   int    `mapstructure:"BIND_ADMIN_API"`  // Port number to bind to for admin secured HTTP connection
+	SystemDir      string `mapstructure:"SYSTEM_DIR"`      // Dir storing all data of this provider
+	RetrievalDir   string `mapstructure:"RETRIEVAL_DIR"`   // Retrieval Dir: /var/.fc-retrieval/provider/files
+	AdminKeyFile   string `mapstructure:"ADMIN_KEY_FILE"`  // File storing the admin access key file
+	ConfigFile     string `mapstructure:"CONFIG_FILE"`     // File storing the provider config
+	PersistOffer   bool   `mapstructure:"PERSIST_OFFER"`   // Boolean indicates whether offers are persisted on disk
+	OfferDBFile    string `mapstructure:"OFFER_DB_FILE"`   // File storing the offer database
+	RenewDBFile    string `mapstructure:"RENEW_DB_FILE"`   // File storing the offer renewal database, used when offers are persisted
+	PersistPayment bool   `mapstructure:"PERSIST_PAYMENT"` // Boolean indicates whether payment channels are persisted on disk
+	PaymentDBFile  string `mapstructure:"PAYMENT_DB_FILE"` // File storing the payment database
 
 	// Duration
 	SyncDuration             time.Duration `mapstructure:"SYNC_DURATION"`               // Sync duration
@@ -64,4 +96,22 @@ type AppSettings struct {
 
 	// Price, this is not configurable at the moment.
 	SearchPrice *big.Int `mapstructure:"SEARCH_PRICE"` // Search price
+
+	// Automatic settlement related
+	AutoSettle          bool          `mapstructure:"AUTO_SETTLE"`           // Boolean indicates whether inbound channels are settled automatically
+	SettleCheckDuration time.Duration `mapstructure:"SETTLE_CHECK_DURATION"` // Duration between two automatic settlement checks
+	SettleMinRedeemed   *big.Int      `mapstructure:"SETTLE_MIN_REDEEMED"`   // Settle when unredeemed amount reaches this value, 0 to disable
+	SettleMaxAge        uint64        `mapstructure:"SETTLE_MAX_AGE"`        // Settle when channel age in blocks exceeds this value, 0 to disable
+	SettleDeregistering bool          `mapstructure:"SETTLE_DEREGISTERING"`  // Boolean indicates whether to settle when sender is deregistering
+	SettleMaxCostRatio  float64       `mapstructure:"SETTLE_MAX_COST_RATIO"` // Settle when cost to settle is below this fraction of unredeemed amount, 0 to disable
+
+	// Offer renewal related
+	AutoRenewOffer     bool          `mapstructure:"AUTO_RENEW_OFFER"`     // Boolean indicates whether published offers are renewed by default
+	RenewCheckDuration time.Duration `mapstructure:"RENEW_CHECK_DURATION"` // Duration between two offer renewal checks
+	OfferRenewBefore   time.Duration `mapstructure:"OFFER_RENEW_BEFORE"`   // Default duration before expiry within which an offer is renewed
+	OfferRenewPeriod   time.Duration `mapstructure:"OFFER_RENEW_PERIOD"`   // Default duration a renewed offer is valid for, used for offers loaded at start-up
+	ReplicationFactor  uint          `mapstructure:"REPLICATION_FACTOR"`   // Number of gateways closest to a cid that an offer is republished to
+
+	// Content ID related, built from CID_CHUNK_SIZE, CID_VERSION and CID_RAW_LEAVES
+	DAGParams cid.DAGParams // Parameters of the UnixFS DAG used to compute the cid of every file
 }
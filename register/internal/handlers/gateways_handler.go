@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/go-openapi/runtime/middleware"
@@ -38,8 +39,8 @@ func AddGatewayRegister(params op.AddGatewayRegisterParams) middleware.Responder
 	return op.NewAddGatewayRegisterOK().WithPayload(register)
 }
 
-// GetGatewayRegisters retrieve Gateway register list
-func GetGatewayRegisters(_ op.GetGatewayRegistersParams) middleware.Responder {
+// GetGatewayRegisters retrieve Gateway register list, of a region and at a page if given
+func GetGatewayRegisters(params op.GetGatewayRegistersParams) middleware.Responder {
 	ctx := context.Background()
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     apiconfig.GetString("REDIS_URL") + ":" + apiconfig.GetString("REDIS_PORT"),
@@ -62,12 +63,23 @@ func GetGatewayRegisters(_ op.GetGatewayRegistersParams) middleware.Responder {
 		if unmarshalErr := json.Unmarshal([]byte(g), &registerData); unmarshalErr != nil {
 			log.Error("inside GetGatewayRegisters - can't unmarshall JSON, %s", unmarshalErr.Error())
 		}
+		if params.Region != nil && !strings.EqualFold(registerData.RegionCode, *params.Region) {
+			continue
+		}
 		payload = append(payload, &registerData)
 		debugOutputSb.WriteString(fmt.Sprintf("%s, ", registerData.NodeID))
 	}
 	//log.Debug("total gateway register records: %d; IDs: %s", len(gatewayRegisters), debugOutputSb.String())
 
-	return op.NewGetGatewayRegistersOK().WithPayload(payload)
+	// Pages are ordered by node ID
+	sort.Slice(payload, func(i, j int) bool {
+		return payload[i].NodeID < payload[j].NodeID
+	})
+	total := len(payload)
+	start, end := pageRange(total, params.Page)
+	payload = payload[start:end]
+
+	return op.NewGetGatewayRegistersOK().WithXTotalCount(int64(total)).WithPayload(payload)
 }
 
 // GetGatewayRegisterByID retrieve Gateway register by ID
@@ -7,7 +7,25 @@ E.g. - get an updated list of gateways, including just recently enrolled ones
 package handlers
 
 import (
+	"github.com/wcgcyx/fc-retrieval/common/pkg/register"
 	"github.com/wcgcyx/fc-retrieval/register/config"
 )
 
 var apiconfig = config.Config()
+
+// pageRange gets the range of the entries at a given page of a register list with a given number of entries.
+// A nil page covers all entries, a page beyond the list covers nothing.
+func pageRange(total int, page *int64) (int, int) {
+	if page == nil {
+		return 0, total
+	}
+	if *page < 0 || *page*register.PageSize >= int64(total) {
+		return total, total
+	}
+	start := int(*page * register.PageSize)
+	end := start + register.PageSize
+	if end > total {
+		end = total
+	}
+	return start, end
+}
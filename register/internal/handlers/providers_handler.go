@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/go-openapi/runtime/middleware"
@@ -38,8 +39,8 @@ func AddProviderRegister(params op.AddProviderRegisterParams) middleware.Respond
 	return op.NewAddProviderRegisterOK().WithPayload(register)
 }
 
-// GetProviderRegisters retrieve Provider register list
-func GetProviderRegisters(_ op.GetProviderRegistersParams) middleware.Responder {
+// GetProviderRegisters retrieve Provider register list, of a region and at a page if given
+func GetProviderRegisters(params op.GetProviderRegistersParams) middleware.Responder {
 	ctx := context.Background()
 
 	rdb := redis.NewClient(&redis.Options{
@@ -62,12 +63,23 @@ func GetProviderRegisters(_ op.GetProviderRegistersParams) middleware.Responder
 		if unmarshallErr := json.Unmarshal([]byte(register), &registerData); unmarshallErr != nil {
 			log.Error("inside GetProviderRegisters - can't unmarshall JSON: %s", unmarshallErr.Error())
 		}
+		if params.Region != nil && !strings.EqualFold(registerData.RegionCode, *params.Region) {
+			continue
+		}
 		payload = append(payload, &registerData)
 		debugOutputSb.WriteString(fmt.Sprintf("%s, ", registerData.NodeID))
 	}
 	//log.Debug("total provider register records: %d; IDs: %s", len(providerRegisters), debugOutputSb.String())
 
-	return op.NewGetProviderRegistersOK().WithPayload(payload)
+	// Pages are ordered by node ID
+	sort.Slice(payload, func(i, j int) bool {
+		return payload[i].NodeID < payload[j].NodeID
+	})
+	total := len(payload)
+	start, end := pageRange(total, params.Page)
+	payload = payload[start:end]
+
+	return op.NewGetProviderRegistersOK().WithXTotalCount(int64(total)).WithPayload(payload)
 }
 
 // GetProviderRegisterByID retrieve Provider register by ID
@@ -66,6 +66,21 @@ func init() {
         ],
         "summary": "Get register list",
         "operationId": "getGatewayRegisters",
+        "parameters": [
+          {
+            "type": "string",
+            "description": "ISO 3166-1 alpha-2 region code of the registers, all regions if absent",
+            "name": "region",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "format": "int64",
+            "description": "Page of the register list ordered by node ID, every page holds up to 100 registers, all pages if absent",
+            "name": "page",
+            "in": "query"
+          }
+        ],
         "responses": {
           "200": {
             "description": "Gateway register list",
@@ -74,6 +89,13 @@ func init() {
               "items": {
                 "$ref": "#/definitions/GatewayRegister"
               }
+            },
+            "headers": {
+              "X-Total-Count": {
+                "type": "integer",
+                "format": "int64",
+                "description": "Number of registers of the region, across all pages"
+              }
             }
           },
           "default": {
@@ -181,6 +203,21 @@ func init() {
         ],
         "summary": "Get Provider register list",
         "operationId": "getProviderRegisters",
+        "parameters": [
+          {
+            "type": "string",
+            "description": "ISO 3166-1 alpha-2 region code of the registers, all regions if absent",
+            "name": "region",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "format": "int64",
+            "description": "Page of the register list ordered by node ID, every page holds up to 100 registers, all pages if absent",
+            "name": "page",
+            "in": "query"
+          }
+        ],
         "responses": {
           "200": {
             "description": "Provider register list",
@@ -189,6 +226,13 @@ func init() {
               "items": {
                 "$ref": "#/definitions/ProviderRegister"
               }
+            },
+            "headers": {
+              "X-Total-Count": {
+                "type": "integer",
+                "format": "int64",
+                "description": "Number of registers of the region, across all pages"
+              }
             }
           },
           "default": {
@@ -454,6 +498,21 @@ func init() {
         ],
         "summary": "Get register list",
         "operationId": "getGatewayRegisters",
+        "parameters": [
+          {
+            "type": "string",
+            "description": "ISO 3166-1 alpha-2 region code of the registers, all regions if absent",
+            "name": "region",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "format": "int64",
+            "description": "Page of the register list ordered by node ID, every page holds up to 100 registers, all pages if absent",
+            "name": "page",
+            "in": "query"
+          }
+        ],
         "responses": {
           "200": {
             "description": "Gateway register list",
@@ -462,6 +521,13 @@ func init() {
               "items": {
                 "$ref": "#/definitions/GatewayRegister"
               }
+            },
+            "headers": {
+              "X-Total-Count": {
+                "type": "integer",
+                "format": "int64",
+                "description": "Number of registers of the region, across all pages"
+              }
             }
           },
           "default": {
@@ -569,6 +635,21 @@ func init() {
         ],
         "summary": "Get Provider register list",
         "operationId": "getProviderRegisters",
+        "parameters": [
+          {
+            "type": "string",
+            "description": "ISO 3166-1 alpha-2 region code of the registers, all regions if absent",
+            "name": "region",
+            "in": "query"
+          },
+          {
+            "type": "integer",
+            "format": "int64",
+            "description": "Page of the register list ordered by node ID, every page holds up to 100 registers, all pages if absent",
+            "name": "page",
+            "in": "query"
+          }
+        ],
         "responses": {
           "200": {
             "description": "Provider register list",
@@ -577,6 +658,13 @@ func init() {
               "items": {
                 "$ref": "#/definitions/ProviderRegister"
               }
+            },
+            "headers": {
+              "X-Total-Count": {
+                "type": "integer",
+                "format": "int64",
+                "description": "Number of registers of the region, across all pages"
+              }
             }
           },
           "default": {
@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/swag"
 
 	"github.com/wcgcyx/fc-retrieval/register/models"
 )
@@ -21,6 +22,10 @@ const GetGatewayRegistersOKCode int = 200
 swagger:response getGatewayRegistersOK
 */
 type GetGatewayRegistersOK struct {
+	/*Number of registers of the region, across all pages
+
+	 */
+	XTotalCount int64 `json:"X-Total-Count"`
 
 	/*
 	  In: Body
@@ -34,6 +39,17 @@ func NewGetGatewayRegistersOK() *GetGatewayRegistersOK {
 	return &GetGatewayRegistersOK{}
 }
 
+// WithXTotalCount adds the xTotalCount to the get gateway registers o k response
+func (o *GetGatewayRegistersOK) WithXTotalCount(xTotalCount int64) *GetGatewayRegistersOK {
+	o.XTotalCount = xTotalCount
+	return o
+}
+
+// SetXTotalCount sets the xTotalCount to the get gateway registers o k response
+func (o *GetGatewayRegistersOK) SetXTotalCount(xTotalCount int64) {
+	o.XTotalCount = xTotalCount
+}
+
 // WithPayload adds the payload to the get gateway registers o k response
 func (o *GetGatewayRegistersOK) WithPayload(payload []*models.GatewayRegister) *GetGatewayRegistersOK {
 	o.Payload = payload
@@ -48,6 +64,13 @@ func (o *GetGatewayRegistersOK) SetPayload(payload []*models.GatewayRegister) {
 // WriteResponse to the client
 func (o *GetGatewayRegistersOK) WriteResponse(rw http.ResponseWriter, producer runtime.Producer) {
 
+	// response header X-Total-Count
+
+	xTotalCount := swag.FormatInt64(o.XTotalCount)
+	if xTotalCount != "" {
+		rw.Header().Set("X-Total-Count", xTotalCount)
+	}
+
 	rw.WriteHeader(200)
 	payload := o.Payload
 	if payload == nil {
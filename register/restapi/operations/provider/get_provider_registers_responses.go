@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"github.com/go-openapi/runtime"
+	"github.com/go-openapi/swag"
 
 	"github.com/wcgcyx/fc-retrieval/register/models"
 )
@@ -21,6 +22,10 @@ const GetProviderRegistersOKCode int = 200
 swagger:response getProviderRegistersOK
 */
 type GetProviderRegistersOK struct {
+	/*Number of registers of the region, across all pages
+
+	 */
+	XTotalCount int64 `json:"X-Total-Count"`
 
 	/*
 	  In: Body
@@ -34,6 +39,17 @@ func NewGetProviderRegistersOK() *GetProviderRegistersOK {
 	return &GetProviderRegistersOK{}
 }
 
+// WithXTotalCount adds the xTotalCount to the get provider registers o k response
+func (o *GetProviderRegistersOK) WithXTotalCount(xTotalCount int64) *GetProviderRegistersOK {
+	o.XTotalCount = xTotalCount
+	return o
+}
+
+// SetXTotalCount sets the xTotalCount to the get provider registers o k response
+func (o *GetProviderRegistersOK) SetXTotalCount(xTotalCount int64) {
+	o.XTotalCount = xTotalCount
+}
+
 // WithPayload adds the payload to the get provider registers o k response
 func (o *GetProviderRegistersOK) WithPayload(payload []*models.ProviderRegister) *GetProviderRegistersOK {
 	o.Payload = payload
@@ -48,6 +64,13 @@ func (o *GetProviderRegistersOK) SetPayload(payload []*models.ProviderRegister)
 // WriteResponse to the client
 func (o *GetProviderRegistersOK) WriteResponse(rw http.ResponseWriter, producer runtime.Producer) {
 
+	// response header X-Total-Count
+
+	xTotalCount := swag.FormatInt64(o.XTotalCount)
+	if xTotalCount != "" {
+		rw.Header().Set("X-Total-Count", xTotalCount)
+	}
+
 	rw.WriteHeader(200)
 	payload := o.Payload
 	if payload == nil {